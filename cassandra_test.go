@@ -737,7 +737,7 @@ func TestReconnection(t *testing.T) {
 	defer session.Close()
 
 	h := session.ring.allHosts()[0]
-	session.handleNodeDown(h.ConnectAddress(), h.Port())
+	session.handleNodeDown(h.ConnectAddress(), h.Port(), StateChangeEvent)
 
 	if h.State() != NodeDown {
 		t.Fatal("Host should be NodeDown but not.")
@@ -750,6 +750,43 @@ func TestReconnection(t *testing.T) {
 	}
 }
 
+func TestSessionRefreshRing(t *testing.T) {
+	session := createSession(t)
+	defer session.Close()
+
+	hosts := session.ring.allHosts()
+	removed := hosts[len(hosts)-1]
+	session.removeHost(removed)
+	if session.ring.getHost(removed.ConnectAddress()) != nil {
+		t.Fatalf("expected host %v to be removed from the ring", removed.ConnectAddress())
+	}
+
+	// refreshing concurrently should still leave the ring consistent
+	var wg sync.WaitGroup
+	errs := make(chan error, 5)
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- session.RefreshRing()
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if session.ring.getHost(removed.ConnectAddress()) == nil {
+		t.Fatalf("expected host %v to be added back to the ring", removed.ConnectAddress())
+	} else if n := len(session.ring.allHosts()); n != len(hosts) {
+		t.Fatalf("expected %d hosts in the ring got %d", len(hosts), n)
+	}
+}
+
 type FullName struct {
 	FirstName string
 	LastName  string
@@ -1791,12 +1828,6 @@ func TestBatchStats(t *testing.T) {
 	}
 }
 
-type funcBatchObserver func(context.Context, ObservedBatch)
-
-func (f funcBatchObserver) ObserveBatch(ctx context.Context, o ObservedBatch) {
-	f(ctx, o)
-}
-
 func TestBatchObserve(t *testing.T) {
 	session := createSession(t)
 	defer session.Close()
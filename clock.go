@@ -0,0 +1,41 @@
+package gocql
+
+import "time"
+
+// clock is the source of time for the driver, tests replace it with a fake
+// clock to drive time dependent behaviour without sleeping.
+type clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) clockTimer
+	After(d time.Duration) <-chan time.Time
+}
+
+// clockTimer is a timer created by a clock, which behaves like a time.Timer.
+type clockTimer interface {
+	C() <-chan time.Time
+	Reset(d time.Duration) bool
+	Stop() bool
+}
+
+// realClock is the clock of the time package.
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTimer(d time.Duration) clockTimer {
+	return realTimer{time.NewTimer(d)}
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+type realTimer struct {
+	*time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time {
+	return t.Timer.C
+}
@@ -45,10 +45,10 @@ type ClusterConfig struct {
 	// highest supported protocol for the cluster. In clusters with nodes of different
 	// versions the protocol selected is not defined (ie, it can be any of the supported in the cluster)
 	ProtoVersion       int
-	Timeout            time.Duration      // connection timeout (default: 600ms)
-	ConnectTimeout     time.Duration      // initial connection timeout, used during initial dial to server (default: 600ms)
+	Timeout            time.Duration      // per request timeout, after which the request returns ErrTimeoutNoResponse (default: 600ms)
+	ConnectTimeout     time.Duration      // connection timeout, covering the dial to the server and the protocol startup, independent of Timeout (default: 600ms)
 	Port               int                // port (default: 9042)
-	Keyspace           string             // initial keyspace (optional)
+	Keyspace           string             // initial keyspace, used by every connection including reconnected ones (optional)
 	NumConns           int                // number of connections per host (default: 2)
 	Consistency        Consistency        // default consistency level (default: Quorum)
 	Compressor         Compressor         // compression algorithm (default: nil)
@@ -67,11 +67,37 @@ type ClusterConfig struct {
 	// configuration of host selection and connection selection policies.
 	PoolConfig PoolConfig
 
+	// NumRemoteConns is the number of connections per host in the remote
+	// datacenters, the hosts which the host selection policy does not report
+	// as local. Which remote hosts are connected to at all can be limited with
+	// UsedHostsPerRemoteDC. (default: 0, NumConns)
+	NumRemoteConns int
+
+	// KeyspaceConsistency sets the default consistency for queries and batches
+	// against a keyspace, overriding Consistency. The keyspace is the one set
+	// with Query.SetKeyspace, or which the tables of the statements are
+	// qualified with, such as ks for SELECT * FROM ks.tbl, otherwise that of
+	// the session. A consistency set on the query itself always takes
+	// precedence. (default: unset)
+	KeyspaceConsistency map[string]Consistency
+
 	// If not zero, gocql attempt to reconnect known DOWN nodes in every ReconnectInterval.
 	ReconnectInterval time.Duration
 
+	// MinReadyHosts makes CreateSession wait until at least this many hosts
+	// have an open connection before returning, the hosts which could not be
+	// connected to are retried while waiting. If they are not ready within
+	// ReadyTimeout an error is returned. (default: 0, do not wait)
+	MinReadyHosts int
+
+	// ReadyTimeout is the maximum amount of time CreateSession waits for
+	// MinReadyHosts to be ready. (default: 10s)
+	ReadyTimeout time.Duration
+
 	// The maximum amount of time to wait for schema agreement in a cluster after
-	// receiving a schema change frame. (deault: 60s)
+	// receiving a schema change frame. The schema versions reported by the
+	// nodes which are up are polled until they all agree, if zero schema
+	// changes do not wait for agreement. (deault: 60s)
 	MaxWaitSchemaAgreement time.Duration
 
 	// HostFilter will filter all incoming events for host, any which don't pass
@@ -79,6 +105,17 @@ type ClusterConfig struct {
 	// via Discovery
 	HostFilter HostFilter
 
+	// ProxyEndpoint is the address, host:port, of a proxy which all the
+	// connections are made through, for deployments where the nodes are not
+	// reachable directly. The hosts are still discovered and tracked by their
+	// own addresses, which the token aware policies route queries by, but
+	// connections to them are dialled to the proxy. The proxy routes each
+	// connection to its node by the TLS server name, which is set to the host
+	// ID of the node, or its host:port if the host ID is not known yet, so
+	// SslOpts must be set. With EnableHostVerification the certificate of the
+	// proxy is verified against that name.
+	ProxyEndpoint string
+
 	// AddressTranslator will translate addresses found on peer discovery and/or
 	// node change events.
 	AddressTranslator AddressTranslator
@@ -97,6 +134,10 @@ type ClusterConfig struct {
 	// hosts supplied and will not attempt to lookup the hosts information, this will
 	// mean that data_centre, rack and token information will not be available and as
 	// such host filtering and token aware query routing will not be available.
+	//
+	// Hosts which are not supplied are also not discovered later, the control
+	// connection still receives events for the supplied hosts but NEW_NODE
+	// events and ring refreshes will not add any other hosts to the pool.
 	DisableInitialHostLookup bool
 
 	// Configure events the driver will register for
@@ -107,8 +148,20 @@ type ClusterConfig struct {
 		DisableTopologyEvents bool
 		// disable registering for schema events (keyspace/table/function removed/created/updated)
 		DisableSchemaEvents bool
+		// IgnoreSchemaChanges is a mask of the targets of schema events which
+		// are ignored, their changes do not invalidate the cached schema
+		// metadata, reducing the metadata read when their schema changes
+		// often.
+		IgnoreSchemaChanges SchemaChangeTarget
 	}
 
+	// DisableEvents disables all events from the cluster, overriding the
+	// options in Events. The driver does not register for events and does not
+	// start the goroutines which handle them, so the hosts are only updated
+	// by ReconnectInterval and Session.RefreshRing and cached schema metadata
+	// is not invalidated when the schema changes.
+	DisableEvents bool
+
 	// DisableSkipMetadata will override the internal result metadata cache so that the driver does not
 	// send skip_metadata for queries, this means that the result will always contain
 	// the metadata to parse the rows and will not reuse the metadata from the prepared
@@ -133,9 +186,122 @@ type ClusterConfig struct {
 	// Use it to collect metrics / stats from frames by providing an implementation of FrameHeaderObserver.
 	FrameHeaderObserver FrameHeaderObserver
 
+	// EventFrameBufferSize is the capacity of the buffers used to read event
+	// frames, which are reused between events. Event frames larger than this are
+	// read into a buffer which is then dropped. (default: 128)
+	EventFrameBufferSize int
+
 	// Default idempotence for queries
 	DefaultIdempotence bool
 
+	// WriteCoalesceWindow enables buffering of frames written concurrently to a
+	// connection for up to this duration, so that they are sent to the socket in
+	// a single write. A frame is written immediately if the connection has not
+	// been written to within the window, so single queries are not delayed.
+	// (default: 0, disabled)
+	WriteCoalesceWindow time.Duration
+
+	// HealthCheckInterval enables a periodic lightweight query on each
+	// connection, closing connections which do not respond so that silently
+	// dropped connections are detected. The pool then reconnects to the host
+	// or marks it down if it can not. Unlike HeartbeatInterval the query is
+	// sent whether or not the connection is idle. (default: 0, disabled)
+	HealthCheckInterval time.Duration
+
+	// HeartbeatInterval enables sending an OPTIONS request on connections
+	// which have not received a frame for the interval, closing connections
+	// which do not respond so that connections silently dropped by the
+	// network, such as by a load balancer, are detected. The pool then
+	// reconnects to the host or marks it down if it can not.
+	// (default: 0, disabled)
+	HeartbeatInterval time.Duration
+
+	// RetryBudget limits the retries of the session's queries and batches to
+	// a ratio of the requests it executes, whatever their retry policy allows,
+	// so that a failing cluster is not sent a storm of retries.
+	// Session.ThrottledRetries counts the retries skipped. (default: nil,
+	// unlimited)
+	RetryBudget *RetryBudget
+
+	// TimestampGenerator generates the default timestamps of the queries and
+	// batches which do not set one, when DefaultTimestamp is enabled.
+	// (default: MonotonicTimestampGenerator())
+	TimestampGenerator TimestampGenerator
+
+	// RequireLocalDC makes queries and batches with LOCAL_ONE or
+	// LOCAL_QUORUM consistency fail with ErrLocalConsistencyNoDC, without
+	// being sent, unless the host selection policy has a local datacenter,
+	// such as DCAwareRoundRobinPolicy, or they are pinned to a host with
+	// Query.SetHost. (default: false)
+	RequireLocalDC bool
+
+	// PageStateKey is the secret used by Session.EncodePageState to sign
+	// paging states handed to untrusted clients, such as for stateless
+	// pagination over HTTP, and by Session.DecodePageState to reject those
+	// which were tampered with. (default: nil, paging states can not be
+	// encoded)
+	PageStateKey []byte
+
+	// MaxConcurrentRequests limits the number of queries and batches which
+	// the session executes at once across all hosts, including their retries
+	// and the fetching of further pages, as backpressure against overloading
+	// the cluster. New requests past the limit wait for a request to finish,
+	// or for their context to be done. (default: 0, unlimited)
+	MaxConcurrentRequests int
+
+	// MaxConcurrentRequestsFailFast makes requests past MaxConcurrentRequests
+	// fail immediately with ErrTooManyRequests instead of waiting.
+	MaxConcurrentRequestsFailFast bool
+
+	// MaxRequestsPerConn limits the number of requests in flight on each
+	// connection, below the number of streams allowed by the protocol, 32768
+	// for protocol version 3 and above and 128 before, less the reserved
+	// stream 0. Creating a session fails if it is above the limit of
+	// ProtoVersion. Requests are sent on the connection of their host which
+	// has the most requests left, once every connection is at the limit the
+	// host is skipped, or the request queues as set by StreamWaitTimeout.
+	// (default: 0, the protocol limit)
+	MaxRequestsPerConn int
+
+	// StreamWaitTimeout is how long a request waits for a stream to be
+	// released when all the streams of its connection are in use before
+	// failing with ErrNoStreams. Requests fail immediately if it is zero.
+	// When it is set a request whose host has all its connections in use
+	// waits on one of them rather than going to the next host.
+	// Session.StreamExhaustions counts the requests which found no stream
+	// available. (default: 0)
+	StreamWaitTimeout time.Duration
+
+	// ReadBufferSize is the size of the buffer which frames are read from
+	// each connection through, a larger buffer reads more frames at once
+	// when large results arrive in many pages. (default: 4096)
+	ReadBufferSize int
+
+	// WriteBufferSize is the initial size of the buffer which each request
+	// frame is built in before being written to its connection, avoiding
+	// growing the buffer as large statements or batches are written.
+	// (default: 128)
+	WriteBufferSize int
+
+	// MaxFrameSize is the largest frame body the driver reads from a node, a
+	// frame with a larger length closes the connection with a protocol error
+	// instead of the body being read, protecting against corrupt lengths. It
+	// can not be raised above the 256MB limit of the protocol.
+	// (default: 256MB)
+	MaxFrameSize int
+
+	// MaxConcurrentHostFetches limits the number of host info lookups made
+	// on the control connection at once, such as when many NEW_NODE events
+	// arrive together after a rack rejoins the cluster. If zero the lookups
+	// are not limited. (default: 1)
+	MaxConcurrentHostFetches int
+
+	// SlowQueryThreshold logs every attempt of a query which takes longer
+	// than the threshold to Logger, with the statement, host and duration.
+	// Only the types of the bound values are logged, not the values
+	// themselves. (default: 0, disabled)
+	SlowQueryThreshold time.Duration
+
 	// internal config for testing
 	disableControlConn bool
 }
@@ -151,21 +317,23 @@ type ClusterConfig struct {
 // the same host, and will not mark the node being down or up from events.
 func NewCluster(hosts ...string) *ClusterConfig {
 	cfg := &ClusterConfig{
-		Hosts:                  hosts,
-		CQLVersion:             "3.0.0",
-		Timeout:                600 * time.Millisecond,
-		ConnectTimeout:         600 * time.Millisecond,
-		Port:                   9042,
-		NumConns:               2,
-		Consistency:            Quorum,
-		MaxPreparedStmts:       defaultMaxPreparedStmts,
-		MaxRoutingKeyInfo:      1000,
-		PageSize:               5000,
-		DefaultTimestamp:       true,
-		MaxWaitSchemaAgreement: 60 * time.Second,
-		ReconnectInterval:      60 * time.Second,
-		ConvictionPolicy:       &SimpleConvictionPolicy{},
-		ReconnectionPolicy:     &ConstantReconnectionPolicy{MaxRetries: 3, Interval: 1 * time.Second},
+		Hosts:                    hosts,
+		CQLVersion:               "3.0.0",
+		Timeout:                  600 * time.Millisecond,
+		ConnectTimeout:           600 * time.Millisecond,
+		Port:                     9042,
+		NumConns:                 2,
+		Consistency:              Quorum,
+		MaxPreparedStmts:         defaultMaxPreparedStmts,
+		MaxRoutingKeyInfo:        1000,
+		PageSize:                 5000,
+		DefaultTimestamp:         true,
+		MaxWaitSchemaAgreement:   60 * time.Second,
+		ReconnectInterval:        60 * time.Second,
+		ReadyTimeout:             10 * time.Second,
+		MaxConcurrentHostFetches: 1,
+		ConvictionPolicy:         &SimpleConvictionPolicy{},
+		ReconnectionPolicy:       &ConstantReconnectionPolicy{MaxRetries: 3, Interval: 1 * time.Second},
 	}
 	return cfg
 }
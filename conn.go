@@ -100,6 +100,40 @@ type ConnConfig struct {
 	Authenticator  Authenticator
 	Keepalive      time.Duration
 	tlsConfig      *tls.Config
+
+	// WriteCoalesceWindow is the maximum time to buffer frames before
+	// writing them to the socket together, disabled if zero.
+	WriteCoalesceWindow time.Duration
+
+	// HealthCheckInterval is how often an idle query is sent to check that the
+	// connection is still alive, disabled if zero.
+	HealthCheckInterval time.Duration
+
+	// HeartbeatInterval is how long a connection can be idle before an
+	// OPTIONS request is sent to check that it is still alive, disabled if
+	// zero.
+	HeartbeatInterval time.Duration
+
+	// MaxFrameSize is the largest frame body read from the connection, the
+	// protocol limit of 256MB if it is not positive or larger.
+	MaxFrameSize int
+
+	// MaxRequestsPerConn limits the number of requests in flight on the
+	// connection below the number of streams, and StreamWaitTimeout is how
+	// long a request waits for a stream when all are in use.
+	MaxRequestsPerConn int
+	StreamWaitTimeout  time.Duration
+
+	// ProxyEndpoint is the address which connections are dialled to instead
+	// of the address of their host, if it is set. The host is sent as the
+	// TLS server name.
+	ProxyEndpoint string
+
+	// ReadBufferSize is the size of the buffered reader of the socket and
+	// WriteBufferSize the initial size of the buffer request frames are
+	// written into, the defaults are used if they are not positive.
+	ReadBufferSize  int
+	WriteBufferSize int
 }
 
 type ConnErrorHandler interface {
@@ -120,12 +154,17 @@ func (fn connErrorHandlerFn) HandleError(conn *Conn, err error, closed bool) {
 // depreciated
 var TimeoutLimit int64 = 0
 
+// defaultReadBufSize is the size of the buffered reader of connections, the
+// default size of bufio.Reader.
+const defaultReadBufSize = 4096
+
 // Conn is a single connection to a Cassandra node. It can be used to execute
 // queries, but users are usually advised to use a more reliable, higher
 // level API.
 type Conn struct {
 	conn          net.Conn
 	r             *bufio.Reader
+	w             io.Writer
 	timeout       time.Duration
 	cfg           *ConnConfig
 	frameObserver FrameHeaderObserver
@@ -141,9 +180,28 @@ type Conn struct {
 	auth         Authenticator
 	addr         string
 
-	version         uint8
+	version uint8
+	host    *HostInfo
+
+	// maxFrameSize is the largest frame body read from the connection when
+	// positive, larger frames are rejected by the framer
+	maxFrameSize int
+
+	// writeBufSize is the initial size of the buffer request frames are
+	// written into, the framer's default if it is not positive
+	writeBufSize int
+
+	// requests holds a token for each request in flight when the requests
+	// are limited or wait for streams, for up to streamWait
+	requests   chan struct{}
+	streamWait time.Duration
+
+	// startup options supported by the node, from the SUPPORTED response
+	supported map[string][]string
+
+	// keyspaceMu guards currentKeyspace, which is switched by UseKeyspace
+	keyspaceMu      sync.Mutex
 	currentKeyspace string
-	host            *HostInfo
 
 	session *Session
 
@@ -151,10 +209,14 @@ type Conn struct {
 	quit   chan struct{}
 
 	timeouts int64
+
+	// unix nano time the last frame was read, accessed atomically
+	lastRead int64
 }
 
-// Connect establishes a connection to a Cassandra node.
-func (s *Session) dial(host *HostInfo, cfg *ConnConfig, errorHandler ConnErrorHandler) (*Conn, error) {
+// Connect establishes a connection to a Cassandra node, dialling and the
+// startup of the connection stop once ctx is done.
+func (s *Session) dial(ctx context.Context, host *HostInfo, cfg *ConnConfig, errorHandler ConnErrorHandler) (*Conn, error) {
 	ip := host.ConnectAddress()
 	port := host.port
 
@@ -177,26 +239,60 @@ func (s *Session) dial(host *HostInfo, cfg *ConnConfig, errorHandler ConnErrorHa
 	// TODO(zariel): handle ipv6 zone
 	addr := (&net.TCPAddr{IP: ip, Port: port}).String()
 
-	if cfg.tlsConfig != nil {
+	// every host is reached through the proxy, which routes the connection
+	// to the host named by the TLS server name
+	dialAddr := addr
+	tlsConfig := cfg.tlsConfig
+	if cfg.ProxyEndpoint != "" {
+		dialAddr = cfg.ProxyEndpoint
+		if tlsConfig != nil {
+			tlsConfig = tlsConfig.Clone()
+			tlsConfig.ServerName = host.HostID()
+			if tlsConfig.ServerName == "" {
+				// an IP address alone is not sent as a server name
+				tlsConfig.ServerName = addr
+			}
+		}
+	}
+
+	if tlsConfig != nil {
 		// the TLS config is safe to be reused by connections but it must not
 		// be modified after being used.
-		conn, err = tls.DialWithDialer(dialer, "tcp", addr, cfg.tlsConfig)
+		conn, err = dialTLS(ctx, dialer, dialAddr, tlsConfig)
 	} else {
-		conn, err = dialer.Dial("tcp", addr)
+		conn, err = dialer.DialContext(ctx, "tcp", dialAddr)
 	}
 
 	if err != nil {
 		return nil, err
 	}
 
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		atomic.AddUint64(&s.tlsHandshakes, 1)
+		if tlsConn.ConnectionState().DidResume {
+			atomic.AddUint64(&s.tlsResumed, 1)
+		}
+	}
+
+	// connections are identified by the address of their host, which
+	// statements are prepared on, rather than by the proxy
+	if cfg.ProxyEndpoint == "" {
+		addr = conn.RemoteAddr().String()
+	}
+
+	readBufSize := defaultReadBufSize
+	if cfg.ReadBufferSize > 0 {
+		readBufSize = cfg.ReadBufferSize
+	}
+
 	c := &Conn{
 		conn:          conn,
-		r:             bufio.NewReader(conn),
+		r:             bufio.NewReaderSize(conn, readBufSize),
 		cfg:           cfg,
 		calls:         make(map[int]*callReq),
 		timeout:       cfg.Timeout,
 		version:       uint8(cfg.ProtoVersion),
-		addr:          conn.RemoteAddr().String(),
+		addr:          addr,
 		errorHandler:  errorHandler,
 		compressor:    cfg.Compressor,
 		auth:          cfg.Authenticator,
@@ -205,20 +301,36 @@ func (s *Session) dial(host *HostInfo, cfg *ConnConfig, errorHandler ConnErrorHa
 		streams:       streams.New(cfg.ProtoVersion),
 		host:          host,
 		frameObserver: s.frameObserver,
+		writeBufSize:  cfg.WriteBufferSize,
+	}
+	if cfg.MaxFrameSize < maxFrameSize {
+		c.maxFrameSize = cfg.MaxFrameSize
+	}
+	if cfg.MaxRequestsPerConn > 0 || cfg.StreamWaitTimeout > 0 {
+		// stream 0 is reserved
+		n := c.streams.NumStreams - 1
+		if cfg.MaxRequestsPerConn > 0 && cfg.MaxRequestsPerConn < n {
+			n = cfg.MaxRequestsPerConn
+		}
+		c.requests = make(chan struct{}, n)
+		c.streamWait = cfg.StreamWaitTimeout
+	}
+
+	c.w = c
+	if cfg.WriteCoalesceWindow > 0 {
+		c.w = newWriteCoalescer(conn, c.timeout, cfg.WriteCoalesceWindow)
 	}
 
 	if cfg.Keepalive > 0 {
 		c.setKeepalive(cfg.Keepalive)
 	}
 
-	var (
-		ctx    context.Context
-		cancel func()
-	)
+	dialCtx := ctx
+	var cancel func()
 	if cfg.ConnectTimeout > 0 {
-		ctx, cancel = context.WithTimeout(context.Background(), cfg.ConnectTimeout)
+		ctx, cancel = context.WithTimeout(ctx, cfg.ConnectTimeout)
 	} else {
-		ctx, cancel = context.WithCancel(context.Background())
+		ctx, cancel = context.WithCancel(ctx)
 	}
 	defer cancel()
 
@@ -255,14 +367,155 @@ func (s *Session) dial(host *HostInfo, cfg *ConnConfig, errorHandler ConnErrorHa
 		}
 	case <-ctx.Done():
 		c.Close()
+		if err := dialCtx.Err(); err != nil {
+			return nil, err
+		}
 		return nil, errors.New("gocql: no response to connection startup within timeout")
 	}
 
 	go c.serve()
 
+	if cfg.HealthCheckInterval > 0 {
+		go c.healthCheck(cfg.HealthCheckInterval)
+	}
+	if cfg.HeartbeatInterval > 0 {
+		go c.heartbeat(cfg.HeartbeatInterval)
+	}
+
 	return c, nil
 }
 
+const healthCheckQuery = "SELECT key FROM system.local"
+
+// healthCheck periodically queries the node and closes the connection if no
+// response is received, this detects connections which have been silently
+// dropped without the socket being closed. The host is marked down if the
+// conviction policy agrees, it will then be reconnected to by the session.
+func (c *Conn) healthCheck(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.quit:
+			return
+		case <-ticker.C:
+		}
+
+		q := c.session.Query(healthCheckQuery).Consistency(One)
+		if c.timeout <= 0 {
+			// without a timeout a dead connection would block forever
+			q.Timeout(interval)
+		}
+
+		err := c.executeQuery(q).Close()
+		if err == nil || err == ErrNoStreams {
+			continue
+		} else if _, ok := err.(RequestError); ok {
+			// the node responded so the connection is alive
+			continue
+		}
+
+		err = fmt.Errorf("gocql: health check failed: %v", err)
+		c.closeWithError(err)
+		if c.session.cfg.ConvictionPolicy.AddFailure(err, c.host) {
+			c.session.handleNodeDown(c.host.ConnectAddress(), c.host.Port(), StateChangeConnection)
+		}
+		return
+	}
+}
+
+// heartbeat sends an OPTIONS request once the connection has been idle for
+// interval and closes the connection if no response is received, so that
+// connections which were dropped without being closed are detected.
+func (c *Conn) heartbeat(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.quit:
+			return
+		case <-ticker.C:
+		}
+
+		if time.Since(time.Unix(0, atomic.LoadInt64(&c.lastRead))) < interval {
+			// the connection is in use, frames have been read recently
+			continue
+		}
+
+		// without a timeout a dead connection would block forever
+		timeout := c.timeout
+		if timeout <= 0 {
+			timeout = interval
+		}
+
+		framer, err := c.execTimeout(context.Background(), &writeOptionsFrame{}, nil, timeout)
+		if err == ErrNoStreams {
+			// all the streams are in use so the connection is not idle
+			continue
+		} else if err == nil {
+			// any response from the node means the connection is alive
+			_, err = framer.parseFrame()
+		}
+		if err == nil {
+			continue
+		}
+
+		err = fmt.Errorf("gocql: heartbeat failed: %v", err)
+		c.closeWithError(err)
+		if c.session.cfg.ConvictionPolicy.AddFailure(err, c.host) {
+			c.session.handleNodeDown(c.host.ConnectAddress(), c.host.Port(), StateChangeConnection)
+		}
+		return
+	}
+}
+
+// dialTLS is like tls.DialWithDialer but stops dialling and the handshake
+// once ctx is done.
+func dialTLS(ctx context.Context, dialer *net.Dialer, addr string, config *tls.Config) (*tls.Conn, error) {
+	if dialer.Timeout > 0 {
+		// the timeout covers the handshake as well
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, dialer.Timeout)
+		defer cancel()
+	}
+
+	rawConn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.ServerName == "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			rawConn.Close()
+			return nil, err
+		}
+		config = config.Clone()
+		config.ServerName = host
+	}
+
+	conn := tls.Client(rawConn, config)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- conn.Handshake()
+	}()
+
+	select {
+	case err := <-errc:
+		if err != nil {
+			rawConn.Close()
+			return nil, err
+		}
+		return conn, nil
+	case <-ctx.Done():
+		rawConn.Close()
+		<-errc
+		return nil, ctx.Err()
+	}
+}
+
 func (c *Conn) Write(p []byte) (int, error) {
 	if c.timeout > 0 {
 		c.conn.SetWriteDeadline(time.Now().Add(c.timeout))
@@ -271,6 +524,129 @@ func (c *Conn) Write(p []byte) (int, error) {
 	return c.conn.Write(p)
 }
 
+// writeCoalescer buffers frames written to a busy connection so that they can
+// be sent with a single writev. If nothing has been written for window then a
+// frame is written straight away, otherwise it is buffered for at most window
+// before being flushed along with any other buffered frames.
+type writeCoalescer struct {
+	conn    net.Conn
+	timeout time.Duration
+	window  time.Duration
+
+	mu   sync.Mutex
+	cond *sync.Cond
+
+	// writing is true while a write to conn is in progress
+	writing bool
+	// last is when the last write to conn finished
+	last time.Time
+	// timer is set while buffers are waiting to be flushed
+	timer   *time.Timer
+	buffers net.Buffers
+
+	// gen is incremented after each flush, err is the result of the last flush
+	gen uint64
+	err error
+}
+
+func newWriteCoalescer(conn net.Conn, timeout, window time.Duration) *writeCoalescer {
+	w := &writeCoalescer{
+		conn:    conn,
+		timeout: timeout,
+		window:  window,
+	}
+	w.cond = sync.NewCond(&w.mu)
+	return w
+}
+
+// Write blocks until p has been written to the connection, p must not be
+// modified until Write returns.
+func (w *writeCoalescer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.err != nil {
+		// the connection is broken, fail fast
+		return 0, w.err
+	}
+
+	if !w.writing && len(w.buffers) == 0 && time.Since(w.last) >= w.window {
+		// the connection is idle so dont wait to coalesce
+		w.writeLocked(net.Buffers{p})
+		return w.result(len(p))
+	}
+
+	// the buffers are written once the in progress write, if any, is done
+	target := w.gen + 1
+	if w.writing {
+		target++
+	}
+
+	w.buffers = append(w.buffers, p)
+	if w.timer == nil && !w.writing {
+		w.timer = time.AfterFunc(w.window, w.flush)
+	}
+
+	for w.gen < target {
+		w.cond.Wait()
+	}
+
+	return w.result(len(p))
+}
+
+func (w *writeCoalescer) result(n int) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	return n, nil
+}
+
+func (w *writeCoalescer) flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.timer = nil
+	if w.writing || len(w.buffers) == 0 {
+		// the in progress write will flush the buffers once it is done
+		return
+	}
+
+	buffers := w.buffers
+	w.buffers = nil
+	w.writeLocked(buffers)
+}
+
+// writeLocked writes buffers to the connection with the lock released, then
+// wakes anyone waiting for them. Any frames buffered in the meantime are
+// flushed straight away.
+func (w *writeCoalescer) writeLocked(buffers net.Buffers) {
+	for len(buffers) > 0 {
+		w.writing = true
+		w.mu.Unlock()
+
+		if w.timeout > 0 {
+			w.conn.SetWriteDeadline(time.Now().Add(w.timeout))
+		}
+		_, err := buffers.WriteTo(w.conn)
+
+		w.mu.Lock()
+		w.writing = false
+		w.last = time.Now()
+		if err != nil {
+			w.err = err
+		}
+		w.gen++
+		w.cond.Broadcast()
+
+		buffers = w.buffers
+		w.buffers = nil
+		if w.timer != nil {
+			w.timer.Stop()
+			w.timer = nil
+		}
+	}
+}
+
 func (c *Conn) Read(p []byte) (n int, err error) {
 	const maxAttempts = 5
 
@@ -294,7 +670,22 @@ func (c *Conn) Read(p []byte) (n int, err error) {
 	return
 }
 
+// startupTimeout is how long each request of the connection startup waits
+// for its response. The startup as a whole is bounded by ConnectTimeout, so
+// a slow handshake is not failed by the query Timeout, which only applies if
+// there is no ConnectTimeout.
+func (c *Conn) startupTimeout() time.Duration {
+	if c.cfg.ConnectTimeout > 0 {
+		return 0
+	}
+	return c.timeout
+}
+
 func (c *Conn) startup(ctx context.Context, frameTicker chan struct{}) error {
+	if err := c.options(ctx, frameTicker); err != nil {
+		return err
+	}
+
 	m := map[string]string{
 		"CQL_VERSION": c.cfg.CQLVersion,
 	}
@@ -309,7 +700,7 @@ func (c *Conn) startup(ctx context.Context, frameTicker chan struct{}) error {
 		return ctx.Err()
 	}
 
-	framer, err := c.exec(ctx, &writeStartupFrame{opts: m}, nil)
+	framer, err := c.execTimeout(ctx, &writeStartupFrame{opts: m}, nil, c.startupTimeout())
 	if err != nil {
 		return err
 	}
@@ -331,6 +722,36 @@ func (c *Conn) startup(ctx context.Context, frameTicker chan struct{}) error {
 	}
 }
 
+// options asks the node which startup options it supports, before the
+// connection is started.
+func (c *Conn) options(ctx context.Context, frameTicker chan struct{}) error {
+	select {
+	case frameTicker <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	framer, err := c.execTimeout(ctx, &writeOptionsFrame{}, nil, c.startupTimeout())
+	if err != nil {
+		return err
+	}
+
+	frame, err := framer.parseFrame()
+	if err != nil {
+		return err
+	}
+
+	switch v := frame.(type) {
+	case error:
+		return v
+	case *supportedFrame:
+		c.supported = v.supported
+		return nil
+	default:
+		return NewErrProtocol("Unknown type of response to options frame: %s", v)
+	}
+}
+
 func (c *Conn) authenticateHandshake(ctx context.Context, authFrame *authenticateFrame, frameTicker chan struct{}) error {
 	if c.auth == nil {
 		return fmt.Errorf("authentication required (using %q)", authFrame.class)
@@ -350,7 +771,7 @@ func (c *Conn) authenticateHandshake(ctx context.Context, authFrame *authenticat
 			return ctx.Err()
 		}
 
-		framer, err := c.exec(ctx, req, nil)
+		framer, err := c.execTimeout(ctx, req, nil, c.startupTimeout())
 		if err != nil {
 			return err
 		}
@@ -470,6 +891,7 @@ func (c *Conn) recv() error {
 	if err != nil {
 		return err
 	}
+	atomic.StoreInt64(&c.lastRead, headEndTime.UnixNano())
 
 	if c.frameObserver != nil {
 		c.frameObserver.ObserveFrameHeader(context.Background(), ObservedFrameHeader{
@@ -483,12 +905,19 @@ func (c *Conn) recv() error {
 		})
 	}
 
+	// a length larger than the limit is most likely corrupt, so the body is
+	// not read and the connection is closed as the stream can not be trusted
+	if c.maxFrameSize > 0 && head.length > c.maxFrameSize {
+		return NewErrProtocol("gocql: frame body length %d is bigger than the maximum frame size %d", head.length, c.maxFrameSize)
+	}
+
 	if head.stream > c.streams.NumStreams {
 		return fmt.Errorf("gocql: frame header stream is beyond call expected bounds: %d", head.stream)
 	} else if head.stream == -1 {
 		// TODO: handle cassandra event frames, we shouldnt get any currently
-		framer := newFramer(c, c, c.compressor, c.version)
+		framer := c.session.framerPool.get(c, c, c.compressor, c.version)
 		if err := framer.readFrame(&head); err != nil {
+			c.session.framerPool.put(framer)
 			return err
 		}
 		go c.session.handleEvent(framer)
@@ -557,6 +986,71 @@ func (c *Conn) releaseStream(stream int) {
 
 	streamPool.Put(call)
 	c.streams.Clear(stream)
+	if c.requests != nil {
+		<-c.requests
+	}
+}
+
+// acquireStream returns a free stream for a request, if all are in use it
+// waits for up to c.streamWait for one to be released before failing with
+// ErrNoStreams.
+func (c *Conn) acquireStream(ctx context.Context) (int, error) {
+	if c.requests != nil {
+		select {
+		case c.requests <- struct{}{}:
+		default:
+			c.streamsExhausted()
+			if c.streamWait <= 0 {
+				return 0, ErrNoStreams
+			}
+
+			var ctxDone <-chan struct{}
+			if ctx != nil {
+				ctxDone = ctx.Done()
+			}
+
+			timer := time.NewTimer(c.streamWait)
+			defer timer.Stop()
+
+			select {
+			case c.requests <- struct{}{}:
+			case <-timer.C:
+				return 0, ErrNoStreams
+			case <-ctxDone:
+				return 0, ctx.Err()
+			case <-c.quit:
+				return 0, ErrConnectionClosed
+			}
+		}
+	}
+
+	stream, ok := c.streams.GetStream()
+	if !ok {
+		if c.requests != nil {
+			<-c.requests
+		} else {
+			c.streamsExhausted()
+		}
+		return 0, ErrNoStreams
+	}
+	return stream, nil
+}
+
+// maxRequestsPerConn returns the most requests which can be in flight on a
+// connection of protocol version proto, one per stream except the reserved
+// stream 0. A version of 0, which is discovered when connecting, allows as many
+// as the latest versions.
+func maxRequestsPerConn(proto int) int {
+	if proto > 0 && proto <= protoVersion2 {
+		return 127
+	}
+	return 32767
+}
+
+func (c *Conn) streamsExhausted() {
+	if c.session != nil {
+		atomic.AddUint64(&c.session.streamExhaustions, 1)
+	}
 }
 
 func (c *Conn) handleTimeout() {
@@ -586,14 +1080,20 @@ type callReq struct {
 }
 
 func (c *Conn) exec(ctx context.Context, req frameWriter, tracer Tracer) (*framer, error) {
+	return c.execTimeout(ctx, req, tracer, c.timeout)
+}
+
+// execTimeout is like exec but waits for at most timeout for the response,
+// wait forever if it is not positive.
+func (c *Conn) execTimeout(ctx context.Context, req frameWriter, tracer Tracer, timeout time.Duration) (*framer, error) {
 	// TODO: move tracer onto conn
-	stream, ok := c.streams.GetStream()
-	if !ok {
-		return nil, ErrNoStreams
+	stream, err := c.acquireStream(ctx)
+	if err != nil {
+		return nil, err
 	}
 
 	// resp is basically a waiting semaphore protecting the framer
-	framer := newFramer(c, c, c.compressor, c.version)
+	framer := newFramerSize(c, c.w, c.compressor, c.version, c.writeBufSize)
 
 	call := streamPool.Get().(*callReq)
 	call.framer = framer
@@ -615,7 +1115,7 @@ func (c *Conn) exec(ctx context.Context, req frameWriter, tracer Tracer) (*frame
 		framer.trace()
 	}
 
-	err := req.writeFrame(framer, stream)
+	err = req.writeFrame(framer, stream)
 	if err != nil {
 		// closeWithError will block waiting for this stream to either receive a response
 		// or for us to timeout, close the timeout chan here. Im not entirely sure
@@ -631,7 +1131,7 @@ func (c *Conn) exec(ctx context.Context, req frameWriter, tracer Tracer) (*frame
 	}
 
 	var timeoutCh <-chan time.Time
-	if c.timeout > 0 {
+	if timeout > 0 {
 		if call.timer == nil {
 			call.timer = time.NewTimer(0)
 			<-call.timer.C
@@ -644,7 +1144,7 @@ func (c *Conn) exec(ctx context.Context, req frameWriter, tracer Tracer) (*frame
 			}
 		}
 
-		call.timer.Reset(c.timeout)
+		call.timer.Reset(timeout)
 		timeoutCh = call.timer.C
 	}
 
@@ -702,13 +1202,37 @@ type inflightPrepare struct {
 	wg  sync.WaitGroup
 	err error
 
+	// the statement and where it is prepared, to prepare it again
+	addr      string
+	keyspace  string
+	statement string
+
 	preparedStatment *preparedStatment
 }
 
 func (c *Conn) prepareStatement(ctx context.Context, stmt string, tracer Tracer) (*preparedStatment, error) {
-	stmtCacheKey := c.session.stmtsLRU.keyFor(c.addr, c.currentKeyspace, stmt)
+	return c.prepareStatementKeyspace(ctx, "", stmt, tracer)
+}
+
+// prepareStatementKeyspace prepares stmt in keyspace, which is sent with the
+// prepare request on protocol version 5 and above. The keyspace of the
+// connection is used if keyspace is empty.
+func (c *Conn) prepareStatementKeyspace(ctx context.Context, keyspace, stmt string, tracer Tracer) (*preparedStatment, error) {
+	prep := &writePrepareFrame{
+		statement: stmt,
+		keyspace:  keyspace,
+	}
+	if keyspace == "" {
+		keyspace = c.keyspace()
+	}
+
+	stmtCacheKey := c.session.stmtsLRU.keyFor(c.addr, keyspace, stmt)
 	flight, ok := c.session.stmtsLRU.execIfMissing(stmtCacheKey, func(lru *lru.Cache) *inflightPrepare {
-		flight := new(inflightPrepare)
+		flight := &inflightPrepare{
+			addr:      c.addr,
+			keyspace:  prep.keyspace,
+			statement: stmt,
+		}
 		flight.wg.Add(1)
 		lru.Add(stmtCacheKey, flight)
 		return flight
@@ -719,10 +1243,6 @@ func (c *Conn) prepareStatement(ctx context.Context, stmt string, tracer Tracer)
 		return flight.preparedStatment, flight.err
 	}
 
-	prep := &writePrepareFrame{
-		statement: stmt,
-	}
-
 	framer, err := c.exec(ctx, prep, tracer)
 	if err != nil {
 		flight.err = err
@@ -770,6 +1290,63 @@ func (c *Conn) prepareStatement(ctx context.Context, stmt string, tracer Tracer)
 	return flight.preparedStatment, flight.err
 }
 
+// maxConcurrentReprepares is the number of statements reprepareStatements
+// prepares at once.
+const maxConcurrentReprepares = 8
+
+// reprepareStatements prepares the statements cached for the host of c again,
+// as a node which was restarted has lost the statements prepared on it and
+// executing them would otherwise fail with an unprepared error. The cached
+// statements are dropped before it returns and prepared again in the
+// background, until the connection is closed, any which are executed first
+// are prepared by their queries.
+func (c *Conn) reprepareStatements() {
+	var flights []*inflightPrepare
+	for key, flight := range c.session.stmtsLRU.forAddr(c.addr) {
+		c.session.stmtsLRU.remove(key)
+		flights = append(flights, flight)
+	}
+	if len(flights) == 0 {
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			select {
+			case <-c.quit:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		sem := make(chan struct{}, maxConcurrentReprepares)
+		var wg sync.WaitGroup
+		for _, flight := range flights {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+			}
+			if ctx.Err() != nil {
+				break
+			}
+
+			wg.Add(1)
+			go func(flight *inflightPrepare) {
+				defer wg.Done()
+				defer func() { <-sem }()
+
+				_, err := c.prepareStatementKeyspace(ctx, flight.keyspace, flight.statement, nil)
+				if err != nil && ctx.Err() == nil {
+					Logger.Printf("gocql: unable to prepare %q on %s again: %v\n", flight.statement, c.addr, err)
+				}
+			}(flight)
+		}
+		wg.Wait()
+	}()
+}
+
 func marshalQueryValue(typ TypeInfo, value interface{}, dst *queryValues) error {
 	if named, ok := value.(*namedValue); ok {
 		dst.name = named.name
@@ -790,7 +1367,49 @@ func marshalQueryValue(typ TypeInfo, value interface{}, dst *queryValues) error
 	return nil
 }
 
+// queryKeyspace returns the keyspace a query which sets keyspace, or the
+// keyspace of the connection if empty, is executed against on c.
+func (c *Conn) queryKeyspace(keyspace string) string {
+	if keyspace != "" {
+		return keyspace
+	}
+	return c.keyspace()
+}
+
+// keyspaceConn returns the connection to execute a query which sets keyspace
+// on. It is c unless c uses another keyspace on a protocol version before 5,
+// which can not send the keyspace with the query, then it is the connection of
+// the pool of the host dedicated to keyspace.
+func (c *Conn) keyspaceConn(keyspace string) (*Conn, error) {
+	if keyspace == "" || c.version > protoVersion4 || keyspace == c.keyspace() {
+		return c, nil
+	}
+	if c.host == nil {
+		return nil, ErrNoConnections
+	}
+	pool, ok := c.session.pool.getPool(c.host)
+	if !ok {
+		return nil, ErrNoConnections
+	}
+	return pool.keyspaceConn(keyspace)
+}
+
+// keyspace returns the keyspace the connection is using.
+func (c *Conn) keyspace() string {
+	c.keyspaceMu.Lock()
+	keyspace := c.currentKeyspace
+	c.keyspaceMu.Unlock()
+	return keyspace
+}
+
 func (c *Conn) executeQuery(qry *Query) *Iter {
+	if conn, err := c.keyspaceConn(qry.keyspace); err != nil {
+		return &Iter{err: err}
+	} else if conn != c {
+		return conn.executeQuery(qry)
+	}
+	keyspace := c.queryKeyspace(qry.keyspace)
+
 	params := queryParams{
 		consistency: qry.cons,
 	}
@@ -799,6 +1418,15 @@ func (c *Conn) executeQuery(qry *Query) *Iter {
 	params.serialConsistency = qry.serialCons
 	params.defaultTimestamp = qry.defaultTimestamp
 	params.defaultTimestampValue = qry.defaultTimestampValue
+	qry.lastTimestamp = 0
+	if qry.defaultTimestamp && c.version > protoVersion2 {
+		// the timestamp is generated here rather than when the frame is
+		// written so that it is known to LastTimestamp
+		if params.defaultTimestampValue == 0 {
+			params.defaultTimestampValue = c.session.timestamps.Next()
+		}
+		qry.lastTimestamp = params.defaultTimestampValue
+	}
 
 	if len(qry.pageState) > 0 {
 		params.pagingState = qry.pageState
@@ -806,16 +1434,21 @@ func (c *Conn) executeQuery(qry *Query) *Iter {
 	if qry.pageSize > 0 {
 		params.pageSize = qry.pageSize
 	}
+	if qry.nowInSeconds != 0 {
+		c.session.nowInSecondsIgnored.Do(func() {
+			Logger.Printf("gocql: setting the current time of a query requires protocol version 5, which is not supported, ignoring it\n")
+		})
+	}
 
 	var (
 		frame frameWriter
 		info  *preparedStatment
+		err   error
 	)
 
 	if qry.shouldPrepare() {
 		// Prepare all DML queries. Other queries can not be prepared.
-		var err error
-		info, err = c.prepareStatement(qry.context, qry.stmt, qry.trace)
+		info, err = c.prepareStatementKeyspace(qry.context, keyspace, qry.stmt, qry.trace)
 		if err != nil {
 			return &Iter{err: err}
 		}
@@ -858,13 +1491,21 @@ func (c *Conn) executeQuery(qry *Query) *Iter {
 			params:     params,
 		}
 	} else {
+		if c.version > protoVersion4 {
+			params.keyspace = qry.keyspace
+		}
 		frame = &writeQueryFrame{
 			statement: qry.stmt,
 			params:    params,
 		}
 	}
 
-	framer, err := c.exec(qry.context, frame, qry.trace)
+	timeout := c.timeout
+	if qry.timeout > 0 {
+		timeout = qry.timeout
+	}
+
+	framer, err := c.execTimeout(qry.context, frame, qry.trace, timeout)
 	if err != nil {
 		return &Iter{err: err}
 	}
@@ -926,7 +1567,7 @@ func (c *Conn) executeQuery(qry *Query) *Iter {
 		// is not consistent with regards to its schema.
 		return iter
 	case *RequestErrUnprepared:
-		stmtCacheKey := c.session.stmtsLRU.keyFor(c.addr, c.currentKeyspace, qry.stmt)
+		stmtCacheKey := c.session.stmtsLRU.keyFor(c.addr, keyspace, qry.stmt)
 		if c.session.stmtsLRU.remove(stmtCacheKey) {
 			return c.executeQuery(qry)
 		}
@@ -958,10 +1599,19 @@ func (c *Conn) Address() string {
 }
 
 func (c *Conn) AvailableStreams() int {
-	return c.streams.Available()
+	available := c.streams.Available()
+	if c.requests != nil {
+		if n := cap(c.requests) - len(c.requests); n < available {
+			available = n
+		}
+	}
+	return available
 }
 
 func (c *Conn) UseKeyspace(keyspace string) error {
+	c.keyspaceMu.Lock()
+	defer c.keyspaceMu.Unlock()
+
 	q := &writeQueryFrame{statement: `USE "` + keyspace + `"`}
 	q.params.consistency = Any
 
@@ -1002,6 +1652,12 @@ func (c *Conn) executeBatch(batch *Batch) *Iter {
 		defaultTimestamp:      batch.defaultTimestamp,
 		defaultTimestampValue: batch.defaultTimestampValue,
 	}
+	if req.defaultTimestamp && req.defaultTimestampValue == 0 && c.version > protoVersion2 {
+		req.defaultTimestampValue = c.session.timestamps.Next()
+	}
+
+	// batches are executed against the keyspace of the session
+	keyspace := c.queryKeyspace("")
 
 	stmts := make(map[string]string, len(batch.Entries))
 
@@ -1010,7 +1666,7 @@ func (c *Conn) executeBatch(batch *Batch) *Iter {
 		b := &req.statements[i]
 
 		if len(entry.Args) > 0 || entry.binding != nil {
-			info, err := c.prepareStatement(batch.context, entry.Stmt, nil)
+			info, err := c.prepareStatementKeyspace(batch.context, keyspace, entry.Stmt, nil)
 			if err != nil {
 				return &Iter{err: err}
 			}
@@ -1069,7 +1725,7 @@ func (c *Conn) executeBatch(batch *Batch) *Iter {
 	case *RequestErrUnprepared:
 		stmt, found := stmts[string(x.StatementId)]
 		if found {
-			key := c.session.stmtsLRU.keyFor(c.addr, c.currentKeyspace, stmt)
+			key := c.session.stmtsLRU.keyFor(c.addr, keyspace, stmt)
 			c.session.stmtsLRU.remove(key)
 		}
 
@@ -1113,10 +1769,14 @@ func (c *Conn) query(statement string, values ...interface{}) (iter *Iter) {
 
 func (c *Conn) awaitSchemaAgreement() (err error) {
 	const (
-		peerSchemas  = "SELECT schema_version, peer FROM system.peers"
+		peerSchemas  = "SELECT schema_version, peer, rpc_address FROM system.peers"
 		localSchemas = "SELECT schema_version FROM system.local WHERE key='local'"
 	)
 
+	if c.session.cfg.MaxWaitSchemaAgreement <= 0 {
+		return nil
+	}
+
 	var versions map[string]struct{}
 
 	endDeadline := time.Now().Add(c.session.cfg.MaxWaitSchemaAgreement)
@@ -1126,10 +1786,20 @@ func (c *Conn) awaitSchemaAgreement() (err error) {
 		versions = make(map[string]struct{})
 
 		var schemaVersion string
-		var peer string
-		for iter.Scan(&schemaVersion, &peer) {
+		var peer, rpcAddress net.IP
+		for iter.Scan(&schemaVersion, &peer, &rpcAddress) {
 			if schemaVersion == "" {
-				Logger.Printf("skipping peer entry with empty schema_version: peer=%q", peer)
+				Logger.Printf("skipping peer entry with empty schema_version: peer=%v", peer)
+				continue
+			}
+
+			// nodes which are down will not see the schema change until they
+			// come back up, so do not wait for them to agree
+			host := c.session.ring.getHost(rpcAddress)
+			if host == nil {
+				host = c.session.ring.getHost(peer)
+			}
+			if host != nil && !host.IsUp() {
 				continue
 			}
 
@@ -1181,6 +1851,9 @@ func (c *Conn) localHostInfo() (*HostInfo, error) {
 	}
 
 	port := c.conn.RemoteAddr().(*net.TCPAddr).Port
+	if c.cfg.ProxyEndpoint != "" {
+		port = c.host.Port()
+	}
 
 	// TODO(zariel): avoid doing this here
 	host, err := c.session.hostInfoFromMap(row, port)
@@ -15,6 +15,10 @@ import (
 	"io"
 	"io/ioutil"
 	"net"
+	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -78,6 +82,34 @@ func TestSimple(t *testing.T) {
 	}
 }
 
+func TestQueryHost(t *testing.T) {
+	srv := NewTestServer(t, defaultProto, context.Background())
+	defer srv.Stop()
+
+	cluster := testCluster(srv.Address, defaultProto)
+	// failed attempts should still report their host once retries run out
+	cluster.RetryPolicy = &SimpleRetryPolicy{NumRetries: 1}
+
+	db, err := cluster.CreateSession()
+	if err != nil {
+		t.Fatalf("NewCluster: %v", err)
+	}
+	defer db.Close()
+
+	exp := srv.host()
+	for _, stmt := range []string{"void", "kill"} {
+		iter := db.Query(stmt).Iter()
+		host := iter.Host()
+		iter.Close()
+
+		if host == nil {
+			t.Errorf("%s: expected iter to have a host", stmt)
+		} else if !host.ConnectAddress().Equal(exp.ConnectAddress()) || host.Port() != exp.Port() {
+			t.Errorf("%s: expected host %v:%d got %v:%d", stmt, exp.ConnectAddress(), exp.Port(), host.ConnectAddress(), host.Port())
+		}
+	}
+}
+
 func TestSSLSimple(t *testing.T) {
 	srv := NewSSLTestServer(t, defaultProto, context.Background())
 	defer srv.Stop()
@@ -106,6 +138,39 @@ func TestSSLSimpleNoClientCert(t *testing.T) {
 	}
 }
 
+func TestSSLSessionResumption(t *testing.T) {
+	srv := NewSSLTestServer(t, defaultProto, context.Background())
+	defer srv.Stop()
+
+	cluster := createTestSslCluster(srv.Address, defaultProto, true)
+	cluster.NumConns = 1
+	// TLS sessions are not resumed once the certificate of the server has
+	// expired, as the test certificates have
+	cluster.SslOpts.Config = &tls.Config{
+		Time: func() time.Time { return time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC) },
+	}
+	db, err := cluster.CreateSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if handshakes, resumed := db.TLSHandshakes(); handshakes != 1 || resumed != 0 {
+		t.Fatalf("expected a single full handshake got %d handshakes and %d resumed", handshakes, resumed)
+	}
+
+	// the second connection to the host resumes the TLS session of the first
+	conn, err := db.connect(context.Background(), srv.host(), connErrorHandlerFn(func(*Conn, error, bool) {}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if handshakes, resumed := db.TLSHandshakes(); handshakes != 2 || resumed != 1 {
+		t.Fatalf("expected the second connection to resume the TLS session got %d handshakes and %d resumed", handshakes, resumed)
+	}
+}
+
 func createTestSslCluster(addr string, proto protoVersion, useClientCert bool) *ClusterConfig {
 	cluster := testCluster(addr, proto)
 	sslOpts := &SslOptions{
@@ -200,6 +265,33 @@ func TestDNSLookupError(t *testing.T) {
 	}
 }
 
+func TestNowInSecondsIgnoredWarnsOnce(t *testing.T) {
+	log := &testLogger{}
+	Logger = log
+	defer func() {
+		Logger = &defaultLogger{}
+	}()
+
+	srv := NewTestServer(t, defaultProto, context.Background())
+	defer srv.Stop()
+
+	db, err := newTestSession(srv.Address, defaultProto)
+	if err != nil {
+		t.Fatalf("NewCluster: %v", err)
+	}
+	defer db.Close()
+
+	for i := 0; i < 3; i++ {
+		if err := db.Query("void").NowInSeconds(1500000000).Exec(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := strings.Count(log.String(), "setting the current time of a query"); n != 1 {
+		t.Fatalf("expected the ignored time to be logged once got %d times: %q", n, log.String())
+	}
+}
+
 func TestStartupTimeout(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	log := &testLogger{}
@@ -244,6 +336,181 @@ func TestStartupTimeout(t *testing.T) {
 	cancel()
 }
 
+func TestConnectTimeoutSlowStartup(t *testing.T) {
+	srv := NewTestServer(t, defaultProto, context.Background())
+	defer srv.Stop()
+	srv.startupDelay = 100 * time.Millisecond
+
+	// the startup taking longer than the query timeout does not fail the
+	// connection within the connect timeout
+	cluster := testCluster(srv.Address, defaultProto)
+	cluster.Timeout = 20 * time.Millisecond
+	cluster.ConnectTimeout = time.Second
+	s, err := cluster.CreateSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	conn, err := s.connect(context.Background(), srv.host(), connErrorHandlerFn(func(*Conn, error, bool) {}))
+	if err != nil {
+		t.Fatalf("expected the slow startup to complete within the connect timeout: %v", err)
+	}
+	conn.Close()
+
+	// past the connect timeout the connection fails, however long the query
+	// timeout is
+	cfg := *s.connCfg
+	cfg.Timeout = 5 * time.Second
+	cfg.ConnectTimeout = 50 * time.Millisecond
+	start := time.Now()
+	if conn, err := s.dial(context.Background(), srv.host(), &cfg, connErrorHandlerFn(func(*Conn, error, bool) {})); err == nil {
+		conn.Close()
+		t.Fatal("expected the startup to time out past the connect timeout")
+	}
+	if took := time.Since(start); took >= srv.startupDelay {
+		t.Fatalf("expected the connection to fail at the connect timeout, took %v", took)
+	}
+}
+
+func TestMinReadyHosts(t *testing.T) {
+	Logger = &testLogger{}
+	defer func() {
+		Logger = &defaultLogger{}
+	}()
+
+	srv := NewTestServer(t, defaultProto, context.Background())
+	defer srv.Stop()
+
+	// the first connection attempts time out until the server starts
+	// responding to startup frames
+	const delay = 100 * time.Millisecond
+	atomic.StoreInt32(&srv.TimeoutOnStartup, 1)
+	time.AfterFunc(delay, func() {
+		atomic.StoreInt32(&srv.TimeoutOnStartup, 0)
+	})
+
+	cluster := testCluster(srv.Address, defaultProto)
+	cluster.ConnectTimeout = 20 * time.Millisecond
+	// connect once per attempt to fill the pool so that the host is retried
+	// while waiting for it to be ready
+	cluster.ReconnectionPolicy = &ConstantReconnectionPolicy{MaxRetries: 1}
+	cluster.MinReadyHosts = 1
+	cluster.ReadyTimeout = 5 * time.Second
+
+	start := time.Now()
+	db, err := cluster.CreateSession()
+	if err != nil {
+		t.Fatalf("NewCluster: %v", err)
+	}
+	defer db.Close()
+
+	if elapsed := time.Since(start); elapsed < delay {
+		t.Fatalf("expected CreateSession to wait for the host to be ready, returned after %v", elapsed)
+	}
+	if db.pool.Size() == 0 {
+		t.Fatal("expected the session to have connections")
+	}
+	if err := db.Query("void").Exec(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the connections still being attempted log their errors, which must
+	// happen before the logger is restored
+	pool, ok := db.pool.getPool(srv.host())
+	if !ok {
+		t.Fatal("expected the host to have a pool")
+	}
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(10 * time.Millisecond) {
+		pool.mu.RLock()
+		filling := pool.filling
+		pool.mu.RUnlock()
+		if !filling {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatal("expected the pool to finish filling")
+		}
+	}
+}
+
+func TestMinReadyHostsTimeout(t *testing.T) {
+	Logger = &testLogger{}
+	defer func() {
+		Logger = &defaultLogger{}
+	}()
+
+	srv := NewTestServer(t, defaultProto, context.Background())
+	defer srv.Stop()
+
+	cluster := testCluster(srv.Address, defaultProto)
+	// the second host is unreachable so the minimum is never reached
+	cluster.Hosts = append(cluster.Hosts, "127.0.0.2:1")
+	cluster.MinReadyHosts = 2
+	cluster.ReadyTimeout = 100 * time.Millisecond
+
+	start := time.Now()
+	_, err := cluster.CreateSession()
+	if err == nil {
+		t.Fatal("expected CreateSession to fail waiting for hosts")
+	} else if !strings.Contains(err.Error(), "gocql: only 1 of the 2 required hosts are ready") {
+		t.Fatalf("expected an error about the ready hosts got %v", err)
+	} else if !strings.Contains(err.Error(), "unable to connect to 127.0.0.2:1") {
+		t.Fatalf("expected the error to list the unreachable host got %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed < cluster.ReadyTimeout {
+		t.Fatalf("expected CreateSession to wait %v for hosts, returned after %v", cluster.ReadyTimeout, elapsed)
+	}
+}
+
+func TestMinReadyHostsUnreachable(t *testing.T) {
+	Logger = &testLogger{}
+	defer func() {
+		Logger = &defaultLogger{}
+	}()
+
+	srv := NewTestServer(t, defaultProto, context.Background())
+	defer srv.Stop()
+
+	// one reachable host is enough
+	cluster := testCluster(srv.Address, defaultProto)
+	cluster.Hosts = append(cluster.Hosts, "127.0.0.2:1")
+	cluster.MinReadyHosts = 1
+	cluster.ReadyTimeout = time.Second
+
+	db, err := cluster.CreateSession()
+	if err != nil {
+		t.Fatalf("NewCluster: %v", err)
+	}
+	defer db.Close()
+
+	if err := db.Query("void").Exec(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCreateSessionUnreachableHosts(t *testing.T) {
+	Logger = &testLogger{}
+	defer func() {
+		Logger = &defaultLogger{}
+	}()
+
+	cluster := NewCluster("127.0.0.1:1", "127.0.0.2:1")
+	cluster.ProtoVersion = int(defaultProto)
+	cluster.ConnectTimeout = 100 * time.Millisecond
+
+	_, err := cluster.CreateSession()
+	if err == nil {
+		t.Fatal("expected CreateSession to fail without any reachable hosts")
+	}
+	// the error lists every host which could not be connected to
+	for _, addr := range cluster.Hosts {
+		if !strings.Contains(err.Error(), addr+": ") {
+			t.Errorf("expected the error to contain host %s got %v", addr, err)
+		}
+	}
+}
+
 func TestTimeout(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 
@@ -320,300 +587,3204 @@ func TestQueryRetry(t *testing.T) {
 	}
 }
 
-func TestStreams_Protocol1(t *testing.T) {
-	srv := NewTestServer(t, protoVersion1, context.Background())
-	defer srv.Stop()
+func TestQueryRetryErrorCode(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-	// TODO: these are more like session tests and should instead operate
-	// on a single Conn
-	cluster := testCluster(srv.Address, protoVersion1)
-	cluster.NumConns = 1
-	cluster.ProtoVersion = 1
+	srv := NewTestServer(t, defaultProto, ctx)
+	defer srv.Stop()
 
-	db, err := cluster.CreateSession()
+	db, err := newTestSession(srv.Address, defaultProto)
 	if err != nil {
-		t.Fatal(err)
+		t.Fatalf("NewCluster: %v", err)
 	}
 	defer db.Close()
 
-	var wg sync.WaitGroup
-	for i := 1; i < 128; i++ {
-		// here were just validating that if we send NumStream request we get
-		// a response for every stream and the lengths for the queries are set
-		// correctly.
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			if err := db.Query("void").Exec(); err != nil {
-				t.Error(err)
+	// kill is answered with an overloaded error
+	cases := []struct {
+		retryType RetryType
+		requests  int64
+	}{
+		{Rethrow, 1},
+		{Retry, 3},
+	}
+
+	for _, c := range cases {
+		atomic.StoreInt64(&srv.nKillReq, 0)
+
+		rt := &ErrorCodeRetryPolicy{
+			RetryPolicy: &SimpleRetryPolicy{NumRetries: 2},
+			RetryTypes:  map[int]RetryType{ErrCodeOverloaded: c.retryType},
+		}
+
+		err := db.Query("kill").RetryPolicy(rt).Exec()
+		if reqErr, ok := err.(RequestError); !ok || reqErr.Code() != ErrCodeOverloaded {
+			t.Fatalf("expected overloaded error got %v", err)
+		}
+
+		if requests := atomic.LoadInt64(&srv.nKillReq); requests != c.requests {
+			t.Fatalf("retry type %v: expected %d requests got %d", c.retryType, c.requests, requests)
+		}
+	}
+}
+
+func TestBatchDefaultTimestamp(t *testing.T) {
+	for _, proto := range []uint8{protoVersion2, protoVersion3, protoVersion4} {
+		srv := NewTestServer(t, proto, context.Background())
+		defer srv.Stop()
+
+		db, err := newTestSession(srv.Address, protoVersion(proto))
+		if err != nil {
+			t.Fatalf("NewCluster: %v", err)
+		}
+		defer db.Close()
+
+		const stmt = "UPDATE tbl SET v1 = 0 WHERE v0 = 0"
+		before := time.Now().UnixNano() / 1000
+		for _, b := range []*Batch{
+			db.NewBatch(LoggedBatch).WithTimestamp(42),
+			db.NewBatch(LoggedBatch).DefaultTimestamp(true),
+			db.NewBatch(LoggedBatch).DefaultTimestamp(false),
+		} {
+			b.Query(stmt)
+			b.Query(stmt)
+			if err := db.ExecuteBatch(b); err != nil {
+				t.Fatal(err)
 			}
-		}()
+		}
+
+		srv.mu.Lock()
+		timestamps := srv.batchTimestamps
+		srv.mu.Unlock()
+		if len(timestamps) != 3 {
+			t.Fatalf("proto %d: expected 3 batches got %d", proto, len(timestamps))
+		}
+		if proto == protoVersion2 {
+			// the timestamp is only sent on protocol version 3 and above
+			if !reflect.DeepEqual(timestamps, []int64{0, 0, 0}) {
+				t.Fatalf("proto %d: expected no timestamps got %v", proto, timestamps)
+			}
+			continue
+		}
+		if timestamps[0] != 42 {
+			t.Errorf("proto %d: expected the batch to carry the timestamp 42 got %d", proto, timestamps[0])
+		}
+		if now := time.Now().UnixNano() / 1000; timestamps[1] < before || timestamps[1] > now {
+			t.Errorf("proto %d: expected the client timestamp to be between %d and %d got %d", proto, before, now, timestamps[1])
+		}
+		if timestamps[2] != 0 {
+			t.Errorf("proto %d: expected no timestamp once disabled got %d", proto, timestamps[2])
+		}
 	}
-	wg.Wait()
 }
 
-func TestStreams_Protocol3(t *testing.T) {
+func TestClusterTimestampGenerator(t *testing.T) {
 	srv := NewTestServer(t, protoVersion3, context.Background())
 	defer srv.Stop()
 
-	// TODO: these are more like session tests and should instead operate
-	// on a single Conn
+	var generated int64
 	cluster := testCluster(srv.Address, protoVersion3)
-	cluster.NumConns = 1
-	cluster.ProtoVersion = 3
-
+	cluster.TimestampGenerator = TimestampGeneratorFunc(func() int64 {
+		return atomic.AddInt64(&generated, 1)
+	})
 	db, err := cluster.CreateSession()
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer db.Close()
 
-	for i := 1; i < 32768; i++ {
-		// the test server processes each conn synchronously
-		// here were just validating that if we send NumStream request we get
-		// a response for every stream and the lengths for the queries are set
-		// correctly.
-		if err = db.Query("void").Exec(); err != nil {
-			t.Fatal(err)
-		}
+	// queries and batches without a timestamp use the generator
+	qry := db.Query("void")
+	if err := qry.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	want := atomic.LoadInt64(&generated)
+	if ts := qry.LastTimestamp(); ts == 0 || ts != want {
+		t.Fatalf("expected the generated timestamp %d got %d", want, ts)
+	}
+
+	batch := db.NewBatch(LoggedBatch)
+	batch.Query("UPDATE tbl SET v1 = 0 WHERE v0 = 0")
+	if err := db.ExecuteBatch(batch); err != nil {
+		t.Fatal(err)
+	}
+	want = atomic.LoadInt64(&generated)
+	srv.mu.Lock()
+	timestamps := srv.batchTimestamps
+	srv.mu.Unlock()
+	if len(timestamps) != 1 || timestamps[0] != want {
+		t.Fatalf("expected the batch to be sent the generated timestamp %d got %v", want, timestamps)
 	}
 }
 
-func BenchmarkProtocolV3(b *testing.B) {
-	srv := NewTestServer(b, protoVersion3, context.Background())
+func TestQueryLastTimestamp(t *testing.T) {
+	srv := NewTestServer(t, protoVersion3, context.Background())
 	defer srv.Stop()
 
-	// TODO: these are more like session tests and should instead operate
-	// on a single Conn
-	cluster := NewCluster(srv.Address)
-	cluster.NumConns = 1
-	cluster.ProtoVersion = 3
-
-	db, err := cluster.CreateSession()
+	db, err := newTestSession(srv.Address, protoVersion3)
 	if err != nil {
-		b.Fatal(err)
+		t.Fatalf("NewCluster: %v", err)
 	}
 	defer db.Close()
 
-	b.ResetTimer()
-	b.ReportAllocs()
-	for i := 0; i < b.N; i++ {
-		if err = db.Query("void").Exec(); err != nil {
-			b.Fatal(err)
+	qry := db.Query("void")
+	if ts := qry.LastTimestamp(); ts != 0 {
+		t.Fatalf("expected no timestamp before the query is executed got %d", ts)
+	}
+
+	// the generated timestamps increase even if the queries are executed
+	// within the same microsecond
+	before := time.Now().UnixNano() / 1000
+	var last int64
+	for i := 0; i < 100; i++ {
+		if err := qry.Exec(); err != nil {
+			t.Fatal(err)
 		}
+		ts := qry.LastTimestamp()
+		if ts < before || ts <= last {
+			t.Fatalf("expected a timestamp after %d got %d", last, ts)
+		}
+		last = ts
+	}
+
+	if err := qry.WithTimestamp(42).Exec(); err != nil {
+		t.Fatal(err)
+	} else if ts := qry.LastTimestamp(); ts != 42 {
+		t.Fatalf("expected the timestamp set on the query got %d", ts)
+	}
+	if err := qry.DefaultTimestamp(false).Exec(); err != nil {
+		t.Fatal(err)
+	} else if ts := qry.LastTimestamp(); ts != 0 {
+		t.Fatalf("expected no timestamp without the default timestamp got %d", ts)
 	}
 }
 
-// This tests that the policy connection pool handles SSL correctly
-func TestPolicyConnPoolSSL(t *testing.T) {
-	srv := NewSSLTestServer(t, defaultProto, context.Background())
+func TestBatchPreparedAndSimple(t *testing.T) {
+	const proto = protoVersion3
+
+	srv := NewTestServer(t, proto, context.Background())
 	defer srv.Stop()
 
-	cluster := createTestSslCluster(srv.Address, defaultProto, true)
-	cluster.PoolConfig.HostSelectionPolicy = RoundRobinHostPolicy()
+	db, err := newTestSession(srv.Address, proto)
+	if err != nil {
+		t.Fatalf("NewCluster: %v", err)
+	}
+	defer db.Close()
+
+	const (
+		insert = "INSERT INTO tbl (v0, v1) VALUES (?, ?)"
+		update = "UPDATE tbl SET v1 = ? WHERE v0 = ?"
+		simple = "UPDATE tbl SET v1 = 0 WHERE v0 = 0"
+	)
+
+	b := db.NewBatch(UnloggedBatch)
+	b.Query(insert, 1, 2)
+	b.Query(simple)
+	b.Query(insert, 3, 4)
+	b.Bind(update, func(q *QueryInfo) ([]interface{}, error) {
+		if len(q.Args) != 2 {
+			return nil, fmt.Errorf("expected 2 args got %d", len(q.Args))
+		}
+		return []interface{}{5, 6}, nil
+	})
+	if err := db.ExecuteBatch(b); err != nil {
+		t.Fatal(err)
+	}
+
+	// each unique statement is prepared once and its id reused across entries
+	if n := atomic.LoadInt64(&srv.nPrepareReq); n != 2 {
+		t.Fatalf("expected 2 statements to be prepared got %d", n)
+	}
+
+	srv.mu.Lock()
+	batches := srv.batches
+	srv.mu.Unlock()
+	if len(batches) != 1 {
+		t.Fatalf("expected 1 batch got %d", len(batches))
+	}
+
+	values := func(vals ...int) [][]byte {
+		out := make([][]byte, len(vals))
+		for i, v := range vals {
+			out[i] = encInt(int32(v))
+		}
+		return out
+	}
+	expected := []testBatchStatement{
+		{prepared: true, stmt: insert, values: values(1, 2)},
+		{prepared: false, stmt: simple, values: values()},
+		{prepared: true, stmt: insert, values: values(3, 4)},
+		{prepared: true, stmt: update, values: values(5, 6)},
+	}
+	if !reflect.DeepEqual(batches[0], expected) {
+		t.Fatalf("expected batch statements %v got %v", expected, batches[0])
+	}
+}
+
+func TestSlowQueryThreshold(t *testing.T) {
+	log := &testLogger{}
+	Logger = log
+	defer func() {
+		Logger = &defaultLogger{}
+	}()
+
+	srv := NewTestServer(t, defaultProto, context.Background())
+	defer srv.Stop()
 
+	cluster := testCluster(srv.Address, defaultProto)
+	// slow is answered after 50ms
+	cluster.SlowQueryThreshold = 25 * time.Millisecond
+	cluster.PoolConfig.HostSelectionPolicy = LatencyAwareHostPolicy(RoundRobinHostPolicy())
 	db, err := cluster.CreateSession()
 	if err != nil {
-		t.Fatalf("failed to create new session: %v", err)
+		t.Fatalf("NewCluster: %v", err)
+	}
+	defer db.Close()
+
+	if err := db.Query("void", "secret").Exec(); err != nil {
+		t.Fatal(err)
+	}
+	if s := log.String(); strings.Contains(s, "slow query") {
+		t.Fatalf("expected fast query not to be logged got %q", s)
+	}
+
+	if err := db.Query("slow", "secret", 1).Exec(); err != nil {
+		t.Fatal(err)
+	}
+	s := log.String()
+	if !strings.Contains(s, "gocql: slow query took") {
+		t.Fatalf("expected slow query to be logged got %q", s)
+	}
+	if !strings.Contains(s, `"slow"`) || !strings.Contains(s, "args=[string, int]") {
+		t.Fatalf("expected statement and args summary to be logged got %q", s)
+	}
+	if !strings.Contains(s, srv.Address) {
+		t.Fatalf("expected host %s to be logged got %q", srv.Address, s)
+	}
+	if strings.Contains(s, "secret") {
+		t.Fatalf("expected bound values to be redacted got %q", s)
+	}
+
+	// the latency of each attempt is tracked on the host for the latency
+	// aware policy
+	host := db.ring.getHost(srv.host().ConnectAddress())
+	if latency, measured, _ := host.latencyStats(); measured != 2 {
+		t.Fatalf("expected 2 latency measurements got %d", measured)
+	} else if latency <= 0 {
+		t.Fatalf("expected a positive average latency got %v", latency)
+	}
+}
+
+func TestQueryLatencyNotMeasured(t *testing.T) {
+	srv := NewTestServer(t, defaultProto, context.Background())
+	defer srv.Stop()
+
+	db, err := newTestSession(srv.Address, defaultProto)
+	if err != nil {
+		t.Fatalf("NewCluster: %v", err)
 	}
+	defer db.Close()
 
 	if err := db.Query("void").Exec(); err != nil {
-		t.Fatalf("query failed due to error: %v", err)
+		t.Fatal(err)
 	}
-	db.Close()
 
-	// wait for the pool to drain
-	time.Sleep(100 * time.Millisecond)
-	size := db.pool.Size()
-	if size != 0 {
-		t.Fatalf("connection pool did not drain, still contains %d connections", size)
+	// the default policy does not use the latency so it is not measured
+	host := db.ring.getHost(srv.host().ConnectAddress())
+	if _, measured, _ := host.latencyStats(); measured != 0 {
+		t.Fatalf("expected no latency measurements got %d", measured)
 	}
 }
 
-func TestQueryTimeout(t *testing.T) {
+func TestQueryHostInFlight(t *testing.T) {
 	srv := NewTestServer(t, defaultProto, context.Background())
 	defer srv.Stop()
 
-	cluster := testCluster(srv.Address, defaultProto)
-	// Set the timeout arbitrarily low so that the query hits the timeout in a
-	// timely manner.
-	cluster.Timeout = 1 * time.Millisecond
+	db, err := newTestSession(srv.Address, defaultProto)
+	if err != nil {
+		t.Fatalf("NewCluster: %v", err)
+	}
+	defer db.Close()
+
+	host := db.ring.getHost(srv.host().ConnectAddress())
+
+	// the test server takes 50ms to respond to a slow query
+	done := make(chan error, 1)
+	go func() {
+		done <- db.Query("slow").Exec()
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for host.InFlight() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the slow query to be in flight to the host")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if n := host.InFlight(); n != 0 {
+		t.Fatalf("expected no queries in flight after the query finished got %d", n)
+	}
+}
+
+func TestSessionSupportedOptions(t *testing.T) {
+	srv := NewTestServer(t, defaultProto, context.Background())
+	defer srv.Stop()
+
+	db, err := newTestSession(srv.Address, defaultProto)
+	if err != nil {
+		t.Fatalf("NewCluster: %v", err)
+	}
+	defer db.Close()
 
+	// each connection asks for the options before starting up
+	if n := atomic.LoadInt64(&srv.nOptionsReq); n == 0 {
+		t.Fatal("expected an OPTIONS request when connecting")
+	}
+
+	expected := map[string][]string{
+		"CQL_VERSION":       {"3.4.4"},
+		"COMPRESSION":       {"snappy", "lz4"},
+		"PROTOCOL_VERSIONS": {"3/v3", "4/v4"},
+	}
+	options := db.SupportedOptions()
+	if !reflect.DeepEqual(options, expected) {
+		t.Fatalf("expected supported options %v got %v", expected, options)
+	}
+	// the options returned are a copy
+	options["CQL_VERSION"][0] = "changed"
+	if v := db.SupportedOptions()["CQL_VERSION"]; v[0] != "3.4.4" {
+		t.Fatalf("expected the supported options not to be modified got %v", v)
+	}
+
+	// the version is unknown without host lookup
+	if v := db.ServerVersion(); v.Major != 0 {
+		t.Fatalf("expected no server version got %v", v)
+	}
+	db.ring.getHost(srv.host().ConnectAddress()).setVersion(3, 11, 2)
+	db.ring.addHost(&HostInfo{connectAddress: net.IPv4(127, 0, 0, 2), port: 9042, version: cassVersion{3, 0, 15}})
+	db.ring.addHost(&HostInfo{connectAddress: net.IPv4(127, 0, 0, 3), port: 9042})
+	if v := db.ServerVersion(); v != (cassVersion{3, 0, 15}) {
+		t.Fatalf("expected the lowest server version v3.0.15 got %v", v)
+	}
+}
+
+func TestReprepareOnNodeUp(t *testing.T) {
+	srv := NewTestServer(t, defaultProto, context.Background())
+	defer srv.Stop()
+
+	cluster := testCluster(srv.Address, defaultProto)
+	cluster.NumConns = 1
 	db, err := cluster.CreateSession()
 	if err != nil {
 		t.Fatalf("NewCluster: %v", err)
 	}
 	defer db.Close()
 
-	ch := make(chan error, 1)
+	// more statements than are prepared again at once
+	const stmts = 2*maxConcurrentReprepares + 1
+	for i := 0; i < stmts; i++ {
+		if err := db.Query(fmt.Sprintf("INSERT INTO t%d (v) VALUES (?)", i), 1).Exec(); err != nil {
+			t.Fatal(err)
+		}
+	}
 
-	go func() {
-		err := db.Query("timeout").Exec()
-		if err != nil {
-			ch <- err
-			return
+	if n := atomic.LoadInt64(&srv.nPrepareReq); n != stmts {
+		t.Fatalf("expected the %d statements to be prepared once got %d prepares", stmts, n)
+	}
+
+	// the node restarts, losing its prepared statements
+	host := srv.host()
+	db.handleNodeDown(host.ConnectAddress(), host.Port(), StateChangeConnection)
+	srv.forgetPrepared()
+	db.handleNodeUp(host.ConnectAddress(), host.Port(), false, StateChangeReconnect)
+
+	// the statements are prepared again in the background
+	for deadline := time.Now().Add(time.Second); atomic.LoadInt64(&srv.nPrepareReq) != 2*stmts; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the %d statements to be prepared again when the node came up, got %d prepares", stmts, atomic.LoadInt64(&srv.nPrepareReq)-stmts)
 		}
-		t.Errorf("err was nil, expected to get a timeout after %v", db.cfg.Timeout)
-	}()
+	}
+	for i := 0; i < stmts; i++ {
+		if err := db.Query(fmt.Sprintf("INSERT INTO t%d (v) VALUES (?)", i), 2).Exec(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := atomic.LoadInt64(&srv.nUnpreparedReq); n != 0 {
+		t.Fatalf("expected no unprepared errors got %d", n)
+	}
+}
 
-	select {
-	case err := <-ch:
-		if err != ErrTimeoutNoResponse {
-			t.Fatalf("expected to get %v for timeout got %v", ErrTimeoutNoResponse, err)
+func TestQueryPrepared(t *testing.T) {
+	srv := NewTestServer(t, protoVersion4, context.Background())
+	defer srv.Stop()
+	srv.pkIndexes = []uint16{1, 0}
+	// statements of system.peers_v2 are prepared with its result columns
+	srv.peersV2 = true
+
+	db, err := newTestSession(srv.Address, protoVersion4)
+	if err != nil {
+		t.Fatalf("NewCluster: %v", err)
+	}
+	defer db.Close()
+
+	qry := db.Query("SELECT * FROM system.peers_v2 WHERE v0 = ? AND v1 = ?")
+	meta, err := qry.Prepared()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, param := range meta.Args {
+		if param.Keyspace != "ks" || param.Table != "tbl" || param.Name != fmt.Sprintf("v%d", i) || param.TypeInfo.Type() != TypeInt {
+			t.Errorf("expected bind marker %d to be the int ks.tbl.v%d got %v", i, i, param)
 		}
-	case <-time.After(10*time.Millisecond + db.cfg.Timeout):
-		// ensure that the query goroutines have been scheduled
-		t.Fatalf("query did not timeout after %v", db.cfg.Timeout)
+	}
+	if len(meta.Args) != 2 {
+		t.Fatalf("expected 2 bind markers got %v", meta.Args)
+	}
+	if !reflect.DeepEqual(meta.PKeyColumns, []int{1, 0}) {
+		t.Fatalf("expected the partition key indexes [1 0] got %v", meta.PKeyColumns)
+	}
+
+	if len(meta.Rval) != len(testPeerV2) {
+		t.Fatalf("expected %d result columns got %v", len(testPeerV2), meta.Rval)
+	}
+	for i, col := range meta.Rval {
+		if col.Keyspace != "system" || col.Table != "peers_v2" || col.Name != testPeerV2[i].name || col.TypeInfo.Type() != testPeerV2[i].typ {
+			t.Errorf("expected result column %d to be the %v system.peers_v2.%s got %v", i, testPeerV2[i].typ, testPeerV2[i].name, col)
+		}
+	}
+
+	// the metadata is cached with the prepared statement, and modifying it
+	// does not change the cache
+	meta.Args[0].Name = "changed"
+	meta, err = qry.Prepared()
+	if err != nil {
+		t.Fatal(err)
+	} else if meta.Args[0].Name != "v0" {
+		t.Fatalf("expected the cached metadata not to be modified got %v", meta.Args[0])
+	}
+	if n := atomic.LoadInt64(&srv.nPrepareReq); n != 1 {
+		t.Fatalf("expected the statement to be prepared once got %d", n)
 	}
 }
 
-func BenchmarkSingleConn(b *testing.B) {
-	srv := NewTestServer(b, 3, context.Background())
+func TestQueryRoutingKeyFromPrepared(t *testing.T) {
+	srv := NewTestServer(t, protoVersion4, context.Background())
 	defer srv.Stop()
+	// the partition key is (v1, v0)
+	srv.pkIndexes = []uint16{1, 0}
 
-	cluster := testCluster(srv.Address, 3)
-	// Set the timeout arbitrarily low so that the query hits the timeout in a
-	// timely manner.
-	cluster.Timeout = 500 * time.Millisecond
+	db, err := newTestSession(srv.Address, protoVersion4)
+	if err != nil {
+		t.Fatalf("NewCluster: %v", err)
+	}
+	defer db.Close()
+
+	qry := db.Query("SELECT v FROM t WHERE v0 = ? AND v1 = ?", 7, 3)
+	key, err := qry.GetRoutingKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the routing key is computed from the bound values without the
+	// table metadata
+	v0, _ := Marshal(NativeType{proto: 4, typ: TypeInt}, 7)
+	v1, _ := Marshal(NativeType{proto: 4, typ: TypeInt}, 3)
+	if exp := CompositeRoutingKey(v1, v0); !bytes.Equal(key, exp) {
+		t.Fatalf("expected routing key % X got % X", exp, key)
+	}
+
+	// the query is routed to the replica owning the token of the key
+	tok := int64(murmur3Partitioner{}.Hash(key).(murmur3Token))
+	hosts := [...]*HostInfo{
+		{connectAddress: net.IPv4(10, 0, 0, 1), tokens: []string{strconv.FormatInt(tok-100, 10)}},
+		{connectAddress: net.IPv4(10, 0, 0, 2), tokens: []string{strconv.FormatInt(tok+10, 10)}},
+		{connectAddress: net.IPv4(10, 0, 0, 3), tokens: []string{strconv.FormatInt(tok+100, 10)}},
+	}
+	policy := TokenAwareHostPolicy(RoundRobinHostPolicy())
+	for _, host := range hosts {
+		policy.AddHost(host)
+	}
+	policy.SetPartitioner("Murmur3Partitioner")
+
+	if actual := policy.Pick(qry)(); !actual.Info().ConnectAddress().Equal(hosts[1].ConnectAddress()) {
+		t.Fatalf("expected replica %s got %s", hosts[1].ConnectAddress(), actual.Info().ConnectAddress())
+	}
+}
+
+func TestProxyEndpoint(t *testing.T) {
+	srv := NewSSLTestServer(t, defaultProto, context.Background())
+	defer srv.Stop()
+
+	// the proxy routes by the TLS server name
+	cluster := testCluster("127.0.0.5:9042", defaultProto)
+	cluster.ProxyEndpoint = srv.Address
+	if _, err := cluster.CreateSession(); err == nil {
+		t.Fatal("expected ProxyEndpoint without SslOpts to fail")
+	}
+
+	// the hosts are not reachable, only through the proxy
+	cluster = createTestSslCluster("127.0.0.5:9042", defaultProto, true)
+	cluster.Hosts = append(cluster.Hosts, "127.0.0.6:9042")
+	cluster.ProxyEndpoint = srv.Address
 	cluster.NumConns = 1
+	cluster.PoolConfig.HostSelectionPolicy = TokenAwareHostPolicy(RoundRobinHostPolicy())
 	db, err := cluster.CreateSession()
 	if err != nil {
-		b.Fatalf("NewCluster: %v", err)
+		t.Fatalf("NewCluster: %v", err)
 	}
 	defer db.Close()
 
-	b.ResetTimer()
-	b.RunParallel(func(pb *testing.PB) {
-		for pb.Next() {
-			err := db.Query("void").Exec()
-			if err != nil {
-				b.Error(err)
-				return
+	for _, addr := range []string{"127.0.0.5", "127.0.0.6"} {
+		pool, ok := db.pool.getPool(db.ring.getHost(net.ParseIP(addr)))
+		if !ok {
+			t.Fatalf("expected a pool for the host %s", addr)
+		}
+		conn := pool.Pick()
+		if conn == nil {
+			t.Fatalf("expected a connection to the host %s", addr)
+		} else if remote := conn.conn.RemoteAddr().String(); remote != srv.Address {
+			t.Fatalf("expected the connection to %s to be dialled to the proxy %s got %s", addr, srv.Address, remote)
+		} else if local := conn.Address(); local != addr+":9042" {
+			t.Fatalf("expected the connection to be identified by its host %s:9042 got %s", addr, local)
+		}
+
+		if err := db.Query("void").SetHost(addr).Exec(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// each connection names its host to the proxy, by its host:port as the
+	// host IDs of the contact points are not known
+	srv.mu.Lock()
+	names := append([]string(nil), srv.serverNames...)
+	srv.mu.Unlock()
+	sort.Strings(names)
+	if exp := []string{"127.0.0.5:9042", "127.0.0.6:9042"}; !reflect.DeepEqual(names, exp) {
+		t.Fatalf("expected the TLS server names %v got %v", exp, names)
+	}
+
+	// the token ring is made of the logical hosts
+	for i, addr := range []string{"127.0.0.5", "127.0.0.6"} {
+		host := db.ring.getHost(net.ParseIP(addr))
+		host.mu.Lock()
+		host.tokens = []string{strconv.Itoa(i*200 - 100)}
+		host.mu.Unlock()
+	}
+	policy := cluster.PoolConfig.HostSelectionPolicy.(*tokenAwareHostPolicy)
+	policy.SetPartitioner("Murmur3Partitioner")
+	tr, _ := policy.tokenRing.Load().(*tokenRing)
+	if tr == nil {
+		t.Fatal("expected a token ring")
+	}
+	for tok, addr := range map[int64]string{-200: "127.0.0.5", 0: "127.0.0.6"} {
+		if host := tr.GetHostForToken(murmur3Token(tok)); host == nil || !host.ConnectAddress().Equal(net.ParseIP(addr)) {
+			t.Fatalf("expected token %d to be owned by %s got %v", tok, addr, host)
+		}
+	}
+}
+
+func TestQuerySetHost(t *testing.T) {
+	srv1 := NewTestServer(t, defaultProto, context.Background())
+	defer srv1.Stop()
+	srv2 := newTestServerAddr(t, "127.0.0.2:0", defaultProto, context.Background())
+	defer srv2.Stop()
+
+	cluster := testCluster(srv1.Address, defaultProto)
+	cluster.Hosts = append(cluster.Hosts, srv2.Address)
+	db, err := cluster.CreateSession()
+	if err != nil {
+		t.Fatalf("NewCluster: %v", err)
+	}
+	defer db.Close()
+
+	for _, srv := range []*TestServer{srv1, srv2, srv1, srv2} {
+		for _, addr := range []string{srv.Address, srv.host().ConnectAddress().String()} {
+			iter := db.Query("void").SetHost(addr).Iter()
+			host := iter.Host()
+			if err := iter.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if host == nil || !host.ConnectAddress().Equal(srv.host().ConnectAddress()) {
+				t.Fatalf("expected query pinned to %s to be sent there got %v", addr, host)
 			}
 		}
-	})
+	}
+
+	if err := db.Query("void").SetHost("127.0.0.3").Exec(); err != ErrHostNotFound {
+		t.Fatalf("expected %v for a host not in the ring got %v", ErrHostNotFound, err)
+	}
+	if err := db.Query("void").SetHost("127.0.0.1:1").Exec(); err != ErrHostNotFound {
+		t.Fatalf("expected %v for a port not in the ring got %v", ErrHostNotFound, err)
+	}
+
+	// the query does not fail over when the host is down
+	host := srv2.host()
+	db.handleNodeDown(host.ConnectAddress(), host.Port(), StateChangeConnection)
+	if err := db.Query("void").SetHost(srv2.Address).Exec(); err != ErrHostDown {
+		t.Fatalf("expected %v for a host which is down got %v", ErrHostDown, err)
+	}
 }
 
-func TestQueryTimeoutReuseStream(t *testing.T) {
-	t.Skip("no longer tests anything")
-	// TODO(zariel): move this to conn test, we really just want to check what
-	// happens when a conn is
+func TestQueryConsistencyValidation(t *testing.T) {
+	srv := NewTestServer(t, defaultProto, context.Background())
+	defer srv.Stop()
+
+	// local consistencies are only checked when asked for, as custom
+	// policies may not report their local datacenter
+	def, err := newTestSession(srv.Address, defaultProto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer def.Close()
+	if err := def.Query("void").Consistency(LocalQuorum).Exec(); err != nil {
+		t.Fatalf("expected LOCAL_QUORUM to be sent without RequireLocalDC got %v", err)
+	}
+
+	cluster := testCluster(srv.Address, defaultProto)
+	cluster.RequireLocalDC = true
+	db, err := cluster.CreateSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// invalid consistencies fail without being sent to the cluster
+	nreq := atomic.LoadUint64(&srv.nreq)
+	if err := db.Query("void").Consistency(LocalQuorum).Exec(); err != ErrLocalConsistencyNoDC {
+		t.Fatalf("expected LOCAL_QUORUM without a local datacenter to fail with ErrLocalConsistencyNoDC got %v", err)
+	}
+	if err := db.Query("INSERT INTO t (id) VALUES (1)").Consistency(Consistency(Serial)).Exec(); err != ErrSerialConsistency {
+		t.Fatalf("expected a SERIAL write to fail with ErrSerialConsistency got %v", err)
+	}
+	batch := db.NewBatch(LoggedBatch)
+	batch.Query("INSERT INTO t (id) VALUES (1)")
+	batch.Cons = Consistency(LocalSerial)
+	if err := db.ExecuteBatch(batch); err != ErrSerialConsistency {
+		t.Fatalf("expected a LOCAL_SERIAL batch to fail with ErrSerialConsistency got %v", err)
+	}
+	if n := atomic.LoadUint64(&srv.nreq); n != nreq {
+		t.Fatalf("expected no requests to be sent got %d", n-nreq)
+	}
+
+	// a query pinned to a host does not need a local datacenter
+	if err := db.Query("void").Consistency(LocalOne).SetHost(srv.Address).Exec(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.validateConsistency(db.Query("SELECT * FROM t"), Consistency(Serial), true); err != nil {
+		t.Fatalf("expected a SERIAL read to be valid got %v", err)
+	}
+
+	// the local datacenter is found through the policies wrapping the DC
+	// aware policy
+	cluster.PoolConfig.HostSelectionPolicy = TokenAwareHostPolicy(DCAwareRoundRobinPolicy("dc1"))
+	dcAware, err := cluster.CreateSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dcAware.Close()
+	if err := dcAware.validateConsistency(dcAware.Query("void"), LocalQuorum, false); err != nil {
+		t.Fatalf("expected LOCAL_QUORUM with a local datacenter to be valid got %v", err)
+	}
+}
+
+func TestQueryRetryBudget(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := NewTestServer(t, defaultProto, ctx)
+	defer srv.Stop()
+
+	cluster := testCluster(srv.Address, defaultProto)
+	cluster.RetryBudget = &RetryBudget{Ratio: 0.5, MinRetries: 2}
+	db, err := cluster.CreateSession()
+	if err != nil {
+		t.Fatalf("NewCluster: %v", err)
+	}
+	defer db.Close()
+
+	rt := &ErrorCodeRetryPolicy{
+		RetryPolicy: &SimpleRetryPolicy{NumRetries: 2},
+		RetryTypes:  map[int]RetryType{ErrCodeOverloaded: Retry},
+	}
+
+	// kill is answered with an overloaded error, the first query takes both
+	// initial tokens, the second only adds half a token and is not retried,
+	// and the third refills the token of a single retry
+	cases := []struct {
+		requests  int64
+		throttled uint64
+	}{
+		{3, 0},
+		{1, 1},
+		{2, 2},
+	}
+
+	for i, c := range cases {
+		atomic.StoreInt64(&srv.nKillReq, 0)
+
+		err := db.Query("kill").RetryPolicy(rt).Exec()
+		if reqErr, ok := err.(RequestError); !ok || reqErr.Code() != ErrCodeOverloaded {
+			t.Fatalf("query %d: expected overloaded error got %v", i, err)
+		}
+
+		if requests := atomic.LoadInt64(&srv.nKillReq); requests != c.requests {
+			t.Errorf("query %d: expected %d requests got %d", i, c.requests, requests)
+		}
+		if throttled := db.ThrottledRetries(); throttled != c.throttled {
+			t.Errorf("query %d: expected %d throttled retries got %d", i, c.throttled, throttled)
+		}
+	}
+}
+
+func TestQueryRetryBudgetNextHost(t *testing.T) {
+	srv1 := NewTestServer(t, defaultProto, context.Background())
+	defer srv1.Stop()
+	srv2 := newTestServerAddr(t, "127.0.0.2:0", defaultProto, context.Background())
+	defer srv2.Stop()
+	atomic.StoreInt32(&srv1.queryErrCode, ErrCodeTruncate)
+	atomic.StoreInt32(&srv2.queryErrCode, ErrCodeTruncate)
+
+	cluster := testCluster(srv1.Address, defaultProto)
+	cluster.Hosts = append(cluster.Hosts, srv2.Address)
+	// the budget has no token for a retry
+	cluster.RetryBudget = &RetryBudget{Ratio: 0.01}
+	db, err := cluster.CreateSession()
+	if err != nil {
+		t.Fatalf("NewCluster: %v", err)
+	}
+	defer db.Close()
+
+	rt := &ErrorCodeRetryPolicy{
+		RetryPolicy: &SimpleRetryPolicy{NumRetries: 2},
+		RetryTypes:  map[int]RetryType{ErrCodeTruncate: Retry},
+	}
+	if err := db.Query("q").RetryPolicy(rt).Exec(); err == nil {
+		t.Fatal("expected the query to fail")
+	}
+
+	// the refused retry is neither sent to the next host nor counted twice
+	if n := atomic.LoadInt64(&srv1.nErrReq) + atomic.LoadInt64(&srv2.nErrReq); n != 1 {
+		t.Errorf("expected 1 request got %d", n)
+	}
+	if throttled := db.ThrottledRetries(); throttled != 1 {
+		t.Errorf("expected 1 throttled retry got %d", throttled)
+	}
+}
+
+func TestQueryHostBusyRetryNextHost(t *testing.T) {
+	for _, code := range []int32{ErrCodeOverloaded, ErrCodeBootstrapping} {
+		srv1 := NewTestServer(t, defaultProto, context.Background())
+		srv2 := newTestServerAddr(t, "127.0.0.2:0", defaultProto, context.Background())
+
+		cluster := testCluster(srv1.Address, defaultProto)
+		cluster.Hosts = append(cluster.Hosts, srv2.Address)
+		// the query goes to the next host without a retry policy
+		cluster.RetryPolicy = nil
+		db, err := cluster.CreateSession()
+		if err != nil {
+			t.Fatalf("NewCluster: %v", err)
+		}
+
+		atomic.StoreInt32(&srv1.queryErrCode, code)
+		for i := 0; i < 4; i++ {
+			iter := db.Query("busy").Iter()
+			host := iter.Host()
+			if err := iter.Close(); err != nil {
+				t.Fatalf("error code %x: %v", code, err)
+			}
+			if host == nil || !host.ConnectAddress().Equal(srv2.host().ConnectAddress()) {
+				t.Fatalf("error code %x: expected query to succeed on %s got %v", code, srv2.Address, host)
+			}
+		}
+
+		// the failing host is backed off so it is only tried once
+		if n := atomic.LoadInt64(&srv1.nErrReq); n != 1 {
+			t.Fatalf("error code %x: expected the failing host to be queried once got %d", code, n)
+		}
+
+		db.Close()
+		srv1.Stop()
+		srv2.Stop()
+	}
+}
+
+func TestQueryAttemptsError(t *testing.T) {
+	srv1 := NewTestServer(t, defaultProto, context.Background())
+	defer srv1.Stop()
+	srv2 := newTestServerAddr(t, "127.0.0.2:0", defaultProto, context.Background())
+	defer srv2.Stop()
+	atomic.StoreInt32(&srv1.queryErrCode, ErrCodeTruncate)
+	atomic.StoreInt32(&srv2.queryErrCode, ErrCodeTruncate)
+
+	cluster := testCluster(srv1.Address, defaultProto)
+	cluster.Hosts = append(cluster.Hosts, srv2.Address)
+	cluster.RetryPolicy = &SimpleRetryPolicy{NumRetries: 2}
+	db, err := cluster.CreateSession()
+	if err != nil {
+		t.Fatalf("NewCluster: %v", err)
+	}
+	defer db.Close()
+
+	iter := db.Query("busy").Iter()
+	attemptsErr, ok := iter.Error().(*AttemptsError)
+	if !ok {
+		t.Fatalf("expected an *AttemptsError got %T: %v", iter.Error(), iter.Error())
+	}
+
+	// Close still returns the error of the last attempt
+	if err := iter.Close(); !reflect.DeepEqual(err, attemptsErr.Err) {
+		t.Fatalf("expected Close to return %v got %v", attemptsErr.Err, err)
+	} else if reqErr, ok := err.(RequestError); !ok || reqErr.Code() != ErrCodeTruncate {
+		t.Fatalf("expected a truncate error got %v", err)
+	}
+
+	if len(attemptsErr.Hosts) != 2 {
+		t.Fatalf("expected the errors of 2 hosts got %v", attemptsErr)
+	}
+	for _, srv := range []*TestServer{srv1, srv2} {
+		if !strings.Contains(attemptsErr.Error(), srv.Address+": query failed") {
+			t.Errorf("expected the error from %s in %q", srv.Address, attemptsErr)
+		}
+	}
+
+	// a query which succeeds has no error
+	atomic.StoreInt32(&srv1.queryErrCode, 0)
+	atomic.StoreInt32(&srv2.queryErrCode, 0)
+	if iter := db.Query("busy").Iter(); iter.Error() != nil {
+		t.Fatalf("expected no error got %v", iter.Error())
+	}
+}
+
+func TestReconnectUseKeyspace(t *testing.T) {
+	srv := NewTestServer(t, defaultProto, context.Background())
+	defer srv.Stop()
+
+	cluster := testCluster(srv.Address, defaultProto)
+	cluster.Keyspace = "ks"
+	cluster.NumConns = 1
+	db, err := cluster.CreateSession()
+	if err != nil {
+		t.Fatalf("NewCluster: %v", err)
+	}
+	defer db.Close()
+
+	pool, ok := db.pool.getPool(srv.host())
+	if !ok {
+		t.Fatal("expected a pool for the host")
+	}
+	conn := pool.Pick()
+	if conn == nil || conn.keyspace() != "ks" {
+		t.Fatalf("expected the connection to be in the cluster keyspace got %v", conn)
+	}
+
+	// the pool replaces the dropped connection with one in the same keyspace
+	conn.conn.Close()
+	var reconnected *Conn
+	for start := time.Now(); ; time.Sleep(time.Millisecond) {
+		if reconnected = pool.Pick(); reconnected != nil && reconnected != conn {
+			break
+		}
+		if time.Since(start) > time.Second {
+			t.Fatal("expected the pool to reconnect")
+		}
+	}
+	if ks := reconnected.keyspace(); ks != "ks" {
+		t.Fatalf("expected the new connection to be in keyspace ks got %q", ks)
+	}
+
+	srv.mu.Lock()
+	keyspaces := srv.keyspaces
+	srv.mu.Unlock()
+	if !reflect.DeepEqual(keyspaces, []string{"ks", "ks"}) {
+		t.Fatalf("expected each connection to USE ks got %v", keyspaces)
+	}
+}
+
+func TestKeyspaceConsistencyOfStatement(t *testing.T) {
+	srv := NewTestServer(t, defaultProto, context.Background())
+	defer srv.Stop()
+
+	cluster := testCluster(srv.Address, defaultProto)
+	cluster.Keyspace = "analytics"
+	cluster.Consistency = Quorum
+	cluster.KeyspaceConsistency = map[string]Consistency{
+		"analytics": One,
+		"billing":   All,
+	}
+	db, err := cluster.CreateSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// the default is that of the keyspace the query is executed against
+	tests := []struct {
+		qry  *Query
+		cons Consistency
+	}{
+		{db.Query("SELECT * FROM t"), One},
+		{db.Query("SELECT * FROM billing.t"), All},
+		{db.Query("INSERT INTO Billing.t (id) VALUES (1)"), All},
+		{db.Query("SELECT * FROM users.t"), Quorum},
+		{db.Query("SELECT * FROM billing.t").SetKeyspace("analytics"), One},
+		{db.Query("SELECT * FROM billing.t").Consistency(Two), Two},
+		// an explicit consistency equal to the default is kept
+		{db.Query("SELECT * FROM billing.t").Consistency(Quorum), Quorum},
+	}
+	for _, test := range tests {
+		if err := test.qry.Exec(); err != nil {
+			t.Fatal(err)
+		}
+		if cons := test.qry.GetConsistency(); cons != test.cons {
+			t.Errorf("%s: expected consistency %v got %v", test.qry.Statement(), test.cons, cons)
+		}
+	}
+
+	batch := db.NewBatch(LoggedBatch)
+	batch.Query("INSERT INTO billing.t (id) VALUES (1)")
+	batch.Query("INSERT INTO billing.u (id) VALUES (1)")
+	if err := db.ExecuteBatch(batch); err != nil {
+		t.Fatal(err)
+	} else if batch.Cons != All {
+		t.Errorf("expected the batch of billing statements to use %v got %v", All, batch.Cons)
+	}
+
+	batch = db.NewBatch(LoggedBatch)
+	batch.Query("INSERT INTO billing.t (id) VALUES (1)")
+	batch.Query("INSERT INTO t (id) VALUES (1)")
+	if err := db.ExecuteBatch(batch); err != nil {
+		t.Fatal(err)
+	} else if batch.Cons != One {
+		t.Errorf("expected the batch of mixed keyspaces to use %v got %v", One, batch.Cons)
+	}
+
+	// the batch is created with the default of the session keyspace, One
+	batch = db.NewBatch(LoggedBatch)
+	batch.SetConsistency(One)
+	batch.Query("INSERT INTO billing.t (id) VALUES (1)")
+	if err := db.ExecuteBatch(batch); err != nil {
+		t.Fatal(err)
+	} else if batch.Cons != One {
+		t.Errorf("expected the batch set to the default %v to keep it got %v", One, batch.Cons)
+	}
+}
+
+func TestQueryKeyspaceConn(t *testing.T) {
+	srv := NewTestServer(t, defaultProto, context.Background())
+	defer srv.Stop()
+
+	cluster := testCluster(srv.Address, defaultProto)
+	cluster.Keyspace = "ks"
+	cluster.NumConns = 1
+	db, err := cluster.CreateSession()
+	if err != nil {
+		t.Fatalf("NewCluster: %v", err)
+	}
+	defer db.Close()
+
+	qry := db.Query("void").SetKeyspace("tenant")
+	if ks := qry.Keyspace(); ks != "tenant" {
+		t.Fatalf("expected query keyspace tenant got %q", ks)
+	}
+
+	// the queries against tenant share a connection dedicated to it
+	for i := 0; i < 2; i++ {
+		if err := db.Query("void").SetKeyspace("tenant").Exec(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.Query("SELECT * FROM t").SetKeyspace("tenant").Prepared(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Query("void").SetKeyspace("ks").Exec(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Query("void").Exec(); err != nil {
+		t.Fatal(err)
+	}
+
+	srv.mu.Lock()
+	keyspaces := srv.keyspaces
+	srv.mu.Unlock()
+	if expected := []string{"ks", "tenant"}; !reflect.DeepEqual(keyspaces, expected) {
+		t.Fatalf("expected USE of keyspaces %v got %v", expected, keyspaces)
+	}
+
+	// the pooled connection is not switched
+	pool, ok := db.pool.getPool(srv.host())
+	if !ok {
+		t.Fatal("expected a pool for the host")
+	}
+	if conn := pool.Pick(); conn == nil || conn.keyspace() != "ks" {
+		t.Fatalf("expected the pooled connection to use ks got %v", conn)
+	}
+	pool.mu.RLock()
+	conn := pool.keyspaceConns["tenant"]
+	pool.mu.RUnlock()
+	if conn == nil || conn.keyspace() != "tenant" {
+		t.Fatalf("expected a connection dedicated to tenant got %v", conn)
+	}
+
+	db.Close()
+	if !conn.Closed() {
+		t.Fatal("expected the dedicated connection to be closed with the session")
+	}
+}
+
+func TestBatchObserverRetries(t *testing.T) {
+	const proto = protoVersion3
+
+	srv := NewTestServer(t, proto, context.Background())
+	defer srv.Stop()
+
+	db, err := newTestSession(srv.Address, proto)
+	if err != nil {
+		t.Fatalf("NewCluster: %v", err)
+	}
+	defer db.Close()
+
+	var observed []ObservedBatch
+	observer := funcBatchObserver(func(ctx context.Context, o ObservedBatch) {
+		observed = append(observed, o)
+	})
+
+	// kill is answered with an overloaded error, retried on the same host
+	b := db.NewBatch(UnloggedBatch).Observer(observer).RetryPolicy(&ErrorCodeRetryPolicy{
+		RetryPolicy: &SimpleRetryPolicy{NumRetries: 2},
+		RetryTypes:  map[int]RetryType{ErrCodeOverloaded: Retry},
+	})
+	b.Query("kill 1")
+	b.Query("kill 2")
+	if err := db.ExecuteBatch(b); err == nil {
+		t.Fatal("expected batch to fail")
+	}
+
+	if requests := atomic.LoadInt64(&srv.nKillReq); len(observed) != 3 || int64(len(observed)) != requests {
+		t.Fatalf("expected 3 observations, one per attempt, got %d for %d attempts", len(observed), requests)
+	}
+	for i, o := range observed {
+		if o.Attempt != i+1 {
+			t.Errorf("observation %d: expected attempt %d got %d", i, i+1, o.Attempt)
+		}
+		if !reflect.DeepEqual(o.Statements, []string{"kill 1", "kill 2"}) {
+			t.Errorf("observation %d: expected 2 statements got %v", i, o.Statements)
+		}
+		if o.Host == nil || !o.Host.ConnectAddress().Equal(srv.host().ConnectAddress()) {
+			t.Errorf("observation %d: expected host %v got %v", i, srv.host().ConnectAddress(), o.Host)
+		}
+		if reqErr, ok := o.Err.(RequestError); !ok || reqErr.Code() != ErrCodeOverloaded {
+			t.Errorf("observation %d: expected overloaded error got %v", i, o.Err)
+		}
+		if o.End.Before(o.Start) {
+			t.Errorf("observation %d: expected end %v after start %v", i, o.End, o.Start)
+		}
+	}
+
+	observed = nil
+	b = db.NewBatch(UnloggedBatch).Observer(observer)
+	b.Query("UPDATE tbl SET v = 1 WHERE k = 1")
+	if err := db.ExecuteBatch(b); err != nil {
+		t.Fatal(err)
+	}
+	if len(observed) != 1 {
+		t.Fatalf("expected 1 observation got %d", len(observed))
+	} else if observed[0].Err != nil || observed[0].Attempt != 1 {
+		t.Fatalf("expected first attempt to succeed got attempt %d with %v", observed[0].Attempt, observed[0].Err)
+	}
+}
+
+func TestExecuteBatchCAS(t *testing.T) {
+	const proto = protoVersion3
+
+	srv := NewTestServer(t, proto, context.Background())
+	defer srv.Stop()
+
+	db, err := newTestSession(srv.Address, proto)
+	if err != nil {
+		t.Fatalf("NewCluster: %v", err)
+	}
+	defer db.Close()
+
+	b := db.NewBatch(LoggedBatch)
+	b.Query("INSERT INTO tbl (k, v) VALUES (1, 1) IF NOT EXISTS")
+	var v int
+	applied, iter, err := db.ExecuteBatchCAS(b, &v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iter.Close()
+	if !applied {
+		t.Fatal("expected batch to be applied")
+	}
+
+	b = db.NewBatch(LoggedBatch)
+	b.Query("UPDATE tbl SET v = 2 WHERE k = 1 IF v = 1")
+	applied, iter, err = db.ExecuteBatchCAS(b, &v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iter.Close()
+	if applied {
+		t.Fatal("expected batch not to be applied")
+	} else if v != 7 {
+		t.Fatalf("expected current value 7 got %d", v)
+	}
+
+	dest := make(map[string]interface{})
+	applied, iter, err = db.MapExecuteBatchCAS(b, dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iter.Close()
+	if applied {
+		t.Fatal("expected batch not to be applied")
+	} else if !reflect.DeepEqual(dest, map[string]interface{}{"v": 7}) {
+		t.Fatalf("expected current values map[v:7] got %v", dest)
+	}
+
+	// a scan error is returned rather than reported as not applied
+	var a, c int
+	if _, iter, err = db.ExecuteBatchCAS(b, &a, &c); err == nil {
+		t.Fatal("expected error scanning into too many values")
+	}
+	iter.Close()
+}
+
+func TestAwaitSchemaAgreement(t *testing.T) {
+	const proto = protoVersion3
+
+	srv := NewTestServer(t, proto, context.Background())
+	defer srv.Stop()
+
+	cluster := testCluster(srv.Address, proto)
+	cluster.MaxWaitSchemaAgreement = 5 * time.Second
+	db, err := cluster.CreateSession()
+	if err != nil {
+		t.Fatalf("NewCluster: %v", err)
+	}
+	defer db.Close()
+
+	conn := db.getConn()
+	if conn == nil {
+		t.Fatal("expected a connection")
+	}
+
+	srv.setSchemaVersions("a", "a", "a")
+	if err := conn.awaitSchemaAgreement(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a schema change waits until the peers agree
+	const delay = 300 * time.Millisecond
+	srv.setSchemaVersions("a", "a", "b")
+	go func() {
+		time.Sleep(delay)
+		srv.setSchemaVersions("a", "a", "a")
+	}()
+	start := time.Now()
+	if err := db.Query("create keyspace ks").Exec(); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < delay {
+		t.Fatalf("expected schema change to wait for agreement, returned after %v", elapsed)
+	}
+
+	// peers which are down are not waited for, 127.0.0.3 reports b
+	srv.setSchemaVersions("a", "a", "b")
+	db.ring.addOrUpdate(&HostInfo{connectAddress: net.IPv4(127, 0, 0, 3), port: 9042, state: NodeDown})
+	if err := conn.awaitSchemaAgreement(); err != nil {
+		t.Fatalf("expected down peer to be ignored got %v", err)
+	}
+
+	// gives up once MaxWaitSchemaAgreement has passed
+	srv.setSchemaVersions("a", "b")
+	db.cfg.MaxWaitSchemaAgreement = 300 * time.Millisecond
+	start = time.Now()
+	err = conn.awaitSchemaAgreement()
+	if err == nil || !strings.Contains(err.Error(), "schema versions not consistent") {
+		t.Fatalf("expected schema versions not to be consistent got %v", err)
+	} else if elapsed := time.Since(start); elapsed < db.cfg.MaxWaitSchemaAgreement {
+		t.Fatalf("expected to wait %v before giving up, gave up after %v", db.cfg.MaxWaitSchemaAgreement, elapsed)
+	}
+
+	// waiting is disabled when MaxWaitSchemaAgreement is zero
+	db.cfg.MaxWaitSchemaAgreement = 0
+	if err := conn.awaitSchemaAgreement(); err != nil {
+		t.Fatalf("expected not to wait for schema agreement got %v", err)
+	}
+}
+
+func TestStreams_Protocol1(t *testing.T) {
+	srv := NewTestServer(t, protoVersion1, context.Background())
+	defer srv.Stop()
+
+	// TODO: these are more like session tests and should instead operate
+	// on a single Conn
+	cluster := testCluster(srv.Address, protoVersion1)
+	cluster.NumConns = 1
+	cluster.ProtoVersion = 1
+
+	db, err := cluster.CreateSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var wg sync.WaitGroup
+	for i := 1; i < 128; i++ {
+		// here were just validating that if we send NumStream request we get
+		// a response for every stream and the lengths for the queries are set
+		// correctly.
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := db.Query("void").Exec(); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestStreams_Protocol3(t *testing.T) {
+	srv := NewTestServer(t, protoVersion3, context.Background())
+	defer srv.Stop()
+
+	// TODO: these are more like session tests and should instead operate
+	// on a single Conn
+	cluster := testCluster(srv.Address, protoVersion3)
+	cluster.NumConns = 1
+	cluster.ProtoVersion = 3
+
+	db, err := cluster.CreateSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for i := 1; i < 32768; i++ {
+		// the test server processes each conn synchronously
+		// here were just validating that if we send NumStream request we get
+		// a response for every stream and the lengths for the queries are set
+		// correctly.
+		if err = db.Query("void").Exec(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkProtocolV3(b *testing.B) {
+	srv := NewTestServer(b, protoVersion3, context.Background())
+	defer srv.Stop()
+
+	// TODO: these are more like session tests and should instead operate
+	// on a single Conn
+	cluster := NewCluster(srv.Address)
+	cluster.NumConns = 1
+	cluster.ProtoVersion = 3
+
+	db, err := cluster.CreateSession()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer db.Close()
+
+	b.ResetTimer()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err = db.Query("void").Exec(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// This tests that the policy connection pool handles SSL correctly
+func TestPolicyConnPoolSSL(t *testing.T) {
+	srv := NewSSLTestServer(t, defaultProto, context.Background())
+	defer srv.Stop()
+
+	cluster := createTestSslCluster(srv.Address, defaultProto, true)
+	cluster.PoolConfig.HostSelectionPolicy = RoundRobinHostPolicy()
+
+	db, err := cluster.CreateSession()
+	if err != nil {
+		t.Fatalf("failed to create new session: %v", err)
+	}
+
+	if err := db.Query("void").Exec(); err != nil {
+		t.Fatalf("query failed due to error: %v", err)
+	}
+	db.Close()
+
+	// wait for the pool to drain
+	time.Sleep(100 * time.Millisecond)
+	size := db.pool.Size()
+	if size != 0 {
+		t.Fatalf("connection pool did not drain, still contains %d connections", size)
+	}
+}
+
+func TestQueryTimeout(t *testing.T) {
+	srv := NewTestServer(t, defaultProto, context.Background())
+	defer srv.Stop()
+
+	cluster := testCluster(srv.Address, defaultProto)
+	// Set the timeout arbitrarily low so that the query hits the timeout in a
+	// timely manner.
+	cluster.Timeout = 1 * time.Millisecond
+
+	db, err := cluster.CreateSession()
+	if err != nil {
+		t.Fatalf("NewCluster: %v", err)
+	}
+	defer db.Close()
+
+	ch := make(chan error, 1)
+
+	go func() {
+		err := db.Query("timeout").Exec()
+		if err != nil {
+			ch <- err
+			return
+		}
+		t.Errorf("err was nil, expected to get a timeout after %v", db.cfg.Timeout)
+	}()
+
+	select {
+	case err := <-ch:
+		if err != ErrTimeoutNoResponse {
+			t.Fatalf("expected to get %v for timeout got %v", ErrTimeoutNoResponse, err)
+		}
+	case <-time.After(10*time.Millisecond + db.cfg.Timeout):
+		// ensure that the query goroutines have been scheduled
+		t.Fatalf("query did not timeout after %v", db.cfg.Timeout)
+	}
+}
+
+func TestQueryTimeoutOverride(t *testing.T) {
+	srv := NewTestServer(t, defaultProto, context.Background())
+	defer srv.Stop()
+
+	cluster := testCluster(srv.Address, defaultProto)
+	cluster.Timeout = 10 * time.Millisecond
+
+	db, err := cluster.CreateSession()
+	if err != nil {
+		t.Fatalf("NewCluster: %v", err)
+	}
+	defer db.Close()
+
+	// the test server takes 50ms to respond to a slow query
+	if err := db.Query("slow").Exec(); err != ErrTimeoutNoResponse {
+		t.Fatalf("expected to get %v with the session timeout got %v", ErrTimeoutNoResponse, err)
+	}
+
+	if err := db.Query("slow").Timeout(time.Second).Exec(); err != nil {
+		t.Fatalf("expected query with a longer timeout to succeed got %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	if err := db.Query("slow").Timeout(time.Second).WithContext(ctx).Exec(); err != context.DeadlineExceeded {
+		t.Fatalf("expected the earlier context deadline to win got %v", err)
+	}
+}
+
+// timeoutRetryPolicy retries queries on the same host when they time out.
+type timeoutRetryPolicy struct {
+	SimpleRetryPolicy
+}
+
+func (*timeoutRetryPolicy) GetRetryType(err error) RetryType {
+	if err == ErrTimeoutNoResponse {
+		return Retry
+	}
+	return Rethrow
+}
+
+func TestQueryTimeoutRetry(t *testing.T) {
+	srv := NewTestServer(t, defaultProto, context.Background())
+	defer srv.Stop()
+
+	cluster := testCluster(srv.Address, defaultProto)
+	cluster.Timeout = 10 * time.Millisecond
+	cluster.RetryPolicy = &timeoutRetryPolicy{SimpleRetryPolicy{NumRetries: 2}}
+
+	db, err := cluster.CreateSession()
+	if err != nil {
+		t.Fatalf("NewCluster: %v", err)
+	}
+	defer db.Close()
+
+	// the test server takes 50ms to respond to a slow query, each attempt must
+	// be abandoned at the timeout rather than waiting for the response
+	qry := db.Query("slow")
+	start := time.Now()
+	if err := qry.Exec(); err != ErrTimeoutNoResponse {
+		t.Fatalf("expected to get %v for timeout got %v", ErrTimeoutNoResponse, err)
+	}
+	elapsed := time.Since(start)
+
+	if qry.Attempts() != 3 {
+		t.Fatalf("expected the timed out query to be attempted 3 times got %d", qry.Attempts())
+	}
+	if elapsed < 3*cluster.Timeout {
+		t.Fatalf("expected each attempt to wait for the %v timeout, took %v", cluster.Timeout, elapsed)
+	} else if elapsed >= 3*50*time.Millisecond {
+		t.Fatalf("expected attempts to time out after %v, took %v", cluster.Timeout, elapsed)
+	}
+}
+
+func TestQueryPageStateCallback(t *testing.T) {
+	srv := NewTestServer(t, defaultProto, context.Background())
+	defer srv.Stop()
+
+	db, err := newTestSession(srv.Address, defaultProto)
+	if err != nil {
+		t.Fatalf("NewCluster: %v", err)
+	}
+	defer db.Close()
+
+	var states [][]byte
+	iter := db.Query("pages").PageSize(1).PageStateCallback(func(state []byte) {
+		states = append(states, state)
+	}).Iter()
+
+	var pages []int
+	var page int
+	for iter.Scan(&page) {
+		if iter.NumRows() != 1 {
+			t.Fatalf("expected 1 row in page %d got %d", page, iter.NumRows())
+		}
+		pages = append(pages, page)
+	}
+	if err := iter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(pages, []int{0, 1, 2}) {
+		t.Fatalf("expected to read pages [0 1 2] got %v", pages)
+	}
+	// the callback is called with the state of the next page at each of the
+	// two page boundaries
+	if expected := [][]byte{{1}, {2}}; !reflect.DeepEqual(states, expected) {
+		t.Fatalf("expected page states %v got %v", expected, states)
+	}
+
+	// and so it is when the pages are read with a Scanner
+	states = nil
+	scanner := db.Query("pages").PageSize(1).PageStateCallback(func(state []byte) {
+		states = append(states, state)
+	}).Iter().Scanner()
+	pages = nil
+	for scanner.Next() {
+		if err := scanner.Scan(&page); err != nil {
+			t.Fatal(err)
+		}
+		pages = append(pages, page)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(pages, []int{0, 1, 2}) {
+		t.Fatalf("expected the scanner to read pages [0 1 2] got %v", pages)
+	}
+	if expected := [][]byte{{1}, {2}}; !reflect.DeepEqual(states, expected) {
+		t.Fatalf("expected the scanner to report page states %v got %v", expected, states)
+	}
+}
+
+func TestQueryNoAutoPaging(t *testing.T) {
+	srv := NewTestServer(t, defaultProto, context.Background())
+	defer srv.Stop()
+
+	db, err := newTestSession(srv.Address, defaultProto)
+	if err != nil {
+		t.Fatalf("NewCluster: %v", err)
+	}
+	defer db.Close()
+
+	var fetched int32
+	observer := funcQueryObserver(func(ctx context.Context, o ObservedQuery) {
+		atomic.AddInt32(&fetched, 1)
+	})
+
+	iter := db.Query("pages").PageSize(1).NoAutoPaging().Observer(observer).Iter()
+	var pages []int
+	var page int
+	for iter.Scan(&page) {
+		pages = append(pages, page)
+	}
+	if err := iter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(pages, []int{0}) {
+		t.Fatalf("expected to read only page 0 got %v", pages)
+	} else if n := atomic.LoadInt32(&fetched); n != 1 {
+		t.Fatalf("expected 1 page to be fetched got %d", n)
+	}
+
+	// the next page is fetched with the paging state of the first
+	state := iter.PageState()
+	if !bytes.Equal(state, []byte{1}) {
+		t.Fatalf("expected the paging state of page 1 got %v", state)
+	}
+	if err := db.Query("pages").PageSize(1).PageState(state).Scan(&page); err != nil {
+		t.Fatal(err)
+	} else if page != 1 {
+		t.Fatalf("expected to resume at page 1 got %d", page)
+	}
+}
+
+func TestSessionEncodePageState(t *testing.T) {
+	srv := NewTestServer(t, defaultProto, context.Background())
+	defer srv.Stop()
+
+	cluster := testCluster(srv.Address, defaultProto)
+	cluster.PageStateKey = []byte("secret")
+	db, err := cluster.CreateSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	iter := db.Query("pages").PageSize(1).NoAutoPaging().Iter()
+	var page int
+	for iter.Scan(&page) {
+	}
+	if err := iter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	encoded, err := db.EncodePageState(iter.PageState())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the paging state round-tripped through the client resumes the query
+	state, err := db.DecodePageState(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Query("pages").PageSize(1).PageState(state).Scan(&page); err != nil {
+		t.Fatal(err)
+	} else if page != 1 {
+		t.Fatalf("expected to resume at page 1 got %d", page)
+	}
+
+	tampered := append([]byte(nil), encoded...)
+	tampered[len(tampered)-1]++
+	if _, err := db.DecodePageState(tampered); err != ErrInvalidPageState {
+		t.Fatalf("expected a tampered paging state to be rejected got %v", err)
+	}
+	if _, err := db.DecodePageState(encoded[:len(encoded)-1]); err != ErrInvalidPageState {
+		t.Fatalf("expected a truncated paging state to be rejected got %v", err)
+	}
+	if _, err := db.DecodePageState(nil); err != ErrInvalidPageState {
+		t.Fatalf("expected an empty paging state to be rejected got %v", err)
+	}
+
+	// a paging state signed by another key is rejected
+	other, err := testCluster(srv.Address, defaultProto).CreateSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	if _, err := other.EncodePageState(state); err != ErrNoPageStateKey {
+		t.Fatalf("expected ErrNoPageStateKey without a key got %v", err)
+	}
+	other.cfg.PageStateKey = []byte("other")
+	if _, err := other.DecodePageState(encoded); err != ErrInvalidPageState {
+		t.Fatalf("expected a paging state signed by another key to be rejected got %v", err)
+	}
+}
+
+func TestQuerySerialConsistency(t *testing.T) {
+	for _, proto := range []uint8{protoVersion3, protoVersion4} {
+		srv := NewTestServer(t, proto, context.Background())
+		defer srv.Stop()
+
+		db, err := newTestSession(srv.Address, protoVersion(proto))
+		if err != nil {
+			t.Fatalf("NewCluster: %v", err)
+		}
+		defer db.Close()
+
+		for _, cons := range []SerialConsistency{LocalSerial, Serial, 0} {
+			qry := db.Query("cas").Consistency(One)
+			if cons != 0 {
+				qry.SerialConsistency(cons)
+			}
+			if applied, err := qry.ScanCAS(); err != nil {
+				t.Fatal(err)
+			} else if !applied {
+				t.Fatalf("proto %d: expected the update to be applied", proto)
+			}
+		}
+
+		// the serial consistency is sent in the query flags independently of
+		// the consistency, and not sent when it is not set
+		srv.mu.Lock()
+		sent := srv.casSerialCons
+		srv.mu.Unlock()
+		if want := []SerialConsistency{LocalSerial, Serial, 0}; !reflect.DeepEqual(sent, want) {
+			t.Fatalf("proto %d: expected the serial consistencies %v to be sent got %v", proto, want, sent)
+		}
+	}
+}
+
+func TestIterApplied(t *testing.T) {
+	srv := NewTestServer(t, defaultProto, context.Background())
+	defer srv.Stop()
+
+	db, err := newTestSession(srv.Address, defaultProto)
+	if err != nil {
+		t.Fatalf("NewCluster: %v", err)
+	}
+	defer db.Close()
+
+	iter := db.Query("cas").Iter()
+	if applied, err := iter.Applied(); err != nil {
+		t.Fatal(err)
+	} else if !applied {
+		t.Fatal("expected the update to be applied")
+	}
+	if err := iter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// the row is released once the iterator is closed
+	if _, err := iter.Applied(); err == nil {
+		t.Fatal("expected an error calling Applied after Close")
+	}
+
+	iter = db.Query("cas conflict").Iter()
+	if applied, err := iter.Applied(); err != nil {
+		t.Fatal(err)
+	} else if applied {
+		t.Fatal("expected the update not to be applied")
+	}
+	// the row is not consumed, the conflicting value can be scanned
+	var (
+		applied bool
+		v       int
+	)
+	if !iter.Scan(&applied, &v) {
+		t.Fatal(iter.Close())
+	} else if applied || v != 1 {
+		t.Fatalf("expected the conflicting value 1 not to be applied got %v applied=%v", v, applied)
+	}
+	if err := iter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// results which are not of conditional statements have no [applied]
+	iter = db.Query("pages").Iter()
+	if _, err := iter.Applied(); err == nil {
+		t.Fatal("expected an error for a result without an [applied] column")
+	}
+	iter.Close()
+}
+
+// testLargeBlob returns the large blob of the "blobs" test query.
+func testLargeBlob() []byte {
+	blob := make([]byte, 2<<20)
+	for i := range blob {
+		blob[i] = byte(i % 251)
+	}
+	return blob
+}
+
+func TestIterScanBlobReader(t *testing.T) {
+	srv := NewTestServer(t, defaultProto, context.Background())
+	defer srv.Stop()
+
+	db, err := newTestSession(srv.Address, defaultProto)
+	if err != nil {
+		t.Fatalf("NewCluster: %v", err)
+	}
+	defer db.Close()
+
+	iter := db.Query("blobs").Iter()
+	var (
+		id   int
+		blob BlobReader
+	)
+	if !iter.Scan(&id, &blob) {
+		t.Fatal(iter.Close())
+	}
+
+	// the blob is read in chunks much smaller than itself
+	var (
+		read bytes.Buffer
+		buf  = make([]byte, 64<<10)
+	)
+	for {
+		n, err := blob.Read(buf)
+		read.Write(buf[:n])
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !bytes.Equal(read.Bytes(), testLargeBlob()) {
+		t.Fatalf("expected the large blob got %d bytes which differ", read.Len())
+	}
+
+	var stale BlobReader
+	if !iter.Scan(&id, &stale) {
+		t.Fatal(iter.Close())
+	} else if !stale.IsNull() {
+		t.Fatal("expected the second blob to be null")
+	} else if n, err := stale.Read(buf); n != 0 || err != io.EOF {
+		t.Fatalf("expected a null blob to read as empty got %d bytes: %v", n, err)
+	}
+
+	if !iter.Scan(&id, &blob) {
+		t.Fatal(iter.Close())
+	} else if blob.IsNull() || blob.Len() != 3 {
+		t.Fatalf("expected a blob of 3 bytes got %d", blob.Len())
+	}
+	// the reader of the previous row is no longer valid
+	if _, err := stale.Read(buf); err != ErrBlobReaderAdvanced {
+		t.Fatalf("expected %v got %v", ErrBlobReaderAdvanced, err)
+	}
+	if err := iter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := blob.Read(buf); err != ErrBlobReaderAdvanced {
+		t.Fatalf("expected %v once the iterator is closed got %v", ErrBlobReaderAdvanced, err)
+	}
+
+	// only blob columns can be read
+	iter = db.Query("blobs").Iter()
+	if iter.Scan(&blob, &id) {
+		t.Fatal("expected an int column not to be scanned into a BlobReader")
+	}
+	if err := iter.Close(); err == nil {
+		t.Fatal("expected an error scanning an int column into a BlobReader")
+	}
+
+	// a Scanner reads blobs the same way
+	scanner := db.Query("blobs").Iter().Scanner()
+	if !scanner.Next() {
+		t.Fatal(scanner.Err())
+	} else if err := scanner.Scan(&id, &blob); err != nil {
+		t.Fatal(err)
+	}
+	read.Reset()
+	if _, err := io.Copy(&read, &blob); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(read.Bytes(), testLargeBlob()) {
+		t.Fatalf("expected the scanner to read the large blob got %d bytes which differ", read.Len())
+	}
+	if !scanner.Next() {
+		t.Fatal(scanner.Err())
+	} else if err := scanner.Scan(&id, &stale); err != nil {
+		t.Fatal(err)
+	} else if !stale.IsNull() {
+		t.Fatal("expected the second blob to be null")
+	}
+	if !scanner.Next() {
+		t.Fatal(scanner.Err())
+	}
+	if _, err := stale.Read(buf); err != ErrBlobReaderAdvanced {
+		t.Fatalf("expected %v once the scanner moved to the next row got %v", ErrBlobReaderAdvanced, err)
+	}
+	if err := scanner.Scan(&id, &blob); err != nil {
+		t.Fatal(err)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := blob.Read(buf); err != ErrBlobReaderAdvanced {
+		t.Fatalf("expected %v once the scanner is closed got %v", ErrBlobReaderAdvanced, err)
+	}
+}
+
+func TestQueryObserverPages(t *testing.T) {
+	srv := NewTestServer(t, defaultProto, context.Background())
+	defer srv.Stop()
+
+	db, err := newTestSession(srv.Address, defaultProto)
+	if err != nil {
+		t.Fatalf("NewCluster: %v", err)
+	}
+	defer db.Close()
+
+	var observed []ObservedQuery
+	observer := funcQueryObserver(func(ctx context.Context, o ObservedQuery) {
+		observed = append(observed, o)
+	})
+
+	iter := db.Query("pages").PageSize(1).Observer(observer).Iter()
+	for iter.Scan(nil) {
+	}
+	if err := iter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// each page is observed with the paging state it was fetched with
+	if len(observed) != 3 {
+		t.Fatalf("expected an observation for each of the 3 pages got %d", len(observed))
+	}
+	for i, o := range observed {
+		var state []byte
+		if i > 0 {
+			state = []byte{byte(i)}
+		}
+		if !bytes.Equal(o.PageState, state) {
+			t.Errorf("page %d: expected paging state %v got %v", i, state, o.PageState)
+		}
+		if o.Statement != "pages" || o.Rows != 1 {
+			t.Errorf("page %d: expected 1 row of pages got %d of %q", i, o.Rows, o.Statement)
+		}
+		if o.Host == nil || !o.Host.ConnectAddress().Equal(srv.host().ConnectAddress()) {
+			t.Errorf("page %d: expected host %v got %v", i, srv.host().ConnectAddress(), o.Host)
+		}
+	}
+
+	// the observed state is a copy which the observer can keep
+	state := []byte{2}
+	observed = nil
+	if err := db.Query("pages").PageState(state).Observer(observer).Exec(); err != nil {
+		t.Fatal(err)
+	}
+	if len(observed) != 1 || !bytes.Equal(observed[0].PageState, state) {
+		t.Fatalf("expected the paging state %v to be observed got %v", state, observed)
+	} else if &observed[0].PageState[0] == &state[0] {
+		t.Fatal("expected the observed paging state to be a copy")
+	}
+}
+
+func TestQueryScanAll(t *testing.T) {
+	srv := NewTestServer(t, defaultProto, context.Background())
+	defer srv.Stop()
+
+	db, err := newTestSession(srv.Address, defaultProto)
+	if err != nil {
+		t.Fatalf("NewCluster: %v", err)
+	}
+	defer db.Close()
+
+	type row struct {
+		Page int
+	}
+
+	var rows []row
+	if err := db.Query("pages").PageSize(1).ScanAll(&rows); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []row{{0}, {1}, {2}}; !reflect.DeepEqual(rows, expected) {
+		t.Fatalf("expected rows %v from all pages got %v", expected, rows)
+	}
+
+	var ptrs []*row
+	if err := db.Query("pages").PageSize(1).ScanAll(&ptrs); err != nil {
+		t.Fatal(err)
+	}
+	if len(ptrs) != 3 || ptrs[2].Page != 2 {
+		t.Fatalf("expected 3 rows got %v", ptrs)
+	}
+
+	// the rows before an error are kept
+	rows = nil
+	if err := db.Query("pages fail").PageSize(1).ScanAll(&rows); err == nil {
+		t.Fatal("expected the page error to be returned")
+	}
+	if expected := []row{{0}}; !reflect.DeepEqual(rows, expected) {
+		t.Fatalf("expected rows %v before the error got %v", expected, rows)
+	}
+
+	var ints []int
+	if err := db.Query("pages").ScanAll(&ints); err == nil {
+		t.Fatal("expected an error scanning into a slice of ints")
+	}
+	if err := db.Query("pages").ScanAll(rows); err == nil {
+		t.Fatal("expected an error scanning into a slice which is not a pointer")
+	}
+}
+
+func TestQueryPageError(t *testing.T) {
+	srv := NewTestServer(t, defaultProto, context.Background())
+	defer srv.Stop()
+
+	db, err := newTestSession(srv.Address, defaultProto)
+	if err != nil {
+		t.Fatalf("NewCluster: %v", err)
+	}
+	defer db.Close()
+
+	iter := db.Query("pages fail").PageSize(1).Iter()
+
+	var pages []int
+	var page int
+	for iter.Scan(&page) {
+		pages = append(pages, page)
+	}
+	if !reflect.DeepEqual(pages, []int{0}) {
+		t.Fatalf("expected to read the first page got %v", pages)
+	}
+	if page != 0 {
+		t.Fatalf("expected the scanned row to be kept got %d", page)
+	}
+
+	// the failed page can be fetched again from the paging state
+	if state := iter.PageState(); !bytes.Equal(state, []byte{1}) {
+		t.Fatalf("expected the paging state of the failed page got %v", state)
+	}
+	if err := iter.Close(); err == nil || !strings.Contains(err.Error(), "page failed") {
+		t.Fatalf("expected the page error from Close got %v", err)
+	}
+}
+
+func TestIterCloseLaterPageError(t *testing.T) {
+	srv := NewTestServer(t, defaultProto, context.Background())
+	defer srv.Stop()
+
+	db, err := newTestSession(srv.Address, defaultProto)
+	if err != nil {
+		t.Fatalf("NewCluster: %v", err)
+	}
+	defer db.Close()
+
+	// the third page fails after the first two are scanned
+	iter := db.Query("pages fail last").PageSize(2).Iter()
+	var rows []int
+	var row int
+	for iter.Scan(&row) {
+		rows = append(rows, row)
+	}
+	if !reflect.DeepEqual(rows, []int{0, 1, 2, 3}) {
+		t.Fatalf("expected the rows of the first two pages got %v", rows)
+	}
+	if err := iter.Close(); err == nil || !strings.Contains(err.Error(), "page failed") {
+		t.Fatalf("expected the error of the third page from Close got %v", err)
+	}
+
+	// the third page is prefetched while scanning the last row of the second,
+	// its error is returned though the iteration stops before reaching it
+	iter = db.Query("pages fail last").PageSize(2).Iter()
+	rows = rows[:0]
+	for len(rows) < 4 && iter.Scan(&row) {
+		rows = append(rows, row)
+	}
+	if !reflect.DeepEqual(rows, []int{0, 1, 2, 3}) {
+		t.Fatalf("expected the rows of the first two pages got %v", rows)
+	}
+	if err := iter.Close(); err == nil || !strings.Contains(err.Error(), "page failed") {
+		t.Fatalf("expected the error of the prefetched third page from Close got %v", err)
+	}
+
+	// a page which was not prefetched is not fetched by Close
+	iter = db.Query("pages fail last").PageSize(2).Iter()
+	if !iter.Scan(&row) {
+		t.Fatal(iter.Close())
+	}
+	if err := iter.Close(); err != nil {
+		t.Fatalf("expected no error closing before the failed page is fetched got %v", err)
+	}
+}
+
+func TestQueryTimeoutOverrideShorter(t *testing.T) {
+	srv := NewTestServer(t, defaultProto, context.Background())
+	defer srv.Stop()
+
+	cluster := testCluster(srv.Address, defaultProto)
+	cluster.Timeout = time.Second
+
+	db, err := cluster.CreateSession()
+	if err != nil {
+		t.Fatalf("NewCluster: %v", err)
+	}
+	defer db.Close()
+
+	// the test server takes 50ms to respond to a slow query
+	if err := db.Query("slow").Timeout(5 * time.Millisecond).Exec(); err != ErrTimeoutNoResponse {
+		t.Fatalf("expected to get %v for timeout got %v", ErrTimeoutNoResponse, err)
+	}
+
+	if err := db.Query("slow").Exec(); err != nil {
+		t.Fatalf("expected query with the session timeout to succeed got %v", err)
+	}
+}
+
+func BenchmarkSingleConn(b *testing.B) {
+	srv := NewTestServer(b, 3, context.Background())
+	defer srv.Stop()
+
+	cluster := testCluster(srv.Address, 3)
+	// Set the timeout arbitrarily low so that the query hits the timeout in a
+	// timely manner.
+	cluster.Timeout = 500 * time.Millisecond
+	cluster.NumConns = 1
+	db, err := cluster.CreateSession()
+	if err != nil {
+		b.Fatalf("NewCluster: %v", err)
+	}
+	defer db.Close()
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			err := db.Query("void").Exec()
+			if err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+func TestWriteCoalescing(t *testing.T) {
+	srv := NewTestServer(t, defaultProto, context.Background())
+	defer srv.Stop()
+
+	cluster := testCluster(srv.Address, defaultProto)
+	cluster.NumConns = 1
+	cluster.WriteCoalesceWindow = 200 * time.Microsecond
+
+	db, err := cluster.CreateSession()
+	if err != nil {
+		t.Fatalf("NewCluster: %v", err)
+	}
+	defer db.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := db.Query("void").Exec(); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestWriteCoalescerIdle(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	go io.Copy(ioutil.Discard, server)
+
+	// a write to an idle connection must not wait for the window
+	w := newWriteCoalescer(client, 0, time.Second)
+	start := time.Now()
+	if _, err := w.Write([]byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed >= w.window {
+		t.Fatalf("expected the write to be sent immediately, took %v", elapsed)
+	}
+}
+
+func TestWriteCoalescerFlushesTogether(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	w := newWriteCoalescer(client, 0, time.Second)
+
+	// the pipe blocks the first write until it is read, the frames written
+	// meanwhile are buffered and must be flushed together once it is done
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if _, err := w.Write([]byte("a")); err != nil {
+			t.Error(err)
+		}
+	}()
+	waitFor := func(cond func() bool) {
+		deadline := time.Now().Add(time.Second)
+		for {
+			w.mu.Lock()
+			ok := cond()
+			w.mu.Unlock()
+			if ok {
+				return
+			} else if time.Now().After(deadline) {
+				t.Fatal("timed out waiting for the write coalescer")
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	waitFor(func() bool { return w.writing })
+
+	for _, p := range []string{"b", "c", "d"} {
+		wg.Add(1)
+		go func(p string) {
+			defer wg.Done()
+			if _, err := w.Write([]byte(p)); err != nil {
+				t.Error(err)
+			}
+		}(p)
+	}
+	waitFor(func() bool { return len(w.buffers) == 3 })
+
+	read := make(chan []byte)
+	go func() {
+		buf, _ := ioutil.ReadAll(server)
+		read <- buf
+	}()
+	wg.Wait()
+	client.Close()
+
+	if buf := <-read; len(buf) != 4 || buf[0] != 'a' {
+		t.Fatalf("expected the 4 frames to be written got %q", buf)
+	}
+	// one write for the first frame and then a single flush for the rest
+	if w.gen != 2 {
+		t.Fatalf("expected 2 writes to the connection got %d", w.gen)
+	}
+}
+
+func BenchmarkWriteCoalescing(b *testing.B) {
+	for _, window := range []time.Duration{0, 200 * time.Microsecond} {
+		b.Run(fmt.Sprintf("window=%v", window), func(b *testing.B) {
+			srv := NewTestServer(b, 3, context.Background())
+			defer srv.Stop()
+
+			cluster := testCluster(srv.Address, 3)
+			cluster.Timeout = 500 * time.Millisecond
+			cluster.NumConns = 1
+			cluster.WriteCoalesceWindow = window
+			db, err := cluster.CreateSession()
+			if err != nil {
+				b.Fatalf("NewCluster: %v", err)
+			}
+			defer db.Close()
+
+			b.SetParallelism(32)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					err := db.Query("void").Exec()
+					if err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
+
+func TestQueryTimeoutReuseStream(t *testing.T) {
+	t.Skip("no longer tests anything")
+	// TODO(zariel): move this to conn test, we really just want to check what
+	// happens when a conn is
+
+	srv := NewTestServer(t, defaultProto, context.Background())
+	defer srv.Stop()
+
+	cluster := testCluster(srv.Address, defaultProto)
+	// Set the timeout arbitrarily low so that the query hits the timeout in a
+	// timely manner.
+	cluster.Timeout = 1 * time.Millisecond
+	cluster.NumConns = 1
+
+	db, err := cluster.CreateSession()
+	if err != nil {
+		t.Fatalf("NewCluster: %v", err)
+	}
+	defer db.Close()
+
+	db.Query("slow").Exec()
+
+	err = db.Query("void").Exec()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestQueryTimeoutClose(t *testing.T) {
+	srv := NewTestServer(t, defaultProto, context.Background())
+	defer srv.Stop()
+
+	cluster := testCluster(srv.Address, defaultProto)
+	// Set the timeout arbitrarily low so that the query hits the timeout in a
+	// timely manner.
+	cluster.Timeout = 1000 * time.Millisecond
+	cluster.NumConns = 1
+
+	db, err := cluster.CreateSession()
+	if err != nil {
+		t.Fatalf("NewCluster: %v", err)
+	}
+
+	ch := make(chan error)
+	go func() {
+		err := db.Query("timeout").Exec()
+		ch <- err
+	}()
+	// ensure that the above goroutine gets sheduled
+	time.Sleep(50 * time.Millisecond)
+
+	db.Close()
+	select {
+	case err = <-ch:
+	case <-time.After(1 * time.Second):
+		t.Fatal("timedout waiting to get a response once cluster is closed")
+	}
+
+	if err != ErrConnectionClosed {
+		t.Fatalf("expected to get %v got %v", ErrConnectionClosed, err)
+	}
+}
+
+func TestStream0(t *testing.T) {
+	// TODO: replace this with type check
+	const expErr = "gocql: received unexpected frame on stream 0"
+
+	var buf bytes.Buffer
+	f := newFramer(nil, &buf, nil, protoVersion4)
+	f.writeHeader(0, opResult, 0)
+	f.writeInt(resultKindVoid)
+	f.wbuf[0] |= 0x80
+	if err := f.finishWrite(); err != nil {
+		t.Fatal(err)
+	}
+
+	conn := &Conn{
+		r:       bufio.NewReader(&buf),
+		streams: streams.New(protoVersion4),
+	}
+
+	err := conn.recv()
+	if err == nil {
+		t.Fatal("expected to get an error on stream 0")
+	} else if !strings.HasPrefix(err.Error(), expErr) {
+		t.Fatalf("expected to get error prefix %q got %q", expErr, err.Error())
+	}
+}
+
+func TestConnRecvFrameTooBig(t *testing.T) {
+	var buf bytes.Buffer
+	f := newFramer(nil, &buf, nil, protoVersion4)
+	f.writeHeader(0, opEvent, -1)
+	f.wbuf[0] |= 0x80
+	if err := f.finishWrite(); err != nil {
+		t.Fatal(err)
+	}
+	// claim a body far larger than the limit without sending it
+	head := buf.Bytes()
+	head[5], head[6], head[7], head[8] = 0x7f, 0xff, 0xff, 0xff
+
+	conn := &Conn{
+		r:            bufio.NewReader(&buf),
+		streams:      streams.New(protoVersion4),
+		maxFrameSize: 1024,
+	}
+
+	err := conn.recv()
+	if _, ok := err.(ErrProtocol); !ok {
+		t.Fatalf("expected a protocol error got %v", err)
+	} else if !strings.Contains(err.Error(), "maximum frame size 1024") {
+		t.Fatalf("expected the error to report the frame size got %q", err)
+	}
+}
+
+func TestConnClosedBlocked(t *testing.T) {
+	t.Skip("FLAKE: skipping test flake see https://github.com/gocql/gocql/issues/1088")
+	// issue 664
+	const proto = 3
+
+	srv := NewTestServer(t, proto, context.Background())
+	defer srv.Stop()
+	errorHandler := connErrorHandlerFn(func(conn *Conn, err error, closed bool) {
+		t.Log(err)
+	})
+
+	s, err := srv.session()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	conn, err := s.connect(context.Background(), srv.host(), errorHandler)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := conn.conn.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// This will block indefintaly if #664 is not fixed
+	err = conn.executeQuery(&Query{stmt: "void"}).Close()
+	if !strings.HasSuffix(err.Error(), "use of closed network connection") {
+		t.Fatalf("expected to get use of closed networking connection error got: %v\n", err)
+	}
+}
+
+func TestConnStreamExhaustion(t *testing.T) {
+	srv := NewTestServer(t, defaultProto, context.Background())
+	defer srv.Stop()
+
+	cluster := testCluster(srv.Address, defaultProto)
+	cluster.MaxRequestsPerConn = 2
+	db, err := cluster.CreateSession()
+	if err != nil {
+		t.Fatalf("NewCluster: %v", err)
+	}
+	defer db.Close()
+
+	errorHandler := connErrorHandlerFn(func(conn *Conn, err error, closed bool) {})
+	saturate := func(stmt string) *Conn {
+		conn, err := db.connect(context.Background(), srv.host(), errorHandler)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < cluster.MaxRequestsPerConn; i++ {
+			go conn.exec(context.Background(), &writeQueryFrame{statement: stmt}, nil)
+		}
+		for start := time.Now(); conn.AvailableStreams() > 0; time.Sleep(time.Millisecond) {
+			if time.Since(start) > time.Second {
+				t.Fatalf("expected the requests to use all the streams, %d are available", conn.AvailableStreams())
+			}
+		}
+		return conn
+	}
+
+	// the server never responds to timeout so the streams stay in use
+	conn := saturate("timeout")
+	defer conn.Close()
+
+	// without a wait a request fails immediately
+	if _, err := conn.exec(context.Background(), &writeQueryFrame{statement: "void"}, nil); err != ErrNoStreams {
+		t.Fatalf("expected %v got %v", ErrNoStreams, err)
+	}
+	if n := db.StreamExhaustions(); n != 1 {
+		t.Fatalf("expected 1 stream exhaustion got %d", n)
+	}
+
+	// otherwise it waits for a stream to be released until the timeout
+	conn.streamWait = 20 * time.Millisecond
+	start := time.Now()
+	if _, err := conn.exec(context.Background(), &writeQueryFrame{statement: "void"}, nil); err != ErrNoStreams {
+		t.Fatalf("expected %v got %v", ErrNoStreams, err)
+	}
+	if elapsed := time.Since(start); elapsed < conn.streamWait {
+		t.Fatalf("expected the request to wait %v for a stream, failed after %v", conn.streamWait, elapsed)
+	}
+	if n := db.StreamExhaustions(); n != 2 {
+		t.Fatalf("expected 2 stream exhaustions got %d", n)
+	}
+
+	// slow is answered after 50ms, releasing a stream for the waiting request
+	conn = saturate("slow")
+	defer conn.Close()
+	conn.streamWait = time.Second
+	if _, err := conn.exec(context.Background(), &writeQueryFrame{statement: "void"}, nil); err != nil {
+		t.Fatalf("expected the request to get a released stream got %v", err)
+	}
+}
+
+func TestMaxRequestsPerConnValidation(t *testing.T) {
+	cases := []struct {
+		proto    int
+		requests int
+		valid    bool
+	}{
+		{0, 0, true},
+		{0, 32767, true},
+		{0, 32768, false},
+		{0, -1, false},
+		{2, 127, true},
+		{2, 128, false},
+		{4, 32767, true},
+		{4, 32768, false},
+	}
+
+	for _, c := range cases {
+		cluster := NewCluster()
+		cluster.ProtoVersion = c.proto
+		cluster.MaxRequestsPerConn = c.requests
+		if _, err := connConfig(cluster); c.valid && err != nil {
+			t.Errorf("protocol %d with %d requests per conn: %v", c.proto, c.requests, err)
+		} else if !c.valid && err == nil {
+			t.Errorf("protocol %d with %d requests per conn: expected an error", c.proto, c.requests)
+		}
+	}
+}
+
+func TestPoolMaxRequestsPerConn(t *testing.T) {
+	srv := NewTestServer(t, defaultProto, context.Background())
+	defer srv.Stop()
+
+	cluster := testCluster(srv.Address, defaultProto)
+	cluster.NumConns = 2
+	cluster.MaxRequestsPerConn = 2
+	cluster.StreamWaitTimeout = 20 * time.Millisecond
+	cluster.Timeout = 10 * time.Second
+	db, err := cluster.CreateSession()
+	if err != nil {
+		t.Fatalf("NewCluster: %v", err)
+	}
+	defer db.Close()
+
+	pool, ok := db.pool.getPool(srv.host())
+	if !ok {
+		t.Fatal("expected a pool for the host")
+	}
+	conns := func() []*Conn {
+		pool.mu.RLock()
+		defer pool.mu.RUnlock()
+		return append([]*Conn(nil), pool.conns...)
+	}
+	available := func() int {
+		n := 0
+		for _, conn := range conns() {
+			n += conn.AvailableStreams()
+		}
+		return n
+	}
+	for start := time.Now(); len(conns()) < cluster.NumConns; time.Sleep(time.Millisecond) {
+		if time.Since(start) > time.Second {
+			t.Fatalf("expected the pool to open %d connections got %d", cluster.NumConns, len(conns()))
+		}
+	}
+
+	// the server never responds to timeout so the streams stay in use, the
+	// requests past the limit of the first connection go to the second
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	total := cluster.NumConns * cluster.MaxRequestsPerConn
+	for i := 0; i < total; i++ {
+		go db.Query("timeout").WithContext(ctx).Exec()
+		for start := time.Now(); available() > total-i-1; time.Sleep(time.Millisecond) {
+			if time.Since(start) > time.Second {
+				t.Fatalf("expected request %d to take a stream, %d are available", i, available())
+			}
+		}
+	}
+	for _, conn := range conns() {
+		if n := conn.AvailableStreams(); n != 0 {
+			t.Fatalf("expected the requests to be spread over the connections, %d streams are available on %v", n, conn.Address())
+		}
+	}
+	if n := db.StreamExhaustions(); n != 0 {
+		t.Fatalf("expected no stream exhaustion before every connection is at the limit got %d", n)
+	}
+
+	// once every connection is at the limit a request queues on one of them
+	start := time.Now()
+	if err := db.Query("void").Exec(); err != ErrNoStreams {
+		t.Fatalf("expected %v got %v", ErrNoStreams, err)
+	}
+	if elapsed := time.Since(start); elapsed < cluster.StreamWaitTimeout {
+		t.Fatalf("expected the request to wait %v for a stream, failed after %v", cluster.StreamWaitTimeout, elapsed)
+	}
+	if n := db.StreamExhaustions(); n != 1 {
+		t.Fatalf("expected 1 stream exhaustion got %d", n)
+	}
+}
+
+func TestSessionMaxConcurrentRequests(t *testing.T) {
+	srv := NewTestServer(t, defaultProto, context.Background())
+	defer srv.Stop()
+
+	for _, failFast := range []bool{true, false} {
+		cluster := testCluster(srv.Address, defaultProto)
+		cluster.MaxConcurrentRequests = 2
+		cluster.MaxConcurrentRequestsFailFast = failFast
+		db, err := cluster.CreateSession()
+		if err != nil {
+			t.Fatalf("NewCluster: %v", err)
+		}
+
+		// slow is answered after 50ms, keeping the requests in flight, which
+		// are observed before they release the limit
+		var wg sync.WaitGroup
+		var done int32
+		observer := funcQueryObserver(func(ctx context.Context, o ObservedQuery) {
+			atomic.AddInt32(&done, 1)
+		})
+		for i := 0; i < cluster.MaxConcurrentRequests; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := db.Query("slow").Observer(observer).Exec(); err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+		for start := time.Now(); len(db.requests) < cluster.MaxConcurrentRequests; time.Sleep(time.Millisecond) {
+			if time.Since(start) > time.Second {
+				t.Fatalf("expected %d requests in flight got %d", cluster.MaxConcurrentRequests, len(db.requests))
+			}
+		}
+
+		err = db.Query("void").Exec()
+		if failFast {
+			if err != ErrTooManyRequests {
+				t.Fatalf("expected %v past the limit got %v", ErrTooManyRequests, err)
+			}
+		} else if err != nil {
+			t.Fatal(err)
+		} else if atomic.LoadInt32(&done) == 0 {
+			t.Fatal("expected the request to wait for a request in flight to finish")
+		}
+
+		// the limit is released as the requests finish
+		wg.Wait()
+		if n := len(db.requests); n != 0 {
+			t.Fatalf("expected no requests in flight got %d", n)
+		}
+		if err := db.Query("void").Exec(); err != nil {
+			t.Fatal(err)
+		}
+
+		if !failFast {
+			// waiting requests give up when their context is done
+			for i := 0; i < cluster.MaxConcurrentRequests; i++ {
+				db.requests <- struct{}{}
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			if err := db.Query("void").WithContext(ctx).Exec(); err != context.DeadlineExceeded {
+				t.Fatalf("expected %v got %v", context.DeadlineExceeded, err)
+			}
+			cancel()
+			for i := 0; i < cluster.MaxConcurrentRequests; i++ {
+				<-db.requests
+			}
+		}
+
+		db.Close()
+	}
+}
+
+func TestSessionCloseWithTimeout(t *testing.T) {
+	const inFlight = 5
+
+	srv := NewTestServer(t, defaultProto, context.Background())
+	defer srv.Stop()
+
+	db, err := newTestSession(srv.Address, defaultProto)
+	if err != nil {
+		t.Fatalf("NewCluster: %v", err)
+	}
+
+	// slow is answered after 50ms, the queries are in flight once the
+	// server has received them
+	received := atomic.LoadUint64(&srv.nreq) + inFlight
+	errs := make(chan error, inFlight)
+	for i := 0; i < inFlight; i++ {
+		go func() {
+			errs <- db.Query("slow").Exec()
+		}()
+	}
+	for start := time.Now(); atomic.LoadUint64(&srv.nreq) < received; time.Sleep(time.Millisecond) {
+		if time.Since(start) > time.Second {
+			t.Fatal("timed out waiting for the queries to be sent")
+		}
+	}
+
+	if err := db.CloseWithTimeout(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < inFlight; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("expected the queries in flight to complete got %v", err)
+		}
+	}
+	if err := db.Query("void").Exec(); err != ErrSessionClosed {
+		t.Fatalf("expected %v after closing got %v", ErrSessionClosed, err)
+	}
+
+	// queries which do not finish in time fail as the connections close
+	db, err = newTestSession(srv.Address, defaultProto)
+	if err != nil {
+		t.Fatalf("NewCluster: %v", err)
+	}
+	received = atomic.LoadUint64(&srv.nreq) + 1
+	go func() {
+		errs <- db.Query("timeout").Exec()
+	}()
+	for start := time.Now(); atomic.LoadUint64(&srv.nreq) < received; time.Sleep(time.Millisecond) {
+		if time.Since(start) > time.Second {
+			t.Fatal("timed out waiting for the query to be sent")
+		}
+	}
+
+	if err := db.CloseWithTimeout(20 * time.Millisecond); err != ErrCloseTimeout {
+		t.Fatalf("expected %v got %v", ErrCloseTimeout, err)
+	}
+	if err := <-errs; err == nil {
+		t.Fatal("expected the query in flight to fail")
+	}
+
+	// a concurrent Close returns once the session has been torn down
+	db, err = newTestSession(srv.Address, defaultProto)
+	if err != nil {
+		t.Fatalf("NewCluster: %v", err)
+	}
+	received = atomic.LoadUint64(&srv.nreq) + 1
+	go func() {
+		errs <- db.Query("slow").Exec()
+	}()
+	for start := time.Now(); atomic.LoadUint64(&srv.nreq) < received; time.Sleep(time.Millisecond) {
+		if time.Since(start) > time.Second {
+			t.Fatal("timed out waiting for the query to be sent")
+		}
+	}
+
+	closed := make(chan error, 1)
+	go func() {
+		closed <- db.CloseWithTimeout(time.Second)
+	}()
+	for start := time.Now(); !db.Closed(); time.Sleep(time.Millisecond) {
+		if time.Since(start) > time.Second {
+			t.Fatal("timed out waiting for the session to start closing")
+		}
+	}
+	db.Close()
+	select {
+	case <-db.quit:
+	default:
+		t.Fatal("expected Close to wait for the session to be torn down")
+	}
+	if err := <-closed; err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatalf("expected the query in flight to complete got %v", err)
+	}
+}
+
+func TestMaxConcurrentHostFetches(t *testing.T) {
+	const (
+		maxFetches = 2
+		newNodes   = 10
+	)
+
+	srv := NewTestServer(t, defaultProto, context.Background())
+	defer srv.Stop()
+
+	cluster := testCluster(srv.Address, defaultProto)
+	cluster.MaxConcurrentHostFetches = maxFetches
+	s, err := cluster.CreateSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	conn, err := s.connect(context.Background(), srv.host(), connErrorHandlerFn(func(*Conn, error, bool) {}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.control = createControlConn(s)
+	s.control.conn.Store(&connHost{conn: conn, host: srv.host()})
+
+	frames := make([]frame, newNodes)
+	for i := range frames {
+		frames[i] = &topologyChangeEventFrame{
+			change: "NEW_NODE",
+			host:   net.IPv4(127, 0, 1, byte(i+1)),
+			port:   9042,
+		}
+	}
+	s.handleNodeEvent(frames)
+
+	if n := atomic.LoadInt64(&srv.nPeersReq); n != newNodes {
+		t.Fatalf("expected %d host info lookups got %d", newNodes, n)
+	}
+	if max := atomic.LoadInt64(&srv.maxPeersInFlight); max > maxFetches {
+		t.Fatalf("expected at most %d concurrent host info lookups got %d", maxFetches, max)
+	} else if max < maxFetches {
+		t.Fatalf("expected host info lookups to run concurrently, got at most %d at once", max)
+	}
+}
+
+func TestGetHostsPeersV2(t *testing.T) {
+	srv := NewTestServer(t, defaultProto, context.Background())
+	defer srv.Stop()
+	srv.peersV2 = true
+
+	s, err := srv.session()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	conn, err := s.connect(context.Background(), srv.host(), connErrorHandlerFn(func(*Conn, error, bool) {}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.control = createControlConn(s)
+	s.control.conn.Store(&connHost{conn: conn, host: srv.host()})
+
+	hosts, _, err := s.hostSource.GetHosts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hosts) != 2 {
+		t.Fatalf("expected the control host and its peer got %v", hosts)
+	}
+	// the peer listens for clients on a port other than the cluster's
+	if peer := hosts[1]; !peer.ConnectAddress().Equal(net.IPv4(127, 0, 0, 2)) || peer.Port() != 9043 {
+		t.Fatalf("expected the peer 127.0.0.2:9043 got %s:%d", peer.ConnectAddress(), peer.Port())
+	}
+	if n := atomic.LoadInt64(&srv.nPeersReq); n != 0 {
+		t.Fatalf("expected system.peers not to be queried got %d queries", n)
+	}
+}
+
+func TestDisableInitialHostLookupStaticHosts(t *testing.T) {
+	srv := NewTestServer(t, defaultProto, context.Background())
+	defer srv.Stop()
+	srv.peersV2 = true
+
+	cluster := testCluster(srv.Address, defaultProto)
+	cluster.DisableInitialHostLookup = true
+	s, err := cluster.CreateSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// the peers could be discovered through the control connection
+	conn, err := s.connect(context.Background(), srv.host(), connErrorHandlerFn(func(*Conn, error, bool) {}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.control = createControlConn(s)
+	s.control.conn.Store(&connHost{conn: conn, host: srv.host()})
+
+	onlyConfigured := func(when string) {
+		t.Helper()
+		hosts := s.ring.allHosts()
+		if len(hosts) != 1 || JoinHostPort(hosts[0].ConnectAddress().String(), hosts[0].Port()) != srv.Address {
+			t.Fatalf("%s: expected only the configured host %s in the ring got %v", when, srv.Address, hosts)
+		}
+	}
+	onlyConfigured("after connecting")
+
+	if err := s.RefreshRing(); err != nil {
+		t.Fatal(err)
+	}
+	onlyConfigured("after refreshing the ring")
+
+	s.handleNodeEvent([]frame{&topologyChangeEventFrame{change: "NEW_NODE", host: net.IPv4(127, 0, 0, 2), port: 9043}})
+	onlyConfigured("after a new node event")
+
+	if n := atomic.LoadInt64(&srv.nPeersV2Req) + atomic.LoadInt64(&srv.nPeersReq); n != 0 {
+		t.Fatalf("expected the peers not to be queried got %d queries", n)
+	}
+}
+
+func TestSessionGetHostsEvents(t *testing.T) {
+	srv := NewTestServer(t, defaultProto, context.Background())
+	defer srv.Stop()
+	srv.peersV2 = true
+
+	// the ring is only changed by the events, not refreshed after them
+	cluster := testCluster(srv.Address, defaultProto)
+	cluster.IgnorePeerAddr = true
+	s, err := cluster.CreateSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	conn, err := s.connect(context.Background(), srv.host(), connErrorHandlerFn(func(*Conn, error, bool) {}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.control = createControlConn(s)
+	s.control.conn.Store(&connHost{conn: conn, host: srv.host()})
+
+	peer := net.IPv4(127, 0, 0, 2)
+	s.handleNodeEvent([]frame{&topologyChangeEventFrame{change: "NEW_NODE", host: peer, port: 9043}})
+
+	var added *HostInfo
+	for _, host := range s.GetHosts() {
+		if host.ConnectAddress().Equal(peer) {
+			added = host
+		}
+	}
+	if added == nil {
+		t.Fatalf("expected the new node %v to be in the snapshot got %v", peer, s.GetHosts())
+	} else if added.DataCenter() != "dc1" || added.Rack() != "rack1" || len(added.Tokens()) != 1 {
+		t.Fatalf("expected the new node to be in dc1 rack1 with 1 token got %v", added)
+	}
+
+	s.handleNodeEvent([]frame{&topologyChangeEventFrame{change: "REMOVED_NODE", host: peer, port: 9043}})
+	for _, host := range s.GetHosts() {
+		if host.ConnectAddress().Equal(peer) {
+			t.Fatalf("expected the removed node not to be in the snapshot got %v", host)
+		}
+	}
+}
+
+func TestNodeEventsNewThenRemoved(t *testing.T) {
+	srv := NewTestServer(t, defaultProto, context.Background())
+	defer srv.Stop()
+	srv.peersV2 = true
+
+	cluster := testCluster(srv.Address, defaultProto)
+	cluster.IgnorePeerAddr = true
+	s, err := cluster.CreateSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	conn, err := s.connect(context.Background(), srv.host(), connErrorHandlerFn(func(*Conn, error, bool) {}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.control = createControlConn(s)
+	s.control.conn.Store(&connHost{conn: conn, host: srv.host()})
+
+	peer := net.IPv4(127, 0, 0, 2)
+	known := func() bool {
+		_, inPool := s.pool.getPool(&HostInfo{connectAddress: peer})
+		return s.ring.getHost(peer) != nil || inPool
+	}
+
+	// the host left within the window, the stale UP does not add it back
+	s.handleNodeEvent([]frame{
+		&topologyChangeEventFrame{change: "NEW_NODE", host: peer, port: 9043},
+		&topologyChangeEventFrame{change: "REMOVED_NODE", host: peer, port: 9043},
+		&statusChangeEventFrame{change: "UP", host: peer, port: 9043},
+	})
+	if known() {
+		t.Fatal("expected the removed host not to be in the ring or pool")
+	}
+
+	// the host rejoined within the window
+	s.handleNodeEvent([]frame{
+		&topologyChangeEventFrame{change: "REMOVED_NODE", host: peer, port: 9043},
+		&topologyChangeEventFrame{change: "NEW_NODE", host: peer, port: 9043},
+	})
+	if s.ring.getHost(peer) == nil {
+		t.Fatal("expected the host which rejoined to be in the ring")
+	}
+}
+
+func TestNewNodeUseKeyspace(t *testing.T) {
+	srv := NewTestServer(t, defaultProto, context.Background())
+	defer srv.Stop()
+	srv.peersV2 = true
+	// the peer of system.peers_v2
+	peer := newTestServerAddr(t, "127.0.0.2:9043", defaultProto, context.Background())
+	defer peer.Stop()
+
+	cluster := testCluster(srv.Address, defaultProto)
+	cluster.Keyspace = "ks"
+	cluster.NumConns = 1
+	cluster.IgnorePeerAddr = true
+	s, err := cluster.CreateSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	conn, err := s.connect(context.Background(), srv.host(), connErrorHandlerFn(func(*Conn, error, bool) {}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.control = createControlConn(s)
+	s.control.conn.Store(&connHost{conn: conn, host: srv.host()})
+
+	s.handleNodeEvent([]frame{&topologyChangeEventFrame{change: "NEW_NODE", host: net.IPv4(127, 0, 0, 2), port: 9043}})
+
+	// the connections to the new host are in the keyspace once they are in
+	// the pool
+	pool, ok := s.pool.getPool(peer.host())
+	if !ok {
+		t.Fatal("expected a pool for the new host")
+	}
+	if conn := pool.Pick(); conn == nil {
+		t.Fatal("expected a connection to the new host")
+	} else if ks := conn.keyspace(); ks != "ks" {
+		t.Fatalf("expected the connection to be in keyspace ks got %q", ks)
+	}
+
+	peer.mu.Lock()
+	keyspaces := peer.keyspaces
+	peer.mu.Unlock()
+	if !reflect.DeepEqual(keyspaces, []string{"ks"}) {
+		t.Fatalf("expected the new host to be sent USE ks once got %v", keyspaces)
+	}
+}
+
+func TestPoolConnsPerDC(t *testing.T) {
+	srv := NewTestServer(t, defaultProto, context.Background())
+	defer srv.Stop()
+
+	cluster := testCluster(srv.Address, defaultProto)
+	cluster.NumConns = 2
+	cluster.NumRemoteConns = 1
+	cluster.PoolConfig.HostSelectionPolicy = DCAwareRoundRobinPolicy("dc1", UsedHostsPerRemoteDC(1))
+	s, err := cluster.CreateSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// two hosts in the local dc1 and two in the remote dc2, the host the
+	// session was created with is in a remote datacenter of its own
+	dcs := []string{"dc1", "dc1", "dc2", "dc2"}
+	hosts := make([]*HostInfo, len(dcs))
+	for i, dc := range dcs {
+		srv := newTestServerAddr(t, fmt.Sprintf("127.0.0.%d:0", i+2), defaultProto, context.Background())
+		defer srv.Stop()
+
+		host := srv.host()
+		host.dataCenter = dc
+		hosts[i] = s.ring.addOrUpdate(host)
+		s.addNewNode(hosts[i], StateChangeDiscovery)
+	}
+
+	waitForConns := func(expected []int) {
+		var conns []int
+		for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			conns = conns[:0]
+			for _, host := range hosts {
+				n := 0
+				if pool, ok := s.pool.getPool(host); ok {
+					n = pool.Size()
+				}
+				conns = append(conns, n)
+			}
+			if reflect.DeepEqual(conns, expected) {
+				return
+			}
+		}
+		t.Fatalf("expected %v connections per host got %v", expected, conns)
+	}
+	waitForConns([]int{2, 2, 1, 0})
+
+	// the next host of dc2 is connected to in place of the down one
+	s.handleNodeDown(hosts[2].ConnectAddress(), hosts[2].Port(), StateChangeEvent)
+	waitForConns([]int{2, 2, 0, 1})
+}
+
+func TestRefreshRingRemoteDC(t *testing.T) {
+	srv := NewTestServer(t, defaultProto, context.Background())
+	defer srv.Stop()
+	srv.peersV2 = true
+	// the peer of system.peers_v2, in the remote datacenter dc1
+	peer := newTestServerAddr(t, "127.0.0.2:9043", defaultProto, context.Background())
+	defer peer.Stop()
+
+	cluster := testCluster(srv.Address, defaultProto)
+	cluster.NumConns = 1
+	cluster.IgnorePeerAddr = true
+	cluster.PoolConfig.HostSelectionPolicy = DCAwareRoundRobinPolicy("local", UsedHostsPerRemoteDC(1))
+	s, err := cluster.CreateSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	conn, err := s.connect(context.Background(), srv.host(), connErrorHandlerFn(func(*Conn, error, bool) {}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.control = createControlConn(s)
+	s.control.conn.Store(&connHost{conn: conn, host: srv.host()})
+
+	if err := s.RefreshRing(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the policy knows of the discovered host before the pool asks it
+	// whether the host is used
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if pool, ok := s.pool.getPool(peer.host()); ok && pool.Size() == 1 {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatal("expected a connection to the remote host discovered by refreshing the ring")
+		}
+	}
+}
+
+func TestGetHostsPeersV1(t *testing.T) {
+	srv := NewTestServer(t, defaultProto, context.Background())
+	defer srv.Stop()
+	srv.peersV1 = true
+
+	s, err := srv.session()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	conn, err := s.connect(context.Background(), srv.host(), connErrorHandlerFn(func(*Conn, error, bool) {}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.control = createControlConn(s)
+	s.control.conn.Store(&connHost{conn: conn, host: srv.host()})
+
+	for i := 0; i < 2; i++ {
+		hosts, _, err := s.hostSource.GetHosts()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(hosts) != 2 {
+			t.Fatalf("expected the control host and its peer got %v", hosts)
+		}
+		// system.peers has no port so the peer is connected to on the port
+		// configured for the cluster
+		port := s.cfg.Port
+		if peer := hosts[1]; !peer.ConnectAddress().Equal(net.IPv4(127, 0, 0, 3)) || peer.Port() != port {
+			t.Fatalf("expected the peer 127.0.0.3:%d got %s:%d", port, peer.ConnectAddress(), peer.Port())
+		}
+	}
+
+	// system.peers_v2 is missing so system.peers is queried instead, without
+	// trying system.peers_v2 again
+	if n := atomic.LoadInt64(&srv.nPeersV2Req); n != 1 {
+		t.Fatalf("expected system.peers_v2 to be queried once got %d queries", n)
+	}
+	if n := atomic.LoadInt64(&srv.nPeersReq); n != 2 {
+		t.Fatalf("expected system.peers to be queried twice got %d queries", n)
+	}
+}
+
+func TestHealthCheck(t *testing.T) {
+	const interval = 20 * time.Millisecond
 
 	srv := NewTestServer(t, defaultProto, context.Background())
 	defer srv.Stop()
 
 	cluster := testCluster(srv.Address, defaultProto)
-	// Set the timeout arbitrarily low so that the query hits the timeout in a
-	// timely manner.
-	cluster.Timeout = 1 * time.Millisecond
-	cluster.NumConns = 1
-
+	cluster.HealthCheckInterval = interval
+	cluster.Timeout = 50 * time.Millisecond
+	cluster.ConnectTimeout = 50 * time.Millisecond
+	cluster.ReconnectInterval = 0
+	cluster.ReconnectionPolicy = &ConstantReconnectionPolicy{MaxRetries: 1}
 	db, err := cluster.CreateSession()
 	if err != nil {
-		t.Fatalf("NewCluster: %v", err)
+		t.Fatal(err)
 	}
 	defer db.Close()
 
-	db.Query("slow").Exec()
+	host := db.ring.getHost(srv.host().ConnectAddress())
+	if host == nil {
+		t.Fatal("expected the server to be in the ring")
+	}
 
-	err = db.Query("void").Exec()
-	if err != nil {
-		t.Fatal(err)
+	// a responsive connection passes its health checks
+	time.Sleep(5 * interval)
+	if !host.IsUp() {
+		t.Fatalf("expected host to be up got %v", host.State())
+	} else if size := db.pool.Size(); size != cluster.NumConns {
+		t.Fatalf("expected %d connections got %d", cluster.NumConns, size)
+	}
+
+	atomic.StoreInt32(&srv.Unresponsive, 1)
+
+	deadline := time.Now().Add(time.Second)
+	for host.IsUp() {
+		if time.Now().After(deadline) {
+			t.Fatal("expected host to be marked down after its connections stopped responding")
+		}
+		time.Sleep(interval)
+	}
+
+	if reason := host.StateChangeReason(); reason != StateChangeConnection {
+		t.Errorf("expected host to be marked down with reason %v got %v", StateChangeConnection, reason)
 	}
 }
 
-func TestQueryTimeoutClose(t *testing.T) {
+func TestHeartbeat(t *testing.T) {
+	const interval = 20 * time.Millisecond
+
 	srv := NewTestServer(t, defaultProto, context.Background())
 	defer srv.Stop()
 
 	cluster := testCluster(srv.Address, defaultProto)
-	// Set the timeout arbitrarily low so that the query hits the timeout in a
-	// timely manner.
-	cluster.Timeout = 1000 * time.Millisecond
-	cluster.NumConns = 1
-
+	cluster.HeartbeatInterval = interval
+	cluster.Timeout = 50 * time.Millisecond
+	cluster.ConnectTimeout = 50 * time.Millisecond
+	cluster.ReconnectInterval = 0
+	cluster.ReconnectionPolicy = &ConstantReconnectionPolicy{MaxRetries: 1}
 	db, err := cluster.CreateSession()
 	if err != nil {
-		t.Fatalf("NewCluster: %v", err)
+		t.Fatal(err)
 	}
+	defer db.Close()
 
-	ch := make(chan error)
-	go func() {
-		err := db.Query("timeout").Exec()
-		ch <- err
-	}()
-	// ensure that the above goroutine gets sheduled
-	time.Sleep(50 * time.Millisecond)
-
-	db.Close()
-	select {
-	case err = <-ch:
-	case <-time.After(1 * time.Second):
-		t.Fatal("timedout waiting to get a response once cluster is closed")
+	host := db.ring.getHost(srv.host().ConnectAddress())
+	if host == nil {
+		t.Fatal("expected the server to be in the ring")
 	}
 
-	if err != ErrConnectionClosed {
-		t.Fatalf("expected to get %v got %v", ErrConnectionClosed, err)
+	// heartbeats are sent on idle connections and answered
+	before := atomic.LoadInt64(&srv.nOptionsReq)
+	time.Sleep(5 * interval)
+	if n := atomic.LoadInt64(&srv.nOptionsReq); n <= before {
+		t.Fatal("expected heartbeats to be sent on idle connections")
+	} else if !host.IsUp() {
+		t.Fatalf("expected host to be up got %v", host.State())
+	} else if size := db.pool.Size(); size != cluster.NumConns {
+		t.Fatalf("expected %d connections got %d", cluster.NumConns, size)
 	}
-}
-
-func TestStream0(t *testing.T) {
-	// TODO: replace this with type check
-	const expErr = "gocql: received unexpected frame on stream 0"
 
-	var buf bytes.Buffer
-	f := newFramer(nil, &buf, nil, protoVersion4)
-	f.writeHeader(0, opResult, 0)
-	f.writeInt(resultKindVoid)
-	f.wbuf[0] |= 0x80
-	if err := f.finishWrite(); err != nil {
-		t.Fatal(err)
-	}
+	atomic.StoreInt32(&srv.Unresponsive, 1)
 
-	conn := &Conn{
-		r:       bufio.NewReader(&buf),
-		streams: streams.New(protoVersion4),
+	deadline := time.Now().Add(time.Second)
+	for host.IsUp() {
+		if time.Now().After(deadline) {
+			t.Fatal("expected host to be marked down after its heartbeats were not answered")
+		}
+		time.Sleep(interval)
 	}
 
-	err := conn.recv()
-	if err == nil {
-		t.Fatal("expected to get an error on stream 0")
-	} else if !strings.HasPrefix(err.Error(), expErr) {
-		t.Fatalf("expected to get error prefix %q got %q", expErr, err.Error())
+	if reason := host.StateChangeReason(); reason != StateChangeConnection {
+		t.Errorf("expected host to be marked down with reason %v got %v", StateChangeConnection, reason)
 	}
 }
 
-func TestConnClosedBlocked(t *testing.T) {
-	t.Skip("FLAKE: skipping test flake see https://github.com/gocql/gocql/issues/1088")
-	// issue 664
-	const proto = 3
+// countDebouncers returns the number of running event debouncer goroutines.
+func countDebouncers() int {
+	buf := make([]byte, 1<<20)
+	buf = buf[:runtime.Stack(buf, true)]
+	return strings.Count(string(buf), "(*eventDebouncer).flusher")
+}
 
-	srv := NewTestServer(t, proto, context.Background())
+func TestDisableEvents(t *testing.T) {
+	srv := NewTestServer(t, defaultProto, context.Background())
 	defer srv.Stop()
-	errorHandler := connErrorHandlerFn(func(conn *Conn, err error, closed bool) {
-		t.Log(err)
-	})
 
-	s, err := srv.session()
+	before := countDebouncers()
+
+	cluster := testCluster(srv.Address, defaultProto)
+	cluster.DisableEvents = true
+	db, err := cluster.CreateSession()
 	if err != nil {
 		t.Fatal(err)
 	}
-	defer s.Close()
 
-	conn, err := s.connect(srv.host(), errorHandler)
-	if err != nil {
-		t.Fatal(err)
+	if db.nodeEvents != nil || db.schemaEvents != nil {
+		t.Fatal("expected no event debouncers when events are disabled")
+	} else if n := countDebouncers(); n > before {
+		t.Fatalf("expected no new event debouncer goroutines, had %d now %d", before, n)
 	}
 
-	if err := conn.conn.Close(); err != nil {
+	// events are not registered for on the control connection
+	control := createControlConn(db)
+	if err := control.registerEvents(nil); err != nil {
 		t.Fatal(err)
 	}
 
-	// This will block indefintaly if #664 is not fixed
-	err = conn.executeQuery(&Query{stmt: "void"}).Close()
-	if !strings.HasSuffix(err.Error(), "use of closed network connection") {
-		t.Fatalf("expected to get use of closed networking connection error got: %v\n", err)
-	}
+	// Close must handle the missing debouncers
+	db.Close()
 }
 
 func TestContext_Timeout(t *testing.T) {
@@ -674,14 +3845,18 @@ func TestFrameHeaderObserver(t *testing.T) {
 
 	frames := observer.getFrames()
 
-	if len(frames) != 2 {
-		t.Fatalf("Expected to receive 2 frames, instead received %d", len(frames))
+	if len(frames) != 3 {
+		t.Fatalf("Expected to receive 3 frames, instead received %d", len(frames))
+	}
+	supportedFrame := frames[0]
+	if supportedFrame.Opcode != byte(opSupported) {
+		t.Fatalf("Expected to receive supported frame, instead received frame of opcode %d", supportedFrame.Opcode)
 	}
-	readyFrame := frames[0]
+	readyFrame := frames[1]
 	if readyFrame.Opcode != byte(opReady) {
 		t.Fatalf("Expected to receive ready frame, instead received frame of opcode %d", readyFrame.Opcode)
 	}
-	voidResultFrame := frames[1]
+	voidResultFrame := frames[2]
 	if voidResultFrame.Opcode != byte(opResult) {
 		t.Fatalf("Expected to receive result frame, instead received frame of opcode %d", voidResultFrame.Opcode)
 	}
@@ -690,8 +3865,82 @@ func TestFrameHeaderObserver(t *testing.T) {
 	}
 }
 
+func TestControlShuffleDialParallel(t *testing.T) {
+	srv := NewTestServer(t, defaultProto, context.Background())
+	defer srv.Stop()
+
+	cluster := testCluster(srv.Address, defaultProto)
+	cluster.ConnectTimeout = time.Second
+	s, err := cluster.CreateSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// the dead hosts accept connections but never answer the startup, so each
+	// of them takes the connect timeout to fail, the connections to them
+	// which are still open are counted in open.
+	var open int32
+	hosts := []*HostInfo{srv.host()}
+	for i := 0; i < maxControlDials-1; i++ {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer listener.Close()
+		go func() {
+			for {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				atomic.AddInt32(&open, 1)
+				go func() {
+					defer conn.Close()
+					ioutil.ReadAll(conn)
+					atomic.AddInt32(&open, -1)
+				}()
+			}
+		}()
+
+		dead, err := hostInfo(listener.Addr().String(), 9042)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hosts = append(hosts, dead[0])
+	}
+
+	c := createControlConn(s)
+	start := time.Now()
+	conn, err := c.shuffleDial(hosts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if took := time.Since(start); took >= cluster.ConnectTimeout {
+		t.Fatalf("expected to connect before the dead hosts timed out, took %v", took)
+	}
+	if addr := conn.Address(); addr != srv.Address {
+		t.Fatalf("expected to connect to %s got %s", srv.Address, addr)
+	}
+
+	// the dials to the dead hosts are cancelled rather than left to time out
+	for deadline := start.Add(cluster.ConnectTimeout / 2); atomic.LoadInt32(&open) > 0; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the dials to the dead hosts to be cancelled, %d are still open", atomic.LoadInt32(&open))
+		}
+	}
+}
+
 func NewTestServer(t testing.TB, protocol uint8, ctx context.Context) *TestServer {
-	laddr, err := net.ResolveTCPAddr("tcp", "127.0.0.1:0")
+	return newTestServerAddr(t, "127.0.0.1:0", protocol, ctx)
+}
+
+// newTestServerAddr starts a TestServer listening on addr, loopback addresses
+// other than 127.0.0.1 allow for several hosts in the ring.
+func newTestServerAddr(t testing.TB, addr string, protocol uint8, ctx context.Context) *TestServer {
+	laddr, err := net.ResolveTCPAddr("tcp", addr)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -733,9 +3982,16 @@ func NewSSLTestServer(t testing.TB, protocol uint8, ctx context.Context) *TestSe
 	if err != nil {
 		t.Fatalf("could not load cert")
 	}
+	var srv *TestServer
 	config := &tls.Config{
 		Certificates: []tls.Certificate{mycert},
 		RootCAs:      certPool,
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			srv.mu.Lock()
+			srv.serverNames = append(srv.serverNames, hello.ServerName)
+			srv.mu.Unlock()
+			return nil, nil
+		},
 	}
 	listen, err := tls.Listen("tcp", "127.0.0.1:0", config)
 	if err != nil {
@@ -748,7 +4004,7 @@ func NewSSLTestServer(t testing.TB, protocol uint8, ctx context.Context) *TestSe
 	}
 
 	ctx, cancel := context.WithCancel(ctx)
-	srv := &TestServer{
+	srv = &TestServer{
 		Address:    listen.Addr().String(),
 		listen:     listen,
 		t:          t,
@@ -770,8 +4026,68 @@ type TestServer struct {
 	nreq             uint64
 	listen           net.Listener
 	nKillReq         int64
+	nOptionsReq      int64
 	compressor       Compressor
 
+	// Unresponsive stops the server replying to any frame, like a node
+	// which has dropped off the network
+	Unresponsive int32
+
+	// startupDelay delays the response to startup frames, like a slow
+	// handshake
+	startupDelay time.Duration
+
+	// system.peers queries are answered after a delay, tracking how many
+	// are in flight at once
+	nPeersReq        int64
+	peersInFlight    int64
+	maxPeersInFlight int64
+
+	// peersV2 makes the server have the system.peers_v2 table of Cassandra
+	// 4.0, without it querying the table is an invalid request, the queries of
+	// the table are counted in nPeersV2Req
+	peersV2     bool
+	nPeersV2Req int64
+
+	// peersV1 makes system.peers return the peer of testPeerV1 instead of no
+	// peers
+	peersV1 bool
+
+	// prepared statements and batches received from clients, each ? in a
+	// prepared statement is bound as an int
+	nPrepareReq int64
+	batches     [][]testBatchStatement
+	// default timestamp of each batch, 0 when it is not sent
+	batchTimestamps []int64
+	// serial consistency of each cas query, 0 when it is not sent
+	casSerialCons []SerialConsistency
+
+	// statements prepared on the server, executing any other statement
+	// returns an unprepared error which is counted in nUnpreparedReq
+	prepared       map[string]bool
+	nUnpreparedReq int64
+
+	// pkIndexes are the indexes of the bound partition key columns returned
+	// with prepared statements on protocol version 4 and above
+	pkIndexes []uint16
+
+	// keyspaces switched to with USE, in order
+	keyspaces []string
+
+	// serverNames are the TLS server names sent by the clients of an SSL
+	// test server, in order
+	serverNames []string
+
+	// queryErrCode is the code of the error returned to queries without a
+	// case of their own when it is set, the errors are counted in nErrReq
+	queryErrCode int32
+	nErrReq      int64
+
+	// schema versions reported by system.local and by each of the peers,
+	// which have the addresses 127.0.0.2, 127.0.0.3 and so on
+	localSchema string
+	peerSchemas []string
+
 	protocol   byte
 	headerSize int
 	ctx        context.Context
@@ -782,6 +4098,148 @@ type TestServer struct {
 	closed bool
 }
 
+// testBatchStatement is a statement of a batch received by the TestServer.
+type testBatchStatement struct {
+	prepared bool
+	stmt     string
+	values   [][]byte
+}
+
+// forgetPrepared drops the statements prepared on the server, like a node
+// which was restarted.
+func (srv *TestServer) forgetPrepared() {
+	srv.mu.Lock()
+	srv.prepared = nil
+	srv.mu.Unlock()
+}
+
+func (srv *TestServer) setSchemaVersions(local string, peers ...string) {
+	srv.mu.Lock()
+	srv.localSchema = local
+	srv.peerSchemas = peers
+	srv.mu.Unlock()
+}
+
+// writeSchemaMetadata writes the metadata of the result of a query for the
+// schema version of the local node or of its peers.
+func writeSchemaMetadata(f *framer, peers bool) {
+	f.writeInt(int32(flagGlobalTableSpec))
+	if peers {
+		f.writeInt(3)
+	} else {
+		f.writeInt(1)
+	}
+	f.writeString("system")
+	if peers {
+		f.writeString("peers")
+	} else {
+		f.writeString("local")
+	}
+	f.writeString("schema_version")
+	f.writeShort(uint16(TypeVarchar))
+	if peers {
+		f.writeString("peer")
+		f.writeShort(uint16(TypeInet))
+		f.writeString("rpc_address")
+		f.writeShort(uint16(TypeInet))
+	}
+}
+
+// writeSchemaVersions writes the rows result of a query for the schema version
+// of the local node or of its peers.
+func (srv *TestServer) writeSchemaVersions(f *framer, peers bool) {
+	srv.mu.Lock()
+	versions := []string{srv.localSchema}
+	if peers {
+		versions = srv.peerSchemas
+	}
+	srv.mu.Unlock()
+
+	f.writeInt(resultKindRows)
+	writeSchemaMetadata(f, peers)
+	f.writeInt(int32(len(versions)))
+	for i, version := range versions {
+		f.writeBytes([]byte(version))
+		if peers {
+			ip := net.IPv4(127, 0, 0, byte(i+2)).To4()
+			f.writeBytes(ip)
+			f.writeBytes(ip)
+		}
+	}
+}
+
+// testPeerColumn is a column of a row of system.peers or system.peers_v2
+// returned by the TestServer.
+type testPeerColumn struct {
+	name  string
+	typ   Type
+	value interface{}
+}
+
+// testPeerV1 is the row of system.peers returned by the TestServer, the peer
+// 127.0.0.3 which clients connect to at its rpc_address, the table has no
+// port so the port configured for the cluster is used.
+var testPeerV1 = []testPeerColumn{
+	{"peer", TypeInet, "127.0.0.3"},
+	{"rpc_address", TypeInet, "127.0.0.3"},
+	{"data_center", TypeVarchar, "dc1"},
+	{"rack", TypeVarchar, "rack1"},
+	{"release_version", TypeVarchar, "3.11.2"},
+	{"host_id", TypeUUID, TimeUUID()},
+	{"tokens", TypeSet, []string{"1"}},
+}
+
+// testPeerV2 is the row of system.peers_v2 returned by the TestServer, the
+// peer 127.0.0.2 listening for clients on port 9043.
+var testPeerV2 = []testPeerColumn{
+	{"peer", TypeInet, "127.0.0.2"},
+	{"native_address", TypeInet, "127.0.0.2"},
+	{"native_port", TypeInt, 9043},
+	{"data_center", TypeVarchar, "dc1"},
+	{"rack", TypeVarchar, "rack1"},
+	{"release_version", TypeVarchar, "3.11.2"},
+	{"host_id", TypeUUID, TimeUUID()},
+	{"tokens", TypeSet, []string{"0"}},
+}
+
+// writePeersMetadata writes the metadata of the result of a query of the
+// peers table with the columns cols, sets are of varchar.
+func writePeersMetadata(f *framer, table string, cols []testPeerColumn) {
+	f.writeInt(int32(flagGlobalTableSpec))
+	f.writeInt(int32(len(cols)))
+	f.writeString("system")
+	f.writeString(table)
+	for _, col := range cols {
+		f.writeString(col.name)
+		f.writeShort(uint16(col.typ))
+		if col.typ == TypeSet {
+			f.writeShort(uint16(TypeVarchar))
+		}
+	}
+}
+
+// writePeers writes the rows result of a query of the peers table, a single
+// row of the columns cols.
+func (srv *TestServer) writePeers(f *framer, table string, cols []testPeerColumn) {
+	f.writeInt(resultKindRows)
+	writePeersMetadata(f, table, cols)
+	f.writeInt(1)
+	for _, col := range cols {
+		var info TypeInfo = NativeType{proto: srv.protocol, typ: col.typ}
+		if col.typ == TypeSet {
+			info = CollectionType{
+				NativeType: NativeType{proto: srv.protocol, typ: col.typ},
+				Elem:       NativeType{proto: srv.protocol, typ: TypeVarchar},
+			}
+		}
+		p, err := Marshal(info, col.value)
+		if err != nil {
+			srv.t.Fatal(err)
+		}
+		f.writeBytes(p)
+	}
+}
+
 func (srv *TestServer) session() (*Session, error) {
 	return testCluster(srv.Address, protoVersion(srv.protocol)).CreateSession()
 }
@@ -870,6 +4328,10 @@ func (srv *TestServer) process(f *framer) {
 		return
 	}
 
+	if atomic.LoadInt32(&srv.Unresponsive) > 0 {
+		return
+	}
+
 	switch head.op {
 	case opStartup:
 		if atomic.LoadInt32(&srv.TimeoutOnStartup) > 0 {
@@ -880,10 +4342,26 @@ func (srv *TestServer) process(f *framer) {
 				return
 			}
 		}
+		if srv.startupDelay > 0 {
+			select {
+			case <-srv.ctx.Done():
+				return
+			case <-time.After(srv.startupDelay):
+			}
+		}
 		f.writeHeader(0, opReady, head.stream)
 	case opOptions:
+		atomic.AddInt64(&srv.nOptionsReq, 1)
 		f.writeHeader(0, opSupported, head.stream)
-		f.writeShort(0)
+		f.writeShort(3)
+		f.writeString("CQL_VERSION")
+		f.writeStringList([]string{"3.4.4"})
+		f.writeString("COMPRESSION")
+		f.writeStringList([]string{"snappy", "lz4"})
+		f.writeString("PROTOCOL_VERSIONS")
+		f.writeStringList([]string{"3/v3", "4/v4"})
+	case opRegister:
+		f.writeHeader(0, opReady, head.stream)
 	case opQuery:
 		query := f.readLongString()
 		first := query
@@ -897,11 +4375,137 @@ func (srv *TestServer) process(f *framer) {
 			f.writeInt(0x1001)
 			f.writeString("query killed")
 		case "use":
+			keyspace := strings.Trim(strings.TrimSpace(query[3:]), `"`)
+			srv.mu.Lock()
+			srv.keyspaces = append(srv.keyspaces, keyspace)
+			srv.mu.Unlock()
+			f.writeHeader(0, opResult, head.stream)
 			f.writeInt(resultKindKeyspace)
-			f.writeString(strings.TrimSpace(query[3:]))
+			f.writeString(keyspace)
 		case "void":
 			f.writeHeader(0, opResult, head.stream)
 			f.writeInt(resultKindVoid)
+		case "pages":
+			// three pages of a single row, the paging state and the row are
+			// the index of the page, "pages fail" fails to fetch the second.
+			// "pages fail last" fails to fetch the third and has two rows per
+			// page, the index of the page times two and plus one, so that the
+			// next page is prefetched while scanning the second row
+			f.readShort()
+			flags := f.readByte()
+			if flags&flagPageSize == flagPageSize {
+				f.readInt()
+			}
+			var page byte
+			if flags&flagWithPagingState == flagWithPagingState {
+				page = f.readBytes()[0]
+			}
+			failPage, rows := byte(1), byte(1)
+			if strings.HasSuffix(query, " fail last") {
+				failPage, rows = 2, 2
+			}
+			if page >= failPage && strings.Contains(query, " fail") {
+				f.writeHeader(0, opError, head.stream)
+				f.writeInt(ErrCodeServer)
+				f.writeString("page failed")
+				break
+			}
+			f.writeHeader(0, opResult, head.stream)
+			f.writeInt(resultKindRows)
+			if page < 2 {
+				f.writeInt(int32(flagGlobalTableSpec | flagHasMorePages))
+			} else {
+				f.writeInt(int32(flagGlobalTableSpec))
+			}
+			f.writeInt(1)
+			if page < 2 {
+				f.writeBytes([]byte{page + 1})
+			}
+			f.writeString("ks")
+			f.writeString("tbl")
+			f.writeString("page")
+			f.writeShort(uint16(TypeInt))
+			f.writeInt(int32(rows))
+			for i := byte(0); i < rows; i++ {
+				f.writeBytes([]byte{0, 0, 0, page*rows + i})
+			}
+		case "cas", "cas conflict":
+			// the result of a conditional update, which is not applied by
+			// "cas conflict" and returns the conflicting value 1
+			conflict := query == "cas conflict"
+			var serialCons SerialConsistency
+			if srv.protocol > protoVersion1 {
+				f.readShort()
+				flags := f.readByte()
+				if flags&flagValues == flagValues {
+					for n := f.readShort(); n > 0; n-- {
+						f.readBytes()
+					}
+				}
+				if flags&flagPageSize == flagPageSize {
+					f.readInt()
+				}
+				if flags&flagWithPagingState == flagWithPagingState {
+					f.readBytes()
+				}
+				if flags&flagWithSerialConsistency == flagWithSerialConsistency {
+					serialCons = SerialConsistency(f.readShort())
+				}
+			}
+			srv.mu.Lock()
+			srv.casSerialCons = append(srv.casSerialCons, serialCons)
+			srv.mu.Unlock()
+			f.writeHeader(0, opResult, head.stream)
+			f.writeInt(resultKindRows)
+			f.writeInt(int32(flagGlobalTableSpec))
+			if conflict {
+				f.writeInt(2)
+			} else {
+				f.writeInt(1)
+			}
+			f.writeString("ks")
+			f.writeString("tbl")
+			f.writeString("[applied]")
+			f.writeShort(uint16(TypeBoolean))
+			if conflict {
+				f.writeString("v")
+				f.writeShort(uint16(TypeInt))
+			}
+			f.writeInt(1)
+			if conflict {
+				f.writeBytes([]byte{0})
+				f.writeBytes([]byte{0, 0, 0, 1})
+			} else {
+				f.writeBytes([]byte{1})
+			}
+		case "blobs":
+			// rows of a large blob, a null blob and a small one
+			f.writeHeader(0, opResult, head.stream)
+			f.writeInt(resultKindRows)
+			f.writeInt(int32(flagGlobalTableSpec))
+			f.writeInt(2)
+			f.writeString("ks")
+			f.writeString("tbl")
+			f.writeString("id")
+			f.writeShort(uint16(TypeInt))
+			f.writeString("data")
+			f.writeShort(uint16(TypeBlob))
+			f.writeInt(3)
+			for i, blob := range [][]byte{testLargeBlob(), nil, []byte("abc")} {
+				f.writeBytes([]byte{0, 0, 0, byte(i)})
+				f.writeBytes(blob)
+			}
+		case "create":
+			f.writeHeader(0, opResult, head.stream)
+			f.writeInt(resultKindSchemaChanged)
+			f.writeString("CREATED")
+			if srv.protocol > protoVersion2 {
+				f.writeString("KEYSPACE")
+				f.writeString("ks")
+			} else {
+				f.writeString("ks")
+				f.writeString("")
+			}
 		case "timeout":
 			<-srv.ctx.Done()
 			return
@@ -919,9 +4523,201 @@ func (srv *TestServer) process(f *framer) {
 			}()
 			return
 		default:
+			if code := atomic.LoadInt32(&srv.queryErrCode); code != 0 {
+				atomic.AddInt64(&srv.nErrReq, 1)
+				f.writeHeader(0, opError, head.stream)
+				f.writeInt(code)
+				f.writeString("query failed")
+				break
+			}
+			f.writeHeader(0, opResult, head.stream)
+			f.writeInt(resultKindVoid)
+		}
+	case opPrepare:
+		// the query is used as the prepared id so that it can be matched on
+		// execute, only schema version and peers_v2 queries return columns
+		query := f.readLongString()
+		atomic.AddInt64(&srv.nPrepareReq, 1)
+		if strings.Contains(query, "system.peers_v2") && !srv.peersV2 {
+			atomic.AddInt64(&srv.nPeersV2Req, 1)
+			f.writeHeader(0, opError, head.stream)
+			f.writeInt(ErrCodeInvalid)
+			f.writeString("unconfigured table peers_v2")
+			break
+		}
+		srv.mu.Lock()
+		if srv.prepared == nil {
+			srv.prepared = make(map[string]bool)
+		}
+		srv.prepared[query] = true
+		srv.mu.Unlock()
+		nvals := strings.Count(query, "?")
+		f.writeHeader(0, opResult, head.stream)
+		f.writeInt(resultKindPrepared)
+		f.writeShortBytes([]byte(query))
+		if nvals > 0 {
+			f.writeInt(int32(flagGlobalTableSpec))
+		} else {
+			f.writeInt(0)
+		}
+		f.writeInt(int32(nvals))
+		if srv.protocol >= protoVersion4 {
+			f.writeInt(int32(len(srv.pkIndexes)))
+			for _, index := range srv.pkIndexes {
+				f.writeShort(index)
+			}
+		}
+		if nvals > 0 {
+			f.writeString("ks")
+			f.writeString("tbl")
+			for i := 0; i < nvals; i++ {
+				f.writeString(fmt.Sprintf("v%d", i))
+				f.writeShort(uint16(TypeInt))
+			}
+		}
+		if strings.HasPrefix(query, "SELECT schema_version") {
+			writeSchemaMetadata(f, strings.Contains(query, "system.peers"))
+		} else if strings.Contains(query, "system.peers_v2") {
+			writePeersMetadata(f, "peers_v2", testPeerV2)
+		} else if strings.Contains(query, "system.peers") && srv.peersV1 {
+			writePeersMetadata(f, "peers", testPeerV1)
+		} else {
+			f.writeInt(0)
+			f.writeInt(0)
+		}
+	case opBatch:
+		f.readByte()
+		stmts := make([]testBatchStatement, f.readShort())
+		for i := range stmts {
+			stmt := &stmts[i]
+			if stmt.prepared = f.readByte() == 1; stmt.prepared {
+				stmt.stmt = string(f.readShortBytes())
+			} else {
+				stmt.stmt = f.readLongString()
+			}
+			stmt.values = make([][]byte, f.readShort())
+			for j := range stmt.values {
+				stmt.values[j] = copyBytes(f.readBytes())
+			}
+		}
+		var timestamp int64
+		f.readShort()
+		if srv.protocol > protoVersion2 {
+			flags := f.readByte()
+			if flags&flagWithSerialConsistency == flagWithSerialConsistency {
+				f.readShort()
+			}
+			if flags&flagDefaultTimestamp == flagDefaultTimestamp {
+				timestamp = f.readLong()
+			}
+		}
+		srv.mu.Lock()
+		srv.batches = append(srv.batches, stmts)
+		srv.batchTimestamps = append(srv.batchTimestamps, timestamp)
+		srv.mu.Unlock()
+
+		if len(stmts) > 0 && strings.HasPrefix(stmts[0].stmt, "kill") {
+			atomic.AddInt64(&srv.nKillReq, 1)
+			f.writeHeader(0, opError, head.stream)
+			f.writeInt(0x1001)
+			f.writeString("batch killed")
+			break
+		}
+
+		f.writeHeader(0, opResult, head.stream)
+		// conditional batches are applied when inserting rows which do not
+		// exist, otherwise they are not and the current value of v is 7
+		var conditional, applied bool
+		for _, stmt := range stmts {
+			if strings.Contains(stmt.stmt, " IF ") {
+				conditional = true
+				applied = strings.Contains(stmt.stmt, " IF NOT EXISTS")
+			}
+		}
+		if !conditional {
+			f.writeInt(resultKindVoid)
+			break
+		}
+
+		f.writeInt(resultKindRows)
+		f.writeInt(int32(flagGlobalTableSpec))
+		if applied {
+			f.writeInt(1)
+		} else {
+			f.writeInt(2)
+		}
+		f.writeString("ks")
+		f.writeString("tbl")
+		f.writeString("[applied]")
+		f.writeShort(uint16(TypeBoolean))
+		if !applied {
+			f.writeString("v")
+			f.writeShort(uint16(TypeInt))
+		}
+		f.writeInt(1)
+		if applied {
+			f.writeBytes([]byte{1})
+		} else {
+			f.writeBytes([]byte{0})
+			f.writeBytes(encInt(7))
+		}
+	case opExecute:
+		query := string(f.readShortBytes())
+		srv.mu.Lock()
+		prepared := srv.prepared[query]
+		srv.mu.Unlock()
+		if !prepared {
+			atomic.AddInt64(&srv.nUnpreparedReq, 1)
+			f.writeHeader(0, opError, head.stream)
+			f.writeInt(ErrCodeUnprepared)
+			f.writeString("unprepared statement")
+			f.writeShortBytes([]byte(query))
+			break
+		}
+		if strings.HasPrefix(query, "SELECT schema_version") {
+			f.writeHeader(0, opResult, head.stream)
+			srv.writeSchemaVersions(f, strings.Contains(query, "system.peers"))
+			break
+		}
+		if strings.Contains(query, "system.peers_v2") {
+			atomic.AddInt64(&srv.nPeersV2Req, 1)
+			f.writeHeader(0, opResult, head.stream)
+			srv.writePeers(f, "peers_v2", testPeerV2)
+			break
+		}
+		if !strings.Contains(query, "system.peers") {
 			f.writeHeader(0, opResult, head.stream)
 			f.writeInt(resultKindVoid)
+			break
 		}
+
+		atomic.AddInt64(&srv.nPeersReq, 1)
+		n := atomic.AddInt64(&srv.peersInFlight, 1)
+		for {
+			max := atomic.LoadInt64(&srv.maxPeersInFlight)
+			if n <= max || atomic.CompareAndSwapInt64(&srv.maxPeersInFlight, max, n) {
+				break
+			}
+		}
+		go func() {
+			f.writeHeader(0, opResult, head.stream)
+			if srv.peersV1 {
+				srv.writePeers(f, "peers", testPeerV1)
+			} else {
+				f.writeInt(resultKindVoid)
+			}
+			f.wbuf[0] = srv.protocol | 0x80
+			select {
+			case <-srv.ctx.Done():
+				atomic.AddInt64(&srv.peersInFlight, -1)
+			case <-time.After(20 * time.Millisecond):
+				// decrement before the response is written so the lookup
+				// which follows it is not counted as overlapping
+				atomic.AddInt64(&srv.peersInFlight, -1)
+				f.finishWrite()
+			}
+		}()
+		return
 	case opError:
 		f.writeHeader(0, opError, head.stream)
 		f.wbuf = append(f.wbuf, f.rbuf...)
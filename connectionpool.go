@@ -5,6 +5,7 @@
 package gocql
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"errors"
@@ -59,15 +60,25 @@ func setupTLSConfig(sslOpts *SslOptions) (*tls.Config, error) {
 	sslOpts.InsecureSkipVerify = !sslOpts.EnableHostVerification
 
 	// return clone to avoid race
-	return sslOpts.Config.Clone(), nil
+	config := sslOpts.Config.Clone()
+
+	// connections resume the TLS session of an earlier connection to the same
+	// host rather than doing a full handshake, the cache is shared by all the
+	// connections of the session
+	if config.ClientSessionCache == nil {
+		config.ClientSessionCache = tls.NewLRUClientSessionCache(0)
+	}
+
+	return config, nil
 }
 
 type policyConnPool struct {
 	session *Session
 
-	port     int
-	numConns int
-	keyspace string
+	port           int
+	numConns       int
+	numRemoteConns int
+	keyspace       string
 
 	mu            sync.RWMutex
 	hostConnPools map[string]*hostConnPool
@@ -89,6 +100,15 @@ func connConfig(cfg *ClusterConfig) (*ConnConfig, error) {
 		}
 	}
 
+	if cfg.ProxyEndpoint != "" && tlsConfig == nil {
+		return nil, errors.New("ProxyEndpoint requires SslOpts, the proxy routes connections by their TLS server name")
+	}
+
+	if max := maxRequestsPerConn(cfg.ProtoVersion); cfg.MaxRequestsPerConn < 0 || cfg.MaxRequestsPerConn > max {
+		return nil, fmt.Errorf("MaxRequestsPerConn %d is not between 0 and %d, the streams of protocol version %d",
+			cfg.MaxRequestsPerConn, max, cfg.ProtoVersion)
+	}
+
 	return &ConnConfig{
 		ProtoVersion:   cfg.ProtoVersion,
 		CQLVersion:     cfg.CQLVersion,
@@ -98,17 +118,28 @@ func connConfig(cfg *ClusterConfig) (*ConnConfig, error) {
 		Authenticator:  cfg.Authenticator,
 		Keepalive:      cfg.SocketKeepalive,
 		tlsConfig:      tlsConfig,
+
+		WriteCoalesceWindow: cfg.WriteCoalesceWindow,
+		HealthCheckInterval: cfg.HealthCheckInterval,
+		HeartbeatInterval:   cfg.HeartbeatInterval,
+		MaxFrameSize:        cfg.MaxFrameSize,
+		MaxRequestsPerConn:  cfg.MaxRequestsPerConn,
+		StreamWaitTimeout:   cfg.StreamWaitTimeout,
+		ProxyEndpoint:       cfg.ProxyEndpoint,
+		ReadBufferSize:      cfg.ReadBufferSize,
+		WriteBufferSize:     cfg.WriteBufferSize,
 	}, nil
 }
 
 func newPolicyConnPool(session *Session) *policyConnPool {
 	// create the pool
 	pool := &policyConnPool{
-		session:       session,
-		port:          session.cfg.Port,
-		numConns:      session.cfg.NumConns,
-		keyspace:      session.cfg.Keyspace,
-		hostConnPools: map[string]*hostConnPool{},
+		session:        session,
+		port:           session.cfg.Port,
+		numConns:       session.cfg.NumConns,
+		numRemoteConns: session.cfg.NumRemoteConns,
+		keyspace:       session.cfg.Keyspace,
+		hostConnPools:  map[string]*hostConnPool{},
 	}
 
 	pool.endpoints = make([]string, len(session.cfg.Hosts))
@@ -139,18 +170,23 @@ func (p *policyConnPool) SetHosts(hosts []*HostInfo) {
 			delete(toRemove, ip)
 			continue
 		}
+		numConns := p.hostNumConns(host)
+		if numConns == 0 {
+			// the policy does not use this host
+			continue
+		}
 
 		createCount++
-		go func(host *HostInfo) {
+		go func(host *HostInfo, numConns int) {
 			// create a connection pool for the host
 			pools <- newHostConnPool(
 				p.session,
 				host,
 				p.port,
-				p.numConns,
+				numConns,
 				p.keyspace,
 			)
-		}(host)
+		}(host, numConns)
 	}
 
 	// add created pools
@@ -200,7 +236,29 @@ func (p *policyConnPool) Close() {
 	}
 }
 
+// hostNumConns returns the number of connections to open to host, which is 0
+// if the host selection policy does not use it.
+func (p *policyConnPool) hostNumConns(host *HostInfo) int {
+	if p.session == nil || p.session.policy == nil {
+		return p.numConns
+	}
+
+	policy := p.session.policy
+	if !policyUsesHost(policy, host) {
+		return 0
+	} else if p.numRemoteConns > 0 && !policy.IsLocal(host) {
+		return p.numRemoteConns
+	}
+	return p.numConns
+}
+
 func (p *policyConnPool) addHost(host *HostInfo) {
+	numConns := p.hostNumConns(host)
+	if numConns == 0 {
+		// the policy does not use this host, it is connected to once it does
+		return
+	}
+
 	ip := host.ConnectAddress().String()
 	p.mu.Lock()
 	pool, ok := p.hostConnPools[ip]
@@ -209,7 +267,7 @@ func (p *policyConnPool) addHost(host *HostInfo) {
 			p.session,
 			host,
 			host.Port(), // TODO: if port == 0 use pool.port?
-			p.numConns,
+			numConns,
 			p.keyspace,
 		)
 
@@ -220,6 +278,20 @@ func (p *policyConnPool) addHost(host *HostInfo) {
 	pool.fill()
 }
 
+// addUsedHosts adds the pools of the hosts which are up but have none, for the
+// hosts which the policy starts to use once another has gone down or been
+// removed, such as the next host of a remote datacenter.
+func (p *policyConnPool) addUsedHosts(hosts []*HostInfo) {
+	for _, host := range hosts {
+		if !host.IsUp() {
+			continue
+		} else if _, ok := p.getPool(host); ok {
+			continue
+		}
+		p.addHost(host)
+	}
+}
+
 func (p *policyConnPool) removeHost(ip net.IP) {
 	k := ip.String()
 	p.mu.Lock()
@@ -262,6 +334,11 @@ type hostConnPool struct {
 	closed  bool
 	filling bool
 
+	// keyspaceConns are the connections dedicated to the queries which set
+	// another keyspace than the pool on protocol versions before 5, by
+	// keyspace, see keyspaceConn. They are guarded by mu.
+	keyspaceConns map[string]*Conn
+
 	pos uint32
 }
 
@@ -326,6 +403,12 @@ func (pool *hostConnPool) Pick() *Conn {
 		}
 	}
 
+	if leastBusyConn == nil && pool.session != nil && pool.session.cfg.StreamWaitTimeout > 0 {
+		// every connection is at MaxRequestsPerConn, the request queues for a
+		// stream of one of them rather than failing over to the next host
+		leastBusyConn = pool.conns[pos%size]
+	}
+
 	return leastBusyConn
 }
 
@@ -359,6 +442,10 @@ func (pool *hostConnPool) Close() {
 	// empty the pool
 	conns := pool.conns
 	pool.conns = nil
+	for keyspace, conn := range pool.keyspaceConns {
+		conns = append(conns, conn)
+		delete(pool.keyspaceConns, keyspace)
+	}
 
 	pool.mu.Unlock()
 
@@ -368,6 +455,64 @@ func (pool *hostConnPool) Close() {
 	}
 }
 
+// keyspaceConn returns the connection to the host dedicated to the queries
+// against keyspace, connecting it and switching it to keyspace with USE the
+// first time it is needed. Protocol versions before 5 can not send the
+// keyspace with a query, and switching a pooled connection would change the
+// keyspace of the other queries running on it.
+func (pool *hostConnPool) keyspaceConn(keyspace string) (*Conn, error) {
+	pool.mu.RLock()
+	conn, ok := pool.keyspaceConns[keyspace]
+	closed := pool.closed
+	pool.mu.RUnlock()
+	if ok {
+		return conn, nil
+	} else if closed {
+		return nil, ErrNoConnections
+	}
+
+	// the connection is removed once it is closed, so that the next query
+	// against keyspace connects again
+	var handler connErrorHandlerFn = func(conn *Conn, err error, closed bool) {
+		if !closed {
+			return
+		}
+		pool.mu.Lock()
+		if pool.keyspaceConns[keyspace] == conn {
+			delete(pool.keyspaceConns, keyspace)
+		}
+		pool.mu.Unlock()
+	}
+
+	conn, err := pool.session.connect(context.Background(), pool.host, handler)
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.UseKeyspace(keyspace); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	pool.mu.Lock()
+	existing, ok := pool.keyspaceConns[keyspace]
+	if ok || pool.closed {
+		// another query connected first, or the pool was closed meanwhile
+		pool.mu.Unlock()
+		conn.Close()
+		if ok {
+			return existing, nil
+		}
+		return nil, ErrNoConnections
+	}
+	if pool.keyspaceConns == nil {
+		pool.keyspaceConns = make(map[string]*Conn)
+	}
+	pool.keyspaceConns[keyspace] = conn
+	pool.mu.Unlock()
+
+	return conn, nil
+}
+
 // Fill the connection pool
 func (pool *hostConnPool) fill() {
 	pool.mu.RLock()
@@ -422,7 +567,7 @@ func (pool *hostConnPool) fill() {
 			// this is call with the connection pool mutex held, this call will
 			// then recursively try to lock it again. FIXME
 			if pool.session.cfg.ConvictionPolicy.AddFailure(err, pool.host) {
-				go pool.session.handleNodeDown(pool.host.ConnectAddress(), pool.port)
+				go pool.session.handleNodeDown(pool.host.ConnectAddress(), pool.port, StateChangeConnection)
 			}
 			return
 		}
@@ -504,7 +649,7 @@ func (pool *hostConnPool) connect() (err error) {
 	var conn *Conn
 	reconnectionPolicy := pool.session.cfg.ReconnectionPolicy
 	for i := 0; i < reconnectionPolicy.GetMaxRetries(); i++ {
-		conn, err = pool.session.connect(pool.host, pool)
+		conn, err = pool.session.connect(context.Background(), pool.host, pool)
 		if err == nil {
 			break
 		}
@@ -534,6 +679,13 @@ func (pool *hostConnPool) connect() (err error) {
 		}
 	}
 
+	if pool.Size() == 0 {
+		// the host has just come up, statements prepared on it before it went
+		// down are prepared again, in the background so that connecting is
+		// not held up by them
+		conn.reprepareStatements()
+	}
+
 	// add the Conn to the pool
 	pool.mu.Lock()
 	defer pool.mu.Unlock()
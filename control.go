@@ -10,6 +10,7 @@ import (
 	"os"
 	"regexp"
 	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -161,23 +162,85 @@ func shuffleHosts(hosts []*HostInfo) []*HostInfo {
 	return shuffled
 }
 
+// maxControlDials is the number of initial hosts dialed at once when
+// connecting the control connection.
+const maxControlDials = 4
+
 func (c *controlConn) shuffleDial(endpoints []*HostInfo) (*Conn, error) {
 	// shuffle endpoints so not all drivers will connect to the same initial
 	// node.
 	shuffled := shuffleHosts(endpoints)
 
-	var err error
-	for _, host := range shuffled {
-		var conn *Conn
-		conn, err = c.session.connect(host, c)
-		if err == nil {
-			return conn, nil
+	type dialResult struct {
+		host *HostInfo
+		conn *Conn
+		err  error
+	}
+
+	var (
+		hosts   = make(chan *HostInfo)
+		results = make(chan dialResult, len(shuffled))
+		wg      sync.WaitGroup
+	)
+
+	// hosts are dialed in parallel so that unreachable hosts do not each add
+	// a connect timeout to the startup, once one of them is connected to the
+	// dials in progress are cancelled and no more hosts are dialed.
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		defer close(hosts)
+		for _, host := range shuffled {
+			select {
+			case hosts <- host:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	workers := maxControlDials
+	if len(shuffled) < workers {
+		workers = len(shuffled)
+	}
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for host := range hosts {
+				conn, err := c.session.connect(ctx, host, c)
+				results <- dialResult{host: host, conn: conn, err: err}
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// the error from each host is returned if none can be connected to
+	var errs []string
+	for res := range results {
+		if res.err == nil {
+			cancel()
+			// close the connections to the hosts which finished connecting
+			// before they were cancelled.
+			go func() {
+				for res := range results {
+					if res.conn != nil {
+						res.conn.Close()
+					}
+				}
+			}()
+			return res.conn, nil
 		}
 
-		Logger.Printf("gocql: unable to dial control conn %v: %v\n", host.ConnectAddress(), err)
+		Logger.Printf("gocql: unable to dial control conn %v: %v\n", res.host.ConnectAddress(), res.err)
+		errs = append(errs, fmt.Sprintf("%s: %v", JoinHostPort(res.host.ConnectAddress().String(), res.host.Port()), res.err))
 	}
 
-	return nil, err
+	cancel()
+	return nil, errors.New(strings.Join(errs, "; "))
 }
 
 // this is going to be version dependant and a nightmare to maintain :(
@@ -218,7 +281,7 @@ func (c *controlConn) discoverProtocol(hosts []*HostInfo) (int, error) {
 	var err error
 	for _, host := range hosts {
 		var conn *Conn
-		conn, err = c.session.dial(host, &connCfg, handler)
+		conn, err = c.session.dial(context.Background(), host, &connCfg, handler)
 		if conn != nil {
 			conn.Close()
 		}
@@ -282,12 +345,18 @@ func (c *controlConn) setupConn(conn *Conn) error {
 	}
 
 	c.conn.Store(ch)
-	c.session.handleNodeUp(host.ConnectAddress(), host.Port(), false)
+	// the options supported by the cluster are those of the control connection
+	c.session.supported.Store(conn.supported)
+	c.session.handleNodeUp(host.ConnectAddress(), host.Port(), false, StateChangeConnection)
 
 	return nil
 }
 
 func (c *controlConn) registerEvents(conn *Conn) error {
+	if c.session.cfg.DisableEvents {
+		return nil
+	}
+
 	var events []string
 
 	if !c.session.cfg.Events.DisableTopologyEvents {
@@ -340,12 +409,12 @@ func (c *controlConn) reconnect(refreshring bool) {
 	var newConn *Conn
 	if host != nil {
 		// try to connect to the old host
-		conn, err := c.session.connect(host, c)
+		conn, err := c.session.connect(context.Background(), host, c)
 		if err != nil {
 			// host is dead
 			// TODO: this is replicated in a few places
 			if c.session.cfg.ConvictionPolicy.AddFailure(err, host) {
-				c.session.handleNodeDown(host.ConnectAddress(), host.Port())
+				c.session.handleNodeDown(host.ConnectAddress(), host.Port(), StateChangeConnection)
 			}
 		} else {
 			newConn = conn
@@ -362,7 +431,7 @@ func (c *controlConn) reconnect(refreshring bool) {
 		}
 
 		var err error
-		newConn, err = c.session.connect(host, c)
+		newConn, err = c.session.connect(context.Background(), host, c)
 		if err != nil {
 			// TODO: add log handler for things like this
 			return
@@ -1,26 +1,31 @@
 package gocql
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
+// Error codes returned by the server in ERROR frames, as returned by
+// RequestError.Code.
 const (
-	errServer          = 0x0000
-	errProtocol        = 0x000A
-	errCredentials     = 0x0100
-	errUnavailable     = 0x1000
-	errOverloaded      = 0x1001
-	errBootstrapping   = 0x1002
-	errTruncate        = 0x1003
-	errWriteTimeout    = 0x1100
-	errReadTimeout     = 0x1200
-	errReadFailure     = 0x1300
-	errFunctionFailure = 0x1400
-	errWriteFailure    = 0x1500
-	errSyntax          = 0x2000
-	errUnauthorized    = 0x2100
-	errInvalid         = 0x2200
-	errConfig          = 0x2300
-	errAlreadyExists   = 0x2400
-	errUnprepared      = 0x2500
+	ErrCodeServer          = 0x0000
+	ErrCodeProtocol        = 0x000A
+	ErrCodeCredentials     = 0x0100
+	ErrCodeUnavailable     = 0x1000
+	ErrCodeOverloaded      = 0x1001
+	ErrCodeBootstrapping   = 0x1002
+	ErrCodeTruncate        = 0x1003
+	ErrCodeWriteTimeout    = 0x1100
+	ErrCodeReadTimeout     = 0x1200
+	ErrCodeReadFailure     = 0x1300
+	ErrCodeFunctionFailure = 0x1400
+	ErrCodeWriteFailure    = 0x1500
+	ErrCodeSyntax          = 0x2000
+	ErrCodeUnauthorized    = 0x2100
+	ErrCodeInvalid         = 0x2200
+	ErrCodeConfig          = 0x2300
+	ErrCodeAlreadyExists   = 0x2400
+	ErrCodeUnprepared      = 0x2500
 )
 
 type RequestError interface {
@@ -114,3 +119,40 @@ type RequestErrFunctionFailure struct {
 	Function string
 	ArgTypes []string
 }
+
+// HostError is the last error a query failed with on a host.
+type HostError struct {
+	Host *HostInfo
+	Err  error
+}
+
+// AttemptsError is the error of a query which failed on all of the hosts it
+// was attempted on, returned by Iter.Error. Hosts has the last error from each
+// host in the order they were first tried, and Err is the error the query
+// failed with, as returned by Iter.Close.
+type AttemptsError struct {
+	Hosts []HostError
+	Err   error
+}
+
+func (e *AttemptsError) Error() string {
+	errs := make([]string, len(e.Hosts))
+	for i, h := range e.Hosts {
+		errs[i] = fmt.Sprintf("%s: %v", JoinHostPort(h.Host.ConnectAddress().String(), h.Host.Port()), h.Err)
+	}
+	return fmt.Sprintf("gocql: query failed on %d hosts: %s", len(e.Hosts), strings.Join(errs, "; "))
+}
+
+// addHostError records err as the last error of the query on host.
+func addHostError(errs []HostError, host *HostInfo, err error) []HostError {
+	if err == nil {
+		return errs
+	}
+	for i := range errs {
+		if errs[i].Host == host {
+			errs[i].Err = err
+			return errs
+		}
+	}
+	return append(errs, HostError{Host: host, Err: err})
+}
@@ -8,19 +8,31 @@ import (
 
 type eventDebouncer struct {
 	name   string
-	timer  *time.Timer
+	timer  clockTimer
 	mu     sync.Mutex
 	events []frame
 
 	callback func([]frame)
 	quit     chan struct{}
+
+	// highWater is the most events buffered in a window and dropped the
+	// events dropped because the buffer was full, guarded by mu
+	highWater int
+	dropped   uint64
+}
+
+// EventBufferStats are counters for the buffer which events are debounced in
+// before being handled, which holds up to 1000 events.
+type EventBufferStats struct {
+	HighWaterMark int    // most events buffered before being handled together
+	Dropped       uint64 // events dropped as the buffer was full
 }
 
-func newEventDebouncer(name string, eventHandler func([]frame)) *eventDebouncer {
+func newEventDebouncer(name string, clock clock, eventHandler func([]frame)) *eventDebouncer {
 	e := &eventDebouncer{
 		name:     name,
 		quit:     make(chan struct{}),
-		timer:    time.NewTimer(eventDebounceTime),
+		timer:    clock.NewTimer(eventDebounceTime),
 		callback: eventHandler,
 	}
 	e.timer.Stop()
@@ -37,7 +49,7 @@ func (e *eventDebouncer) stop() {
 func (e *eventDebouncer) flusher() {
 	for {
 		select {
-		case <-e.timer.C:
+		case <-e.timer.C():
 			e.mu.Lock()
 			e.flush()
 			e.mu.Unlock()
@@ -72,15 +84,35 @@ func (e *eventDebouncer) debounce(frame frame) {
 	// TODO: probably need a warning to track if this threshold is too low
 	if len(e.events) < eventBufferSize {
 		e.events = append(e.events, frame)
+		if len(e.events) > e.highWater {
+			e.highWater = len(e.events)
+		}
 	} else {
+		e.dropped++
 		Logger.Printf("%s: buffer full, dropping event frame: %s", e.name, frame)
 	}
 
 	e.mu.Unlock()
 }
 
+// stats returns the counters of the buffer, resetting them if reset is true.
+// The high-water mark is reset to the number of events currently buffered.
+func (e *eventDebouncer) stats(reset bool) EventBufferStats {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	stats := EventBufferStats{HighWaterMark: e.highWater, Dropped: e.dropped}
+	if reset {
+		e.highWater = len(e.events)
+		e.dropped = 0
+	}
+	return stats
+}
+
 func (s *Session) handleEvent(framer *framer) {
 	frame, err := framer.parseFrame()
+	// event frames copy everything they need out of the framer
+	s.framerPool.put(framer)
 	if err != nil {
 		// TODO: logger
 		Logger.Printf("gocql: unable to parse event frame: %v\n", err)
@@ -91,6 +123,11 @@ func (s *Session) handleEvent(framer *framer) {
 		Logger.Printf("gocql: handling frame: %v\n", frame)
 	}
 
+	if s.cfg.DisableEvents {
+		// not registered for events so there is nothing to debounce them
+		return
+	}
+
 	switch f := frame.(type) {
 	case *schemaChangeKeyspace, *schemaChangeFunction,
 		*schemaChangeTable, *schemaChangeAggregate, *schemaChangeType:
@@ -103,9 +140,41 @@ func (s *Session) handleEvent(framer *framer) {
 	}
 }
 
+// SchemaChangeTarget is the kind of element of the schema changed by a schema
+// event, targets are combined into a mask with |.
+type SchemaChangeTarget int
+
+const (
+	SchemaChangeKeyspace SchemaChangeTarget = 1 << iota
+	SchemaChangeTable
+	SchemaChangeType
+	SchemaChangeFunction
+	SchemaChangeAggregate
+)
+
+func schemaChangeTarget(frame frame) SchemaChangeTarget {
+	switch frame.(type) {
+	case *schemaChangeKeyspace:
+		return SchemaChangeKeyspace
+	case *schemaChangeTable:
+		return SchemaChangeTable
+	case *schemaChangeType:
+		return SchemaChangeType
+	case *schemaChangeFunction:
+		return SchemaChangeFunction
+	case *schemaChangeAggregate:
+		return SchemaChangeAggregate
+	}
+	return 0
+}
+
 func (s *Session) handleSchemaEvent(frames []frame) {
 	// TODO: debounce events
 	for _, frame := range frames {
+		if schemaChangeTarget(frame)&s.cfg.Events.IgnoreSchemaChanges != 0 {
+			continue
+		}
+
 		switch f := frame.(type) {
 		case *schemaChangeKeyspace:
 			s.schemaDescriber.clearSchema(f.keyspace)
@@ -136,6 +205,10 @@ func (s *Session) handleNodeEvent(frames []frame) {
 
 	events := make(map[string]*nodeEvent)
 
+	// the latest event of each host is handled, in the order they were
+	// received, except that a host which was removed is only added back by a
+	// later NEW_NODE, status changes received after it left are stale and
+	// would otherwise add it back when it comes UP.
 	for _, frame := range frames {
 		// TODO: can we be sure the order of events in the buffer is correct?
 		switch f := frame.(type) {
@@ -152,11 +225,19 @@ func (s *Session) handleNodeEvent(frames []frame) {
 			if !ok {
 				event = &nodeEvent{change: f.change, host: f.host, port: f.port}
 				events[f.host.String()] = event
+			} else if event.change == "REMOVED_NODE" {
+				continue
 			}
 			event.change = f.change
 		}
 	}
 
+	// new nodes are handled concurrently so that their host info lookups and
+	// node up delays overlap, the host source limits how many lookups are made
+	// at once.
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
 	for _, f := range events {
 		if gocqlDebug {
 			Logger.Printf("gocql: dispatching event: %+v\n", f)
@@ -164,37 +245,48 @@ func (s *Session) handleNodeEvent(frames []frame) {
 
 		switch f.change {
 		case "NEW_NODE":
-			s.handleNewNode(f.host, f.port, true)
+			wg.Add(1)
+			go func(f *nodeEvent) {
+				defer wg.Done()
+				s.handleNewNode(f.host, f.port, true, StateChangeEvent)
+			}(f)
 		case "REMOVED_NODE":
 			s.handleRemovedNode(f.host, f.port)
 		case "MOVED_NODE":
 		// java-driver handles this, not mentioned in the spec
 		// TODO(zariel): refresh token map
 		case "UP":
-			s.handleNodeUp(f.host, f.port, true)
+			s.handleNodeUp(f.host, f.port, true, StateChangeEvent)
 		case "DOWN":
-			s.handleNodeDown(f.host, f.port)
+			s.handleNodeDown(f.host, f.port, StateChangeEvent)
 		}
 	}
 }
 
-func (s *Session) addNewNode(host *HostInfo) {
+func (s *Session) addNewNode(host *HostInfo, reason StateChangeReason) {
 	if s.cfg.filterHost(host) {
 		return
 	}
 
-	host.setState(NodeUp)
-	s.pool.addHost(host)
+	host.setState(NodeUp, reason)
+	// the policy knows of the host first so that the pool can ask it whether
+	// the host is used
 	s.policy.AddHost(host)
+	s.pool.addHost(host)
 }
 
-func (s *Session) handleNewNode(ip net.IP, port int, waitForBinary bool) {
+func (s *Session) handleNewNode(ip net.IP, port int, waitForBinary bool, reason StateChangeReason) {
 	if gocqlDebug {
 		Logger.Printf("gocql: Session.handleNewNode: %s:%d\n", ip.String(), port)
 	}
 
 	ip, port = s.cfg.translateAddressPort(ip, port)
 
+	if s.cfg.DisableInitialHostLookup && !s.ring.isEndpoint(ip) {
+		// only the supplied hosts are used when discovery is disabled
+		return
+	}
+
 	// Get host info and apply any filters to the host
 	hostInfo, err := s.hostSource.getHostInfo(ip, port)
 	if err != nil {
@@ -212,7 +304,7 @@ func (s *Session) handleNewNode(ip net.IP, port int, waitForBinary bool) {
 	// should this handle token moving?
 	hostInfo = s.ring.addOrUpdate(hostInfo)
 
-	s.addNewNode(hostInfo)
+	s.addNewNode(hostInfo, reason)
 
 	if s.control != nil && !s.cfg.IgnorePeerAddr {
 		// TODO(zariel): debounce ring refresh
@@ -237,17 +329,18 @@ func (s *Session) handleRemovedNode(ip net.IP, port int) {
 		return
 	}
 
-	host.setState(NodeDown)
+	host.setState(NodeDown, StateChangeEvent)
 	s.policy.RemoveHost(host)
 	s.pool.removeHost(ip)
 	s.ring.removeHost(ip)
+	s.pool.addUsedHosts(s.ring.allHosts())
 
 	if !s.cfg.IgnorePeerAddr {
 		s.hostSource.refreshRing()
 	}
 }
 
-func (s *Session) handleNodeUp(eventIp net.IP, eventPort int, waitForBinary bool) {
+func (s *Session) handleNodeUp(eventIp net.IP, eventPort int, waitForBinary bool, reason StateChangeReason) {
 	if gocqlDebug {
 		Logger.Printf("gocql: Session.handleNodeUp: %s:%d\n", eventIp.String(), eventPort)
 	}
@@ -258,7 +351,7 @@ func (s *Session) handleNodeUp(eventIp net.IP, eventPort int, waitForBinary bool
 	if host == nil {
 		// TODO(zariel): avoid the need to translate twice in this
 		// case
-		s.handleNewNode(eventIp, eventPort, waitForBinary)
+		s.handleNewNode(eventIp, eventPort, waitForBinary, reason)
 		return
 	}
 
@@ -270,10 +363,10 @@ func (s *Session) handleNodeUp(eventIp net.IP, eventPort int, waitForBinary bool
 		time.Sleep(t)
 	}
 
-	s.addNewNode(host)
+	s.addNewNode(host, reason)
 }
 
-func (s *Session) handleNodeDown(ip net.IP, port int) {
+func (s *Session) handleNodeDown(ip net.IP, port int, reason StateChangeReason) {
 	if gocqlDebug {
 		Logger.Printf("gocql: Session.handleNodeDown: %s:%d\n", ip.String(), port)
 	}
@@ -287,7 +380,8 @@ func (s *Session) handleNodeDown(ip net.IP, port int) {
 		return
 	}
 
-	host.setState(NodeDown)
+	host.setState(NodeDown, reason)
 	s.policy.HostDown(host)
 	s.pool.hostDown(ip)
+	s.pool.addUsedHosts(s.ring.allHosts())
 }
@@ -4,6 +4,7 @@ import (
 	"net"
 	"sync"
 	"testing"
+	"time"
 )
 
 func TestEventDebounce(t *testing.T) {
@@ -12,7 +13,7 @@ func TestEventDebounce(t *testing.T) {
 	wg.Add(1)
 
 	eventsSeen := 0
-	debouncer := newEventDebouncer("testDebouncer", func(events []frame) {
+	debouncer := newEventDebouncer("testDebouncer", realClock{}, func(events []frame) {
 		defer wg.Done()
 		eventsSeen += len(events)
 	})
@@ -31,3 +32,246 @@ func TestEventDebounce(t *testing.T) {
 		t.Fatalf("expected to see %d events but got %d", eventCount, eventsSeen)
 	}
 }
+
+func TestEventDebounceFakeClock(t *testing.T) {
+	clock := newFakeClock()
+	flushed := make(chan []frame, 1)
+	debouncer := newEventDebouncer("testDebouncer", clock, func(events []frame) {
+		flushed <- events
+	})
+	defer debouncer.stop()
+
+	event := &statusChangeEventFrame{
+		change: "UP",
+		host:   net.IPv4(127, 0, 0, 1),
+		port:   9042,
+	}
+	pending := func() int {
+		debouncer.mu.Lock()
+		defer debouncer.mu.Unlock()
+		return len(debouncer.events)
+	}
+
+	debouncer.debounce(event)
+	clock.Advance(eventDebounceTime / 2)
+	// each event delays the flush
+	debouncer.debounce(event)
+	clock.Advance(eventDebounceTime / 2)
+	if n := pending(); n != 2 {
+		t.Fatalf("expected 2 events to be pending got %d", n)
+	}
+
+	clock.Advance(eventDebounceTime / 2)
+	select {
+	case events := <-flushed:
+		if len(events) != 2 {
+			t.Fatalf("expected 2 events to be flushed got %d", len(events))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the events to be flushed")
+	}
+}
+
+func TestEventDebounceStats(t *testing.T) {
+	const burst = 150
+
+	clock := newFakeClock()
+	flushed := make(chan []frame, 1)
+	debouncer := newEventDebouncer("testDebouncer", clock, func(events []frame) {
+		flushed <- events
+	})
+	defer debouncer.stop()
+
+	debounce := func(n int) {
+		for i := 0; i < n; i++ {
+			debouncer.debounce(&statusChangeEventFrame{
+				change: "UP",
+				host:   net.IPv4(127, 0, 0, 1),
+				port:   9042,
+			})
+		}
+		clock.Advance(eventDebounceTime)
+		select {
+		case <-flushed:
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for the events to be flushed")
+		}
+	}
+
+	debounce(burst)
+	debounce(burst / 2)
+	if stats := debouncer.stats(true); stats.HighWaterMark != burst {
+		t.Fatalf("expected a high-water mark of %d got %d", burst, stats.HighWaterMark)
+	} else if stats.Dropped != 0 {
+		t.Fatalf("expected no dropped events got %d", stats.Dropped)
+	}
+	if stats := debouncer.stats(false); stats.HighWaterMark != 0 {
+		t.Fatalf("expected the high-water mark to be reset got %d", stats.HighWaterMark)
+	}
+
+	// the buffer fills up
+	debounce(eventBufferSize + 10)
+	if stats := debouncer.stats(false); stats.HighWaterMark != eventBufferSize {
+		t.Fatalf("expected a high-water mark of %d got %d", eventBufferSize, stats.HighWaterMark)
+	} else if stats.Dropped != 10 {
+		t.Fatalf("expected 10 dropped events got %d", stats.Dropped)
+	}
+}
+
+func TestIgnoreSchemaChanges(t *testing.T) {
+	s := &Session{}
+	s.cfg.Events.IgnoreSchemaChanges = SchemaChangeFunction | SchemaChangeAggregate
+	s.schemaDescriber = newSchemaDescriber(s)
+	s.schemaDescriber.cache["ks"] = &KeyspaceMetadata{Name: "ks"}
+
+	cached := func() bool {
+		s.schemaDescriber.mu.Lock()
+		defer s.schemaDescriber.mu.Unlock()
+		_, ok := s.schemaDescriber.cache["ks"]
+		return ok
+	}
+
+	s.handleSchemaEvent([]frame{
+		&schemaChangeFunction{change: "CREATED", keyspace: "ks", name: "fn"},
+		&schemaChangeAggregate{change: "CREATED", keyspace: "ks", name: "agg"},
+	})
+	if !cached() {
+		t.Fatal("expected ignored schema changes not to invalidate the keyspace metadata")
+	}
+
+	s.handleSchemaEvent([]frame{&schemaChangeTable{change: "UPDATED", keyspace: "ks", object: "tbl"}})
+	if cached() {
+		t.Fatal("expected a table change to invalidate the keyspace metadata")
+	}
+}
+
+func TestDisableInitialHostLookupIgnoresNewNodes(t *testing.T) {
+	s := &Session{
+		cfg:    ClusterConfig{DisableInitialHostLookup: true},
+		policy: RoundRobinHostPolicy(),
+		pool:   &policyConnPool{hostConnPools: map[string]*hostConnPool{}},
+	}
+	s.hostSource = newRingDescriber(s, 0)
+	s.ring.endpoints = []*HostInfo{{connectAddress: net.IPv4(127, 0, 0, 1), port: 9042}}
+
+	s.handleNodeEvent([]frame{
+		&topologyChangeEventFrame{change: "NEW_NODE", host: net.IPv4(127, 0, 0, 2), port: 9042},
+		&statusChangeEventFrame{change: "UP", host: net.IPv4(127, 0, 0, 3), port: 9042},
+	})
+	if hosts := s.ring.allHosts(); len(hosts) != 0 {
+		t.Fatalf("expected no hosts to be discovered from events got %v", hosts)
+	}
+
+	if err := s.hostSource.refreshRing(); err != nil {
+		t.Fatal(err)
+	} else if hosts := s.ring.allHosts(); len(hosts) != 0 {
+		t.Fatalf("expected no hosts to be discovered by refreshing the ring got %v", hosts)
+	}
+}
+
+func TestHostStateChangeReason(t *testing.T) {
+	s := &Session{
+		policy: RoundRobinHostPolicy(),
+		pool:   &policyConnPool{hostConnPools: map[string]*hostConnPool{}},
+	}
+
+	eventHost := &HostInfo{connectAddress: net.IPv4(127, 0, 0, 1), port: 9042}
+	connHost := &HostInfo{connectAddress: net.IPv4(127, 0, 0, 2), port: 9042}
+	for _, h := range []*HostInfo{eventHost, connHost} {
+		s.ring.addHost(h)
+		s.policy.AddHost(h)
+	}
+
+	if reason := eventHost.StateChangeReason(); reason != StateChangeUnknown {
+		t.Fatalf("expected new host to have reason %v got %v", StateChangeUnknown, reason)
+	} else if !eventHost.LastStateChange().IsZero() {
+		t.Fatalf("expected new host to have no state change time got %v", eventHost.LastStateChange())
+	}
+
+	start := time.Now()
+	s.handleNodeEvent([]frame{&statusChangeEventFrame{
+		change: "DOWN",
+		host:   eventHost.ConnectAddress(),
+		port:   eventHost.Port(),
+	}})
+	s.handleNodeDown(connHost.ConnectAddress(), connHost.Port(), StateChangeConnection)
+
+	tests := []struct {
+		host   *HostInfo
+		reason StateChangeReason
+	}{
+		{eventHost, StateChangeEvent},
+		{connHost, StateChangeConnection},
+	}
+
+	for _, test := range tests {
+		if state := test.host.State(); state != NodeDown {
+			t.Errorf("%v: expected state %v got %v", test.host.ConnectAddress(), NodeDown, state)
+		}
+		if reason := test.host.StateChangeReason(); reason != test.reason {
+			t.Errorf("%v: expected reason %v got %v", test.host.ConnectAddress(), test.reason, reason)
+		}
+		if changed := test.host.LastStateChange(); changed.Before(start) {
+			t.Errorf("%v: expected state change after %v got %v", test.host.ConnectAddress(), start, changed)
+		}
+	}
+
+	// the reason is kept until the state changes again
+	eventHost.setState(NodeDown, StateChangeConnection)
+	if reason := eventHost.StateChangeReason(); reason != StateChangeEvent {
+		t.Errorf("expected reason to stay %v got %v", StateChangeEvent, reason)
+	}
+}
+
+func TestSessionGetHosts(t *testing.T) {
+	s := &Session{
+		policy: RoundRobinHostPolicy(),
+		pool:   &policyConnPool{hostConnPools: map[string]*hostConnPool{}},
+	}
+
+	up := &HostInfo{connectAddress: net.IPv4(127, 0, 0, 1), port: 9042, state: NodeUp, tokens: []string{"1"}}
+	down := &HostInfo{connectAddress: net.IPv4(127, 0, 0, 2), port: 9042, state: NodeUp}
+	for _, h := range []*HostInfo{up, down} {
+		s.ring.addHost(h)
+		s.policy.AddHost(h)
+	}
+
+	s.handleNodeEvent([]frame{&statusChangeEventFrame{
+		change: "DOWN",
+		host:   down.ConnectAddress(),
+		port:   down.Port(),
+	}})
+
+	hosts := s.GetHosts()
+	if len(hosts) != 2 {
+		t.Fatalf("expected 2 hosts got %v", hosts)
+	}
+
+	for _, host := range hosts {
+		expected := NodeUp
+		if host.ConnectAddress().Equal(down.ConnectAddress()) {
+			expected = NodeDown
+		}
+		if state := host.State(); state != expected {
+			t.Errorf("%v: expected state %v got %v", host.ConnectAddress(), expected, state)
+		}
+		if host == up || host == down {
+			t.Fatalf("%v: expected a copy of the ring host", host.ConnectAddress())
+		}
+
+		// changing the snapshot does not change the ring
+		host.setState(NodeDown, StateChangeUnknown)
+		host.connectAddress[len(host.connectAddress)-1] = 100
+		if len(host.tokens) > 0 {
+			host.tokens[0] = "2"
+		}
+	}
+
+	if !up.IsUp() {
+		t.Error("expected ring host to stay up")
+	} else if !up.ConnectAddress().Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Errorf("expected ring host address to be unchanged got %v", up.ConnectAddress())
+	} else if up.Tokens()[0] != "1" {
+		t.Errorf("expected ring host tokens to be unchanged got %v", up.Tokens())
+	}
+}
@@ -13,6 +13,8 @@ import (
 	"net"
 	"runtime"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -158,6 +160,10 @@ const (
 	flagDefaultTimestamp      byte = 0x20
 	flagWithNameValues        byte = 0x40
 
+	// v5 query and prepare flags, written as an int
+	flagWithKeyspace        int32 = 0x80
+	flagWithPrepareKeyspace int32 = 0x01
+
 	// header flags
 	flagCompress      byte = 0x01
 	flagTracing       byte = 0x02
@@ -392,10 +398,26 @@ type framer struct {
 }
 
 func newFramer(r io.Reader, w io.Writer, compressor Compressor, version byte) *framer {
+	return newFramerSize(r, w, compressor, version, defaultBufSize)
+}
+
+// newFramerSize is like newFramer but frames are written into a buffer of
+// wbufSize, the default size if it is not positive.
+func newFramerSize(r io.Reader, w io.Writer, compressor Compressor, version byte, wbufSize int) *framer {
+	if wbufSize <= 0 {
+		wbufSize = defaultBufSize
+	}
+
 	f := &framer{
-		wbuf:       make([]byte, defaultBufSize),
+		wbuf:       make([]byte, wbufSize),
 		readBuffer: make([]byte, defaultBufSize),
 	}
+	f.reset(r, w, compressor, version)
+	return f
+}
+
+// reset prepares f to be used for a new frame, keeping its buffers.
+func (f *framer) reset(r io.Reader, w io.Writer, compressor Compressor, version byte) {
 	var flags byte
 	if compressor != nil {
 		flags |= flagCompress
@@ -421,10 +443,76 @@ func newFramer(r io.Reader, w io.Writer, compressor Compressor, version byte) *f
 
 	f.header = nil
 	f.traceID = nil
+}
+
+// FramerPoolStats are counters for the pool of framers which a session uses
+// to read event frames.
+type FramerPoolStats struct {
+	Gets   uint64 // framers taken from the pool
+	Puts   uint64 // framers returned to the pool
+	Misses uint64 // framers which were allocated as none were free in the pool
+}
 
+// framerPool recycles the framers used to read event frames, which unlike
+// query responses are parsed and discarded by the driver so their buffers can
+// be reused. Framers whose read buffer grew beyond bufSize are dropped instead
+// of being returned to the pool so that the heap is not bloated by one large
+// frame.
+type framerPool struct {
+	bufSize int
+	pool    sync.Pool
+
+	gets   uint64
+	puts   uint64
+	misses uint64
+}
+
+func newFramerPool(bufSize int) *framerPool {
+	if bufSize <= 0 {
+		bufSize = defaultBufSize
+	}
+
+	p := &framerPool{bufSize: bufSize}
+	p.pool.New = func() interface{} {
+		atomic.AddUint64(&p.misses, 1)
+		return &framer{
+			wbuf:       make([]byte, defaultBufSize),
+			readBuffer: make([]byte, bufSize),
+		}
+	}
+	return p
+}
+
+func (p *framerPool) get(r io.Reader, w io.Writer, compressor Compressor, version byte) *framer {
+	atomic.AddUint64(&p.gets, 1)
+	f := p.pool.Get().(*framer)
+	f.reset(r, w, compressor, version)
 	return f
 }
 
+func (p *framerPool) put(f *framer) {
+	if cap(f.readBuffer) > p.bufSize {
+		return
+	}
+
+	// dont hold on to the connection or anything parsed from the frame
+	f.r, f.w = nil, nil
+	f.header = nil
+	f.traceID = nil
+	f.rbuf = nil
+
+	atomic.AddUint64(&p.puts, 1)
+	p.pool.Put(f)
+}
+
+func (p *framerPool) stats() FramerPoolStats {
+	return FramerPoolStats{
+		Gets:   atomic.LoadUint64(&p.gets),
+		Puts:   atomic.LoadUint64(&p.puts),
+		Misses: atomic.LoadUint64(&p.misses),
+	}
+}
+
 type frame interface {
 	Header() frameHeader
 }
@@ -585,7 +673,7 @@ func (f *framer) parseErrorFrame() frame {
 	}
 
 	switch code {
-	case errUnavailable:
+	case ErrCodeUnavailable:
 		cl := f.readConsistency()
 		required := f.readInt()
 		alive := f.readInt()
@@ -595,7 +683,7 @@ func (f *framer) parseErrorFrame() frame {
 			Required:    required,
 			Alive:       alive,
 		}
-	case errWriteTimeout:
+	case ErrCodeWriteTimeout:
 		cl := f.readConsistency()
 		received := f.readInt()
 		blockfor := f.readInt()
@@ -607,7 +695,7 @@ func (f *framer) parseErrorFrame() frame {
 			BlockFor:    blockfor,
 			WriteType:   writeType,
 		}
-	case errReadTimeout:
+	case ErrCodeReadTimeout:
 		cl := f.readConsistency()
 		received := f.readInt()
 		blockfor := f.readInt()
@@ -619,7 +707,7 @@ func (f *framer) parseErrorFrame() frame {
 			BlockFor:    blockfor,
 			DataPresent: dataPresent,
 		}
-	case errAlreadyExists:
+	case ErrCodeAlreadyExists:
 		ks := f.readString()
 		table := f.readString()
 		return &RequestErrAlreadyExists{
@@ -627,22 +715,23 @@ func (f *framer) parseErrorFrame() frame {
 			Keyspace:   ks,
 			Table:      table,
 		}
-	case errUnprepared:
+	case ErrCodeUnprepared:
 		stmtId := f.readShortBytes()
 		return &RequestErrUnprepared{
 			errorFrame:  errD,
 			StatementId: copyBytes(stmtId), // defensively copy
 		}
-	case errReadFailure:
+	case ErrCodeReadFailure:
 		res := &RequestErrReadFailure{
 			errorFrame: errD,
 		}
 		res.Consistency = f.readConsistency()
 		res.Received = f.readInt()
 		res.BlockFor = f.readInt()
+		res.NumFailures = f.readInt()
 		res.DataPresent = f.readByte() != 0
 		return res
-	case errWriteFailure:
+	case ErrCodeWriteFailure:
 		res := &RequestErrWriteFailure{
 			errorFrame: errD,
 		}
@@ -652,16 +741,16 @@ func (f *framer) parseErrorFrame() frame {
 		res.NumFailures = f.readInt()
 		res.WriteType = f.readString()
 		return res
-	case errFunctionFailure:
-		res := RequestErrFunctionFailure{
+	case ErrCodeFunctionFailure:
+		res := &RequestErrFunctionFailure{
 			errorFrame: errD,
 		}
 		res.Keyspace = f.readString()
 		res.Function = f.readString()
 		res.ArgTypes = f.readStringList()
 		return res
-	case errInvalid, errBootstrapping, errConfig, errCredentials, errOverloaded,
-		errProtocol, errServer, errSyntax, errTruncate, errUnauthorized:
+	case ErrCodeInvalid, ErrCodeBootstrapping, ErrCodeConfig, ErrCodeCredentials, ErrCodeOverloaded,
+		ErrCodeProtocol, ErrCodeServer, ErrCodeSyntax, ErrCodeTruncate, ErrCodeUnauthorized:
 		// TODO(zariel): we should have some distinct types for these errors
 		return errD
 	default:
@@ -787,11 +876,23 @@ func (w *writeStartupFrame) writeFrame(f *framer, streamID int) error {
 
 type writePrepareFrame struct {
 	statement string
+	// v5+
+	keyspace string
 }
 
 func (w *writePrepareFrame) writeFrame(f *framer, streamID int) error {
 	f.writeHeader(f.flags, opPrepare, streamID)
 	f.writeLongString(w.statement)
+	if f.proto > protoVersion4 {
+		var flags int32
+		if w.keyspace != "" {
+			flags |= flagWithPrepareKeyspace
+		}
+		f.writeInt(flags)
+		if w.keyspace != "" {
+			f.writeString(w.keyspace)
+		}
+	}
 	return f.finishWrite()
 }
 
@@ -809,6 +910,8 @@ func (f *framer) readTypeInfo() TypeInfo {
 		simple.custom = f.readString()
 		if cassType := getApacheCassandraType(simple.custom); cassType != TypeCustom {
 			simple.typ = cassType
+		} else if vector, ok := getApacheCassandraVectorType(simple); ok {
+			return vector
 		}
 	}
 
@@ -1375,11 +1478,13 @@ type queryParams struct {
 	// v3+
 	defaultTimestamp      bool
 	defaultTimestampValue int64
+	// v5+
+	keyspace string
 }
 
 func (q queryParams) String() string {
-	return fmt.Sprintf("[query_params consistency=%v skip_meta=%v page_size=%d paging_state=%q serial_consistency=%v default_timestamp=%v values=%v]",
-		q.consistency, q.skipMeta, q.pageSize, q.pagingState, q.serialConsistency, q.defaultTimestamp, q.values)
+	return fmt.Sprintf("[query_params consistency=%v skip_meta=%v page_size=%d paging_state=%q serial_consistency=%v default_timestamp=%v keyspace=%q values=%v]",
+		q.consistency, q.skipMeta, q.pageSize, q.pagingState, q.serialConsistency, q.defaultTimestamp, q.keyspace, q.values)
 }
 
 func (f *framer) writeQueryParams(opts *queryParams) {
@@ -1420,7 +1525,16 @@ func (f *framer) writeQueryParams(opts *queryParams) {
 		}
 	}
 
-	f.writeByte(flags)
+	if f.proto > protoVersion4 {
+		// v5 widens the flags to an int to make room for the keyspace
+		v5flags := int32(flags)
+		if opts.keyspace != "" {
+			v5flags |= flagWithKeyspace
+		}
+		f.writeInt(v5flags)
+	} else {
+		f.writeByte(flags)
+	}
 
 	if n := len(opts.values); n > 0 {
 		f.writeShort(uint16(n))
@@ -1459,6 +1573,10 @@ func (f *framer) writeQueryParams(opts *queryParams) {
 		}
 		f.writeLong(ts)
 	}
+
+	if f.proto > protoVersion4 && opts.keyspace != "" {
+		f.writeString(opts.keyspace)
+	}
 }
 
 type writeQueryFrame struct {
@@ -2,7 +2,9 @@ package gocql
 
 import (
 	"bytes"
+	"fmt"
 	"os"
+	"reflect"
 	"testing"
 )
 
@@ -106,3 +108,358 @@ func TestFrameReadTooLong(t *testing.T) {
 		t.Fatalf("expected to get header %v got %v", opReady, head.op)
 	}
 }
+
+func TestWriteBatchFrameType(t *testing.T) {
+	for _, typ := range []BatchType{LoggedBatch, UnloggedBatch, CounterBatch} {
+		w := &bytes.Buffer{}
+		framer := newFramer(nil, w, nil, protoVersion3)
+
+		err := framer.writeBatchFrame(1, &writeBatchFrame{
+			typ:         typ,
+			statements:  []batchStatment{{statement: "UPDATE t SET v = 1 WHERE id = 1"}},
+			consistency: One,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the batch type is the first byte after the 9 byte v3 header
+		if got := BatchType(w.Bytes()[9]); got != typ {
+			t.Errorf("expected batch type %v got %v", typ, got)
+		}
+	}
+}
+
+func TestWriteQueryParamsKeyspace(t *testing.T) {
+	const stmt = "SELECT * FROM t"
+	// the query body is the statement, the consistency and then the flags
+	flagsAt := 9 + 4 + len(stmt) + 2
+
+	w := &bytes.Buffer{}
+	framer := newFramer(nil, w, nil, protoVersion5)
+	if err := framer.writeQueryFrame(1, stmt, &queryParams{consistency: One, keyspace: "tenant"}); err != nil {
+		t.Fatal(err)
+	}
+
+	body := w.Bytes()
+	if flags := readInt(body[flagsAt:]); flags&flagWithKeyspace == 0 {
+		t.Fatalf("expected the keyspace flag to be set got flags 0x%x", flags)
+	}
+	if ks := body[flagsAt+4:]; !bytes.Equal(ks, []byte("\x00\x06tenant")) {
+		t.Fatalf("expected the keyspace to follow the flags got %q", ks)
+	}
+
+	// before v5 the flags are a single byte and the keyspace is not sent
+	w.Reset()
+	framer = newFramer(nil, w, nil, protoVersion4)
+	if err := framer.writeQueryFrame(1, stmt, &queryParams{consistency: One, keyspace: "tenant"}); err != nil {
+		t.Fatal(err)
+	}
+	if body := w.Bytes(); len(body) != flagsAt+1 {
+		t.Fatalf("expected a %d byte frame got %d bytes %q", flagsAt+1, len(body), body)
+	}
+}
+
+func TestWritePrepareFrameKeyspace(t *testing.T) {
+	const stmt = "SELECT * FROM t"
+	flagsAt := 9 + 4 + len(stmt)
+
+	w := &bytes.Buffer{}
+	framer := newFramer(nil, w, nil, protoVersion5)
+	if err := (&writePrepareFrame{statement: stmt, keyspace: "tenant"}).writeFrame(framer, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	body := w.Bytes()
+	if flags := readInt(body[flagsAt:]); flags&flagWithPrepareKeyspace == 0 {
+		t.Fatalf("expected the keyspace flag to be set got flags 0x%x", flags)
+	}
+	if ks := body[flagsAt+4:]; !bytes.Equal(ks, []byte("\x00\x06tenant")) {
+		t.Fatalf("expected the keyspace to follow the flags got %q", ks)
+	}
+}
+
+func TestParseSupportedFrame(t *testing.T) {
+	w := newFramer(nil, nil, nil, protoVersion4)
+	w.writeShort(2)
+	w.writeString("CQL_VERSION")
+	w.writeStringList([]string{"3.4.4"})
+	w.writeString("PROTOCOL_VERSIONS")
+	w.writeStringList([]string{"3/v3", "4/v4", "5/v5-beta"})
+	body := w.wbuf
+
+	framer := newFramer(bytes.NewReader(body), nil, nil, protoVersion4)
+	head := &frameHeader{
+		version: protoVersion4 | 0x80,
+		op:      opSupported,
+		length:  len(body),
+	}
+	if err := framer.readFrame(head); err != nil {
+		t.Fatal(err)
+	}
+	frame, err := framer.parseFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	supported, ok := frame.(*supportedFrame)
+	if !ok {
+		t.Fatalf("expected a supported frame got %T", frame)
+	}
+	expected := map[string][]string{
+		"CQL_VERSION":       {"3.4.4"},
+		"PROTOCOL_VERSIONS": {"3/v3", "4/v4", "5/v5-beta"},
+	}
+	if !reflect.DeepEqual(supported.supported, expected) {
+		t.Fatalf("expected options %v got %v", expected, supported.supported)
+	}
+}
+
+// readPooledFrame reads and parses the result frame body using a framer from p.
+func readPooledFrame(p *framerPool, body []byte) error {
+	framer := p.get(bytes.NewReader(body), nil, nil, protoVersion4)
+	defer p.put(framer)
+
+	head := &frameHeader{
+		version: protoVersion4 | 0x80,
+		op:      opResult,
+		length:  len(body),
+	}
+	if err := framer.readFrame(head); err != nil {
+		return err
+	}
+	_, err := framer.parseFrame()
+	return err
+}
+
+func largeVoidFrame(n int) []byte {
+	body := make([]byte, n)
+	body[3] = byte(resultKindVoid)
+	return body
+}
+
+func TestFramerPool(t *testing.T) {
+	p := newFramerPool(1024)
+
+	if err := readPooledFrame(p, largeVoidFrame(512)); err != nil {
+		t.Fatal(err)
+	}
+	if stats := p.stats(); stats != (FramerPoolStats{Gets: 1, Puts: 1, Misses: 1}) {
+		t.Fatalf("unexpected stats after small frame: %+v", stats)
+	}
+
+	// a frame larger than the buffer size should not be returned to the pool
+	if err := readPooledFrame(p, largeVoidFrame(2048)); err != nil {
+		t.Fatal(err)
+	}
+	if stats := p.stats(); stats.Gets != 2 || stats.Puts != 1 {
+		t.Fatalf("expected large framer to be dropped: %+v", stats)
+	}
+
+	framer := p.get(nil, nil, nil, protoVersion4)
+	if cap(framer.readBuffer) != 1024 || len(framer.rbuf) != 0 {
+		t.Errorf("expected reset framer with 1024 byte buffer got cap=%d len=%d", cap(framer.readBuffer), len(framer.rbuf))
+	}
+}
+
+func TestFramerPoolBufferSizeAllocs(t *testing.T) {
+	body := largeVoidFrame(64 * 1024)
+
+	allocs := func(bufSize int) float64 {
+		p := newFramerPool(bufSize)
+		return testing.AllocsPerRun(100, func() {
+			if err := readPooledFrame(p, body); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+
+	def, tuned := allocs(0), allocs(len(body))
+	if tuned >= def {
+		t.Errorf("expected fewer allocations with a tuned buffer size: default=%v tuned=%v", def, tuned)
+	}
+}
+
+func BenchmarkFramerPoolLargeFrames(b *testing.B) {
+	body := largeVoidFrame(64 * 1024)
+
+	for _, bufSize := range []int{0, len(body)} {
+		b.Run(fmt.Sprintf("bufSize=%d", bufSize), func(b *testing.B) {
+			p := newFramerPool(bufSize)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err := readPooledFrame(p, body); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestReadTypeInfoVector(t *testing.T) {
+	framer := newFramer(nil, nil, nil, protoVersion4)
+	framer.writeShort(uint16(TypeCustom))
+	framer.writeString("org.apache.cassandra.db.marshal.VectorType(org.apache.cassandra.db.marshal.FloatType, 3)")
+	framer.rbuf = framer.wbuf
+
+	info, ok := framer.readTypeInfo().(VectorType)
+	if !ok {
+		t.Fatalf("expected VectorType got %T", info)
+	}
+	if info.SubType.Type() != TypeFloat || info.Dimensions != 3 {
+		t.Fatalf("expected vector<float, 3> got %v", info)
+	}
+}
+
+func TestParseErrorFrame(t *testing.T) {
+	head := frameHeader{version: protoVersion4 | 0x80, op: opError, stream: 1}
+	errFrame := func(code int, msg string) errorFrame {
+		return errorFrame{frameHeader: head, code: code, message: msg}
+	}
+
+	tests := []struct {
+		name  string
+		write func(f *framer)
+		err   error
+	}{
+		{
+			name: "unavailable",
+			write: func(f *framer) {
+				f.writeConsistency(Quorum)
+				f.writeInt(3)
+				f.writeInt(1)
+			},
+			err: &RequestErrUnavailable{
+				errorFrame:  errFrame(ErrCodeUnavailable, "unavailable"),
+				Consistency: Quorum,
+				Required:    3,
+				Alive:       1,
+			},
+		},
+		{
+			name: "write_timeout",
+			write: func(f *framer) {
+				f.writeConsistency(LocalQuorum)
+				f.writeInt(1)
+				f.writeInt(2)
+				f.writeString("BATCH_LOG")
+			},
+			err: &RequestErrWriteTimeout{
+				errorFrame:  errFrame(ErrCodeWriteTimeout, "write_timeout"),
+				Consistency: LocalQuorum,
+				Received:    1,
+				BlockFor:    2,
+				WriteType:   "BATCH_LOG",
+			},
+		},
+		{
+			name: "read_timeout",
+			write: func(f *framer) {
+				f.writeConsistency(One)
+				f.writeInt(0)
+				f.writeInt(1)
+				f.writeByte(1)
+			},
+			err: &RequestErrReadTimeout{
+				errorFrame:  errFrame(ErrCodeReadTimeout, "read_timeout"),
+				Consistency: One,
+				Received:    0,
+				BlockFor:    1,
+				DataPresent: 1,
+			},
+		},
+		{
+			name: "read_failure",
+			write: func(f *framer) {
+				f.writeConsistency(All)
+				f.writeInt(1)
+				f.writeInt(3)
+				f.writeInt(2)
+				f.writeByte(0)
+			},
+			err: &RequestErrReadFailure{
+				errorFrame:  errFrame(ErrCodeReadFailure, "read_failure"),
+				Consistency: All,
+				Received:    1,
+				BlockFor:    3,
+				NumFailures: 2,
+				DataPresent: false,
+			},
+		},
+		{
+			name: "write_failure",
+			write: func(f *framer) {
+				f.writeConsistency(Two)
+				f.writeInt(1)
+				f.writeInt(2)
+				f.writeInt(1)
+				f.writeString("SIMPLE")
+			},
+			err: &RequestErrWriteFailure{
+				errorFrame:  errFrame(ErrCodeWriteFailure, "write_failure"),
+				Consistency: Two,
+				Received:    1,
+				BlockFor:    2,
+				NumFailures: 1,
+				WriteType:   "SIMPLE",
+			},
+		},
+		{
+			name: "function_failure",
+			write: func(f *framer) {
+				f.writeString("ks")
+				f.writeString("fn")
+				f.writeStringList([]string{"int", "text"})
+			},
+			err: &RequestErrFunctionFailure{
+				errorFrame: errFrame(ErrCodeFunctionFailure, "function_failure"),
+				Keyspace:   "ks",
+				Function:   "fn",
+				ArgTypes:   []string{"int", "text"},
+			},
+		},
+		{
+			name: "already_exists",
+			write: func(f *framer) {
+				f.writeString("ks")
+				f.writeString("tbl")
+			},
+			err: &RequestErrAlreadyExists{
+				errorFrame: errFrame(ErrCodeAlreadyExists, "already_exists"),
+				Keyspace:   "ks",
+				Table:      "tbl",
+			},
+		},
+		{
+			name: "unprepared",
+			write: func(f *framer) {
+				f.writeShortBytes([]byte{0xde, 0xad})
+			},
+			err: &RequestErrUnprepared{
+				errorFrame:  errFrame(ErrCodeUnprepared, "unprepared"),
+				StatementId: []byte{0xde, 0xad},
+			},
+		},
+		{
+			name:  "overloaded",
+			write: func(f *framer) {},
+			err:   errFrame(ErrCodeOverloaded, "overloaded"),
+		},
+	}
+
+	for _, test := range tests {
+		w := newFramer(nil, nil, nil, protoVersion4)
+		w.writeHeader(0, opError, 1)
+		w.writeInt(int32(test.err.(RequestError).Code()))
+		w.writeString(test.name)
+		test.write(w)
+
+		r := newFramer(nil, nil, nil, protoVersion4)
+		r.header = &head
+		r.rbuf = w.wbuf[w.headSize:]
+
+		if got := r.parseErrorFrame(); !reflect.DeepEqual(got, test.err) {
+			t.Errorf("%s: expected %#v got %#v", test.name, test.err, got)
+		}
+	}
+}
@@ -1,9 +1,13 @@
 package gocql
 
 import (
+	"bufio"
+	"bytes"
 	"compress/gzip"
 	"io/ioutil"
+	"net"
 	"os"
+	"strconv"
 	"testing"
 )
 
@@ -46,3 +50,91 @@ func BenchmarkParseRowsFrame(b *testing.B) {
 		}
 	}
 }
+
+func BenchmarkScanLargeBlob(b *testing.B) {
+	data := appendBytes(nil, make([]byte, 1<<20))
+	cols := []ColumnInfo{{Name: "blob", TypeInfo: NativeType{proto: protoVersion4, typ: TypeBlob}}}
+
+	dests := []struct {
+		name string
+		dest interface{}
+	}{
+		{"copy", new([]byte)},
+		{"borrow", new(RawBytes)},
+	}
+
+	for _, test := range dests {
+		b.Run(test.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				iter := &Iter{
+					meta:    resultMetadata{columns: cols, colCount: 1, actualColCount: 1},
+					numRows: 1,
+					framer:  &framer{rbuf: data},
+				}
+				if !iter.Scan(test.dest) {
+					b.Fatal(iter.Close())
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkReadBufferSize(b *testing.B) {
+	// a large result arriving as pages of 16KB
+	var page bytes.Buffer
+	f := newFramer(nil, &page, nil, protoVersion4)
+	f.writeHeader(0, opResult, 1)
+	f.writeInt(resultKindRows)
+	f.wbuf = append(f.wbuf, make([]byte, 16<<10)...)
+	f.wbuf[0] |= 0x80
+	if err := f.finishWrite(); err != nil {
+		b.Fatal(err)
+	}
+	pages := bytes.Repeat(page.Bytes(), 64)
+
+	for _, size := range []int{defaultReadBufSize, 64 << 10, 256 << 10} {
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer ln.Close()
+
+			go func() {
+				conn, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+				for {
+					if _, err := conn.Write(pages); err != nil {
+						return
+					}
+				}
+			}()
+
+			conn, err := net.Dial("tcp", ln.Addr().String())
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer conn.Close()
+
+			r := bufio.NewReaderSize(conn, size)
+			framer := newFramer(r, nil, nil, protoVersion4)
+			buf := make([]byte, maxFrameHeaderSize)
+
+			b.SetBytes(int64(page.Len()))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				head, err := readHeader(r, buf)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if err := framer.readFrame(&head); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
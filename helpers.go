@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"math/big"
 	"reflect"
+	"strconv"
 	"strings"
 	"time"
 
@@ -59,6 +60,16 @@ func goType(t TypeInfo) reflect.Type {
 		return reflect.TypeOf(make(map[string]interface{}))
 	case TypeDate:
 		return reflect.TypeOf(*new(time.Time))
+	case TypeTime:
+		return reflect.TypeOf(*new(time.Duration))
+	case TypeDuration:
+		return reflect.TypeOf(*new(Duration))
+	case TypeCustom:
+		if vector, ok := t.(VectorType); ok {
+			return reflect.SliceOf(goType(vector.SubType))
+		}
+		// the raw bytes are the best we can do for an unknown type
+		return reflect.TypeOf(*new([]byte))
 	default:
 		return nil
 	}
@@ -90,6 +101,10 @@ func getCassandraBaseType(name string) Type {
 		return TypeInt
 	case "timestamp":
 		return TypeTimestamp
+	case "time":
+		return TypeTime
+	case "date":
+		return TypeDate
 	case "uuid":
 		return TypeUUID
 	case "varchar":
@@ -102,6 +117,8 @@ func getCassandraBaseType(name string) Type {
 		return TypeTimeUUID
 	case "inet":
 		return TypeInet
+	case "duration":
+		return TypeDuration
 	case "MapType":
 		return TypeMap
 	case "ListType":
@@ -129,7 +146,7 @@ func getCassandraType(name string) TypeInfo {
 			Elem:       getCassandraType(strings.TrimPrefix(name[:len(name)-1], "list<")),
 		}
 	} else if strings.HasPrefix(name, "map<") {
-		names := strings.Split(strings.TrimPrefix(name[:len(name)-1], "map<"), ", ")
+		names := splitCompositeTypes(strings.TrimPrefix(name[:len(name)-1], "map<"))
 		if len(names) != 2 {
 			panic(fmt.Sprintf("invalid map type: %v", name))
 		}
@@ -140,7 +157,7 @@ func getCassandraType(name string) TypeInfo {
 			Elem:       getCassandraType(names[1]),
 		}
 	} else if strings.HasPrefix(name, "tuple<") {
-		names := strings.Split(strings.TrimPrefix(name[:len(name)-1], "tuple<"), ", ")
+		names := splitCompositeTypes(strings.TrimPrefix(name[:len(name)-1], "tuple<"))
 		types := make([]TypeInfo, len(names))
 
 		for i, name := range names {
@@ -151,6 +168,22 @@ func getCassandraType(name string) TypeInfo {
 			NativeType: NativeType{typ: TypeTuple},
 			Elems:      types,
 		}
+	} else if strings.HasPrefix(name, "vector<") {
+		names := splitCompositeTypes(strings.TrimPrefix(name[:len(name)-1], "vector<"))
+		if len(names) != 2 {
+			panic(fmt.Sprintf("invalid vector type: %v", name))
+		}
+
+		dimensions, err := strconv.Atoi(names[1])
+		if err != nil {
+			panic(fmt.Sprintf("invalid vector type: %v", name))
+		}
+
+		return VectorType{
+			NativeType: NativeType{typ: TypeCustom},
+			SubType:    getCassandraType(names[0]),
+			Dimensions: dimensions,
+		}
 	} else {
 		return NativeType{
 			typ: getCassandraBaseType(name),
@@ -158,6 +191,61 @@ func getCassandraType(name string) TypeInfo {
 	}
 }
 
+// splitCompositeTypes splits the comma separated type parameters of a
+// composite type such as map or tuple, ignoring commas in nested types.
+func splitCompositeTypes(name string) []string {
+	var (
+		parts []string
+		depth int
+		start int
+	)
+	for i, c := range name {
+		switch c {
+		case '<':
+			depth++
+		case '>':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, strings.TrimSpace(name[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, strings.TrimSpace(name[start:]))
+}
+
+// getApacheCassandraVectorType parses the custom type info of a vector, which
+// is of the form VectorType(subtype, dimensions).
+func getApacheCassandraVectorType(info NativeType) (VectorType, bool) {
+	params := strings.TrimPrefix(info.custom, apacheCassandraTypePrefix+"VectorType(")
+	if len(params) == len(info.custom) || !strings.HasSuffix(params, ")") {
+		return VectorType{}, false
+	}
+
+	i := strings.LastIndex(params, ",")
+	if i < 0 {
+		return VectorType{}, false
+	}
+
+	dimensions, err := strconv.Atoi(strings.TrimSpace(params[i+1 : len(params)-1]))
+	if err != nil || dimensions <= 0 {
+		return VectorType{}, false
+	}
+
+	subClass := strings.TrimSpace(params[:i])
+	sub := NativeType{proto: info.proto, typ: getApacheCassandraType(subClass)}
+	if sub.typ == TypeCustom {
+		sub.custom = subClass
+	}
+
+	return VectorType{
+		NativeType: info,
+		SubType:    sub,
+		Dimensions: dimensions,
+	}, true
+}
+
 func getApacheCassandraType(class string) Type {
 	switch strings.TrimPrefix(class, apacheCassandraTypePrefix) {
 	case "AsciiType":
@@ -184,6 +272,10 @@ func getApacheCassandraType(class string) Type {
 		return TypeTinyInt
 	case "DateType", "TimestampType":
 		return TypeTimestamp
+	case "TimeType":
+		return TypeTime
+	case "SimpleDateType":
+		return TypeDate
 	case "UUIDType", "LexicalUUIDType":
 		return TypeUUID
 	case "UTF8Type":
@@ -194,6 +286,8 @@ func getApacheCassandraType(class string) Type {
 		return TypeTimeUUID
 	case "InetAddressType":
 		return TypeInet
+	case "DurationType":
+		return TypeDuration
 	case "MapType":
 		return TypeMap
 	case "ListType":
@@ -302,6 +396,12 @@ func (iter *Iter) SliceMap() ([]map[string]interface{}, error) {
 // MapScan takes a map[string]interface{} and populates it with a row
 // that is returned from cassandra.
 //
+// Values are typed from the column metadata, for example a list<int> is
+// returned as a []int, a map<text, frozen<address>> as a
+// map[string]map[string]interface{} and a UDT as a map[string]interface{} of
+// its fields, each of which is typed in the same way. Columns of a custom
+// type are returned as their raw []byte.
+//
 // Each call to MapScan() must be called with a new map object.
 // During the call to MapScan() any pointers in the existing map
 // are replaced with non pointer types before the call returns
@@ -358,6 +458,132 @@ func (iter *Iter) MapScan(m map[string]interface{}) bool {
 	return false
 }
 
+// SkipUnmappedColumns sets whether StructScan ignores columns which have no
+// matching struct field, by default it is an error.
+func (iter *Iter) SkipUnmappedColumns(skip bool) *Iter {
+	iter.skipUnmapped = skip
+	return iter
+}
+
+// StructScan scans the next row into the struct pointed to by dst. Each
+// column is scanned into the exported field with a matching cql tag, or
+// otherwise a matching name ignoring case. Tuple columns are matched using
+// the names from TupleColumnName. Fields tagged with cql:"-" are ignored.
+//
+// Example usage:
+//
+//	type User struct {
+//		ID    UUID   `cql:"user_id"`
+//		Name  string
+//	}
+//
+//	iter := session.Query(`SELECT user_id, name FROM users`).Iter()
+//	var user User
+//	for iter.StructScan(&user) {
+//		fmt.Println(user.ID, user.Name)
+//	}
+func (iter *Iter) StructScan(dst interface{}) bool {
+	if iter.err != nil {
+		return false
+	}
+
+	rv := reflect.ValueOf(dst)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		iter.err = fmt.Errorf("gocql: can not struct scan into %T, expected a pointer to a struct", dst)
+		return false
+	}
+	rv = rv.Elem()
+
+	rowData, _ := iter.RowData()
+	for i, col := range rowData.Columns {
+		field, ok := fieldByColumn(rv, col)
+		if ok {
+			rowData.Values[i] = field.Addr().Interface()
+		} else if iter.skipUnmapped {
+			rowData.Values[i] = nil
+		} else {
+			iter.err = fmt.Errorf("gocql: no field in %T to scan column %q into", dst, col)
+			return false
+		}
+	}
+
+	// scanning may switch to the next page which replaces iter
+	skip := iter.skipUnmapped
+	ok := iter.Scan(rowData.Values...)
+	iter.skipUnmapped = skip
+	return ok
+}
+
+// ScanAll scans all the remaining rows, fetching further pages as needed,
+// into new elements appended to the slice pointed to by dst, which must be a
+// slice of structs or of pointers to structs. Rows are scanned as with
+// StructScan. The iterator is closed and its error returned, in which case
+// dst holds the rows scanned before the error.
+//
+//	var users []User
+//	if err := session.Query(`SELECT user_id, name FROM users`).Iter().ScanAll(&users); err != nil {
+//		return err
+//	}
+func (iter *Iter) ScanAll(dst interface{}) error {
+	rv := reflect.ValueOf(dst)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Slice {
+		iter.Close()
+		return fmt.Errorf("gocql: can not scan all rows into %T, expected a pointer to a slice", dst)
+	}
+	slice := rv.Elem()
+
+	elemType := slice.Type().Elem()
+	isPtr := elemType.Kind() == reflect.Ptr
+	if isPtr {
+		elemType = elemType.Elem()
+	}
+	if elemType.Kind() != reflect.Struct {
+		iter.Close()
+		return fmt.Errorf("gocql: can not scan all rows into %T, expected a slice of structs", dst)
+	}
+
+	for {
+		elem := reflect.New(elemType)
+		if !iter.StructScan(elem.Interface()) {
+			break
+		}
+		if isPtr {
+			slice.Set(reflect.Append(slice, elem))
+		} else {
+			slice.Set(reflect.Append(slice, elem.Elem()))
+		}
+	}
+
+	return iter.Close()
+}
+
+// fieldByColumn returns the exported field of the struct v which has a cql tag
+// equal to name, or failing that the field whose name matches ignoring case.
+func fieldByColumn(v reflect.Value, name string) (reflect.Value, bool) {
+	t := v.Type()
+
+	byName := -1
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" {
+			// unexported
+			continue
+		}
+
+		tag := sf.Tag.Get("cql")
+		if tag == name {
+			return v.Field(i), true
+		} else if tag == "" && byName < 0 && strings.EqualFold(sf.Name, name) {
+			byName = i
+		}
+	}
+
+	if byName < 0 {
+		return reflect.Value{}, false
+	}
+	return v.Field(byName), true
+}
+
 func copyBytes(p []byte) []byte {
 	b := make([]byte, len(p))
 	copy(b, p)
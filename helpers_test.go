@@ -1,6 +1,7 @@
 package gocql
 
 import (
+	"database/sql"
 	"reflect"
 	"testing"
 )
@@ -59,6 +60,42 @@ func TestGetCassandraType(t *testing.T) {
 				},
 			},
 		},
+		{
+			"map<text, frozen<map<text, list<int>>>>", CollectionType{
+				NativeType: NativeType{typ: TypeMap},
+
+				Key: NativeType{typ: TypeText},
+				Elem: CollectionType{
+					NativeType: NativeType{typ: TypeMap},
+					Key:        NativeType{typ: TypeText},
+					Elem: CollectionType{
+						NativeType: NativeType{typ: TypeList},
+						Elem:       NativeType{typ: TypeInt},
+					},
+				},
+			},
+		},
+		{
+			"tuple<int, map<text, int>>", TupleTypeInfo{
+				NativeType: NativeType{typ: TypeTuple},
+
+				Elems: []TypeInfo{
+					NativeType{typ: TypeInt},
+					CollectionType{
+						NativeType: NativeType{typ: TypeMap},
+						Key:        NativeType{typ: TypeText},
+						Elem:       NativeType{typ: TypeInt},
+					},
+				},
+			},
+		},
+		{
+			"vector<float, 3>", VectorType{
+				NativeType: NativeType{typ: TypeCustom},
+				SubType:    NativeType{typ: TypeFloat},
+				Dimensions: 3,
+			},
+		},
 	}
 
 	for _, test := range tests {
@@ -72,3 +109,174 @@ func TestGetCassandraType(t *testing.T) {
 		})
 	}
 }
+
+// newTestIter returns an iter over a single row made up of the already
+// marshalled values of cols.
+func newTestIter(cols []ColumnInfo, values ...[]byte) *Iter {
+	f := newFramer(nil, nil, nil, protoVersion4)
+	for _, v := range values {
+		f.rbuf = appendBytes(f.rbuf, v)
+	}
+	return &Iter{
+		meta:    resultMetadata{columns: cols, colCount: len(cols), actualColCount: len(cols)},
+		numRows: 1,
+		framer:  f,
+	}
+}
+
+func TestStructScan(t *testing.T) {
+	cols := []ColumnInfo{
+		{Name: "user_id", TypeInfo: NativeType{typ: TypeInt, proto: protoVersion4}},
+		{Name: "name", TypeInfo: NativeType{typ: TypeVarchar, proto: protoVersion4}},
+		{Name: "extra", TypeInfo: NativeType{typ: TypeVarchar, proto: protoVersion4}},
+	}
+
+	newIter := func() *Iter {
+		return newTestIter(cols, []byte{0, 0, 0, 1}, []byte("alice"), []byte("x"))
+	}
+
+	type user struct {
+		ID   int `cql:"user_id"`
+		Name string
+		age  int
+	}
+
+	var u user
+	iter := newIter()
+	if iter.StructScan(&u) {
+		t.Fatal("expected scan to fail with an unmapped column")
+	}
+	if err := iter.Close(); err == nil {
+		t.Fatal("expected error for unmapped column")
+	}
+
+	iter = newIter().SkipUnmappedColumns(true)
+	if !iter.StructScan(&u) {
+		t.Fatalf("scan failed: %v", iter.Close())
+	}
+	if u.ID != 1 || u.Name != "alice" {
+		t.Errorf("got %+v", u)
+	}
+	if iter.StructScan(&u) {
+		t.Error("expected no more rows")
+	}
+	if err := iter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	iter = newIter()
+	if iter.StructScan(u) {
+		t.Fatal("expected scan into non pointer to fail")
+	}
+	if err := iter.Close(); err == nil {
+		t.Fatal("expected error scanning into non pointer")
+	}
+}
+
+func TestScanNullable(t *testing.T) {
+	cols := []ColumnInfo{
+		{Name: "name", TypeInfo: NativeType{typ: TypeVarchar, proto: protoVersion4}},
+		{Name: "age", TypeInfo: NativeType{typ: TypeBigInt, proto: protoVersion4}},
+		{Name: "score", TypeInfo: NativeType{typ: TypeInt, proto: protoVersion4}},
+	}
+
+	var (
+		name  sql.NullString
+		age   sql.NullInt64
+		score *int64
+	)
+	iter := newTestIter(cols, nil, nil, nil)
+	if !iter.Scan(&name, &age, &score) {
+		t.Fatalf("scan null columns: %v", iter.Close())
+	}
+	if name.Valid || age.Valid || score != nil {
+		t.Errorf("expected null columns to be invalid got %+v %+v %v", name, age, score)
+	}
+
+	iter = newTestIter(cols, []byte("bob"), make([]byte, 8), []byte{0, 0, 0, 7})
+	if !iter.Scan(&name, &age, &score) {
+		t.Fatalf("scan columns: %v", iter.Close())
+	}
+	if !name.Valid || name.String != "bob" {
+		t.Errorf("expected valid bob got %+v", name)
+	}
+	// a zero value is not null
+	if !age.Valid || age.Int64 != 0 {
+		t.Errorf("expected valid 0 got %+v", age)
+	}
+	if score == nil || *score != 7 {
+		t.Errorf("expected 7 got %v", score)
+	}
+}
+
+func TestMapScanTypes(t *testing.T) {
+	native := func(typ Type) NativeType {
+		return NativeType{typ: typ, proto: protoVersion4}
+	}
+
+	address := UDTTypeInfo{
+		NativeType: native(TypeUDT),
+		KeySpace:   "ks",
+		Name:       "address",
+		Elements: []UDTField{
+			{Name: "street", Type: native(TypeText)},
+			{Name: "zips", Type: CollectionType{NativeType: native(TypeList), Elem: native(TypeInt)}},
+			{Name: "pair", Type: TupleTypeInfo{NativeType: native(TypeTuple), Elems: []TypeInfo{native(TypeInt), native(TypeText)}}},
+		},
+	}
+
+	cols := []ColumnInfo{
+		{Name: "list", TypeInfo: CollectionType{NativeType: native(TypeList), Elem: native(TypeBigInt)}},
+		{Name: "map", TypeInfo: CollectionType{NativeType: native(TypeMap), Key: native(TypeText), Elem: address}},
+		{Name: "udt", TypeInfo: address},
+		{Name: "custom", TypeInfo: NativeType{typ: TypeCustom, proto: protoVersion4, custom: "com.example.Custom"}},
+	}
+
+	addr := map[string]interface{}{
+		"street": "main",
+		"zips":   []int{1, 2},
+		"pair":   []interface{}{3, "three"},
+	}
+	input := []interface{}{
+		[]int64{1, 2, 3},
+		map[string]map[string]interface{}{"home": addr},
+		addr,
+		[]byte{0xca, 0xfe},
+	}
+
+	var values [][]byte
+	for i, col := range cols {
+		if col.TypeInfo.Type() == TypeCustom {
+			values = append(values, input[i].([]byte))
+			continue
+		}
+
+		data, err := Marshal(col.TypeInfo, input[i])
+		if err != nil {
+			t.Fatalf("marshal %s: %v", col.Name, err)
+		}
+		values = append(values, data)
+	}
+
+	iter := newTestIter(cols, values...)
+	row := make(map[string]interface{})
+	if !iter.MapScan(row) {
+		t.Fatalf("scan failed: %v", iter.Close())
+	}
+
+	for i, col := range cols {
+		if !reflect.DeepEqual(row[col.Name], input[i]) {
+			t.Errorf("%s: expected %#v got %#v", col.Name, input[i], row[col.Name])
+		}
+	}
+
+	udt := row["udt"].(map[string]interface{})
+	if _, ok := udt["zips"].([]int); !ok {
+		t.Errorf("expected udt field zips to be []int got %T", udt["zips"])
+	}
+	if pair, ok := udt["pair"].([]interface{}); !ok {
+		t.Errorf("expected udt field pair to be []interface{} got %T", udt["pair"])
+	} else if _, ok := pair[1].(string); !ok {
+		t.Errorf("expected second tuple element to be string got %T", pair[1])
+	}
+}
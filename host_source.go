@@ -3,10 +3,12 @@ package gocql
 import (
 	"errors"
 	"fmt"
+	"math"
 	"net"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -26,6 +28,43 @@ const (
 	NodeDown
 )
 
+// StateChangeReason describes what caused the last change of a host's state.
+type StateChangeReason int
+
+const (
+	// StateChangeUnknown is used when the state of the host has not changed
+	// since it was created.
+	StateChangeUnknown StateChangeReason = iota
+	// StateChangeDiscovery is used when the host was found while connecting to
+	// the cluster.
+	StateChangeDiscovery
+	// StateChangeEvent is used when the cluster sent a status or topology event
+	// for the host.
+	StateChangeEvent
+	// StateChangeConnection is used when connecting to the host succeeded or
+	// failed.
+	StateChangeConnection
+	// StateChangeReconnect is used when the host was brought back up by the
+	// periodic reconnection of down hosts, see ClusterConfig.ReconnectInterval.
+	StateChangeReconnect
+)
+
+func (r StateChangeReason) String() string {
+	switch r {
+	case StateChangeUnknown:
+		return "unknown"
+	case StateChangeDiscovery:
+		return "discovery"
+	case StateChangeEvent:
+		return "event"
+	case StateChangeConnection:
+		return "connection"
+	case StateChangeReconnect:
+		return "reconnect"
+	}
+	return fmt.Sprintf("UNKNOWN_%d", int(r))
+}
+
 type cassVersion struct {
 	Major, Minor, Patch int
 }
@@ -83,6 +122,16 @@ func (c cassVersion) Before(major, minor, patch int) bool {
 	return false
 }
 
+// less reports whether c is an earlier version than v.
+func (c cassVersion) less(v cassVersion) bool {
+	if c.Major != v.Major {
+		return c.Major < v.Major
+	} else if c.Minor != v.Minor {
+		return c.Minor < v.Minor
+	}
+	return c.Patch < v.Patch
+}
+
 func (c cassVersion) String() string {
 	return fmt.Sprintf("v%d.%d.%d", c.Major, c.Minor, c.Patch)
 }
@@ -97,6 +146,10 @@ func (c cassVersion) nodeUpDelay() time.Duration {
 }
 
 type HostInfo struct {
+	// number of queries being executed against the host, accessed atomically
+	// and kept first so that it is 64-bit aligned
+	inFlight int64
+
 	// TODO(zariel): reduce locking maybe, not all values will change, but to ensure
 	// that we are thread safe use a mutex to access all fields.
 	mu               sync.RWMutex
@@ -116,8 +169,21 @@ type HostInfo struct {
 	partitioner      string
 	clusterName      string
 	version          cassVersion
+	cqlVersion       string
 	state            nodeState
+	stateChanged     time.Time
+	stateReason      StateChangeReason
 	tokens           []string
+
+	// exponentially weighted moving average of the latency of queries to the
+	// host, see observeLatency
+	latency         float64
+	latencyMeasured int
+	latencyUpdated  time.Time
+
+	// queries are sent to the host after other hosts until backoffUntil, see
+	// backoff
+	backoffUntil time.Time
 }
 
 func (h *HostInfo) Equal(host *HostInfo) bool {
@@ -296,15 +362,99 @@ func (h *HostInfo) setVersion(major, minor, patch int) *HostInfo {
 	return h
 }
 
+// CQLVersion returns the CQL version reported by the host, it is only
+// available for hosts which were discovered from system.local.
+func (h *HostInfo) CQLVersion() string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.cqlVersion
+}
+
 func (h *HostInfo) State() nodeState {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
 	return h.state
 }
 
-func (h *HostInfo) setState(state nodeState) *HostInfo {
+// LastStateChange returns when the state of the host last changed, it is the
+// zero time if it never has.
+func (h *HostInfo) LastStateChange() time.Time {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.stateChanged
+}
+
+// StateChangeReason returns what caused the last change of the host's state.
+func (h *HostInfo) StateChangeReason() StateChangeReason {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.stateReason
+}
+
+// latencyScale is the time scale over which the weight of the previous
+// average latency of a host decays when it is updated.
+const latencyScale = 100 * time.Millisecond
+
+// observeLatency updates the average latency of the host with a query which
+// took latency and finished at now. The weight of the previous average decays
+// with the time since it was last updated so that the average follows the
+// recent latency of the host.
+func (h *HostInfo) observeLatency(latency time.Duration, now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.latencyMeasured == 0 {
+		h.latency = float64(latency)
+	} else if delay := now.Sub(h.latencyUpdated); delay > 0 {
+		scaled := float64(delay) / float64(latencyScale)
+		prevWeight := math.Log(scaled+1) / scaled
+		h.latency = (1-prevWeight)*float64(latency) + prevWeight*h.latency
+	}
+
+	h.latencyMeasured++
+	if now.After(h.latencyUpdated) {
+		h.latencyUpdated = now
+	}
+}
+
+// backoff makes queries try other hosts before the host until the time until,
+// for hosts which are overloaded or still bootstrapping.
+func (h *HostInfo) backoff(until time.Time) {
+	h.mu.Lock()
+	if until.After(h.backoffUntil) {
+		h.backoffUntil = until
+	}
+	h.mu.Unlock()
+}
+
+// backedOff returns whether the host is backed off at now.
+func (h *HostInfo) backedOff(now time.Time) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return now.Before(h.backoffUntil)
+}
+
+// InFlight returns the number of queries currently being executed against the
+// host, which host selection policies can use to prefer less loaded hosts.
+func (h *HostInfo) InFlight() int {
+	return int(atomic.LoadInt64(&h.inFlight))
+}
+
+// latencyStats returns the average latency of the host, the number of
+// queries it was measured from and when it was last updated.
+func (h *HostInfo) latencyStats() (latency time.Duration, measured int, updated time.Time) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return time.Duration(h.latency), h.latencyMeasured, h.latencyUpdated
+}
+
+func (h *HostInfo) setState(state nodeState, reason StateChangeReason) *HostInfo {
 	h.mu.Lock()
 	defer h.mu.Unlock()
+	if h.state != state || h.stateChanged.IsZero() {
+		h.stateChanged = time.Now()
+		h.stateReason = reason
+	}
 	h.state = state
 	return h
 }
@@ -392,11 +542,62 @@ func (h *HostInfo) update(from *HostInfo) {
 	if h.version == (cassVersion{}) {
 		h.version = from.version
 	}
+	if h.cqlVersion == "" {
+		h.cqlVersion = from.cqlVersion
+	}
 	if h.tokens == nil {
 		h.tokens = from.tokens
 	}
 }
 
+// clone returns a deep copy of the host.
+func (h *HostInfo) clone() *HostInfo {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	copyIP := func(ip net.IP) net.IP {
+		if ip == nil {
+			return nil
+		}
+		return net.IP(copyBytes(ip))
+	}
+
+	var tokens []string
+	if h.tokens != nil {
+		tokens = make([]string, len(h.tokens))
+		copy(tokens, h.tokens)
+	}
+
+	return &HostInfo{
+		inFlight:         atomic.LoadInt64(&h.inFlight),
+		peer:             copyIP(h.peer),
+		broadcastAddress: copyIP(h.broadcastAddress),
+		listenAddress:    copyIP(h.listenAddress),
+		rpcAddress:       copyIP(h.rpcAddress),
+		preferredIP:      copyIP(h.preferredIP),
+		connectAddress:   copyIP(h.connectAddress),
+		port:             h.port,
+		dataCenter:       h.dataCenter,
+		rack:             h.rack,
+		hostId:           h.hostId,
+		workload:         h.workload,
+		graph:            h.graph,
+		dseVersion:       h.dseVersion,
+		partitioner:      h.partitioner,
+		clusterName:      h.clusterName,
+		version:          h.version,
+		cqlVersion:       h.cqlVersion,
+		state:            h.state,
+		stateChanged:     h.stateChanged,
+		stateReason:      h.stateReason,
+		tokens:           tokens,
+		latency:          h.latency,
+		latencyMeasured:  h.latencyMeasured,
+		latencyUpdated:   h.latencyUpdated,
+		backoffUntil:     h.backoffUntil,
+	}
+}
+
 func (h *HostInfo) IsUp() bool {
 	return h != nil && h.State() == NodeUp
 }
@@ -420,6 +621,25 @@ type ringDescriber struct {
 	mu              sync.Mutex
 	prevHosts       []*HostInfo
 	prevPartitioner string
+
+	// refreshMu serialises refreshing the ring
+	refreshMu sync.Mutex
+
+	// fetchSem limits the number of host info lookups in flight on the
+	// control connection, a nil channel does not limit them
+	fetchSem chan struct{}
+
+	// noPeersV2 is set once the control connection is found not to have the
+	// system.peers_v2 table of Cassandra 4.0, system.peers is then queried
+	noPeersV2 int32
+}
+
+func newRingDescriber(session *Session, maxConcurrentFetches int) *ringDescriber {
+	r := &ringDescriber{session: session}
+	if maxConcurrentFetches > 0 {
+		r.fetchSem = make(chan struct{}, maxConcurrentFetches)
+	}
+	return r
 }
 
 // Returns true if we are using system_schema.keyspaces instead of system.schema_keyspaces
@@ -427,7 +647,7 @@ func checkSystemSchema(control *controlConn) (bool, error) {
 	iter := control.query("SELECT * FROM system_schema.keyspaces")
 	if err := iter.err; err != nil {
 		if errf, ok := err.(*errorFrame); ok {
-			if errf.code == errSyntax {
+			if errf.code == ErrCodeSyntax {
 				return false, nil
 			}
 		}
@@ -438,8 +658,30 @@ func checkSystemSchema(control *controlConn) (bool, error) {
 	return true, nil
 }
 
-// Given a map that represents a row from either system.local or system.peers
-// return as much information as we can in *HostInfo
+// SELECT * FROM system.peers_v2 errors with an invalid request if the table
+// does not exist, before Cassandra 4.0
+func isPeersV2Missing(err error) bool {
+	reqErr, ok := err.(RequestError)
+	return ok && reqErr.Code() == ErrCodeInvalid
+}
+
+// queryPeers queries the peers of the host of the control connection, from
+// system.peers_v2 which has the native transport port of each peer if the
+// cluster has it, otherwise from system.peers.
+func (r *ringDescriber) queryPeers(ch *connHost) *Iter {
+	if atomic.LoadInt32(&r.noPeersV2) == 0 {
+		iter := ch.conn.query("SELECT * FROM system.peers_v2")
+		if !isPeersV2Missing(iter.err) {
+			return iter
+		}
+		atomic.StoreInt32(&r.noPeersV2, 1)
+	}
+
+	return ch.conn.query("SELECT * FROM system.peers")
+}
+
+// Given a map that represents a row from either system.local, system.peers or
+// system.peers_v2 return as much information as we can in *HostInfo
 func (s *Session) hostInfoFromMap(row map[string]interface{}, port int) (*HostInfo, error) {
 	const assertErrorMsg = "Assertion failed for %s"
 	var ok bool
@@ -473,6 +715,11 @@ func (s *Session) hostInfoFromMap(row map[string]interface{}, port int) (*HostIn
 				return nil, fmt.Errorf(assertErrorMsg, "release_version")
 			}
 			host.version.Set(version)
+		case "cql_version":
+			host.cqlVersion, ok = value.(string)
+			if !ok {
+				return nil, fmt.Errorf(assertErrorMsg, "cql_version")
+			}
 		case "peer":
 			ip, ok := value.(string)
 			if !ok {
@@ -507,6 +754,20 @@ func (s *Session) hostInfoFromMap(row map[string]interface{}, port int) (*HostIn
 				return nil, fmt.Errorf(assertErrorMsg, "rpc_address")
 			}
 			host.rpcAddress = net.ParseIP(ip)
+		case "native_address":
+			ip, ok := value.(string)
+			if !ok {
+				return nil, fmt.Errorf(assertErrorMsg, "native_address")
+			}
+			host.rpcAddress = net.ParseIP(ip)
+		case "native_port":
+			port, ok := value.(int)
+			if !ok {
+				return nil, fmt.Errorf(assertErrorMsg, "native_port")
+			}
+			if port > 0 {
+				host.port = port
+			}
 		case "listen_address":
 			ip, ok := value.(string)
 			if !ok {
@@ -534,8 +795,6 @@ func (s *Session) hostInfoFromMap(row map[string]interface{}, port int) (*HostIn
 				return nil, fmt.Errorf(assertErrorMsg, "dse_version")
 			}
 		}
-		// TODO(thrawn01): Add 'port'? once CASSANDRA-7544 is complete
-		// Not sure what the port field will be called until the JIRA issue is complete
 	}
 
 	ip, port := s.cfg.translateAddressPort(host.ConnectAddress(), host.port)
@@ -550,7 +809,7 @@ func (r *ringDescriber) getClusterPeerInfo() ([]*HostInfo, error) {
 	var hosts []*HostInfo
 	iter := r.session.control.withConnHost(func(ch *connHost) *Iter {
 		hosts = append(hosts, ch.host)
-		return ch.conn.query("SELECT * FROM system.peers")
+		return r.queryPeers(ch)
 	})
 
 	if iter == nil {
@@ -610,6 +869,11 @@ func (r *ringDescriber) GetHosts() ([]*HostInfo, string, error) {
 
 // Given an ip/port return HostInfo for the specified ip/port
 func (r *ringDescriber) getHostInfo(ip net.IP, port int) (*HostInfo, error) {
+	if r.fetchSem != nil {
+		r.fetchSem <- struct{}{}
+		defer func() { <-r.fetchSem }()
+	}
+
 	var host *HostInfo
 	iter := r.session.control.withConnHost(func(ch *connHost) *Iter {
 		if ch.host.ConnectAddress().Equal(ip) {
@@ -617,7 +881,7 @@ func (r *ringDescriber) getHostInfo(ip net.IP, port int) (*HostInfo, error) {
 			return nil
 		}
 
-		return ch.conn.query("SELECT * FROM system.peers")
+		return r.queryPeers(ch)
 	})
 
 	if iter != nil {
@@ -653,6 +917,14 @@ func (r *ringDescriber) getHostInfo(ip net.IP, port int) (*HostInfo, error) {
 }
 
 func (r *ringDescriber) refreshRing() error {
+	if r.session.cfg.DisableInitialHostLookup {
+		// the ring is made of the supplied hosts only
+		return nil
+	}
+
+	r.refreshMu.Lock()
+	defer r.refreshMu.Unlock()
+
 	// if we have 0 hosts this will return the previous list of hosts to
 	// attempt to reconnect to the cluster otherwise we would never find
 	// downed hosts again, could possibly have an optimisation to only
@@ -671,8 +943,8 @@ func (r *ringDescriber) refreshRing() error {
 		}
 
 		if host, ok := r.session.ring.addHostIfMissing(h); !ok {
-			r.session.pool.addHost(h)
 			r.session.policy.AddHost(h)
+			r.session.pool.addHost(h)
 		} else {
 			host.update(h)
 		}
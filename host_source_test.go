@@ -4,6 +4,7 @@ package gocql
 
 import (
 	"net"
+	"reflect"
 	"testing"
 )
 
@@ -67,6 +68,68 @@ func TestIsValidPeer(t *testing.T) {
 	}
 }
 
+func TestHostInfoFromMap(t *testing.T) {
+	s := &Session{}
+
+	row := map[string]interface{}{
+		"peer":            "10.0.0.2",
+		"rpc_address":     "10.0.0.3",
+		"data_center":     "dc1",
+		"rack":            "rack2",
+		"host_id":         TimeUUID(),
+		"release_version": "3.11.2",
+		"cql_version":     "3.4.4",
+		"tokens":          []string{"-9223372036854775808", "0"},
+	}
+
+	host, err := s.hostInfoFromMap(row, 9042)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if dc := host.DataCenter(); dc != "dc1" {
+		t.Errorf("expected data center dc1 got %q", dc)
+	}
+	if rack := host.Rack(); rack != "rack2" {
+		t.Errorf("expected rack rack2 got %q", rack)
+	}
+	if v := host.Version(); v != (cassVersion{3, 11, 2}) {
+		t.Errorf("expected release version 3.11.2 got %v", v)
+	}
+	if v := host.CQLVersion(); v != "3.4.4" {
+		t.Errorf("expected cql version 3.4.4 got %q", v)
+	}
+	if tokens := host.Tokens(); !reflect.DeepEqual(tokens, row["tokens"]) {
+		t.Errorf("expected tokens %v got %v", row["tokens"], tokens)
+	}
+	if ip := host.ConnectAddress(); !ip.Equal(net.ParseIP("10.0.0.3")) {
+		t.Errorf("expected connect address 10.0.0.3 got %v", ip)
+	}
+	if port := host.Port(); port != 9042 {
+		t.Errorf("expected port 9042 got %d", port)
+	}
+
+	// system.peers_v2 has the address and port of the native transport
+	row["native_address"] = "10.0.0.4"
+	row["native_port"] = 9043
+	delete(row, "rpc_address")
+	host, err = s.hostInfoFromMap(row, 9042)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip := host.ConnectAddress(); !ip.Equal(net.ParseIP("10.0.0.4")) {
+		t.Errorf("expected connect address 10.0.0.4 got %v", ip)
+	}
+	if port := host.Port(); port != 9043 {
+		t.Errorf("expected port 9043 got %d", port)
+	}
+
+	row["cql_version"] = 3
+	if _, err := s.hostInfoFromMap(row, 9042); err == nil {
+		t.Error("expected error for invalid cql_version type")
+	}
+}
+
 func TestGetHosts(t *testing.T) {
 	cluster := createCluster()
 	session := createSessionFromCluster(cluster, t)
@@ -118,6 +118,19 @@ func (c *Cache) removeElement(e *list.Element) {
 	}
 }
 
+// Range calls fn for each item in the cache, from the most to the least
+// recently used, without changing their order. The cache must not be modified
+// by fn.
+func (c *Cache) Range(fn func(key string, value interface{})) {
+	if c.cache == nil {
+		return
+	}
+	for e := c.ll.Front(); e != nil; e = e.Next() {
+		kv := e.Value.(*entry)
+		fn(kv.key, kv.value)
+	}
+}
+
 // Len returns the number of items in the cache.
 func (c *Cache) Len() int {
 	if c.cache == nil {
@@ -70,3 +70,25 @@ func TestRemove(t *testing.T) {
 		t.Fatal("TestRemove returned a removed entry")
 	}
 }
+
+func TestRange(t *testing.T) {
+	lru := New(0)
+	lru.Add("one", 1)
+	lru.Add("two", 2)
+	lru.Add("three", 3)
+	lru.Get("one")
+
+	var keys []string
+	lru.Range(func(key string, value interface{}) {
+		keys = append(keys, key)
+	})
+	if len(keys) != 3 || keys[0] != "one" || keys[1] != "three" || keys[2] != "two" {
+		t.Fatalf("expected keys from the most recently used got %v", keys)
+	}
+
+	// ranging does not change the order
+	lru.RemoveOldest()
+	if _, ok := lru.Get("two"); ok {
+		t.Fatal("expected the least recently used entry to be removed")
+	}
+}
@@ -6,6 +6,8 @@ package gocql
 
 import (
 	"bytes"
+	"database/sql"
+	"database/sql/driver"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -41,6 +43,8 @@ type Unmarshaler interface {
 	UnmarshalCQL(info TypeInfo, data []byte) error
 }
 
+var marshalerType = reflect.TypeOf((*Marshaler)(nil)).Elem()
+
 // Marshal returns the CQL encoding of the value for the Cassandra
 // internal type described by the info parameter.
 func Marshal(info TypeInfo, value interface{}) ([]byte, error) {
@@ -60,6 +64,23 @@ func Marshal(info TypeInfo, value interface{}) ([]byte, error) {
 
 	if v, ok := value.(Marshaler); ok {
 		return v.MarshalCQL(info)
+	} else if data, ok, err := marshalPtrReceiver(info, value); ok {
+		return data, err
+	}
+
+	if v, ok := value.(driver.Valuer); ok {
+		// types from database/sql such as sql.NullString
+		dv, err := v.Value()
+		if err != nil {
+			return nil, marshalErrorf("can not marshal %T into %s: %v", value, info, err)
+		} else if dv == nil {
+			return nil, nil
+		}
+		return Marshal(info, dv)
+	}
+
+	if vector, ok := info.(VectorType); ok {
+		return marshalVector(vector, value)
 	}
 
 	switch info.Type() {
@@ -81,8 +102,10 @@ func Marshal(info TypeInfo, value interface{}) ([]byte, error) {
 		return marshalDouble(info, value)
 	case TypeDecimal:
 		return marshalDecimal(info, value)
-	case TypeTimestamp, TypeTime:
+	case TypeTimestamp:
 		return marshalTimestamp(info, value)
+	case TypeTime:
+		return marshalTime(info, value)
 	case TypeList, TypeSet:
 		return marshalList(info, value)
 	case TypeMap:
@@ -99,6 +122,8 @@ func Marshal(info TypeInfo, value interface{}) ([]byte, error) {
 		return marshalUDT(info, value)
 	case TypeDate:
 		return marshalDate(info, value)
+	case TypeDuration:
+		return marshalDuration(info, value)
 	}
 
 	// detect protocol 2 UDT
@@ -110,6 +135,25 @@ func Marshal(info TypeInfo, value interface{}) ([]byte, error) {
 	return nil, fmt.Errorf("can not marshal %T into %s", value, info)
 }
 
+// marshalPtrReceiver marshals value with a MarshalCQL method with a pointer
+// receiver, returning false if it has none. Values such as slice elements and
+// struct fields are not addressable, so they are copied to call it. Only types
+// declared in a package can have methods, so the predeclared and unnamed
+// types, such as int, string and []byte, skip the reflection.
+func marshalPtrReceiver(info TypeInfo, value interface{}) ([]byte, bool, error) {
+	if value == nil {
+		return nil, false, nil
+	}
+	rv := reflect.ValueOf(value)
+	if t := rv.Type(); t.PkgPath() == "" || !reflect.PtrTo(t).Implements(marshalerType) {
+		return nil, false, nil
+	}
+	ptr := reflect.New(rv.Type())
+	ptr.Elem().Set(rv)
+	data, err := ptr.Interface().(Marshaler).MarshalCQL(info)
+	return data, true, err
+}
+
 // Unmarshal parses the CQL encoded data based on the info parameter that
 // describes the Cassandra internal data type and stores the result in the
 // value pointed by value.
@@ -118,10 +162,18 @@ func Unmarshal(info TypeInfo, data []byte, value interface{}) error {
 		return v.UnmarshalCQL(info, data)
 	}
 
+	if v, ok := value.(sql.Scanner); ok {
+		return unmarshalScanner(info, data, v)
+	}
+
 	if isNullableValue(value) {
 		return unmarshalNullable(info, data, value)
 	}
 
+	if vector, ok := info.(VectorType); ok {
+		return unmarshalVector(vector, data, value)
+	}
+
 	switch info.Type() {
 	case TypeVarchar, TypeAscii, TypeBlob, TypeText:
 		return unmarshalVarchar(info, data, value)
@@ -143,8 +195,10 @@ func Unmarshal(info TypeInfo, data []byte, value interface{}) error {
 		return unmarshalDouble(info, data, value)
 	case TypeDecimal:
 		return unmarshalDecimal(info, data, value)
-	case TypeTimestamp, TypeTime:
+	case TypeTimestamp:
 		return unmarshalTimestamp(info, data, value)
+	case TypeTime:
+		return unmarshalTime(info, data, value)
 	case TypeList, TypeSet:
 		return unmarshalList(info, data, value)
 	case TypeMap:
@@ -161,6 +215,8 @@ func Unmarshal(info TypeInfo, data []byte, value interface{}) error {
 		return unmarshalUDT(info, data, value)
 	case TypeDate:
 		return unmarshalDate(info, data, value)
+	case TypeDuration:
+		return unmarshalDuration(info, data, value)
 	}
 
 	// detect protocol 2 UDT
@@ -168,6 +224,16 @@ func Unmarshal(info TypeInfo, data []byte, value interface{}) error {
 		return ErrorUDTUnavailable
 	}
 
+	if v, ok := value.(*[]byte); ok && info.Type() == TypeCustom {
+		// no way to decode an unknown type, give back the raw bytes
+		if data != nil {
+			*v = copyBytes(data)
+		} else {
+			*v = nil
+		}
+		return nil
+	}
+
 	// TODO(tux21b): add the remaining types
 	return fmt.Errorf("can not unmarshal %s into %T", info, value)
 }
@@ -195,6 +261,68 @@ func unmarshalNullable(info TypeInfo, data []byte, value interface{}) error {
 	return Unmarshal(info, data, newValue.Interface())
 }
 
+// unmarshalScanner unmarshals data into the database/sql value type which
+// matches info and passes it to the Scan method of v, null is scanned as nil.
+// This allows the types such as sql.NullString to distinguish null from zero.
+func unmarshalScanner(info TypeInfo, data []byte, v sql.Scanner) error {
+	if data == nil {
+		return v.Scan(nil)
+	}
+
+	var (
+		src interface{}
+		err error
+	)
+	switch info.Type() {
+	case TypeVarchar, TypeAscii, TypeText, TypeUUID, TypeTimeUUID, TypeInet:
+		var s string
+		err = Unmarshal(info, data, &s)
+		src = s
+	case TypeBlob, TypeCustom:
+		src = copyBytes(data)
+	case TypeBoolean:
+		var b bool
+		err = Unmarshal(info, data, &b)
+		src = b
+	case TypeTinyInt, TypeSmallInt, TypeInt, TypeBigInt, TypeCounter, TypeVarint, TypeTime:
+		var i int64
+		err = Unmarshal(info, data, &i)
+		src = i
+	case TypeFloat:
+		var f float32
+		err = Unmarshal(info, data, &f)
+		src = float64(f)
+	case TypeDouble:
+		var f float64
+		err = Unmarshal(info, data, &f)
+		src = f
+	case TypeDecimal:
+		var d inf.Dec
+		err = Unmarshal(info, data, &d)
+		src = d.String()
+	case TypeTimestamp, TypeDate:
+		var t time.Time
+		err = Unmarshal(info, data, &t)
+		src = t
+	default:
+		return unmarshalErrorf("can not unmarshal %s into %T", info, v)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := v.Scan(src); err != nil {
+		return unmarshalErrorf("can not unmarshal %s into %T: %v", info, v, err)
+	}
+	return nil
+}
+
+// RawBytes is a byte slice which refers to memory owned by the driver. It can
+// be used as a scan destination for blob and text columns to avoid copying
+// large values. After a Scan into a RawBytes the slice is only valid until the
+// next call to Scan or Close on the Iter, and it must not be modified.
+type RawBytes []byte
+
 func marshalVarchar(info TypeInfo, value interface{}) ([]byte, error) {
 	switch v := value.(type) {
 	case Marshaler:
@@ -237,6 +365,10 @@ func unmarshalVarchar(info TypeInfo, data []byte, value interface{}) error {
 			*v = nil
 		}
 		return nil
+	case *RawBytes:
+		// borrow the frame buffer, capping it so appends can not clobber it
+		*v = RawBytes(data[:len(data):len(data)])
+		return nil
 	}
 	rv := reflect.ValueOf(value)
 	if rv.Kind() != reflect.Ptr {
@@ -639,6 +771,12 @@ func unmarshalVarint(info TypeInfo, data []byte, value interface{}) error {
 	if len(data) > 0 && len(data) < 8 && data[0]&0x80 > 0 {
 		int64Val -= (1 << uint(len(data)*8))
 	}
+	if int64Val < 0 {
+		switch value.(type) {
+		case *uint, *uint64, *uint32, *uint16, *uint8:
+			return unmarshalErrorf("unmarshal int: varint value %d out of range for %T", int64Val, value)
+		}
+	}
 	return unmarshalIntlike(info, int64Val, data, value)
 }
 
@@ -652,15 +790,15 @@ func marshalVarint(info TypeInfo, value interface{}) ([]byte, error) {
 	case unsetColumn:
 		return nil, nil
 	case uint64:
-		if v > uint64(math.MaxInt64) {
-			retBytes = make([]byte, 9)
-			binary.BigEndian.PutUint64(retBytes[1:], v)
+		retBytes = encVarintUint64(v)
+	case uint:
+		retBytes = encVarintUint64(uint64(v))
+	default:
+		if rv := reflect.ValueOf(value); value != nil && (rv.Kind() == reflect.Uint || rv.Kind() == reflect.Uint64) {
+			retBytes = encVarintUint64(rv.Uint())
 		} else {
-			retBytes = make([]byte, 8)
-			binary.BigEndian.PutUint64(retBytes, v)
+			retBytes, err = marshalBigInt(info, value)
 		}
-	default:
-		retBytes, err = marshalBigInt(info, value)
 	}
 
 	if err == nil {
@@ -693,6 +831,19 @@ func marshalVarint(info TypeInfo, value interface{}) ([]byte, error) {
 	return retBytes, err
 }
 
+// encVarintUint64 encodes v as an untrimmed two's complement varint, adding a
+// leading zero byte when the top bit is set so it is not read back as negative.
+func encVarintUint64(v uint64) []byte {
+	if v > uint64(math.MaxInt64) {
+		b := make([]byte, 9)
+		binary.BigEndian.PutUint64(b[1:], v)
+		return b
+	}
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
+
 func unmarshalIntlike(info TypeInfo, int64Val int64, data []byte, value interface{}) error {
 	switch v := value.(type) {
 	case *int:
@@ -966,6 +1117,93 @@ func unmarshalFloat(info TypeInfo, data []byte, value interface{}) error {
 	return unmarshalErrorf("can not unmarshal %s into %T", info, value)
 }
 
+func marshalVector(info VectorType, value interface{}) ([]byte, error) {
+	if info.SubType.Type() != TypeFloat {
+		return nil, marshalErrorf("can not marshal %s, only vectors of float are supported", info)
+	}
+
+	switch v := value.(type) {
+	case unsetColumn:
+		return nil, nil
+	case []float32:
+		if v == nil {
+			return nil, nil
+		} else if len(v) != info.Dimensions {
+			return nil, marshalErrorf("can not marshal %d elements into %s", len(v), info)
+		}
+
+		buf := make([]byte, 0, 4*len(v))
+		for _, f := range v {
+			buf = appendInt(buf, int32(math.Float32bits(f)))
+		}
+		return buf, nil
+	}
+
+	if value == nil {
+		return nil, nil
+	}
+
+	rv := reflect.ValueOf(value)
+	t := rv.Type()
+	k := t.Kind()
+	if (k != reflect.Slice && k != reflect.Array) || t.Elem().Kind() != reflect.Float32 {
+		return nil, marshalErrorf("can not marshal %T into %s", value, info)
+	} else if k == reflect.Slice && rv.IsNil() {
+		return nil, nil
+	} else if rv.Len() != info.Dimensions {
+		return nil, marshalErrorf("can not marshal %d elements into %s", rv.Len(), info)
+	}
+
+	buf := make([]byte, 0, 4*rv.Len())
+	for i := 0; i < rv.Len(); i++ {
+		buf = appendInt(buf, int32(math.Float32bits(float32(rv.Index(i).Float()))))
+	}
+	return buf, nil
+}
+
+func unmarshalVector(info VectorType, data []byte, value interface{}) error {
+	if v, ok := value.(Unmarshaler); ok {
+		return v.UnmarshalCQL(info, data)
+	}
+
+	if info.SubType.Type() != TypeFloat {
+		return unmarshalErrorf("can not unmarshal %s, only vectors of float are supported", info)
+	}
+
+	rv := reflect.ValueOf(value)
+	if rv.Kind() != reflect.Ptr {
+		return unmarshalErrorf("can not unmarshal into non-pointer %T", value)
+	}
+	rv = rv.Elem()
+	t := rv.Type()
+	k := t.Kind()
+	if (k != reflect.Slice && k != reflect.Array) || t.Elem().Kind() != reflect.Float32 {
+		return unmarshalErrorf("can not unmarshal %s into %T", info, value)
+	}
+
+	if data == nil {
+		if k == reflect.Slice {
+			rv.Set(reflect.Zero(t))
+		}
+		return nil
+	} else if len(data) != 4*info.Dimensions {
+		return unmarshalErrorf("unmarshal vector: expected %d bytes for %s got %d", 4*info.Dimensions, info, len(data))
+	}
+
+	if k == reflect.Array {
+		if rv.Len() != info.Dimensions {
+			return unmarshalErrorf("unmarshal vector: array with wrong size %d for %s", rv.Len(), info)
+		}
+	} else {
+		rv.Set(reflect.MakeSlice(t, info.Dimensions, info.Dimensions))
+	}
+
+	for i := 0; i < info.Dimensions; i++ {
+		rv.Index(i).SetFloat(float64(math.Float32frombits(uint32(decInt(data[i*4 : i*4+4])))))
+	}
+	return nil
+}
+
 func marshalDouble(info TypeInfo, value interface{}) ([]byte, error) {
 	switch v := value.(type) {
 	case Marshaler:
@@ -1007,6 +1245,41 @@ func unmarshalDouble(info TypeInfo, data []byte, value interface{}) error {
 	return unmarshalErrorf("can not unmarshal %s into %T", info, value)
 }
 
+// Decimal is an arbitrary precision CQL decimal, its value is
+// Value * 10^-Scale. It can be used in place of *inf.Dec when only math/big
+// is wanted.
+type Decimal struct {
+	Value *big.Int
+	Scale int32
+}
+
+// String returns the decimal in base 10, for example 1.25 for a Value of 125
+// and a Scale of 2.
+func (d Decimal) String() string {
+	if d.Value == nil {
+		return "<nil>"
+	}
+
+	digits := new(big.Int).Abs(d.Value).String()
+	sign := ""
+	if d.Value.Sign() < 0 {
+		sign = "-"
+	}
+
+	if d.Scale <= 0 {
+		if d.Value.Sign() == 0 {
+			return "0"
+		}
+		return sign + digits + strings.Repeat("0", int(-d.Scale))
+	}
+
+	scale := int(d.Scale)
+	if len(digits) <= scale {
+		digits = strings.Repeat("0", scale-len(digits)+1) + digits
+	}
+	return sign + digits[:len(digits)-scale] + "." + digits[len(digits)-scale:]
+}
+
 func marshalDecimal(info TypeInfo, value interface{}) ([]byte, error) {
 	if value == nil {
 		return nil, nil
@@ -1027,6 +1300,16 @@ func marshalDecimal(info TypeInfo, value interface{}) ([]byte, error) {
 		copy(buf[0:4], encInt(int32(v.Scale())))
 		copy(buf[4:], unscaled)
 		return buf, nil
+	case Decimal:
+		if v.Value == nil {
+			return nil, marshalErrorf("can not marshal %T with nil value into %s", value, info)
+		}
+
+		unscaled := encBigInt2C(v.Value)
+		buf := make([]byte, 4+len(unscaled))
+		copy(buf[0:4], encInt(v.Scale))
+		copy(buf[4:], unscaled)
+		return buf, nil
 	}
 	return nil, marshalErrorf("can not marshal %T into %s", value, info)
 }
@@ -1036,10 +1319,28 @@ func unmarshalDecimal(info TypeInfo, data []byte, value interface{}) error {
 	case Unmarshaler:
 		return v.UnmarshalCQL(info, data)
 	case *inf.Dec:
+		if len(data) == 0 {
+			*v = inf.Dec{}
+			return nil
+		} else if len(data) < 4 {
+			return unmarshalErrorf("can not unmarshal %s into %T: expected at least 4 bytes got %d", info, value, len(data))
+		}
 		scale := decInt(data[0:4])
 		unscaled := decBigInt2C(data[4:], nil)
 		*v = *inf.NewDecBig(unscaled, inf.Scale(scale))
 		return nil
+	case *Decimal:
+		if len(data) == 0 {
+			*v = Decimal{}
+			return nil
+		} else if len(data) < 4 {
+			return unmarshalErrorf("can not unmarshal %s into %T: expected at least 4 bytes got %d", info, value, len(data))
+		}
+		*v = Decimal{
+			Value: decBigInt2C(data[4:], nil),
+			Scale: decInt(data[0:4]),
+		}
+		return nil
 	}
 	return unmarshalErrorf("can not unmarshal %s into %T", info, value)
 }
@@ -1148,31 +1449,105 @@ func unmarshalTimestamp(info TypeInfo, data []byte, value interface{}) error {
 	return unmarshalErrorf("can not unmarshal %s into %T", info, value)
 }
 
+// maxTimeOfDay is the largest value of the CQL time type, the number of
+// nanoseconds in a day minus one.
+const maxTimeOfDay = int64(24*time.Hour) - 1
+
+func marshalTime(info TypeInfo, value interface{}) ([]byte, error) {
+	var nanos int64
+	switch v := value.(type) {
+	case Marshaler:
+		return v.MarshalCQL(info)
+	case unsetColumn:
+		return nil, nil
+	case int64:
+		nanos = v
+	case time.Duration:
+		nanos = v.Nanoseconds()
+	default:
+		if value == nil {
+			return nil, nil
+		}
+
+		rv := reflect.ValueOf(value)
+		if rv.Type().Kind() != reflect.Int64 {
+			return nil, marshalErrorf("can not marshal %T into %s", value, info)
+		}
+		nanos = rv.Int()
+	}
+
+	if nanos < 0 || nanos > maxTimeOfDay {
+		return nil, marshalErrorf("can not marshal %T into %s: %d is out of range for time of day", value, info, nanos)
+	}
+	return encBigInt(nanos), nil
+}
+
+func unmarshalTime(info TypeInfo, data []byte, value interface{}) error {
+	if v, ok := value.(Unmarshaler); ok {
+		return v.UnmarshalCQL(info, data)
+	}
+
+	rv := reflect.ValueOf(value)
+	if rv.Kind() != reflect.Ptr {
+		return unmarshalErrorf("can not unmarshal into non-pointer %T", value)
+	}
+	rv = rv.Elem()
+	if rv.Type().Kind() != reflect.Int64 {
+		return unmarshalErrorf("can not unmarshal %s into %T", info, value)
+	}
+
+	if len(data) == 0 {
+		rv.SetInt(0)
+		return nil
+	} else if len(data) != 8 {
+		return unmarshalErrorf("can not unmarshal %s into %T: expected 8 bytes got %d", info, value, len(data))
+	}
+
+	nanos := decBigInt(data)
+	if nanos < 0 || nanos > maxTimeOfDay {
+		return unmarshalErrorf("can not unmarshal %s into %T: %d is out of range for time of day", info, value, nanos)
+	}
+	rv.SetInt(nanos)
+	return nil
+}
+
+// dateEpochOffset is the CQL date value of the unix epoch, dates are stored as
+// an unsigned number of days with the epoch in the middle of the range.
+const dateEpochOffset = int64(1 << 31)
+
+// encDate encodes the UTC day of the unix time in milliseconds ms.
+func encDate(info TypeInfo, value interface{}, ms int64) ([]byte, error) {
+	days := ms / 86400000
+	if ms%86400000 < 0 {
+		// round down to the start of the day for dates before the epoch
+		days--
+	}
+
+	x := days + dateEpochOffset
+	if x < 0 || x > math.MaxUint32 {
+		return nil, marshalErrorf("can not marshal %T into %s: date out of range", value, info)
+	}
+	return encInt(int32(uint32(x))), nil
+}
+
 func marshalDate(info TypeInfo, value interface{}) ([]byte, error) {
-	var timestamp int64
 	switch v := value.(type) {
 	case Marshaler:
 		return v.MarshalCQL(info)
 	case unsetColumn:
 		return nil, nil
 	case int64:
-		timestamp = v
-		x := timestamp/86400000 + int64(1<<31)
-		return encInt(int32(x)), nil
+		return encDate(info, value, v)
 	case time.Time:
 		if v.IsZero() {
 			return []byte{}, nil
 		}
-		timestamp = int64(v.UTC().Unix()*1e3) + int64(v.UTC().Nanosecond()/1e6)
-		x := timestamp/86400000 + int64(1<<31)
-		return encInt(int32(x)), nil
+		return encDate(info, value, timeToDateMillis(v))
 	case *time.Time:
 		if v.IsZero() {
 			return []byte{}, nil
 		}
-		timestamp = int64(v.UTC().Unix()*1e3) + int64(v.UTC().Nanosecond()/1e6)
-		x := timestamp/86400000 + int64(1<<31)
-		return encInt(int32(x)), nil
+		return encDate(info, value, timeToDateMillis(*v))
 	case string:
 		if v == "" {
 			return []byte{}, nil
@@ -1181,9 +1556,7 @@ func marshalDate(info TypeInfo, value interface{}) ([]byte, error) {
 		if err != nil {
 			return nil, marshalErrorf("can not marshal %T into %s, date layout must be '2006-01-02'", value, info)
 		}
-		timestamp = int64(t.UTC().Unix()*1e3) + int64(t.UTC().Nanosecond()/1e6)
-		x := timestamp/86400000 + int64(1<<31)
-		return encInt(int32(x)), nil
+		return encDate(info, value, timeToDateMillis(t))
 	}
 
 	if value == nil {
@@ -1192,6 +1565,14 @@ func marshalDate(info TypeInfo, value interface{}) ([]byte, error) {
 	return nil, marshalErrorf("can not marshal %T into %s", value, info)
 }
 
+// timeToDateMillis returns the milliseconds since the epoch of t truncated to
+// the start of its UTC day, this avoids overflowing int64 nanoseconds for dates
+// far from the epoch.
+func timeToDateMillis(t time.Time) int64 {
+	t = t.UTC()
+	return (t.Unix() - int64(t.Hour()*3600+t.Minute()*60+t.Second())) * 1000
+}
+
 func unmarshalDate(info TypeInfo, data []byte, value interface{}) error {
 	switch v := value.(type) {
 	case Unmarshaler:
@@ -1200,16 +1581,198 @@ func unmarshalDate(info TypeInfo, data []byte, value interface{}) error {
 		if len(data) == 0 {
 			*v = time.Time{}
 			return nil
+		} else if len(data) != 4 {
+			return unmarshalErrorf("can not unmarshal %s into %T: expected 4 bytes got %d", info, value, len(data))
 		}
-		var origin uint32 = 1 << 31
-		var current uint32 = binary.BigEndian.Uint32(data)
-		timestamp := (int64(current) - int64(origin)) * 86400000
-		*v = time.Unix(0, timestamp*int64(time.Millisecond)).In(time.UTC)
+		days := int64(binary.BigEndian.Uint32(data)) - dateEpochOffset
+		*v = time.Unix(days*86400, 0).In(time.UTC)
+		return nil
+	case *string:
+		if len(data) == 0 {
+			*v = ""
+			return nil
+		} else if len(data) != 4 {
+			return unmarshalErrorf("can not unmarshal %s into %T: expected 4 bytes got %d", info, value, len(data))
+		}
+		days := int64(binary.BigEndian.Uint32(data)) - dateEpochOffset
+		*v = time.Unix(days*86400, 0).In(time.UTC).Format("2006-01-02")
+		return nil
+	case *int64:
+		// milliseconds since the epoch at the start of the day, matching the
+		// int64 values accepted by marshalDate
+		if len(data) == 0 {
+			*v = 0
+			return nil
+		} else if len(data) != 4 {
+			return unmarshalErrorf("can not unmarshal %s into %T: expected 4 bytes got %d", info, value, len(data))
+		}
+		days := int64(binary.BigEndian.Uint32(data)) - dateEpochOffset
+		*v = days * 86400000
 		return nil
 	}
 	return unmarshalErrorf("can not unmarshal %s into %T", info, value)
 }
 
+// Duration represents a CQL duration, which is made of a number of months,
+// days and nanoseconds. The components are stored separately as months and
+// days can not be converted to a fixed number of nanoseconds. Each component
+// may be negative, although Cassandra requires all non zero components to
+// share the same sign.
+type Duration struct {
+	Months      int32
+	Days        int32
+	Nanoseconds int64
+}
+
+func marshalDuration(info TypeInfo, value interface{}) ([]byte, error) {
+	switch v := value.(type) {
+	case Marshaler:
+		return v.MarshalCQL(info)
+	case unsetColumn:
+		return nil, nil
+	case Duration:
+		return encVints(v.Months, v.Days, v.Nanoseconds), nil
+	case *Duration:
+		if v == nil {
+			return nil, nil
+		}
+		return encVints(v.Months, v.Days, v.Nanoseconds), nil
+	case time.Duration:
+		return encVints(0, 0, v.Nanoseconds()), nil
+	case int64:
+		return encVints(0, 0, v), nil
+	case string:
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, marshalErrorf("can not marshal %T into %s: %v", value, info, err)
+		}
+		return encVints(0, 0, d.Nanoseconds()), nil
+	}
+
+	if value == nil {
+		return nil, nil
+	}
+	return nil, marshalErrorf("can not marshal %T into %s", value, info)
+}
+
+func unmarshalDuration(info TypeInfo, data []byte, value interface{}) error {
+	switch v := value.(type) {
+	case Unmarshaler:
+		return v.UnmarshalCQL(info, data)
+	case *Duration:
+		if len(data) == 0 {
+			*v = Duration{}
+			return nil
+		}
+		months, days, nanos, err := decVints(data)
+		if err != nil {
+			return unmarshalErrorf("can not unmarshal %s into %T: %v", info, value, err)
+		}
+		*v = Duration{Months: months, Days: days, Nanoseconds: nanos}
+		return nil
+	case *time.Duration:
+		if len(data) == 0 {
+			*v = 0
+			return nil
+		}
+		months, days, nanos, err := decVints(data)
+		if err != nil {
+			return unmarshalErrorf("can not unmarshal %s into %T: %v", info, value, err)
+		}
+		if months != 0 || days != 0 {
+			return unmarshalErrorf("can not unmarshal %s into %T: duration has months or days set", info, value)
+		}
+		*v = time.Duration(nanos)
+		return nil
+	}
+	return unmarshalErrorf("can not unmarshal %s into %T", info, value)
+}
+
+func encIntZigZag(n int64) uint64 {
+	return uint64((n >> 63) ^ (n << 1))
+}
+
+func decIntZigZag(n uint64) int64 {
+	return int64(n>>1) ^ -int64(n&1)
+}
+
+// encVint encodes v as a zigzag encoded variable length integer, as described
+// in the native protocol v5 spec. The number of leading 1 bits in the first
+// byte gives the number of extra bytes which follow it.
+func encVint(v int64) []byte {
+	n := encIntZigZag(v)
+
+	extra := 0
+	for extra < 8 && n>>uint(7*(extra+1)) != 0 {
+		extra++
+	}
+
+	buf := make([]byte, extra+1)
+	for i := extra; i >= 0; i-- {
+		buf[i] = byte(n)
+		n >>= 8
+	}
+	buf[0] |= ^byte(0xff >> uint(extra))
+
+	return buf
+}
+
+func decVint(data []byte) (int64, int, error) {
+	if len(data) == 0 {
+		return 0, 0, errors.New("unexpected end of data reading vint")
+	}
+
+	first := data[0]
+	extra := 0
+	for extra < 8 && first&(0x80>>uint(extra)) != 0 {
+		extra++
+	}
+
+	if len(data) < extra+1 {
+		return 0, 0, fmt.Errorf("vint expected %d bytes got %d", extra+1, len(data))
+	}
+
+	n := uint64(first & (0xff >> uint(extra)))
+	for i := 1; i <= extra; i++ {
+		n = n<<8 | uint64(data[i])
+	}
+
+	return decIntZigZag(n), extra + 1, nil
+}
+
+func encVints(months, days int32, nanos int64) []byte {
+	buf := encVint(int64(months))
+	buf = append(buf, encVint(int64(days))...)
+	return append(buf, encVint(nanos)...)
+}
+
+func decVints(data []byte) (months, days int32, nanos int64, err error) {
+	m, n, err := decVint(data)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	data = data[n:]
+
+	d, n, err := decVint(data)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	data = data[n:]
+
+	nanos, n, err = decVint(data)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	if n != len(data) {
+		return 0, 0, 0, fmt.Errorf("%d trailing bytes after duration", len(data)-n)
+	}
+	if m > math.MaxInt32 || m < math.MinInt32 || d > math.MaxInt32 || d < math.MinInt32 {
+		return 0, 0, 0, errors.New("duration months or days overflow int32")
+	}
+
+	return int32(m), int32(d), nanos, nil
+}
+
 func writeCollectionSize(info CollectionType, n int, buf *bytes.Buffer) error {
 	if info.proto > protoVersion2 {
 		if n > math.MaxInt32 {
@@ -1232,6 +1795,22 @@ func writeCollectionSize(info CollectionType, n int, buf *bytes.Buffer) error {
 	return nil
 }
 
+// writeCollectionItem writes item of type elem prefixed by its length. Cassandra
+// rejects null elements, so a nil item is an error from protocol 3, which can
+// represent them, unless it is a nested collection which is written as empty.
+func writeCollectionItem(info CollectionType, elem TypeInfo, item []byte, buf *bytes.Buffer) error {
+	if item == nil && info.proto > protoVersion2 {
+		if _, ok := elem.(CollectionType); !ok {
+			return marshalErrorf("marshal %s: can not marshal null %s elements", info, elem)
+		}
+	}
+	if err := writeCollectionSize(info, len(item), buf); err != nil {
+		return err
+	}
+	buf.Write(item)
+	return nil
+}
+
 func marshalList(info TypeInfo, value interface{}) ([]byte, error) {
 	listInfo, ok := info.(CollectionType)
 	if !ok {
@@ -1265,10 +1844,9 @@ func marshalList(info TypeInfo, value interface{}) ([]byte, error) {
 			if err != nil {
 				return nil, err
 			}
-			if err := writeCollectionSize(listInfo, len(item), buf); err != nil {
+			if err := writeCollectionItem(listInfo, listInfo.Elem, item, buf); err != nil {
 				return nil, err
 			}
-			buf.Write(item)
 		}
 		return buf.Bytes(), nil
 	case reflect.Map:
@@ -1285,17 +1863,39 @@ func marshalList(info TypeInfo, value interface{}) ([]byte, error) {
 	return nil, marshalErrorf("can not marshal %T into %s", value, info)
 }
 
-func readCollectionSize(info CollectionType, data []byte) (size, read int) {
+func readCollectionSize(info CollectionType, data []byte) (size, read int, err error) {
 	if info.proto > protoVersion2 {
-		size = int(data[0])<<24 | int(data[1])<<16 | int(data[2])<<8 | int(data[3])
+		if len(data) < 4 {
+			return 0, 0, unmarshalErrorf("unmarshal %s: unexpected eof", info)
+		}
+		size = int(int32(binary.BigEndian.Uint32(data)))
 		read = 4
 	} else {
-		size = int(data[0])<<8 | int(data[1])
+		if len(data) < 2 {
+			return 0, 0, unmarshalErrorf("unmarshal %s: unexpected eof", info)
+		}
+		size = int(binary.BigEndian.Uint16(data))
 		read = 2
 	}
 	return
 }
 
+// readCollectionItem reads a length prefixed item from data, returning the item
+// and the remaining data. The item is nil if it is null.
+func readCollectionItem(info CollectionType, data []byte) (item, rest []byte, err error) {
+	m, p, err := readCollectionSize(info, data)
+	if err != nil {
+		return nil, nil, err
+	}
+	data = data[p:]
+	if m < 0 {
+		return nil, data, nil
+	} else if m > len(data) {
+		return nil, nil, unmarshalErrorf("unmarshal %s: unexpected eof", info)
+	}
+	return data[:m], data[m:], nil
+}
+
 func unmarshalList(info TypeInfo, data []byte, value interface{}) error {
 	listInfo, ok := info.(CollectionType)
 	if !ok {
@@ -1312,7 +1912,9 @@ func unmarshalList(info TypeInfo, data []byte, value interface{}) error {
 
 	switch k {
 	case reflect.Slice, reflect.Array:
-		if data == nil {
+		// an empty value is treated as null, which is how nested collections
+		// without a null representation are written in protocol 2
+		if len(data) == 0 {
 			if k == reflect.Array {
 				return unmarshalErrorf("unmarshal list: can not store nil in array value")
 			}
@@ -1322,10 +1924,12 @@ func unmarshalList(info TypeInfo, data []byte, value interface{}) error {
 			rv.Set(reflect.Zero(t))
 			return nil
 		}
-		if len(data) < 2 {
-			return unmarshalErrorf("unmarshal list: unexpected eof")
+		n, p, err := readCollectionSize(listInfo, data)
+		if err != nil {
+			return err
+		} else if n < 0 {
+			return unmarshalErrorf("unmarshal list: negative collection size %d", n)
 		}
-		n, p := readCollectionSize(listInfo, data)
 		data = data[p:]
 		if k == reflect.Array {
 			if rv.Len() != n {
@@ -1335,15 +1939,14 @@ func unmarshalList(info TypeInfo, data []byte, value interface{}) error {
 			rv.Set(reflect.MakeSlice(t, n, n))
 		}
 		for i := 0; i < n; i++ {
-			if len(data) < 2 {
-				return unmarshalErrorf("unmarshal list: unexpected eof")
+			var item []byte
+			item, data, err = readCollectionItem(listInfo, data)
+			if err != nil {
+				return err
 			}
-			m, p := readCollectionSize(listInfo, data)
-			data = data[p:]
-			if err := Unmarshal(listInfo.Elem, data[:m], rv.Index(i).Addr().Interface()); err != nil {
+			if err := Unmarshal(listInfo.Elem, item, rv.Index(i).Addr().Interface()); err != nil {
 				return err
 			}
-			data = data[m:]
 		}
 		return nil
 	}
@@ -1385,19 +1988,17 @@ func marshalMap(info TypeInfo, value interface{}) ([]byte, error) {
 		if err != nil {
 			return nil, err
 		}
-		if err := writeCollectionSize(mapInfo, len(item), buf); err != nil {
+		if err := writeCollectionItem(mapInfo, mapInfo.Key, item, buf); err != nil {
 			return nil, err
 		}
-		buf.Write(item)
 
 		item, err = Marshal(mapInfo.Elem, rv.MapIndex(key).Interface())
 		if err != nil {
 			return nil, err
 		}
-		if err := writeCollectionSize(mapInfo, len(item), buf); err != nil {
+		if err := writeCollectionItem(mapInfo, mapInfo.Elem, item, buf); err != nil {
 			return nil, err
 		}
-		buf.Write(item)
 	}
 	return buf.Bytes(), nil
 }
@@ -1417,35 +2018,37 @@ func unmarshalMap(info TypeInfo, data []byte, value interface{}) error {
 	if t.Kind() != reflect.Map {
 		return unmarshalErrorf("can not unmarshal %s into %T", info, value)
 	}
-	if data == nil {
+	if len(data) == 0 {
 		rv.Set(reflect.Zero(t))
 		return nil
 	}
 	rv.Set(reflect.MakeMap(t))
-	if len(data) < 2 {
-		return unmarshalErrorf("unmarshal map: unexpected eof")
+	n, p, err := readCollectionSize(mapInfo, data)
+	if err != nil {
+		return err
+	} else if n < 0 {
+		return unmarshalErrorf("unmarshal map: negative collection size %d", n)
 	}
-	n, p := readCollectionSize(mapInfo, data)
 	data = data[p:]
 	for i := 0; i < n; i++ {
-		if len(data) < 2 {
-			return unmarshalErrorf("unmarshal list: unexpected eof")
+		var item []byte
+		item, data, err = readCollectionItem(mapInfo, data)
+		if err != nil {
+			return err
 		}
-		m, p := readCollectionSize(mapInfo, data)
-		data = data[p:]
 		key := reflect.New(t.Key())
-		if err := Unmarshal(mapInfo.Key, data[:m], key.Interface()); err != nil {
+		if err := Unmarshal(mapInfo.Key, item, key.Interface()); err != nil {
 			return err
 		}
-		data = data[m:]
 
-		m, p = readCollectionSize(mapInfo, data)
-		data = data[p:]
+		item, data, err = readCollectionItem(mapInfo, data)
+		if err != nil {
+			return err
+		}
 		val := reflect.New(t.Elem())
-		if err := Unmarshal(mapInfo.Elem, data[:m], val.Interface()); err != nil {
+		if err := Unmarshal(mapInfo.Elem, item, val.Interface()); err != nil {
 			return err
 		}
-		data = data[m:]
 
 		rv.SetMapIndex(key.Elem(), val.Elem())
 	}
@@ -1539,19 +2142,14 @@ func marshalInet(info TypeInfo, value interface{}) ([]byte, error) {
 	case unsetColumn:
 		return nil, nil
 	case net.IP:
-		t := val.To4()
-		if t == nil {
-			return val.To16(), nil
+		if val == nil {
+			return nil, nil
 		}
-		return t, nil
+		return encInet(info, val)
 	case string:
 		b := net.ParseIP(val)
 		if b != nil {
-			t := b.To4()
-			if t == nil {
-				return b.To16(), nil
-			}
-			return t, nil
+			return encInet(info, b)
 		}
 		return nil, marshalErrorf("cannot marshal. invalid ip string %s", val)
 	}
@@ -1560,14 +2158,38 @@ func marshalInet(info TypeInfo, value interface{}) ([]byte, error) {
 		return nil, nil
 	}
 
+	// types defined as net.IP or []byte
+	rv := reflect.ValueOf(value)
+	if t := rv.Type(); t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8 {
+		if rv.IsNil() {
+			return nil, nil
+		}
+		return encInet(info, net.IP(rv.Bytes()))
+	}
+
 	return nil, marshalErrorf("cannot marshal %T into %s", value, info)
 }
 
+// encInet returns the 4 byte form of IPv4 and IPv4-mapped IPv6 addresses and
+// the 16 byte form of all other IPv6 addresses.
+func encInet(info TypeInfo, ip net.IP) ([]byte, error) {
+	if v4 := ip.To4(); v4 != nil {
+		return v4, nil
+	} else if v6 := ip.To16(); v6 != nil {
+		return v6, nil
+	}
+	return nil, marshalErrorf("cannot marshal %s: invalid sized IP: got %d bytes not 4 or 16", info, len(ip))
+}
+
 func unmarshalInet(info TypeInfo, data []byte, value interface{}) error {
 	switch v := value.(type) {
 	case Unmarshaler:
 		return v.UnmarshalCQL(info, data)
 	case *net.IP:
+		if len(data) == 0 {
+			*v = nil
+			return nil
+		}
 		if x := len(data); !(x == 4 || x == 16) {
 			return unmarshalErrorf("cannot unmarshal %s into %T: invalid sized IP: got %d bytes not 4 or 16", info, value, x)
 		}
@@ -1584,6 +2206,9 @@ func unmarshalInet(info TypeInfo, data []byte, value interface{}) error {
 			*v = ""
 			return nil
 		}
+		if x := len(data); !(x == 4 || x == 16) {
+			return unmarshalErrorf("cannot unmarshal %s into %T: invalid sized IP: got %d bytes not 4 or 16", info, value, x)
+		}
 		ip := net.IP(data)
 		if v4 := ip.To4(); v4 != nil {
 			*v = v4.String()
@@ -1592,6 +2217,20 @@ func unmarshalInet(info TypeInfo, data []byte, value interface{}) error {
 		*v = ip.String()
 		return nil
 	}
+
+	rv := reflect.ValueOf(value)
+	if rv.Kind() != reflect.Ptr {
+		return unmarshalErrorf("can not unmarshal into non-pointer %T", value)
+	}
+	rv = rv.Elem()
+	if t := rv.Type(); t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8 {
+		var ip net.IP
+		if err := unmarshalInet(info, data, &ip); err != nil {
+			return err
+		}
+		rv.SetBytes(ip)
+		return nil
+	}
 	return unmarshalErrorf("cannot unmarshal %s into %T", info, value)
 }
 
@@ -1693,6 +2332,11 @@ func unmarshalTuple(info TypeInfo, data []byte, value interface{}) error {
 			var p []byte
 			p, data = readBytes(data)
 
+			if v[i] == nil {
+				// skip this element
+				continue
+			}
+
 			err := Unmarshal(elem, p, v[i])
 			if err != nil {
 				return err
@@ -2068,6 +2712,23 @@ func (t TupleTypeInfo) New() interface{} {
 	return reflect.New(goType(t)).Interface()
 }
 
+// VectorType is the type info of a vector<subtype, dimensions> column, which
+// Cassandra 5.0 sends as the custom type
+// org.apache.cassandra.db.marshal.VectorType.
+type VectorType struct {
+	NativeType
+	SubType    TypeInfo
+	Dimensions int
+}
+
+func (v VectorType) New() interface{} {
+	return reflect.New(goType(v)).Interface()
+}
+
+func (v VectorType) String() string {
+	return fmt.Sprintf("vector<%v, %d>", v.SubType, v.Dimensions)
+}
+
 type UDTField struct {
 	Name string
 	Type TypeInfo
@@ -2130,6 +2791,7 @@ const (
 	TypeTime      Type = 0x0012
 	TypeSmallInt  Type = 0x0013
 	TypeTinyInt   Type = 0x0014
+	TypeDuration  Type = 0x0015
 	TypeList      Type = 0x0020
 	TypeMap       Type = 0x0021
 	TypeSet       Type = 0x0022
@@ -2180,6 +2842,8 @@ func (t Type) String() string {
 		return "smallint"
 	case TypeTinyInt:
 		return "tinyint"
+	case TypeDuration:
+		return "duration"
 	case TypeList:
 		return "list"
 	case TypeMap:
@@ -4,6 +4,8 @@ package gocql
 
 import (
 	"bytes"
+	"database/sql"
+	"fmt"
 	"math"
 	"math/big"
 	"net"
@@ -875,6 +877,83 @@ var marshalTests = []struct {
 		nil,
 		nil,
 	},
+	{
+		NativeType{proto: 2, typ: TypeDecimal},
+		[]byte("\x00\x00\x00\x02\x19"),
+		Decimal{Value: big.NewInt(25), Scale: 2},
+		nil,
+		nil,
+	},
+	{
+		NativeType{proto: 2, typ: TypeDecimal},
+		[]byte("\x00\x00\x00\x12\xF2\xD8\x02\xB6R\x7F\x99\xEE\x98#\x99\xA9V"),
+		Decimal{Value: bigintize("-1042342234234123423435647768234"), Scale: 18},
+		nil,
+		nil,
+	},
+	{
+		NativeType{proto: 2, typ: TypeDecimal},
+		[]byte("\xFF\xFF\xFF\xFE\x01\x00\x00\x00\x00\x00\x00\x00\x00"),
+		Decimal{Value: bigintize("18446744073709551616"), Scale: -2},
+		nil,
+		nil,
+	},
+	{
+		NativeType{proto: 2, typ: TypeDecimal},
+		[]byte("\x00\x00\x00\x01\xFF"),
+		Decimal{Value: big.NewInt(-1), Scale: 1},
+		nil,
+		nil,
+	},
+	{
+		NativeType{proto: 2, typ: TypeDecimal},
+		[]byte("\x00\x00\x00\x03\x80"),
+		Decimal{Value: big.NewInt(-128), Scale: 3},
+		nil,
+		nil,
+	},
+	{
+		NativeType{proto: 2, typ: TypeDecimal},
+		[]byte("\x00\x00\x00\x00\x00\x80"),
+		Decimal{Value: big.NewInt(128), Scale: 0},
+		nil,
+		nil,
+	},
+	{
+		NativeType{proto: 2, typ: TypeDecimal},
+		[]byte("\x00\x00\x00\x00\xFF\x00"),
+		Decimal{Value: big.NewInt(-256), Scale: 0},
+		nil,
+		nil,
+	},
+	{
+		NativeType{proto: 5, typ: TypeDuration},
+		[]byte("\x00\x00\x00"),
+		Duration{},
+		nil,
+		nil,
+	},
+	{
+		NativeType{proto: 5, typ: TypeDuration},
+		[]byte("\x02\x04\x06"),
+		Duration{Months: 1, Days: 2, Nanoseconds: 3},
+		nil,
+		nil,
+	},
+	{
+		NativeType{proto: 5, typ: TypeDuration},
+		[]byte("\x01\x03\x05"),
+		Duration{Months: -1, Days: -2, Nanoseconds: -3},
+		nil,
+		nil,
+	},
+	{
+		NativeType{proto: 5, typ: TypeDuration},
+		[]byte("\x02\x01\x87\xd0"),
+		Duration{Months: 1, Days: -1, Nanoseconds: 1000},
+		nil,
+		nil,
+	},
 }
 
 func decimalize(s string) *inf.Dec {
@@ -1069,6 +1148,37 @@ func TestMarshalVarint(t *testing.T) {
 	}
 }
 
+func TestMarshalVarintUnsigned(t *testing.T) {
+	type namedUint64 uint64
+	info := NativeType{proto: 2, typ: TypeVarint}
+
+	for i, value := range []interface{}{uint(math.MaxUint64), namedUint64(math.MaxUint64)} {
+		data, err := Marshal(info, value)
+		if err != nil {
+			t.Errorf("error marshaling varint: %v (test #%d)", err, i)
+			continue
+		}
+		if expected := []byte("\x00\xFF\xFF\xFF\xFF\xFF\xFF\xFF\xFF"); !bytes.Equal(expected, data) {
+			t.Errorf("marshaled varint mismatch: expected %v, got %v (test #%d)", expected, data, i)
+		}
+	}
+
+	negatives := [][]byte{
+		[]byte("\xFF"),
+		[]byte("\x80\x00"),
+		[]byte("\x80\x00\x00\x00\x00\x00\x00\x00"),
+	}
+	for i, data := range negatives {
+		for _, binder := range []interface{}{new(uint), new(uint64), new(uint32), new(uint16), new(uint8), new(namedUint64)} {
+			if err := Unmarshal(info, data, binder); err == nil {
+				t.Errorf("expected error unmarshaling negative varint %v into %T (test #%d)", data, binder, i)
+			} else if _, ok := err.(UnmarshalError); !ok {
+				t.Errorf("expected UnmarshalError, got %T: %v (test #%d)", err, err, i)
+			}
+		}
+	}
+}
+
 func equalStringSlice(leftList, rightList []string) bool {
 	if len(leftList) != len(rightList) {
 		return false
@@ -1122,6 +1232,236 @@ func TestMarshalList(t *testing.T) {
 	}
 }
 
+func TestMarshalNestedCollections(t *testing.T) {
+	tests := []struct {
+		proto byte
+		data  []byte
+	}{
+		{protoVersion2, []byte("\x00\x01\x00\x01a\x00\x08\x00\x01\x00\x04\x00\x00\x00\x01")},
+		{protoVersion3, []byte("\x00\x00\x00\x01\x00\x00\x00\x01a\x00\x00\x00\x0c\x00\x00\x00\x01\x00\x00\x00\x04\x00\x00\x00\x01")},
+	}
+
+	for _, test := range tests {
+		// map<text, frozen<list<int>>>
+		info := CollectionType{
+			NativeType: NativeType{proto: test.proto, typ: TypeMap},
+			Key:        NativeType{proto: test.proto, typ: TypeVarchar},
+			Elem: CollectionType{
+				NativeType: NativeType{proto: test.proto, typ: TypeList},
+				Elem:       NativeType{proto: test.proto, typ: TypeInt},
+			},
+		}
+
+		value := map[string][]int{"a": {1}}
+		data, err := Marshal(info, value)
+		if err != nil {
+			t.Errorf("proto %d: marshal: %v", test.proto, err)
+			continue
+		} else if !bytes.Equal(data, test.data) {
+			t.Errorf("proto %d: expected % X got % X", test.proto, test.data, data)
+		}
+
+		var got map[string][]int
+		if err := Unmarshal(info, data, &got); err != nil {
+			t.Errorf("proto %d: unmarshal: %v", test.proto, err)
+		} else if !reflect.DeepEqual(got, value) {
+			t.Errorf("proto %d: expected %v got %v", test.proto, value, got)
+		}
+
+		// nil and empty nested lists both read back as nil
+		data, err = Marshal(info, map[string][]int{"a": nil, "b": {}})
+		if err != nil {
+			t.Errorf("proto %d: marshal: %v", test.proto, err)
+			continue
+		}
+		got = nil
+		if err := Unmarshal(info, data, &got); err != nil {
+			t.Errorf("proto %d: unmarshal: %v", test.proto, err)
+		} else if len(got) != 2 || got["a"] != nil || len(got["b"]) != 0 {
+			t.Errorf("proto %d: expected empty lists got %v", test.proto, got)
+		}
+
+		// map<text, frozen<map<text, int>>>
+		nested := CollectionType{
+			NativeType: NativeType{proto: test.proto, typ: TypeMap},
+			Key:        NativeType{proto: test.proto, typ: TypeVarchar},
+			Elem: CollectionType{
+				NativeType: NativeType{proto: test.proto, typ: TypeMap},
+				Key:        NativeType{proto: test.proto, typ: TypeVarchar},
+				Elem:       NativeType{proto: test.proto, typ: TypeInt},
+			},
+		}
+		nestedValue := map[string]map[string]int{"a": {"x": 1, "y": 2}, "b": {"z": 3}}
+		data, err = Marshal(nested, nestedValue)
+		if err != nil {
+			t.Errorf("proto %d: marshal: %v", test.proto, err)
+			continue
+		}
+		var nestedGot map[string]map[string]int
+		if err := Unmarshal(nested, data, &nestedGot); err != nil {
+			t.Errorf("proto %d: unmarshal: %v", test.proto, err)
+		} else if !reflect.DeepEqual(nestedGot, nestedValue) {
+			t.Errorf("proto %d: expected %v got %v", test.proto, nestedValue, nestedGot)
+		}
+	}
+}
+
+func TestMarshalSetOfUDTs(t *testing.T) {
+	type address struct {
+		Street string  `cql:"street"`
+		Number *int    `cql:"number"`
+		Zip    *string `cql:"zip"`
+	}
+
+	for _, proto := range []byte{protoVersion2, protoVersion3} {
+		info := CollectionType{
+			NativeType: NativeType{proto: proto, typ: TypeSet},
+			Elem: UDTTypeInfo{
+				NativeType: NativeType{proto: proto, typ: TypeUDT},
+				Name:       "address",
+				Elements: []UDTField{
+					{Name: "street", Type: NativeType{proto: proto, typ: TypeVarchar}},
+					{Name: "number", Type: NativeType{proto: proto, typ: TypeInt}},
+					{Name: "zip", Type: NativeType{proto: proto, typ: TypeVarchar}},
+				},
+			},
+		}
+
+		number, zip := 10, "12345"
+		value := []map[string]interface{}{
+			{"street": "main", "number": number, "zip": zip},
+			// fields which are missing or nil are null
+			{"street": "high", "zip": nil},
+		}
+		data, err := Marshal(info, value)
+		if err != nil {
+			t.Errorf("proto %d: marshal: %v", proto, err)
+			continue
+		}
+
+		var got []address
+		if err := Unmarshal(info, data, &got); err != nil {
+			t.Errorf("proto %d: unmarshal: %v", proto, err)
+			continue
+		}
+
+		exp := []address{
+			{Street: "main", Number: &number, Zip: &zip},
+			{Street: "high"},
+		}
+		if !reflect.DeepEqual(got, exp) {
+			t.Errorf("proto %d: expected %+v got %+v", proto, exp, got)
+		}
+	}
+}
+
+func TestMarshalCollectionNullElements(t *testing.T) {
+	info := CollectionType{
+		NativeType: NativeType{proto: protoVersion3, typ: TypeList},
+		Elem:       NativeType{proto: protoVersion3, typ: TypeBlob},
+	}
+
+	// Cassandra rejects null elements so they are not sent
+	if _, err := Marshal(info, [][]byte{[]byte("a"), nil}); err == nil {
+		t.Fatal("expected error marshalling a null list element")
+	} else if _, ok := err.(MarshalError); !ok {
+		t.Fatalf("expected MarshalError got %T: %v", err, err)
+	}
+	mapInfo := CollectionType{
+		NativeType: NativeType{proto: protoVersion3, typ: TypeMap},
+		Key:        NativeType{proto: protoVersion3, typ: TypeVarchar},
+		Elem:       NativeType{proto: protoVersion3, typ: TypeBlob},
+	}
+	if _, err := Marshal(mapInfo, map[string][]byte{"a": nil}); err == nil {
+		t.Fatal("expected error marshalling a null map value")
+	}
+
+	// nested collections which are nil are written as empty
+	nested := CollectionType{
+		NativeType: NativeType{proto: protoVersion3, typ: TypeList},
+		Elem:       CollectionType{NativeType: NativeType{proto: protoVersion3, typ: TypeList}, Elem: info.Elem},
+	}
+	if data, err := Marshal(nested, [][][]byte{nil}); err != nil {
+		t.Fatal(err)
+	} else if exp := []byte("\x00\x00\x00\x01\x00\x00\x00\x00"); !bytes.Equal(data, exp) {
+		t.Fatalf("expected % X got % X", exp, data)
+	}
+
+	// null elements read from the server are unmarshalled as nil
+	data := []byte("\x00\x00\x00\x02\x00\x00\x00\x01a\xFF\xFF\xFF\xFF")
+	var got []*[]byte
+	if err := Unmarshal(info, data, &got); err != nil {
+		t.Fatal(err)
+	} else if len(got) != 2 || got[0] == nil || string(*got[0]) != "a" || got[1] != nil {
+		t.Fatalf("expected [a <nil>] got %v", got)
+	}
+
+	// protocol 2 has no null elements so nil is written as empty
+	info.proto, info.Elem = protoVersion2, NativeType{proto: protoVersion2, typ: TypeBlob}
+	if data, err := Marshal(info, [][]byte{nil}); err != nil {
+		t.Fatal(err)
+	} else if exp := []byte("\x00\x01\x00\x00"); !bytes.Equal(data, exp) {
+		t.Fatalf("expected % X got % X", exp, data)
+	}
+
+	// element lengths past the end of the data are an error rather than a panic
+	truncated := []byte("\x00\x00\x00\x01\x00\x00\x00\x08a")
+	info.proto, info.Elem = protoVersion3, NativeType{proto: protoVersion3, typ: TypeBlob}
+	var s [][]byte
+	if err := Unmarshal(info, truncated, &s); err == nil {
+		t.Fatal("expected error unmarshalling truncated list")
+	} else if _, ok := err.(UnmarshalError); !ok {
+		t.Fatalf("expected UnmarshalError got %T: %v", err, err)
+	}
+}
+
+func TestMarshalSQLNullTypes(t *testing.T) {
+	tests := []struct {
+		info  NativeType
+		value interface{}
+		data  []byte
+	}{
+		{NativeType{proto: 4, typ: TypeVarchar}, sql.NullString{String: "a", Valid: true}, []byte("a")},
+		{NativeType{proto: 4, typ: TypeInt}, sql.NullInt64{Int64: 1, Valid: true}, []byte{0, 0, 0, 1}},
+		{NativeType{proto: 4, typ: TypeDouble}, sql.NullFloat64{Float64: 1.5, Valid: true}, []byte{0x3f, 0xf8, 0, 0, 0, 0, 0, 0}},
+		{NativeType{proto: 4, typ: TypeBoolean}, sql.NullBool{Bool: true, Valid: true}, []byte{1}},
+		{NativeType{proto: 4, typ: TypeVarchar}, sql.NullString{}, nil},
+		{NativeType{proto: 4, typ: TypeInt}, sql.NullInt64{}, nil},
+	}
+
+	for i, test := range tests {
+		data, err := Marshal(test.info, test.value)
+		if err != nil {
+			t.Errorf("%d: marshal %v: %v", i, test.value, err)
+			continue
+		} else if !bytes.Equal(data, test.data) {
+			t.Errorf("%d: marshal %v: expected % X got % X", i, test.value, test.data, data)
+		}
+
+		dst := reflect.New(reflect.TypeOf(test.value))
+		if err := Unmarshal(test.info, data, dst.Interface()); err != nil {
+			t.Errorf("%d: unmarshal % X: %v", i, data, err)
+		} else if got := dst.Elem().Interface(); !reflect.DeepEqual(got, test.value) {
+			t.Errorf("%d: unmarshal % X: expected %+v got %+v", i, data, test.value, got)
+		}
+	}
+
+	var s sql.NullString
+	if err := Unmarshal(NativeType{proto: 4, typ: TypeUUID}, []byte("\x6b\xa7\xb8\x10\x9d\xad\x11\xd1\x80\xb4\x00\xc0\x4f\xd4\x30\xc8"), &s); err != nil {
+		t.Fatal(err)
+	} else if !s.Valid || s.String != "6ba7b810-9dad-11d1-80b4-00c04fd430c8" {
+		t.Fatalf("expected valid uuid string got %+v", s)
+	}
+
+	info := CollectionType{
+		NativeType: NativeType{proto: 4, typ: TypeList},
+		Elem:       NativeType{proto: 4, typ: TypeInt},
+	}
+	if err := Unmarshal(info, []byte{0, 0, 0, 0}, &s); err == nil {
+		t.Fatal("expected error unmarshalling a list into sql.NullString")
+	}
+}
+
 type CustomString string
 
 func (c CustomString) MarshalCQL(info TypeInfo) ([]byte, error) {
@@ -1196,6 +1536,75 @@ func TestMarshalPointer(t *testing.T) {
 	}
 }
 
+// money is stored as text with two decimal places, it is marshalled through a
+// pointer receiver to check that values which are not addressable use it.
+type money int64
+
+func (m *money) MarshalCQL(info TypeInfo) ([]byte, error) {
+	if info.Type() != TypeVarchar {
+		return nil, marshalErrorf("can not marshal money into %s", info)
+	}
+	return []byte(fmt.Sprintf("%d.%02d", *m/100, *m%100)), nil
+}
+
+func (m *money) UnmarshalCQL(info TypeInfo, data []byte) error {
+	if data == nil {
+		*m = 0
+		return nil
+	}
+	var units, cents int64
+	if _, err := fmt.Sscanf(string(data), "%d.%02d", &units, &cents); err != nil {
+		return unmarshalErrorf("can not unmarshal money %q: %v", data, err)
+	}
+	*m = money(units*100 + cents)
+	return nil
+}
+
+func TestMarshalCustomType(t *testing.T) {
+	info := NativeType{proto: 4, typ: TypeVarchar}
+
+	data, err := Marshal(info, money(1234))
+	if err != nil {
+		t.Fatal(err)
+	} else if string(data) != "12.34" {
+		t.Fatalf("expected 12.34 got %q", data)
+	}
+
+	var m money
+	if err := Unmarshal(info, []byte("5.07"), &m); err != nil {
+		t.Fatal(err)
+	} else if m != 507 {
+		t.Fatalf("expected 507 got %d", m)
+	}
+
+	if _, err := Marshal(NativeType{proto: 4, typ: TypeInt}, money(1)); err == nil {
+		t.Fatal("expected the error from MarshalCQL to be returned")
+	}
+	if err := Unmarshal(info, []byte("x"), &m); err == nil {
+		t.Fatal("expected the error from UnmarshalCQL to be returned")
+	}
+
+	// the elements of collections use the custom encoding
+	listInfo := CollectionType{
+		NativeType: NativeType{proto: 4, typ: TypeList},
+		Elem:       info,
+	}
+	values := []money{100, 1}
+	data, err = Marshal(listInfo, values)
+	if err != nil {
+		t.Fatal(err)
+	} else if exp := []byte("\x00\x00\x00\x02\x00\x00\x00\x041.00\x00\x00\x00\x040.01"); !bytes.Equal(data, exp) {
+		t.Fatalf("expected % X got % X", exp, data)
+	}
+
+	var got []money
+	if err := Unmarshal(listInfo, data, &got); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(got, values) {
+		t.Fatalf("expected %v got %v", values, got)
+	}
+}
+
 func TestMarshalTimestamp(t *testing.T) {
 	var marshalTimestampTests = []struct {
 		Info  TypeInfo
@@ -1342,6 +1751,339 @@ func TestUnmarshalInetCopyBytes(t *testing.T) {
 	}
 }
 
+func TestMarshalInetSizes(t *testing.T) {
+	info := NativeType{proto: 4, typ: TypeInet}
+
+	tests := []struct {
+		value interface{}
+		data  []byte
+		ip    net.IP
+	}{
+		{net.IPv4(10, 0, 0, 1), []byte{10, 0, 0, 1}, net.IPv4(10, 0, 0, 1).To4()},
+		{net.IPv4(10, 0, 0, 1).To4(), []byte{10, 0, 0, 1}, net.IPv4(10, 0, 0, 1).To4()},
+		{net.ParseIP("::ffff:10.0.0.1"), []byte{10, 0, 0, 1}, net.IPv4(10, 0, 0, 1).To4()},
+		{"::ffff:10.0.0.1", []byte{10, 0, 0, 1}, net.IPv4(10, 0, 0, 1).To4()},
+		{net.IPv6loopback, net.IPv6loopback, net.IPv6loopback},
+		{"2001:db8::1", net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::1")},
+	}
+
+	for i, test := range tests {
+		data, err := Marshal(info, test.value)
+		if err != nil {
+			t.Errorf("%d: marshal %v: %v", i, test.value, err)
+			continue
+		} else if !bytes.Equal(data, test.data) {
+			t.Errorf("%d: marshal %v: expected % X got % X", i, test.value, test.data, data)
+		}
+
+		var ip net.IP
+		if err := Unmarshal(info, data, &ip); err != nil {
+			t.Errorf("%d: unmarshal % X: %v", i, data, err)
+		} else if !bytes.Equal(ip, test.ip) {
+			t.Errorf("%d: unmarshal % X: expected %v got %v", i, data, test.ip, ip)
+		}
+	}
+}
+
+func TestMarshalVector(t *testing.T) {
+	info := VectorType{
+		NativeType: NativeType{proto: 4, typ: TypeCustom, custom: "org.apache.cassandra.db.marshal.VectorType(org.apache.cassandra.db.marshal.FloatType, 3)"},
+		SubType:    NativeType{proto: 4, typ: TypeFloat},
+		Dimensions: 3,
+	}
+
+	exp := []byte("\x3f\x80\x00\x00\xc0\x00\x00\x00\x00\x00\x00\x00")
+	data, err := Marshal(info, []float32{1, -2, 0})
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(data, exp) {
+		t.Fatalf("expected % X got % X", exp, data)
+	}
+
+	var vec []float32
+	if err := Unmarshal(info, data, &vec); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(vec, []float32{1, -2, 0}) {
+		t.Fatalf("expected [1 -2 0] got %v", vec)
+	}
+
+	var arr [3]float32
+	if err := Unmarshal(info, data, &arr); err != nil {
+		t.Fatal(err)
+	} else if arr != [3]float32{1, -2, 0} {
+		t.Fatalf("expected [1 -2 0] got %v", arr)
+	}
+
+	if _, ok := info.New().(*[]float32); !ok {
+		t.Errorf("expected New to return *[]float32 got %T", info.New())
+	}
+
+	if data, err := Marshal(info, []float32(nil)); err != nil || data != nil {
+		t.Errorf("expected nil vector to marshal as null got % X, %v", data, err)
+	}
+}
+
+func TestMarshalVectorInvalid(t *testing.T) {
+	info := VectorType{
+		NativeType: NativeType{proto: 4, typ: TypeCustom},
+		SubType:    NativeType{proto: 4, typ: TypeFloat},
+		Dimensions: 3,
+	}
+
+	for _, v := range []interface{}{[]float32{1, 2}, []float32{1, 2, 3, 4}, [2]float32{}, []float64{1, 2, 3}} {
+		if _, err := Marshal(info, v); err == nil {
+			t.Errorf("expected error marshalling %#v into %s", v, info)
+		}
+	}
+
+	var vec []float32
+	if err := Unmarshal(info, make([]byte, 8), &vec); err == nil {
+		t.Error("expected error unmarshalling 8 bytes into vector<float, 3>")
+	}
+
+	ints := VectorType{
+		NativeType: NativeType{proto: 4, typ: TypeCustom},
+		SubType:    NativeType{proto: 4, typ: TypeInt},
+		Dimensions: 1,
+	}
+	if _, err := Marshal(ints, []float32{1}); err == nil {
+		t.Error("expected error marshalling unsupported vector subtype")
+	}
+}
+
+func TestUnmarshalRawBytes(t *testing.T) {
+	data := []byte("hello world")
+	info := NativeType{proto: 4, typ: TypeBlob}
+
+	var raw RawBytes
+	if err := Unmarshal(info, data[:5], &raw); err != nil {
+		t.Fatal(err)
+	}
+	if string(raw) != "hello" {
+		t.Fatalf("expected hello got %q", raw)
+	} else if &raw[0] != &data[0] {
+		t.Error("expected RawBytes to refer to the frame data")
+	} else if cap(raw) != len(raw) {
+		t.Errorf("expected capacity to be capped at %d got %d", len(raw), cap(raw))
+	}
+
+	var copied []byte
+	if err := Unmarshal(info, data[:5], &copied); err != nil {
+		t.Fatal(err)
+	}
+	if &copied[0] == &data[0] {
+		t.Error("expected []byte to be a copy of the frame data")
+	}
+
+	if err := Unmarshal(info, nil, &raw); err != nil {
+		t.Fatal(err)
+	} else if raw != nil {
+		t.Errorf("expected nil for null value got %q", raw)
+	}
+
+	if b, err := Marshal(info, RawBytes("abc")); err != nil {
+		t.Fatal(err)
+	} else if string(b) != "abc" {
+		t.Errorf("expected abc got %q", b)
+	}
+}
+
+func TestMarshalInetNamedType(t *testing.T) {
+	type addr net.IP
+	info := NativeType{proto: 4, typ: TypeInet}
+
+	tests := []struct {
+		value addr
+		data  []byte
+	}{
+		{addr(net.IPv4(10, 0, 0, 1)), []byte{10, 0, 0, 1}},
+		{addr(net.ParseIP("2001:db8::1")), net.ParseIP("2001:db8::1")},
+	}
+
+	for i, test := range tests {
+		data, err := Marshal(info, test.value)
+		if err != nil {
+			t.Errorf("%d: marshal %v: %v", i, test.value, err)
+			continue
+		} else if !bytes.Equal(data, test.data) {
+			t.Errorf("%d: marshal %v: expected % X got % X", i, test.value, test.data, data)
+		}
+
+		var a addr
+		if err := Unmarshal(info, data, &a); err != nil {
+			t.Errorf("%d: unmarshal % X: %v", i, data, err)
+		} else if !net.IP(a).Equal(net.IP(test.value)) {
+			t.Errorf("%d: unmarshal % X: expected %v got %v", i, data, net.IP(test.value), net.IP(a))
+		}
+	}
+
+	if data, err := Marshal(info, addr(nil)); err != nil {
+		t.Fatal(err)
+	} else if data != nil {
+		t.Errorf("expected nil addr to marshal as null got % X", data)
+	}
+
+	var a addr
+	if err := Unmarshal(info, []byte{1, 2, 3}, &a); err == nil {
+		t.Error("expected error unmarshalling 3 bytes into a named IP type")
+	}
+}
+
+func TestUnmarshalInetMapped(t *testing.T) {
+	info := NativeType{proto: 4, typ: TypeInet}
+	data := []byte("\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\xff\xff\xc0\xa8\x01\x02")
+
+	var ip net.IP
+	if err := Unmarshal(info, data, &ip); err != nil {
+		t.Fatal(err)
+	} else if len(ip) != net.IPv4len || !ip.Equal(net.IPv4(192, 168, 1, 2)) {
+		t.Fatalf("expected 4 byte 192.168.1.2 got %v (%d bytes)", ip, len(ip))
+	}
+
+	var s string
+	if err := Unmarshal(info, data, &s); err != nil {
+		t.Fatal(err)
+	} else if s != "192.168.1.2" {
+		t.Fatalf("expected 192.168.1.2 got %q", s)
+	}
+}
+
+func TestMarshalInetInvalid(t *testing.T) {
+	info := NativeType{proto: 4, typ: TypeInet}
+
+	if _, err := Marshal(info, net.IP{1, 2, 3}); err == nil {
+		t.Error("expected error marshalling 3 byte IP")
+	}
+	if _, err := Marshal(info, "not an ip"); err == nil {
+		t.Error("expected error marshalling invalid ip string")
+	}
+
+	var ip net.IP
+	if err := Unmarshal(info, []byte{1, 2, 3}, &ip); err == nil {
+		t.Error("expected error unmarshalling 3 bytes into net.IP")
+	}
+	var s string
+	if err := Unmarshal(info, []byte{1, 2, 3}, &s); err == nil {
+		t.Error("expected error unmarshalling 3 bytes into string")
+	}
+
+	ip = net.IPv4(127, 0, 0, 1)
+	if err := Unmarshal(info, nil, &ip); err != nil {
+		t.Fatal(err)
+	} else if ip != nil {
+		t.Errorf("expected nil IP for null inet got %v", ip)
+	}
+}
+
+func TestMarshalDecimalZero(t *testing.T) {
+	info := NativeType{proto: 4, typ: TypeDecimal}
+
+	data, err := Marshal(info, Decimal{Value: big.NewInt(0), Scale: 3})
+	if err != nil {
+		t.Fatal(err)
+	} else if exp := []byte("\x00\x00\x00\x03\x00"); !bytes.Equal(data, exp) {
+		t.Fatalf("expected % X got % X", exp, data)
+	}
+
+	var dec Decimal
+	if err := Unmarshal(info, data, &dec); err != nil {
+		t.Fatal(err)
+	} else if dec.Value.Sign() != 0 || dec.Scale != 3 {
+		t.Fatalf("expected zero with scale 3 got %v scale %d", dec.Value, dec.Scale)
+	}
+}
+
+func TestDecimalString(t *testing.T) {
+	tests := []struct {
+		dec Decimal
+		exp string
+	}{
+		{Decimal{Value: big.NewInt(125), Scale: 2}, "1.25"},
+		{Decimal{Value: big.NewInt(-125), Scale: 2}, "-1.25"},
+		{Decimal{Value: big.NewInt(5), Scale: 3}, "0.005"},
+		{Decimal{Value: big.NewInt(-5), Scale: 1}, "-0.5"},
+		{Decimal{Value: big.NewInt(12), Scale: -2}, "1200"},
+		{Decimal{Value: big.NewInt(0), Scale: 2}, "0.00"},
+		{Decimal{Value: big.NewInt(0), Scale: -2}, "0"},
+		{Decimal{}, "<nil>"},
+	}
+
+	for _, test := range tests {
+		if got := test.dec.String(); got != test.exp {
+			t.Errorf("%v scale %d: expected %q got %q", test.dec.Value, test.dec.Scale, test.exp, got)
+		}
+	}
+}
+
+func TestUnmarshalDecimalShort(t *testing.T) {
+	info := NativeType{proto: 4, typ: TypeDecimal}
+
+	var dec inf.Dec
+	if err := Unmarshal(info, []byte{0, 0, 1}, &dec); err == nil {
+		t.Error("expected error unmarshalling 3 bytes into inf.Dec")
+	}
+
+	var d Decimal
+	if err := Unmarshal(info, []byte{0, 0, 1}, &d); err == nil {
+		t.Error("expected error unmarshalling 3 bytes into Decimal")
+	}
+	if _, err := Marshal(info, Decimal{Scale: 1}); err == nil {
+		t.Error("expected error marshalling Decimal with nil value")
+	}
+}
+
+func TestMarshalTime(t *testing.T) {
+	info := NativeType{proto: 4, typ: TypeTime}
+
+	tests := []time.Duration{
+		0,                             // midnight
+		12 * time.Hour,                // noon
+		24*time.Hour - 1,              // 23:59:59.999999999
+		13*time.Hour + 37*time.Minute, // 13:37
+	}
+
+	for _, test := range tests {
+		data, err := Marshal(info, test)
+		if err != nil {
+			t.Errorf("marshal %v: %v", test, err)
+			continue
+		} else if !bytes.Equal(data, encBigInt(int64(test))) {
+			t.Errorf("marshal %v: expected % X got % X", test, encBigInt(int64(test)), data)
+		}
+
+		var d time.Duration
+		if err := Unmarshal(info, data, &d); err != nil {
+			t.Errorf("unmarshal %v: %v", test, err)
+		} else if d != test {
+			t.Errorf("expected %v got %v", test, d)
+		}
+
+		var n int64
+		if err := Unmarshal(info, data, &n); err != nil {
+			t.Errorf("unmarshal %v: %v", test, err)
+		} else if n != int64(test) {
+			t.Errorf("expected %d got %d", test, n)
+		}
+	}
+}
+
+func TestMarshalTimeOutOfRange(t *testing.T) {
+	info := NativeType{proto: 4, typ: TypeTime}
+
+	for _, v := range []interface{}{-time.Nanosecond, 24 * time.Hour, int64(-1), int64(24 * time.Hour)} {
+		if _, err := Marshal(info, v); err == nil {
+			t.Errorf("expected error marshalling %v", v)
+		}
+	}
+
+	var d time.Duration
+	for _, data := range [][]byte{encBigInt(-1), encBigInt(int64(24 * time.Hour)), {0, 1}} {
+		if err := Unmarshal(info, data, &d); err == nil {
+			t.Errorf("expected error unmarshalling % X", data)
+		}
+	}
+}
+
 func TestUnmarshalDate(t *testing.T) {
 	data := []uint8{0x80, 0x0, 0x43, 0x31}
 	var date time.Time
@@ -1357,6 +2099,98 @@ func TestUnmarshalDate(t *testing.T) {
 	}
 }
 
+func TestMarshalDateRoundTrip(t *testing.T) {
+	info := NativeType{proto: 4, typ: TypeDate}
+
+	tests := []struct {
+		date time.Time
+		data []byte
+	}{
+		{time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC), []byte{0x80, 0, 0, 0}},
+		{time.Date(1969, 12, 31, 0, 0, 0, 0, time.UTC), []byte{0x7f, 0xff, 0xff, 0xff}},
+		{time.Date(1970, 1, 2, 0, 0, 0, 0, time.UTC), []byte{0x80, 0, 0, 1}},
+		{time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC), []byte{0x7f, 0xff, 0x9c, 0x21}},
+		{time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC), []byte{0x80, 0x2c, 0xc0, 0xa0}},
+		{time.Date(-5877641, 6, 23, 0, 0, 0, 0, time.UTC), []byte{0, 0, 0, 0}},
+		{time.Date(5881580, 7, 11, 0, 0, 0, 0, time.UTC), []byte{0xff, 0xff, 0xff, 0xff}},
+	}
+
+	for _, test := range tests {
+		data, err := Marshal(info, test.date)
+		if err != nil {
+			t.Errorf("marshal %v: %v", test.date, err)
+			continue
+		} else if !bytes.Equal(data, test.data) {
+			t.Errorf("marshal %v: expected % X got % X", test.date, test.data, data)
+		}
+
+		var date time.Time
+		if err := Unmarshal(info, test.data, &date); err != nil {
+			t.Errorf("unmarshal % X: %v", test.data, err)
+		} else if !date.Equal(test.date) {
+			t.Errorf("unmarshal % X: expected %v got %v", test.data, test.date, date)
+		}
+	}
+
+	// times during a day before the epoch belong to that day
+	data, err := Marshal(info, time.Date(1969, 12, 31, 12, 30, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	} else if exp := []byte{0x7f, 0xff, 0xff, 0xff}; !bytes.Equal(data, exp) {
+		t.Errorf("expected % X got % X", exp, data)
+	}
+
+	data, err = Marshal(info, int64(-1))
+	if err != nil {
+		t.Fatal(err)
+	} else if exp := []byte{0x7f, 0xff, 0xff, 0xff}; !bytes.Equal(data, exp) {
+		t.Errorf("expected % X got % X", exp, data)
+	}
+
+	var s string
+	if err := Unmarshal(info, []byte{0x7f, 0xff, 0x9c, 0x21}, &s); err != nil {
+		t.Fatal(err)
+	} else if s != "1900-01-01" {
+		t.Errorf("expected 1900-01-01 got %q", s)
+	}
+
+	if _, err := Marshal(info, time.Date(5881580, 7, 12, 0, 0, 0, 0, time.UTC)); err == nil {
+		t.Error("expected error marshalling date after the maximum date")
+	}
+
+	// the first and last instant of a day are the same date
+	for _, tm := range []time.Time{
+		time.Date(1969, 12, 31, 0, 0, 0, 0, time.UTC),
+		time.Date(1969, 12, 31, 23, 59, 59, 999999999, time.UTC),
+	} {
+		data, err := Marshal(info, tm)
+		if err != nil {
+			t.Fatal(err)
+		} else if exp := []byte{0x7f, 0xff, 0xff, 0xff}; !bytes.Equal(data, exp) {
+			t.Errorf("marshal %v: expected % X got % X", tm, exp, data)
+		}
+	}
+
+	for _, ms := range []int64{0, -86400000, -1, 86400000 - 1, 86400000} {
+		data, err := Marshal(info, ms)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got int64
+		if err := Unmarshal(info, data, &got); err != nil {
+			t.Fatal(err)
+		}
+		exp := ms - ms%86400000
+		if ms%86400000 < 0 {
+			exp -= 86400000
+		}
+		if got != exp {
+			t.Errorf("round trip %d: expected %d got %d", ms, exp, got)
+		}
+	}
+}
+
 func TestMarshalDate(t *testing.T) {
 	now := time.Now()
 	timestamp := now.UnixNano() / int64(time.Millisecond)
@@ -1401,3 +2235,88 @@ func TestMarshalDate(t *testing.T) {
 		}
 	}
 }
+
+func TestMarshalDuration(t *testing.T) {
+	info := NativeType{proto: 5, typ: TypeDuration}
+
+	tests := []Duration{
+		{},
+		// P1Y2M3DT4H5M6S
+		{Months: 14, Days: 3, Nanoseconds: int64(4*time.Hour + 5*time.Minute + 6*time.Second)},
+		// -P1Y2M3DT4H5M6S
+		{Months: -14, Days: -3, Nanoseconds: -int64(4*time.Hour + 5*time.Minute + 6*time.Second)},
+		// PT0.000000001S
+		{Nanoseconds: 1},
+		{Months: math.MaxInt32, Days: math.MaxInt32, Nanoseconds: math.MaxInt64},
+		{Months: math.MinInt32, Days: math.MinInt32, Nanoseconds: math.MinInt64},
+		{Months: math.MaxInt32, Days: math.MinInt32, Nanoseconds: -1},
+		{Months: -1, Days: 1, Nanoseconds: math.MinInt64},
+	}
+
+	for _, test := range tests {
+		data, err := Marshal(info, test)
+		if err != nil {
+			t.Errorf("marshal %+v: %v", test, err)
+			continue
+		}
+
+		var got Duration
+		if err := Unmarshal(info, data, &got); err != nil {
+			t.Errorf("unmarshal %+v (%x): %v", test, data, err)
+			continue
+		}
+
+		if got != test {
+			t.Errorf("expected %+v got %+v (%x)", test, got, data)
+		}
+	}
+}
+
+func TestMarshalDurationFromTimeDuration(t *testing.T) {
+	info := NativeType{proto: 5, typ: TypeDuration}
+
+	data, err := Marshal(info, 90*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got Duration
+	if err := Unmarshal(info, data, &got); err != nil {
+		t.Fatal(err)
+	} else if exp := (Duration{Nanoseconds: int64(90 * time.Minute)}); got != exp {
+		t.Fatalf("expected %+v got %+v", exp, got)
+	}
+
+	var d time.Duration
+	if err := Unmarshal(info, data, &d); err != nil {
+		t.Fatal(err)
+	} else if d != 90*time.Minute {
+		t.Fatalf("expected %v got %v", 90*time.Minute, d)
+	}
+
+	data, err = Marshal(info, Duration{Days: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Unmarshal(info, data, &d); err == nil {
+		t.Fatal("expected error unmarshalling duration with days into time.Duration")
+	}
+}
+
+func TestUnmarshalDurationInvalid(t *testing.T) {
+	info := NativeType{proto: 5, typ: TypeDuration}
+
+	tests := [][]byte{
+		[]byte("\x00"),
+		[]byte("\x00\x00"),
+		[]byte("\x00\x00\x87"),
+		[]byte("\x00\x00\x00\x00"),
+	}
+
+	for _, data := range tests {
+		var d Duration
+		if err := Unmarshal(info, data, &d); err == nil {
+			t.Errorf("expected error unmarshalling %x", data)
+		}
+	}
+}
@@ -20,6 +20,7 @@ type KeyspaceMetadata struct {
 	StrategyClass   string
 	StrategyOptions map[string]interface{}
 	Tables          map[string]*TableMetadata
+	UserTypes       map[string]*UserTypeMetadata
 }
 
 // schema metadata for a table (a.k.a. column family)
@@ -52,6 +53,14 @@ type ColumnMetadata struct {
 	Index           ColumnIndexMetadata
 }
 
+// schema metadata for a user defined type
+type UserTypeMetadata struct {
+	Keyspace   string
+	Name       string
+	FieldNames []string
+	FieldTypes []TypeInfo
+}
+
 // the ordering of the column with regard to its comparator
 type ColumnOrder bool
 
@@ -196,9 +205,14 @@ func (s *schemaDescriber) refreshSchema(keyspaceName string) error {
 	if err != nil {
 		return err
 	}
+	types, err := getUserTypeMetadata(s.session, keyspaceName)
+	if err != nil {
+		return err
+	}
 
 	// organize the schema data
 	compileMetadata(s.session.cfg.ProtoVersion, keyspace, tables, columns)
+	compileUserTypes(keyspace, types)
 
 	// update the cache
 	s.cache[keyspaceName] = keyspace
@@ -263,6 +277,14 @@ func compileMetadata(
 	}
 }
 
+// links the user types queried by getUserTypeMetadata into the keyspace
+func compileUserTypes(keyspace *KeyspaceMetadata, types []UserTypeMetadata) {
+	keyspace.UserTypes = make(map[string]*UserTypeMetadata, len(types))
+	for i := range types {
+		keyspace.UserTypes[types[i].Name] = &types[i]
+	}
+}
+
 // Compiles derived information from TableMetadata which have had
 // ColumnMetadata added already. V1 protocol does not return as much
 // column metadata as V2+ (because V1 doesn't support the "type" column in the
@@ -765,11 +787,89 @@ func (s *Session) scanColumnMetadataSystem(keyspace string) ([]ColumnMetadata, e
 		return nil, err
 	}
 
-	// TODO(zariel): get column index info from system_schema.indexes
+	indexes, err := s.scanIndexMetadataSystem(keyspace)
+	if err != nil {
+		return nil, err
+	}
+	applyIndexMetadata(columns, indexes)
 
 	return columns, nil
 }
 
+// a row from system_schema.indexes
+type indexMetadata struct {
+	table   string
+	name    string
+	kind    string
+	options map[string]string
+}
+
+func (s *Session) scanIndexMetadataSystem(keyspace string) ([]indexMetadata, error) {
+	const stmt = `
+			SELECT
+				table_name,
+				index_name,
+				kind,
+				options
+			FROM system_schema.indexes
+			WHERE keyspace_name = ?`
+
+	var indexes []indexMetadata
+
+	rows := s.control.query(stmt, keyspace).Scanner()
+	for rows.Next() {
+		var index indexMetadata
+		if err := rows.Scan(&index.table, &index.name, &index.kind, &index.options); err != nil {
+			return nil, err
+		}
+		indexes = append(indexes, index)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return indexes, nil
+}
+
+// indexTargetColumn returns the column name of an index target such as
+// "keys(col)" or "\"Col\"".
+func indexTargetColumn(target string) string {
+	if i := strings.IndexByte(target, '('); i >= 0 && strings.HasSuffix(target, ")") {
+		target = target[i+1 : len(target)-1]
+	}
+
+	if len(target) > 1 && target[0] == '"' && target[len(target)-1] == '"' {
+		target = strings.Replace(target[1:len(target)-1], `""`, `"`, -1)
+	}
+
+	return target
+}
+
+// sets the index of each column which is the target of an index, as
+// system_schema.columns does not include it.
+func applyIndexMetadata(columns []ColumnMetadata, indexes []indexMetadata) {
+	for _, index := range indexes {
+		target := indexTargetColumn(index.options["target"])
+
+		for i := range columns {
+			col := &columns[i]
+			if col.Table != index.table || col.Name != target {
+				continue
+			}
+
+			col.Index = ColumnIndexMetadata{
+				Name:    index.name,
+				Type:    index.kind,
+				Options: make(map[string]interface{}, len(index.options)),
+			}
+			for k, v := range index.options {
+				col.Index.Options[k] = v
+			}
+		}
+	}
+}
+
 // query for only the column metadata in the specified keyspace from system.schema_columns
 func getColumnMetadata(session *Session, keyspaceName string) ([]ColumnMetadata, error) {
 	var (
@@ -793,6 +893,67 @@ func getColumnMetadata(session *Session, keyspaceName string) ([]ColumnMetadata,
 	return columns, nil
 }
 
+// query for the user defined types in the specified keyspace
+func getUserTypeMetadata(session *Session, keyspaceName string) ([]UserTypeMetadata, error) {
+	var stmt string
+	if session.useSystemSchema { // Cassandra 3.x+
+		stmt = `
+		SELECT
+			type_name,
+			field_names,
+			field_types
+		FROM system_schema.types
+		WHERE keyspace_name = ?`
+	} else if session.cfg.ProtoVersion >= protoVersion3 {
+		stmt = `
+		SELECT
+			type_name,
+			field_names,
+			field_types
+		FROM system.schema_usertypes
+		WHERE keyspace_name = ?`
+	} else {
+		// user types were added in Cassandra 2.1 along with protocol v3
+		return nil, nil
+	}
+
+	var types []UserTypeMetadata
+
+	rows := session.control.query(stmt, keyspaceName).Scanner()
+	for rows.Next() {
+		var (
+			fieldTypes []string
+			udt        = UserTypeMetadata{Keyspace: keyspaceName}
+		)
+
+		if err := rows.Scan(&udt.Name, &udt.FieldNames, &fieldTypes); err != nil {
+			return nil, err
+		}
+
+		udt.FieldTypes = make([]TypeInfo, len(fieldTypes))
+		for i, typ := range fieldTypes {
+			udt.FieldTypes[i] = parseUserTypeField(typ, session.useSystemSchema)
+		}
+
+		types = append(types, udt)
+	}
+
+	if err := rows.Err(); err != nil && err != ErrNotFound {
+		return nil, fmt.Errorf("Error querying user type schema: %v", err)
+	}
+
+	return types, nil
+}
+
+// parses the type of a user type field, system_schema.types stores CQL type
+// names where as system.schema_usertypes stores marshal class names.
+func parseUserTypeField(typ string, systemSchema bool) TypeInfo {
+	if systemSchema {
+		return getCassandraType(typ)
+	}
+	return parseType(typ).types[0]
+}
+
 // type definition parser state
 type typeParser struct {
 	input string
@@ -5,6 +5,7 @@
 package gocql
 
 import (
+	"reflect"
 	"strconv"
 	"testing"
 )
@@ -564,6 +565,158 @@ func assertKeyspaceMetadata(t *testing.T, actual, expected *KeyspaceMetadata) {
 	}
 }
 
+func TestCompileMetadataColumnKinds(t *testing.T) {
+	keyspace := &KeyspaceMetadata{Name: "ks"}
+	tables := []TableMetadata{{Keyspace: "ks", Name: "tbl"}}
+	// the rows of system_schema.columns for
+	// CREATE TABLE tbl (a int, b text, c int, d int, s text static, v text,
+	//     PRIMARY KEY ((b, a), d, c)) WITH CLUSTERING ORDER BY (d DESC, c ASC)
+	// which are ordered by name, not by position
+	columns := []ColumnMetadata{
+		{Keyspace: "ks", Table: "tbl", Name: "a", Kind: ColumnPartitionKey, ComponentIndex: 1, ClusteringOrder: "none", Validator: "int"},
+		{Keyspace: "ks", Table: "tbl", Name: "b", Kind: ColumnPartitionKey, ComponentIndex: 0, ClusteringOrder: "none", Validator: "text"},
+		{Keyspace: "ks", Table: "tbl", Name: "c", Kind: ColumnClusteringKey, ComponentIndex: 1, ClusteringOrder: "asc", Validator: "int"},
+		{Keyspace: "ks", Table: "tbl", Name: "d", Kind: ColumnClusteringKey, ComponentIndex: 0, ClusteringOrder: "desc", Validator: "int"},
+		{Keyspace: "ks", Table: "tbl", Name: "s", Kind: ColumnStatic, ComponentIndex: -1, ClusteringOrder: "none", Validator: "text"},
+		{Keyspace: "ks", Table: "tbl", Name: "v", Kind: ColumnRegular, ComponentIndex: -1, ClusteringOrder: "none", Validator: "text"},
+	}
+	compileMetadata(4, keyspace, tables, columns)
+
+	table := keyspace.Tables["tbl"]
+	names := func(cols []*ColumnMetadata) []string {
+		var names []string
+		for _, col := range cols {
+			names = append(names, col.Name)
+		}
+		return names
+	}
+	if pk := names(table.PartitionKey); !reflect.DeepEqual(pk, []string{"b", "a"}) {
+		t.Errorf("expected the partition key (b, a) in position order got %v", pk)
+	}
+	if ck := names(table.ClusteringColumns); !reflect.DeepEqual(ck, []string{"d", "c"}) {
+		t.Errorf("expected the clustering columns (d, c) in position order got %v", ck)
+	} else if table.ClusteringColumns[0].Order != DESC || table.ClusteringColumns[1].Order != ASC {
+		t.Errorf("expected the clustering order (d DESC, c ASC) got (d %v, c %v)", table.ClusteringColumns[0].Order, table.ClusteringColumns[1].Order)
+	}
+
+	kinds := map[string]ColumnKind{
+		"a": ColumnPartitionKey,
+		"b": ColumnPartitionKey,
+		"c": ColumnClusteringKey,
+		"d": ColumnClusteringKey,
+		"s": ColumnStatic,
+		"v": ColumnRegular,
+	}
+	for name, kind := range kinds {
+		if col := table.Columns[name]; col.Kind != kind {
+			t.Errorf("expected column %s to be %v got %v", name, kind, col.Kind)
+		}
+	}
+	if typ := table.Columns["s"].Type.Type(); typ != TypeText {
+		t.Errorf("expected the static column to be text got %v", typ)
+	}
+}
+
+func TestColumnKindFromSchema(t *testing.T) {
+	cases := map[string]ColumnKind{
+		"partition_key":  ColumnPartitionKey,
+		"clustering":     ColumnClusteringKey,
+		"clustering_key": ColumnClusteringKey,
+		"regular":        ColumnRegular,
+		"static":         ColumnStatic,
+		"compact_value":  ColumnCompact,
+	}
+	for schema, expected := range cases {
+		var kind ColumnKind
+		if err := kind.UnmarshalCQL(NativeType{typ: TypeVarchar}, []byte(schema)); err != nil {
+			t.Errorf("%s: %v", schema, err)
+		} else if kind != expected {
+			t.Errorf("%s: expected %v got %v", schema, expected, kind)
+		}
+	}
+
+	var kind ColumnKind
+	if err := kind.UnmarshalCQL(NativeType{typ: TypeVarchar}, []byte("unknown")); err == nil {
+		t.Error("expected an error for an unknown column kind")
+	}
+}
+
+func TestCompileUserTypes(t *testing.T) {
+	keyspace := &KeyspaceMetadata{Name: "V3Keyspace"}
+	types := []UserTypeMetadata{
+		{
+			Keyspace:   "V3Keyspace",
+			Name:       "address",
+			FieldNames: []string{"street", "zip", "tags"},
+			FieldTypes: []TypeInfo{
+				parseUserTypeField("text", true),
+				parseUserTypeField("int", true),
+				parseUserTypeField("frozen<set<text>>", true),
+			},
+		},
+		{
+			Keyspace:   "V3Keyspace",
+			Name:       "phone",
+			FieldNames: []string{"number"},
+			FieldTypes: []TypeInfo{
+				parseUserTypeField("org.apache.cassandra.db.marshal.UTF8Type", false),
+			},
+		},
+	}
+
+	compileUserTypes(keyspace, types)
+
+	if len(keyspace.UserTypes) != 2 {
+		t.Fatalf("expected 2 user types got %d", len(keyspace.UserTypes))
+	}
+
+	address, ok := keyspace.UserTypes["address"]
+	if !ok {
+		t.Fatal("expected user type address")
+	}
+	expTypes := []Type{TypeText, TypeInt, TypeSet}
+	for i, typ := range address.FieldTypes {
+		if typ.Type() != expTypes[i] {
+			t.Errorf("address field %q: expected type %v got %v", address.FieldNames[i], expTypes[i], typ.Type())
+		}
+	}
+	if elem := address.FieldTypes[2].(CollectionType).Elem.Type(); elem != TypeText {
+		t.Errorf("expected set<text> got set<%v>", elem)
+	}
+
+	if typ := keyspace.UserTypes["phone"].FieldTypes[0].Type(); typ != TypeVarchar {
+		t.Errorf("phone field number: expected type %v got %v", TypeVarchar, typ)
+	}
+}
+
+func TestApplyIndexMetadata(t *testing.T) {
+	columns := []ColumnMetadata{
+		{Table: "users", Name: "email"},
+		{Table: "users", Name: "Attrs"},
+		{Table: "users", Name: "name"},
+		{Table: "events", Name: "email"},
+	}
+	indexes := []indexMetadata{
+		{table: "users", name: "users_email_idx", kind: "COMPOSITES", options: map[string]string{"target": "email"}},
+		{table: "users", name: "users_attrs_idx", kind: "COMPOSITES", options: map[string]string{"target": `keys("Attrs")`}},
+	}
+
+	applyIndexMetadata(columns, indexes)
+
+	if idx := columns[0].Index; idx.Name != "users_email_idx" || idx.Type != "COMPOSITES" || idx.Options["target"] != "email" {
+		t.Errorf("unexpected index for users.email: %+v", idx)
+	}
+	if idx := columns[1].Index; idx.Name != "users_attrs_idx" {
+		t.Errorf("unexpected index for users.Attrs: %+v", idx)
+	}
+	if idx := columns[2].Index; idx.Name != "" {
+		t.Errorf("expected no index for users.name got %+v", idx)
+	}
+	if idx := columns[3].Index; idx.Name != "" {
+		t.Errorf("expected no index for events.email got %+v", idx)
+	}
+}
+
 // Tests the cassandra type definition parser
 func TestTypeParser(t *testing.T) {
 	// native type
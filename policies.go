@@ -273,6 +273,147 @@ func (d *DowngradingConsistencyRetryPolicy) GetRetryType(err error) RetryType {
 	}
 }
 
+// ErrorCodeRetryPolicy decides how to retry a query based on the code of the
+// error returned by the server. Errors whose code is not found in RetryTypes,
+// and errors which did not come from the server, are handled by the wrapped
+// RetryPolicy.
+//
+// See below for examples of usage:
+//
+//     cluster.RetryPolicy = &gocql.ErrorCodeRetryPolicy{
+//         RetryPolicy: &gocql.SimpleRetryPolicy{NumRetries: 3},
+//         RetryTypes: map[int]gocql.RetryType{
+//             gocql.ErrCodeOverloaded:    gocql.RetryNextHost,
+//             gocql.ErrCodeBootstrapping: gocql.RetryNextHost,
+//             gocql.ErrCodeSyntax:        gocql.Rethrow,
+//         },
+//     }
+//
+type ErrorCodeRetryPolicy struct {
+	RetryPolicy RetryPolicy
+	RetryTypes  map[int]RetryType
+}
+
+// Attempt defers to the wrapped RetryPolicy, a nil RetryPolicy never allows
+// another attempt.
+func (e *ErrorCodeRetryPolicy) Attempt(q RetryableQuery) bool {
+	if e.RetryPolicy == nil {
+		return false
+	}
+	return e.RetryPolicy.Attempt(q)
+}
+
+func (e *ErrorCodeRetryPolicy) GetRetryType(err error) RetryType {
+	if reqErr, ok := err.(RequestError); ok {
+		if rt, ok := e.RetryTypes[reqErr.Code()]; ok {
+			return rt
+		}
+	}
+	if e.RetryPolicy == nil {
+		return Rethrow
+	}
+	return e.RetryPolicy.GetRetryType(err)
+}
+
+// RetryBudget limits the retries of a session to a ratio of its queries and
+// batches, so that a cluster which fails many requests is not sent a retry for
+// each of them on top.
+//
+// It works as a token bucket: every request adds Ratio tokens to the bucket
+// and every retry, on the same or the next host, takes one. Retries are
+// skipped while the bucket holds less than a token, returning the error of
+// the last attempt, until enough requests have refilled it.
+//
+//     cluster.RetryBudget = &gocql.RetryBudget{Ratio: 0.1, MinRetries: 10}
+//
+type RetryBudget struct {
+	// Ratio is the number of retries allowed per request, 0.1 allows a retry
+	// for every ten requests.
+	Ratio float64
+
+	// MinRetries is the number of tokens the bucket starts with and holds at
+	// most, so that a session which sends few requests, or has just been
+	// idle, can still retry MinRetries of them. (default: 0, no retry is
+	// allowed until Ratio has added a token)
+	MinRetries int
+}
+
+// retryBudget is the token bucket of a RetryBudget, a nil retryBudget allows
+// every retry. The bucket counts thousandths of a token so that adding Ratio
+// tokens per request does not accumulate rounding errors.
+type retryBudget struct {
+	ratio int64
+	max   int64
+
+	mu     sync.Mutex
+	tokens int64
+
+	// number of retries skipped, accessed atomically
+	throttled uint64
+}
+
+const retryBudgetToken = 1000
+
+func newRetryBudget(cfg *RetryBudget) *retryBudget {
+	if cfg == nil || cfg.Ratio <= 0 {
+		return nil
+	}
+	ratio := int64(math.Floor(cfg.Ratio*retryBudgetToken + 0.5))
+	if ratio < 1 {
+		ratio = 1
+	}
+	tokens := int64(cfg.MinRetries) * retryBudgetToken
+	max := tokens
+	if max < retryBudgetToken {
+		// the bucket must hold a whole token to allow a retry at all
+		max = retryBudgetToken
+	}
+	return &retryBudget{
+		ratio:  ratio,
+		max:    max,
+		tokens: tokens,
+	}
+}
+
+// deposit adds the tokens of a request to the bucket.
+func (b *retryBudget) deposit() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	b.tokens += b.ratio
+	if b.tokens > b.max {
+		b.tokens = b.max
+	}
+	b.mu.Unlock()
+}
+
+// withdraw takes the token of a retry from the bucket and returns whether the
+// retry is allowed.
+func (b *retryBudget) withdraw() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	ok := b.tokens >= retryBudgetToken
+	if ok {
+		b.tokens -= retryBudgetToken
+	}
+	b.mu.Unlock()
+	if !ok {
+		atomic.AddUint64(&b.throttled, 1)
+	}
+	return ok
+}
+
+// throttledRetries returns the number of retries skipped.
+func (b *retryBudget) throttledRetries() uint64 {
+	if b == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&b.throttled)
+}
+
 func (e *ExponentialBackoffRetryPolicy) napTime(attempts int) time.Duration {
 	return getExponentialTime(e.Min, e.Max, attempts)
 }
@@ -374,12 +515,67 @@ func (r *roundRobinHostPolicy) HostDown(host *HostInfo) {
 	r.RemoveHost(host)
 }
 
+// ShuffleReplicas shuffles the replicas of a partition for each query, so
+// that the replica tried first varies between queries and reads are spread
+// evenly across the replicas instead of always going to the first.
 func ShuffleReplicas() func(*tokenAwareHostPolicy) {
 	return func(t *tokenAwareHostPolicy) {
 		t.shuffleReplicas = true
 	}
 }
 
+// LeastInFlightReplicas orders the replicas of a partition by the number of
+// queries in flight to them, so that the least loaded replica is tried first.
+// Replicas with the same number of queries in flight keep their order, which
+// is shuffled if ShuffleReplicas is also used.
+func LeastInFlightReplicas() func(*tokenAwareHostPolicy) {
+	return func(t *tokenAwareHostPolicy) {
+		t.leastInFlightReplicas = true
+	}
+}
+
+// PreferRackReplicas orders the replicas of a partition in rack before the
+// other replicas, so that queries stay within the rack of the client when
+// possible. The order of the replicas is otherwise kept.
+func PreferRackReplicas(rack string) func(*tokenAwareHostPolicy) {
+	return func(t *tokenAwareHostPolicy) {
+		t.preferredRack = rack
+	}
+}
+
+// rackFirst returns a copy of hosts with the hosts in rack first, keeping the
+// order of the hosts otherwise.
+func rackFirst(hosts []*HostInfo, rack string) []*HostInfo {
+	sorted := make([]*HostInfo, 0, len(hosts))
+	for _, host := range hosts {
+		if host.Rack() == rack {
+			sorted = append(sorted, host)
+		}
+	}
+	for _, host := range hosts {
+		if host.Rack() != rack {
+			sorted = append(sorted, host)
+		}
+	}
+	return sorted
+}
+
+// sortByInFlight returns a copy of hosts ordered by the number of queries in
+// flight to each host, hosts with the same number keep their order.
+func sortByInFlight(hosts []*HostInfo) []*HostInfo {
+	sorted := make([]*HostInfo, len(hosts))
+	inFlight := make([]int, len(hosts))
+	for i, host := range hosts {
+		n := host.InFlight()
+		j := i
+		for ; j > 0 && inFlight[j-1] > n; j-- {
+			sorted[j], inFlight[j] = sorted[j-1], inFlight[j-1]
+		}
+		sorted[j], inFlight[j] = host, n
+	}
+	return sorted
+}
+
 // TokenAwareHostPolicy is a token aware host selection policy, where hosts are
 // selected based on the partition key, so queries are sent to the host which
 // owns the partition. Fallback is used when routing information is not available.
@@ -405,7 +601,9 @@ type tokenAwareHostPolicy struct {
 	tokenRing atomic.Value // *tokenRing
 	keyspaces atomic.Value // *keyspaceMeta
 
-	shuffleReplicas bool
+	shuffleReplicas       bool
+	leastInFlightReplicas bool
+	preferredRack         string
 }
 
 func (t *tokenAwareHostPolicy) Init(s *Session) {
@@ -416,6 +614,18 @@ func (t *tokenAwareHostPolicy) IsLocal(host *HostInfo) bool {
 	return t.fallback.IsLocal(host)
 }
 
+func (t *tokenAwareHostPolicy) usesHost(host *HostInfo) bool {
+	return policyUsesHost(t.fallback, host)
+}
+
+func (t *tokenAwareHostPolicy) localDC() string {
+	return policyLocalDC(t.fallback)
+}
+
+func (t *tokenAwareHostPolicy) usesLatency() bool {
+	return policyUsesLatency(t.fallback)
+}
+
 func (t *tokenAwareHostPolicy) KeyspaceChanged(update KeyspaceUpdateEvent) {
 	meta, _ := t.keyspaces.Load().(*keyspaceMeta)
 	var size = 1
@@ -431,7 +641,7 @@ func (t *tokenAwareHostPolicy) KeyspaceChanged(update KeyspaceUpdateEvent) {
 	if err == nil {
 		strat := getStrategy(ks)
 		tr := t.tokenRing.Load().(*tokenRing)
-		if tr != nil {
+		if tr != nil && strat != nil {
 			newMeta.replicas[update.Keyspace] = strat.replicaMap(t.hosts.get(), tr.tokens)
 		}
 	}
@@ -544,8 +754,16 @@ func (t *tokenAwareHostPolicy) Pick(qry ExecutableQuery) NextHost {
 	replicas, ok := t.getReplicas(qry.Keyspace(), token)
 	if !ok {
 		replicas = []*HostInfo{primaryEndpoint}
-	} else if t.shuffleReplicas {
-		replicas = shuffleHosts(replicas)
+	} else {
+		if t.shuffleReplicas {
+			replicas = shuffleHosts(replicas)
+		}
+		if t.leastInFlightReplicas {
+			replicas = sortByInFlight(replicas)
+		}
+		if t.preferredRack != "" {
+			replicas = rackFirst(replicas, t.preferredRack)
+		}
 	}
 
 	var (
@@ -580,6 +798,170 @@ func (t *tokenAwareHostPolicy) Pick(qry ExecutableQuery) NextHost {
 	}
 }
 
+// LatencyExclusionThreshold sets how many times slower than the fastest host a
+// host must be to be penalised by LatencyAwareHostPolicy. (default: 2)
+func LatencyExclusionThreshold(threshold float64) func(*latencyAwareHostPolicy) {
+	return func(l *latencyAwareHostPolicy) {
+		l.exclusionThreshold = threshold
+	}
+}
+
+// LatencyRetryPeriod sets how long LatencyAwareHostPolicy penalises a slow
+// host for without a new measurement of its latency. Once the period has
+// passed the host is picked in its normal order again so that its latency can
+// recover. (default: 10s)
+func LatencyRetryPeriod(period time.Duration) func(*latencyAwareHostPolicy) {
+	return func(l *latencyAwareHostPolicy) {
+		l.retryPeriod = period
+	}
+}
+
+// LatencyMinMeasured sets how many queries must have been sent to a host
+// before LatencyAwareHostPolicy will penalise it. (default: 50)
+func LatencyMinMeasured(n int) func(*latencyAwareHostPolicy) {
+	return func(l *latencyAwareHostPolicy) {
+		l.minMeasured = n
+	}
+}
+
+// LatencyAwareHostPolicy is a host selection policy which moves hosts that are
+// much slower than the fastest host to the end of the hosts picked by the
+// fallback policy. The latency of each host is an exponentially weighted
+// moving average of the latency of the queries sent to it. The slow hosts are
+// picked by the number of queries in flight to them, so that the least loaded
+// is tried first.
+//
+// See below for examples of usage:
+//
+//     cluster.PoolConfig.HostSelectionPolicy = gocql.LatencyAwareHostPolicy(
+//         gocql.RoundRobinHostPolicy(),
+//         gocql.LatencyExclusionThreshold(3),
+//         gocql.LatencyRetryPeriod(30*time.Second),
+//     )
+//
+func LatencyAwareHostPolicy(fallback HostSelectionPolicy, opts ...func(*latencyAwareHostPolicy)) HostSelectionPolicy {
+	l := &latencyAwareHostPolicy{
+		fallback:           fallback,
+		exclusionThreshold: 2,
+		retryPeriod:        10 * time.Second,
+		minMeasured:        50,
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l
+}
+
+type latencyAwareHostPolicy struct {
+	hosts    cowHostList
+	fallback HostSelectionPolicy
+
+	exclusionThreshold float64
+	retryPeriod        time.Duration
+	minMeasured        int
+}
+
+func (l *latencyAwareHostPolicy) Init(s *Session) {
+	l.fallback.Init(s)
+}
+
+func (l *latencyAwareHostPolicy) IsLocal(host *HostInfo) bool {
+	return l.fallback.IsLocal(host)
+}
+
+func (l *latencyAwareHostPolicy) usesHost(host *HostInfo) bool {
+	return policyUsesHost(l.fallback, host)
+}
+
+func (l *latencyAwareHostPolicy) localDC() string {
+	return policyLocalDC(l.fallback)
+}
+
+func (l *latencyAwareHostPolicy) usesLatency() bool {
+	return true
+}
+
+func (l *latencyAwareHostPolicy) KeyspaceChanged(update KeyspaceUpdateEvent) {
+	l.fallback.KeyspaceChanged(update)
+}
+
+func (l *latencyAwareHostPolicy) SetPartitioner(partitioner string) {
+	l.fallback.SetPartitioner(partitioner)
+}
+
+func (l *latencyAwareHostPolicy) AddHost(host *HostInfo) {
+	l.hosts.add(host)
+	l.fallback.AddHost(host)
+}
+
+func (l *latencyAwareHostPolicy) RemoveHost(host *HostInfo) {
+	l.hosts.remove(host.ConnectAddress())
+	l.fallback.RemoveHost(host)
+}
+
+func (l *latencyAwareHostPolicy) HostUp(host *HostInfo) {
+	l.hosts.add(host)
+	l.fallback.HostUp(host)
+}
+
+func (l *latencyAwareHostPolicy) HostDown(host *HostInfo) {
+	l.hosts.remove(host.ConnectAddress())
+	l.fallback.HostDown(host)
+}
+
+// measured reports the latency of host if it has enough recent measurements
+// to be compared with the other hosts.
+func (l *latencyAwareHostPolicy) measured(host *HostInfo, now time.Time) (time.Duration, bool) {
+	latency, measured, updated := host.latencyStats()
+	if measured < l.minMeasured || now.Sub(updated) > l.retryPeriod {
+		return 0, false
+	}
+	return latency, true
+}
+
+func (l *latencyAwareHostPolicy) Pick(qry ExecutableQuery) NextHost {
+	now := time.Now()
+
+	var fastest time.Duration
+	for _, host := range l.hosts.get() {
+		if latency, ok := l.measured(host, now); ok && (fastest == 0 || latency < fastest) {
+			fastest = latency
+		}
+	}
+
+	next := l.fallback.Pick(qry)
+	if fastest == 0 {
+		return next
+	}
+
+	limit := time.Duration(l.exclusionThreshold * float64(fastest))
+
+	var slow []SelectedHost
+	return func() SelectedHost {
+		for host := next(); host != nil; host = next() {
+			if latency, ok := l.measured(host.Info(), now); ok && latency > limit {
+				slow = append(slow, host)
+				continue
+			}
+			return host
+		}
+
+		if len(slow) == 0 {
+			return nil
+		}
+		// the slow hosts are tried least loaded first
+		least := 0
+		for i := 1; i < len(slow); i++ {
+			if slow[i].Info().InFlight() < slow[least].Info().InFlight() {
+				least = i
+			}
+		}
+		host := slow[least]
+		slow = append(slow[:least], slow[least+1:]...)
+		return host
+	}
+}
+
 // HostPoolHostPolicy is a host policy which uses the bitly/go-hostpool library
 // to distribute queries between hosts and prevent sending queries to
 // unresponsive hosts. When creating the host pool that is passed to the policy
@@ -728,13 +1110,49 @@ type dcAwareRR struct {
 	mu          sync.RWMutex
 	localHosts  cowHostList
 	remoteHosts cowHostList
+
+	// number of hosts used in each remote datacenter, all of them if negative
+	usedHostsPerRemoteDC int
+	allowRemoteLocalCL   bool
 }
 
 // DCAwareRoundRobinPolicy is a host selection policies which will prioritize and
 // return hosts which are in the local datacentre before returning hosts in all
 // other datercentres
-func DCAwareRoundRobinPolicy(localDC string) HostSelectionPolicy {
-	return &dcAwareRR{local: localDC}
+func DCAwareRoundRobinPolicy(localDC string, opts ...func(*dcAwareRR)) HostSelectionPolicy {
+	p := &dcAwareRR{local: localDC, usedHostsPerRemoteDC: -1}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// UsedHostsPerRemoteDC limits the hosts of each remote datacenter which
+// DCAwareRoundRobinPolicy falls back to, and which the session connects to,
+// to the first n which are up, so that only a few connections are kept to the
+// remote datacenters for failover, or none if n is 0. The next host of a
+// datacenter is used when one of its used hosts goes down.
+//
+// Queries at a local consistency level, LOCAL_ONE or LOCAL_QUORUM, are not
+// sent to remote datacenters once they are limited, unless
+// AllowRemoteDCsForLocalConsistencyLevel is also set. (default: all the hosts
+// are used)
+func UsedHostsPerRemoteDC(n int) func(*dcAwareRR) {
+	return func(d *dcAwareRR) {
+		if n < 0 {
+			n = 0
+		}
+		d.usedHostsPerRemoteDC = n
+	}
+}
+
+// AllowRemoteDCsForLocalConsistencyLevel lets queries at a local consistency
+// level fall back to the hosts of the remote datacenters used with
+// UsedHostsPerRemoteDC.
+func AllowRemoteDCsForLocalConsistencyLevel() func(*dcAwareRR) {
+	return func(d *dcAwareRR) {
+		d.allowRemoteLocalCL = true
+	}
 }
 
 func (d *dcAwareRR) Init(*Session)                       {}
@@ -745,6 +1163,41 @@ func (d *dcAwareRR) IsLocal(host *HostInfo) bool {
 	return host.DataCenter() == d.local
 }
 
+func (d *dcAwareRR) localDC() string {
+	return d.local
+}
+
+func (d *dcAwareRR) usesHost(host *HostInfo) bool {
+	if d.IsLocal(host) || d.usedHostsPerRemoteDC < 0 {
+		return true
+	}
+	for _, h := range d.usedRemoteHosts() {
+		if h.Equal(host) {
+			return true
+		}
+	}
+	return false
+}
+
+// usedRemoteHosts returns the first usedHostsPerRemoteDC remote hosts of each
+// datacenter.
+func (d *dcAwareRR) usedRemoteHosts() []*HostInfo {
+	hosts := d.remoteHosts.get()
+	if d.usedHostsPerRemoteDC < 0 {
+		return hosts
+	}
+
+	used := make([]*HostInfo, 0, len(hosts))
+	perDC := make(map[string]int)
+	for _, host := range hosts {
+		if dc := host.DataCenter(); perDC[dc] < d.usedHostsPerRemoteDC {
+			perDC[dc]++
+			used = append(used, host)
+		}
+	}
+	return used
+}
+
 func (d *dcAwareRR) AddHost(host *HostInfo) {
 	if host.DataCenter() == d.local {
 		d.localHosts.add(host)
@@ -765,11 +1218,21 @@ func (d *dcAwareRR) HostUp(host *HostInfo)   { d.AddHost(host) }
 func (d *dcAwareRR) HostDown(host *HostInfo) { d.RemoveHost(host) }
 
 func (d *dcAwareRR) Pick(q ExecutableQuery) NextHost {
+	// local consistency levels stay in the local datacenter once the remote
+	// ones are limited
+	remote := d.usedHostsPerRemoteDC < 0 || d.allowRemoteLocalCL ||
+		q == nil || !isLocalConsistency(q.GetConsistency())
+
 	var i int
 	return func() SelectedHost {
-		var hosts []*HostInfo
+		var (
+			hosts       []*HostInfo
+			remoteHosts []*HostInfo
+		)
 		localHosts := d.localHosts.get()
-		remoteHosts := d.remoteHosts.get()
+		if remote {
+			remoteHosts = d.usedRemoteHosts()
+		}
 		if len(localHosts) != 0 {
 			hosts = localHosts
 		} else {
@@ -791,6 +1254,54 @@ func (d *dcAwareRR) Pick(q ExecutableQuery) NextHost {
 	}
 }
 
+// isLocalConsistency returns whether c only requires replicas of the local
+// datacenter.
+func isLocalConsistency(c Consistency) bool {
+	return c == LocalOne || c == LocalQuorum
+}
+
+// usedHostsPolicy is implemented by the host selection policies which do not
+// use all the hosts which are up, the pool does not connect to the others.
+type usedHostsPolicy interface {
+	usesHost(host *HostInfo) bool
+}
+
+// policyUsesHost returns whether policy may pick host.
+func policyUsesHost(policy HostSelectionPolicy, host *HostInfo) bool {
+	if p, ok := policy.(usedHostsPolicy); ok {
+		return p.usesHost(host)
+	}
+	return true
+}
+
+// localDCPolicy is implemented by the host selection policies which prefer
+// the hosts of a local datacenter.
+type localDCPolicy interface {
+	localDC() string
+}
+
+// policyLocalDC returns the local datacenter of policy, empty if it has none.
+func policyLocalDC(policy HostSelectionPolicy) string {
+	if p, ok := policy.(localDCPolicy); ok {
+		return p.localDC()
+	}
+	return ""
+}
+
+// latencyPolicy is implemented by the host selection policies which pick hosts
+// by their latency, the latency of queries is only measured for them.
+type latencyPolicy interface {
+	usesLatency() bool
+}
+
+// policyUsesLatency returns whether policy picks hosts by their latency.
+func policyUsesLatency(policy HostSelectionPolicy) bool {
+	if p, ok := policy.(latencyPolicy); ok {
+		return p.usesLatency()
+	}
+	return false
+}
+
 // ConvictionPolicy interface is used by gocql to determine if a host should be
 // marked as DOWN based on the error and host info
 type ConvictionPolicy interface {
@@ -7,6 +7,8 @@ package gocql
 import (
 	"fmt"
 	"net"
+	"reflect"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -109,6 +111,132 @@ func TestHostPolicy_TokenAware(t *testing.T) {
 	}
 }
 
+func TestHostPolicy_TokenAware_LeastInFlightReplicas(t *testing.T) {
+	policy := TokenAwareHostPolicy(RoundRobinHostPolicy(), LeastInFlightReplicas())
+
+	hosts := [...]*HostInfo{
+		{connectAddress: net.IPv4(10, 0, 0, 1), tokens: []string{"00"}, inFlight: 5},
+		{connectAddress: net.IPv4(10, 0, 0, 2), tokens: []string{"25"}, inFlight: 3},
+		{connectAddress: net.IPv4(10, 0, 0, 3), tokens: []string{"50"}, inFlight: 1},
+		{connectAddress: net.IPv4(10, 0, 0, 4), tokens: []string{"75"}},
+	}
+	for _, host := range hosts {
+		policy.AddHost(host)
+	}
+	policy.SetPartitioner("OrderedPartitioner")
+
+	// the first three hosts replicate the partition
+	policy.(*tokenAwareHostPolicy).keyspaces.Store(&keyspaceMeta{
+		replicas: map[string]map[token][]*HostInfo{
+			"ks": {orderedToken("25"): hosts[:3]},
+		},
+	})
+
+	query := (&Query{}).SetKeyspace("ks")
+	query.RoutingKey([]byte("25"))
+
+	// the replicas are tried from the least loaded, the other host is last
+	expected := []int{2, 1, 0, 3}
+	iter := policy.Pick(query)
+	for _, i := range expected {
+		if actual := iter(); actual == nil || actual.Info() != hosts[i] {
+			t.Fatalf("expected host %v got %v", hosts[i].ConnectAddress(), actual)
+		}
+	}
+
+	// replicas with the same load keep the order of the replica map
+	atomic.StoreInt64(&hosts[0].inFlight, 1)
+	if actual := policy.Pick(query)(); actual.Info() != hosts[0] {
+		t.Fatalf("expected host %v got %v", hosts[0].ConnectAddress(), actual.Info().ConnectAddress())
+	}
+}
+
+func TestHostPolicy_TokenAware_PreferRackReplicas(t *testing.T) {
+	policy := TokenAwareHostPolicy(DCAwareRoundRobinPolicy("dc1"), PreferRackReplicas("r1"))
+
+	hosts := [...]*HostInfo{
+		{connectAddress: net.IPv4(10, 0, 0, 1), tokens: []string{"00"}, dataCenter: "dc1", rack: "r2"},
+		{connectAddress: net.IPv4(10, 0, 0, 2), tokens: []string{"25"}, dataCenter: "dc2", rack: "r1"},
+		{connectAddress: net.IPv4(10, 0, 0, 3), tokens: []string{"50"}, dataCenter: "dc1", rack: "r1"},
+		{connectAddress: net.IPv4(10, 0, 0, 4), tokens: []string{"75"}, dataCenter: "dc1", rack: "r1"},
+	}
+	for _, host := range hosts {
+		policy.AddHost(host)
+	}
+	policy.SetPartitioner("OrderedPartitioner")
+
+	policy.(*tokenAwareHostPolicy).keyspaces.Store(&keyspaceMeta{
+		replicas: map[string]map[token][]*HostInfo{
+			"ks": {orderedToken("25"): hosts[:]},
+		},
+	})
+
+	query := (&Query{}).SetKeyspace("ks")
+	query.RoutingKey([]byte("25"))
+
+	// the local replicas in the rack are tried first in replica order, then
+	// the other local replica, the replica in the rack of the remote DC is
+	// not preferred
+	expected := []int{2, 3, 0}
+	iter := policy.Pick(query)
+	for _, i := range expected {
+		if actual := iter(); actual == nil || actual.Info() != hosts[i] {
+			t.Fatalf("expected host %v got %v", hosts[i].ConnectAddress(), actual)
+		}
+	}
+}
+
+func TestHostPolicy_TokenAware_ShuffleReplicas(t *testing.T) {
+	policy := TokenAwareHostPolicy(RoundRobinHostPolicy(), ShuffleReplicas())
+
+	hosts := [...]*HostInfo{
+		{connectAddress: net.IPv4(10, 0, 0, 1), tokens: []string{"00"}},
+		{connectAddress: net.IPv4(10, 0, 0, 2), tokens: []string{"25"}},
+		{connectAddress: net.IPv4(10, 0, 0, 3), tokens: []string{"50"}},
+		{connectAddress: net.IPv4(10, 0, 0, 4), tokens: []string{"75"}},
+	}
+	for _, host := range hosts {
+		policy.AddHost(host)
+	}
+	policy.SetPartitioner("OrderedPartitioner")
+
+	replicas := []*HostInfo{hosts[1], hosts[2], hosts[3]}
+	policy.(*tokenAwareHostPolicy).keyspaces.Store(&keyspaceMeta{
+		replicas: map[string]map[token][]*HostInfo{
+			"ks": {orderedToken("25"): replicas},
+		},
+	})
+
+	query := (&Query{}).SetKeyspace("ks")
+	query.RoutingKey([]byte("25"))
+
+	first := make(map[*HostInfo]int)
+	for i := 0; i < 300; i++ {
+		actual := policy.Pick(query)()
+		if actual == nil {
+			t.Fatal("expected a host got nil")
+		}
+		first[actual.Info()]++
+	}
+
+	// every replica is tried first by some queries, the other host never is
+	for _, host := range replicas {
+		if first[host] == 0 {
+			t.Errorf("expected replica %v to be tried first got %v", host.ConnectAddress(), first)
+		}
+	}
+	if n := first[hosts[0]]; n != 0 {
+		t.Errorf("expected the host which is not a replica not to be tried first got %d", n)
+	}
+
+	// the replicas of the ring are not modified
+	for i, host := range replicas {
+		if host != hosts[i+1] {
+			t.Fatalf("expected the replicas not to be reordered got %v", replicas)
+		}
+	}
+}
+
 // Tests of the host pool host selection policy implementation
 func TestHostPolicy_HostPool(t *testing.T) {
 	policy := HostPoolHostPolicy(hostpool.New(nil))
@@ -361,6 +489,250 @@ func TestDowngradingConsistencyRetryPolicy(t *testing.T) {
 	}
 }
 
+func TestRetryBudget(t *testing.T) {
+	if b := newRetryBudget(nil); b != nil || !b.withdraw() {
+		t.Fatal("a nil budget should allow every retry")
+	}
+
+	b := newRetryBudget(&RetryBudget{Ratio: 0.1, MinRetries: 2})
+
+	// the bucket starts full and does not grow past MinRetries
+	b.deposit()
+	for i := 0; i < 2; i++ {
+		if !b.withdraw() {
+			t.Fatalf("retry %d should be allowed from the initial tokens", i)
+		}
+	}
+	if b.withdraw() {
+		t.Fatal("retry should be throttled once the budget is exhausted")
+	}
+
+	// ten requests add the token of a retry
+	for i := 0; i < 9; i++ {
+		b.deposit()
+		if b.withdraw() {
+			t.Fatalf("retry should be throttled after %d requests", i+1)
+		}
+	}
+	b.deposit()
+	if !b.withdraw() {
+		t.Fatal("retry should be allowed after the bucket refilled")
+	}
+	if b.withdraw() {
+		t.Fatal("retry should be throttled after taking the refilled token")
+	}
+
+	if n := b.throttledRetries(); n != 11 {
+		t.Fatalf("expected 11 throttled retries got %d", n)
+	}
+}
+
+func TestErrorCodeRetryPolicy(t *testing.T) {
+	q := &Query{cons: One}
+
+	rt := &ErrorCodeRetryPolicy{
+		RetryPolicy: &DowngradingConsistencyRetryPolicy{ConsistencyLevelsToTry: []Consistency{One}},
+		RetryTypes: map[int]RetryType{
+			ErrCodeOverloaded:    RetryNextHost,
+			ErrCodeBootstrapping: RetryNextHost,
+			ErrCodeSyntax:        Rethrow,
+			ErrCodeReadTimeout:   Ignore,
+		},
+	}
+
+	cases := []struct {
+		err       error
+		retryType RetryType
+	}{
+		{errorFrame{code: ErrCodeOverloaded}, RetryNextHost},
+		{errorFrame{code: ErrCodeBootstrapping}, RetryNextHost},
+		{errorFrame{code: ErrCodeSyntax}, Rethrow},
+		{&RequestErrReadTimeout{errorFrame: errorFrame{code: ErrCodeReadTimeout}}, Ignore},
+		// not configured, falls through to the wrapped policy
+		{&RequestErrUnavailable{errorFrame: errorFrame{code: ErrCodeUnavailable}, Alive: 1}, Retry},
+		{errorFrame{code: ErrCodeServer}, RetryNextHost},
+		{ErrTimeoutNoResponse, RetryNextHost},
+	}
+
+	for _, c := range cases {
+		if got := rt.GetRetryType(c.err); got != c.retryType {
+			t.Errorf("%v: retry type should be %v got %v", c.err, c.retryType, got)
+		}
+	}
+
+	q.attempts = 1
+	if !rt.Attempt(q) {
+		t.Fatal("should allow retry after 1 attempt")
+	}
+	q.attempts = 2
+	if rt.Attempt(q) {
+		t.Fatal("should not allow retry after 2 attempts")
+	}
+
+	empty := &ErrorCodeRetryPolicy{RetryTypes: map[int]RetryType{ErrCodeOverloaded: RetryNextHost}}
+	if got := empty.GetRetryType(errorFrame{code: ErrCodeOverloaded}); got != RetryNextHost {
+		t.Fatalf("retry type should be %v got %v", RetryNextHost, got)
+	}
+	if got := empty.GetRetryType(errorFrame{code: ErrCodeServer}); got != Rethrow {
+		t.Fatalf("retry type should be %v got %v", Rethrow, got)
+	}
+	if empty.Attempt(q) {
+		t.Fatal("should not allow retry without a RetryPolicy")
+	}
+}
+
+// observeLatencies records n queries to host each taking latency, finishing
+// interval apart and ending at end.
+func observeLatencies(host *HostInfo, latency time.Duration, n int, interval time.Duration, end time.Time) {
+	for i := n - 1; i >= 0; i-- {
+		host.observeLatency(latency, end.Add(-time.Duration(i)*interval))
+	}
+}
+
+// pickOrder returns the ids of the hosts in the order they are picked.
+func pickOrder(policy HostSelectionPolicy) []string {
+	var ids []string
+	next := policy.Pick(nil)
+	for host := next(); host != nil; host = next() {
+		ids = append(ids, host.Info().HostID())
+	}
+	return ids
+}
+
+func TestHostPolicy_LatencyAware(t *testing.T) {
+	policy := LatencyAwareHostPolicy(RoundRobinHostPolicy(),
+		LatencyExclusionThreshold(2),
+		LatencyRetryPeriod(time.Minute),
+		LatencyMinMeasured(10),
+	)
+	if !policyUsesLatency(policy) || !policyUsesLatency(TokenAwareHostPolicy(policy)) {
+		t.Fatal("expected the latency aware policy to use the latency of hosts")
+	}
+	if policyUsesLatency(TokenAwareHostPolicy(RoundRobinHostPolicy())) {
+		t.Fatal("expected the round robin policy not to use the latency of hosts")
+	}
+
+	hosts := [...]*HostInfo{
+		{hostId: "0", connectAddress: net.IPv4(0, 0, 0, 1)},
+		{hostId: "1", connectAddress: net.IPv4(0, 0, 0, 2)},
+		{hostId: "2", connectAddress: net.IPv4(0, 0, 0, 3)},
+	}
+	for _, host := range hosts {
+		policy.AddHost(host)
+	}
+
+	// without measurements the fallback order is used
+	var firsts []string
+	for i := 0; i < len(hosts); i++ {
+		order := pickOrder(policy)
+		if len(order) != len(hosts) {
+			t.Fatalf("expected %d hosts got %v", len(hosts), order)
+		}
+		firsts = append(firsts, order[0])
+	}
+	if fmt.Sprint(firsts) != "[0 1 2]" {
+		t.Fatalf("expected each host to be picked first in turn got %v", firsts)
+	}
+
+	now := time.Now()
+	observeLatencies(hosts[0], 10*time.Millisecond, 20, time.Millisecond, now)
+	observeLatencies(hosts[1], 15*time.Millisecond, 20, time.Millisecond, now)
+	observeLatencies(hosts[2], 100*time.Millisecond, 20, time.Millisecond, now)
+
+	if latency, measured, _ := hosts[2].latencyStats(); measured != 20 || latency != 100*time.Millisecond {
+		t.Fatalf("expected 20 measurements averaging 100ms got %d averaging %v", measured, latency)
+	}
+
+	// the slow host is moved to the end, the others keep their order
+	for i := 0; i < len(hosts); i++ {
+		order := pickOrder(policy)
+		if len(order) != len(hosts) {
+			t.Fatalf("expected %d hosts got %v", len(hosts), order)
+		} else if order[len(order)-1] != "2" {
+			t.Fatalf("expected slow host to be picked last got %v", order)
+		}
+	}
+
+	// the average follows the recent latency of the host
+	observeLatencies(hosts[2], 12*time.Millisecond, 6, 200*time.Millisecond, now.Add(1200*time.Millisecond))
+	if latency, _, _ := hosts[2].latencyStats(); latency > 20*time.Millisecond {
+		t.Fatalf("expected average latency to recover got %v", latency)
+	}
+	found := false
+	for i := 0; i < len(hosts); i++ {
+		if order := pickOrder(policy); order[0] == "2" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatal("expected recovered host to be picked first again")
+	}
+}
+
+func TestHostPolicy_LatencyAwareRetryPeriod(t *testing.T) {
+	policy := LatencyAwareHostPolicy(RoundRobinHostPolicy(),
+		LatencyRetryPeriod(time.Second),
+		LatencyMinMeasured(10),
+	)
+
+	hosts := [...]*HostInfo{
+		{hostId: "0", connectAddress: net.IPv4(0, 0, 0, 1)},
+		{hostId: "1", connectAddress: net.IPv4(0, 0, 0, 2)},
+		{hostId: "2", connectAddress: net.IPv4(0, 0, 0, 3)},
+	}
+	for _, host := range hosts {
+		policy.AddHost(host)
+	}
+
+	now := time.Now()
+	observeLatencies(hosts[0], 10*time.Millisecond, 20, time.Millisecond, now)
+	// too few measurements to be penalised
+	observeLatencies(hosts[1], 100*time.Millisecond, 5, time.Millisecond, now)
+	// not measured within the retry period so it is tried again
+	observeLatencies(hosts[2], 100*time.Millisecond, 20, time.Millisecond, now.Add(-5*time.Second))
+
+	for _, id := range []string{"1", "2"} {
+		found := false
+		for i := 0; i < len(hosts); i++ {
+			if order := pickOrder(policy); order[0] == id {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("expected host %s not to be penalised", id)
+		}
+	}
+}
+
+func TestHostPolicy_LatencyAwareInFlight(t *testing.T) {
+	policy := LatencyAwareHostPolicy(RoundRobinHostPolicy(),
+		LatencyMinMeasured(10),
+	)
+
+	hosts := [...]*HostInfo{
+		{hostId: "0", connectAddress: net.IPv4(0, 0, 0, 1)},
+		{hostId: "1", connectAddress: net.IPv4(0, 0, 0, 2), inFlight: 5},
+		{hostId: "2", connectAddress: net.IPv4(0, 0, 0, 3)},
+		{hostId: "3", connectAddress: net.IPv4(0, 0, 0, 4), inFlight: 2},
+	}
+	for _, host := range hosts {
+		policy.AddHost(host)
+	}
+
+	now := time.Now()
+	observeLatencies(hosts[0], 10*time.Millisecond, 20, time.Millisecond, now)
+	for _, host := range hosts[1:] {
+		observeLatencies(host, 100*time.Millisecond, 20, time.Millisecond, now)
+	}
+
+	// the slow hosts are picked after the fast host, least loaded first
+	for i := 0; i < len(hosts); i++ {
+		if order := fmt.Sprint(pickOrder(policy)); order != "[0 2 3 1]" {
+			t.Fatalf("expected the slow hosts to be picked by their queries in flight got %v", order)
+		}
+	}
+}
+
 func TestHostPolicy_DCAwareRR(t *testing.T) {
 	p := DCAwareRoundRobinPolicy("local")
 
@@ -408,3 +780,75 @@ func TestHostPolicy_DCAwareRR(t *testing.T) {
 	}
 
 }
+
+func TestHostPolicy_DCAwareRR_UsedHostsPerRemoteDC(t *testing.T) {
+	hosts := [...]*HostInfo{
+		{hostId: "0", connectAddress: net.ParseIP("10.0.0.1"), dataCenter: "local"},
+		{hostId: "1", connectAddress: net.ParseIP("10.0.1.1"), dataCenter: "remote1"},
+		{hostId: "2", connectAddress: net.ParseIP("10.0.1.2"), dataCenter: "remote1"},
+		{hostId: "3", connectAddress: net.ParseIP("10.0.2.1"), dataCenter: "remote2"},
+		{hostId: "4", connectAddress: net.ParseIP("10.0.2.2"), dataCenter: "remote2"},
+	}
+
+	p := DCAwareRoundRobinPolicy("local", UsedHostsPerRemoteDC(1))
+	for _, host := range hosts {
+		p.AddHost(host)
+	}
+
+	used := func() []string {
+		var ids []string
+		for _, host := range hosts {
+			if policyUsesHost(p, host) {
+				ids = append(ids, host.HostID())
+			}
+		}
+		return ids
+	}
+	if ids := used(); !reflect.DeepEqual(ids, []string{"0", "1", "3"}) {
+		t.Fatalf("expected the local host and the first host of each remote dc to be used got %v", ids)
+	}
+
+	picked := func(cons Consistency) map[string]bool {
+		ids := make(map[string]bool)
+		iter := p.Pick(&Query{cons: cons})
+		for host := iter(); host != nil; host = iter() {
+			ids[host.Info().HostID()] = true
+		}
+		return ids
+	}
+
+	// the remote hosts are only picked once the local one is down
+	p.HostDown(hosts[0])
+	if ids := picked(Quorum); !reflect.DeepEqual(ids, map[string]bool{"1": true, "3": true}) {
+		t.Fatalf("expected the used remote hosts to be picked got %v", ids)
+	}
+	if ids := picked(LocalQuorum); len(ids) != 0 {
+		t.Fatalf("expected no remote host to be picked at a local consistency got %v", ids)
+	}
+
+	// the next host of the datacenter is used in place of a down one
+	p.HostDown(hosts[1])
+	if ids := used(); !reflect.DeepEqual(ids, []string{"0", "2", "3"}) {
+		t.Fatalf("expected the next host of remote1 to be used got %v", ids)
+	}
+
+	p = DCAwareRoundRobinPolicy("local", UsedHostsPerRemoteDC(1), AllowRemoteDCsForLocalConsistencyLevel())
+	for _, host := range hosts[1:] {
+		p.AddHost(host)
+	}
+	if ids := picked(LocalOne); !reflect.DeepEqual(ids, map[string]bool{"1": true, "3": true}) {
+		t.Fatalf("expected the used remote hosts to be picked at a local consistency got %v", ids)
+	}
+
+	// without a limit every remote host is used
+	p = DCAwareRoundRobinPolicy("local")
+	for _, host := range hosts[1:] {
+		p.AddHost(host)
+	}
+	if ids := used(); len(ids) != len(hosts) {
+		t.Fatalf("expected all the hosts to be used got %v", ids)
+	}
+	if ids := picked(LocalOne); len(ids) != len(hosts)-1 {
+		t.Fatalf("expected all the remote hosts to be picked got %v", ids)
+	}
+}
@@ -58,6 +58,20 @@ func (p *preparedLRU) execIfMissing(key string, fn func(lru *lru.Cache) *infligh
 	return fn(p.lru), false
 }
 
+// forAddr returns the statements prepared on the host at addr by their keys.
+func (p *preparedLRU) forAddr(addr string) map[string]*inflightPrepare {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stmts := make(map[string]*inflightPrepare)
+	p.lru.Range(func(key string, val interface{}) {
+		if flight := val.(*inflightPrepare); flight.addr == addr {
+			stmts[key] = flight
+		}
+	})
+	return stmts
+}
+
 func (p *preparedLRU) keyFor(addr, keyspace, statement string) string {
 	// TODO: maybe use []byte for keys?
 	return addr + keyspace + statement
@@ -0,0 +1,173 @@
+// Package qb implements a minimal builder for CQL SELECT statements, producing
+// the statement and the values to bind to it so that queries do not need to be
+// built by concatenating strings.
+//
+//     stmt, values := qb.Select("ks.events").
+//         Columns("id", "count(*)").
+//         Where(qb.Eq("id", id), qb.Gt("ts", since)).
+//         GroupBy("id").
+//         PerPartitionLimit(10).
+//         ToCql()
+//     iter := session.Query(stmt, values...).Iter()
+package qb
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+
+	"github.com/gocql/gocql"
+)
+
+// Cmp is a condition on a column used in the WHERE clause of a statement.
+type Cmp struct {
+	column string
+	op     string
+	value  interface{}
+}
+
+// Eq is the condition column = value.
+func Eq(column string, value interface{}) Cmp {
+	return Cmp{column: column, op: "=", value: value}
+}
+
+// Lt is the condition column < value.
+func Lt(column string, value interface{}) Cmp {
+	return Cmp{column: column, op: "<", value: value}
+}
+
+// LtOrEq is the condition column <= value.
+func LtOrEq(column string, value interface{}) Cmp {
+	return Cmp{column: column, op: "<=", value: value}
+}
+
+// Gt is the condition column > value.
+func Gt(column string, value interface{}) Cmp {
+	return Cmp{column: column, op: ">", value: value}
+}
+
+// GtOrEq is the condition column >= value.
+func GtOrEq(column string, value interface{}) Cmp {
+	return Cmp{column: column, op: ">=", value: value}
+}
+
+// In is the condition column IN values, values is bound as a single list.
+func In(column string, values ...interface{}) Cmp {
+	return Cmp{column: column, op: "IN", value: values}
+}
+
+// Contains is the condition column CONTAINS value, for collection columns.
+func Contains(column string, value interface{}) Cmp {
+	return Cmp{column: column, op: "CONTAINS", value: value}
+}
+
+// SelectBuilder builds a SELECT statement, create one with Select.
+type SelectBuilder struct {
+	table             string
+	columns           []string
+	where             []Cmp
+	groupBy           []string
+	perPartitionLimit int
+	limit             int
+	allowFiltering    bool
+}
+
+// Select returns a builder for a SELECT statement from table, which may be
+// qualified with its keyspace.
+func Select(table string) *SelectBuilder {
+	return &SelectBuilder{table: table}
+}
+
+// Columns adds columns to select, all columns are selected if none are added.
+func (b *SelectBuilder) Columns(columns ...string) *SelectBuilder {
+	b.columns = append(b.columns, columns...)
+	return b
+}
+
+// Where adds conditions to the WHERE clause, the conditions are joined with
+// AND.
+func (b *SelectBuilder) Where(cmps ...Cmp) *SelectBuilder {
+	b.where = append(b.where, cmps...)
+	return b
+}
+
+// GroupBy adds columns to the GROUP BY clause.
+func (b *SelectBuilder) GroupBy(columns ...string) *SelectBuilder {
+	b.groupBy = append(b.groupBy, columns...)
+	return b
+}
+
+// PerPartitionLimit limits the number of rows returned from each partition,
+// no limit is set if n is not positive.
+func (b *SelectBuilder) PerPartitionLimit(n int) *SelectBuilder {
+	b.perPartitionLimit = n
+	return b
+}
+
+// Limit limits the number of rows returned, no limit is set if n is not
+// positive.
+func (b *SelectBuilder) Limit(n int) *SelectBuilder {
+	b.limit = n
+	return b
+}
+
+// AllowFiltering adds ALLOW FILTERING to the statement.
+func (b *SelectBuilder) AllowFiltering() *SelectBuilder {
+	b.allowFiltering = true
+	return b
+}
+
+// ToCql returns the statement and the values to bind to it.
+func (b *SelectBuilder) ToCql() (stmt string, values []interface{}) {
+	var buf bytes.Buffer
+
+	buf.WriteString("SELECT ")
+	if len(b.columns) == 0 {
+		buf.WriteByte('*')
+	} else {
+		buf.WriteString(strings.Join(b.columns, ", "))
+	}
+	buf.WriteString(" FROM ")
+	buf.WriteString(b.table)
+
+	for i, cmp := range b.where {
+		if i == 0 {
+			buf.WriteString(" WHERE ")
+		} else {
+			buf.WriteString(" AND ")
+		}
+		buf.WriteString(cmp.column)
+		buf.WriteByte(' ')
+		buf.WriteString(cmp.op)
+		buf.WriteString(" ?")
+		values = append(values, cmp.value)
+	}
+
+	if len(b.groupBy) > 0 {
+		buf.WriteString(" GROUP BY ")
+		buf.WriteString(strings.Join(b.groupBy, ", "))
+	}
+
+	if b.perPartitionLimit > 0 {
+		buf.WriteString(" PER PARTITION LIMIT ")
+		buf.WriteString(strconv.Itoa(b.perPartitionLimit))
+	}
+
+	if b.limit > 0 {
+		buf.WriteString(" LIMIT ")
+		buf.WriteString(strconv.Itoa(b.limit))
+	}
+
+	if b.allowFiltering {
+		buf.WriteString(" ALLOW FILTERING")
+	}
+
+	return buf.String(), values
+}
+
+// Query builds the statement and returns a query for it from session, with
+// the values bound.
+func (b *SelectBuilder) Query(session *gocql.Session) *gocql.Query {
+	stmt, values := b.ToCql()
+	return session.Query(stmt, values...)
+}
@@ -0,0 +1,65 @@
+package qb
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSelect(t *testing.T) {
+	tests := []struct {
+		name   string
+		b      *SelectBuilder
+		stmt   string
+		values []interface{}
+	}{
+		{
+			name: "all columns",
+			b:    Select("ks.t"),
+			stmt: "SELECT * FROM ks.t",
+		},
+		{
+			name:   "columns and where",
+			b:      Select("t").Columns("a", "b").Where(Eq("id", 1), GtOrEq("ts", 2)),
+			stmt:   "SELECT a, b FROM t WHERE id = ? AND ts >= ?",
+			values: []interface{}{1, 2},
+		},
+		{
+			name:   "in and contains",
+			b:      Select("t").Where(In("id", 1, 2, 3), Contains("tags", "x")).AllowFiltering(),
+			stmt:   "SELECT * FROM t WHERE id IN ? AND tags CONTAINS ? ALLOW FILTERING",
+			values: []interface{}{[]interface{}{1, 2, 3}, "x"},
+		},
+		{
+			name:   "per partition limit",
+			b:      Select("t").Where(Lt("ts", 5)).PerPartitionLimit(2).AllowFiltering(),
+			stmt:   "SELECT * FROM t WHERE ts < ? PER PARTITION LIMIT 2 ALLOW FILTERING",
+			values: []interface{}{5},
+		},
+		{
+			name:   "group by with limits",
+			b:      Select("t").Columns("id", "count(*)").Where(Gt("ts", 1), LtOrEq("ts", 9)).GroupBy("id").PerPartitionLimit(3).Limit(10),
+			stmt:   "SELECT id, count(*) FROM t WHERE ts > ? AND ts <= ? GROUP BY id PER PARTITION LIMIT 3 LIMIT 10",
+			values: []interface{}{1, 9},
+		},
+		{
+			name: "limit without where",
+			b:    Select("t").Columns("id").GroupBy("id", "ck").Limit(1),
+			stmt: "SELECT id FROM t GROUP BY id, ck LIMIT 1",
+		},
+		{
+			name: "non positive limits are ignored",
+			b:    Select("t").PerPartitionLimit(0).Limit(-1),
+			stmt: "SELECT * FROM t",
+		},
+	}
+
+	for _, test := range tests {
+		stmt, values := test.b.ToCql()
+		if stmt != test.stmt {
+			t.Errorf("%s: expected statement %q got %q", test.name, test.stmt, stmt)
+		}
+		if !reflect.DeepEqual(values, test.values) {
+			t.Errorf("%s: expected values %v got %v", test.name, test.values, values)
+		}
+	}
+}
@@ -1,6 +1,10 @@
 package gocql
 
 import (
+	"fmt"
+	"net"
+	"strconv"
+	"sync/atomic"
 	"time"
 )
 
@@ -8,31 +12,140 @@ type ExecutableQuery interface {
 	execute(conn *Conn) *Iter
 	attempt(keyspace string, end, start time.Time, iter *Iter, host *HostInfo)
 	retryPolicy() RetryPolicy
+	pinnedHost() string
 	GetRoutingKey() ([]byte, error)
 	Keyspace() string
 	RetryableQuery
 }
 
+// hostBackoff is how long hosts which are overloaded or bootstrapping are
+// tried after the other hosts.
+const hostBackoff = time.Second
+
+// isHostBusy returns whether err is an error returned by a host which could
+// not handle the query, but which another host may.
+func isHostBusy(err error) bool {
+	if reqErr, ok := err.(RequestError); ok {
+		switch reqErr.Code() {
+		case ErrCodeOverloaded, ErrCodeBootstrapping:
+			return true
+		}
+	}
+	return false
+}
+
+// backoffHostIter returns an iterator over the hosts of next which returns the
+// hosts that are backed off after the others.
+func backoffHostIter(next NextHost) NextHost {
+	var (
+		backedOff []SelectedHost
+		done      bool
+	)
+	return func() SelectedHost {
+		for !done {
+			host := next()
+			if host == nil {
+				done = true
+			} else if info := host.Info(); info != nil && info.backedOff(time.Now()) {
+				backedOff = append(backedOff, host)
+			} else {
+				return host
+			}
+		}
+
+		if len(backedOff) == 0 {
+			return nil
+		}
+		host := backedOff[0]
+		backedOff = backedOff[1:]
+		return host
+	}
+}
+
 type queryExecutor struct {
 	pool   *policyConnPool
 	policy HostSelectionPolicy
+	budget *retryBudget
+	// measureLatency is whether the latency of queries is recorded on the
+	// hosts, which is only needed by latency aware policies
+	measureLatency bool
 }
 
 func (q *queryExecutor) attemptQuery(qry ExecutableQuery, conn *Conn) *Iter {
+	if conn.host != nil {
+		atomic.AddInt64(&conn.host.inFlight, 1)
+		defer atomic.AddInt64(&conn.host.inFlight, -1)
+	}
+
 	start := time.Now()
 	iter := qry.execute(conn)
 	end := time.Now()
 
+	// record the coordinator for every attempt, including the fetches of
+	// further pages which do not go through executeQuery
+	iter.host = conn.host
 	qry.attempt(q.pool.keyspace, end, start, iter, conn.host)
+	if q.measureLatency && conn.host != nil {
+		conn.host.observeLatency(end.Sub(start), end)
+	}
 
 	return iter
 }
 
+// pinnedHostIter returns an iterator over only the host at addr, for queries
+// pinned to it.
+func (q *queryExecutor) pinnedHostIter(addr string) (NextHost, error) {
+	port := 0
+	if h, p, err := net.SplitHostPort(addr); err == nil {
+		if port, err = strconv.Atoi(p); err != nil {
+			return nil, fmt.Errorf("gocql: invalid port in host address %q", addr)
+		}
+		addr = h
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return nil, fmt.Errorf("gocql: invalid host address %q", addr)
+	}
+
+	host := q.pool.session.ring.getHost(ip)
+	if host == nil || (port != 0 && host.Port() != port) {
+		return nil, ErrHostNotFound
+	} else if !host.IsUp() {
+		return nil, ErrHostDown
+	}
+
+	picked := false
+	return func() SelectedHost {
+		if picked {
+			return nil
+		}
+		picked = true
+		return (*selectedHost)(host)
+	}, nil
+}
+
 func (q *queryExecutor) executeQuery(qry ExecutableQuery) (*Iter, error) {
 	rt := qry.retryPolicy()
-	hostIter := q.policy.Pick(qry)
+	q.budget.deposit()
 
-	var iter *Iter
+	var hostIter NextHost
+	if addr := qry.pinnedHost(); addr != "" {
+		var err error
+		if hostIter, err = q.pinnedHostIter(addr); err != nil {
+			return nil, err
+		}
+	} else {
+		hostIter = backoffHostIter(q.policy.Pick(qry))
+	}
+
+	var (
+		iter       *Iter
+		hostErrors []HostError
+		// whether the next attempt is a retry which takes from the budget
+		retrying bool
+		// whether the budget refused a retry, which ends the attempts
+		throttled bool
+	)
 	for hostResponse := hostIter(); hostResponse != nil; hostResponse = hostIter() {
 		host := hostResponse.Info()
 		if host == nil || !host.IsUp() {
@@ -49,30 +162,49 @@ func (q *queryExecutor) executeQuery(qry ExecutableQuery) (*Iter, error) {
 			continue
 		}
 
+		if retrying && !q.budget.withdraw() {
+			break
+		}
+		retrying = true
+
 		iter = q.attemptQuery(qry, conn)
 		// Update host
 		hostResponse.Mark(iter.err)
+		hostErrors = addHostError(hostErrors, host, iter.err)
+
+		if isHostBusy(iter.err) {
+			host.backoff(time.Now().Add(hostBackoff))
+			if rt == nil {
+				// without a retry policy the query is still sent to the next
+				// host, as another host is likely able to handle it
+				continue
+			}
+		}
 
 		if rt == nil {
-			iter.host = host
 			break
 		}
 
 		switch rt.GetRetryType(iter.err) {
 		case Retry:
 			for rt.Attempt(qry) {
+				if !q.budget.withdraw() {
+					throttled = true
+					break
+				}
 				iter = q.attemptQuery(qry, conn)
 				hostResponse.Mark(iter.err)
 				if iter.err == nil {
-					iter.host = host
 					return iter, nil
 				}
+				hostErrors = addHostError(hostErrors, host, iter.err)
 				if rt.GetRetryType(iter.err) != Retry {
 					break
 				}
 			}
 		case Rethrow:
-			return nil, iter.err
+			iter.hostErrors = hostErrors
+			return iter, nil
 		case Ignore:
 			return iter, nil
 		case RetryNextHost:
@@ -81,10 +213,14 @@ func (q *queryExecutor) executeQuery(qry ExecutableQuery) (*Iter, error) {
 
 		// Exit for loop if the query was successful
 		if iter.err == nil {
-			iter.host = host
 			return iter, nil
 		}
 
+		if throttled {
+			// the retry is not tried on the next host either
+			break
+		}
+
 		if !rt.Attempt(qry) {
 			// What do here? Should we just return an error here?
 			break
@@ -95,5 +231,6 @@ func (q *queryExecutor) executeQuery(qry ExecutableQuery) (*Iter, error) {
 		return nil, ErrNoConnections
 	}
 
+	iter.hostErrors = hostErrors
 	return iter, nil
 }
@@ -36,6 +36,17 @@ func (r *ring) rrHost() *HostInfo {
 	return r.hostList[pos%len(r.hostList)]
 }
 
+// isEndpoint returns true if ip is one of the endpoints the driver was
+// configured with.
+func (r *ring) isEndpoint(ip net.IP) bool {
+	for _, host := range r.endpoints {
+		if host.ConnectAddress().Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
 func (r *ring) getHost(ip net.IP) *HostInfo {
 	r.mu.RLock()
 	host := r.hosts[ip.String()]
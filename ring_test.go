@@ -2,7 +2,9 @@ package gocql
 
 import (
 	"net"
+	"reflect"
 	"testing"
+	"time"
 )
 
 func TestRing_AddHostIfMissing_Missing(t *testing.T) {
@@ -36,3 +38,44 @@ func TestRing_AddHostIfMissing_Existing(t *testing.T) {
 		t.Fatalf("returned host same pointer: %p != %p", h1, host)
 	}
 }
+
+func TestHostInfoClone(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	// every field is set so that a field missing from clone is caught
+	host := &HostInfo{
+		inFlight:         3,
+		peer:             net.IPv4(1, 1, 1, 1),
+		broadcastAddress: net.IPv4(1, 1, 1, 2),
+		listenAddress:    net.IPv4(1, 1, 1, 3),
+		rpcAddress:       net.IPv4(1, 1, 1, 4),
+		preferredIP:      net.IPv4(1, 1, 1, 5),
+		connectAddress:   net.IPv4(1, 1, 1, 6),
+		port:             9042,
+		dataCenter:       "dc1",
+		rack:             "rack1",
+		hostId:           "host1",
+		workload:         "Cassandra",
+		graph:            true,
+		dseVersion:       "6.0.0",
+		partitioner:      "Murmur3Partitioner",
+		clusterName:      "cluster",
+		version:          cassVersion{3, 11, 4},
+		cqlVersion:       "3.4.4",
+		state:            NodeUp,
+		stateChanged:     now,
+		stateReason:      StateChangeConnection,
+		tokens:           []string{"1", "2"},
+		latency:          float64(time.Millisecond),
+		latencyMeasured:  5,
+		latencyUpdated:   now,
+		backoffUntil:     now.Add(time.Second),
+	}
+
+	clone := host.clone()
+	if !reflect.DeepEqual(clone, host) {
+		t.Fatalf("expected the clone to equal the host\ngot  %+v\nwant %+v", clone, host)
+	}
+	if &clone.tokens[0] == &host.tokens[0] || &clone.peer[0] == &host.peer[0] {
+		t.Fatal("expected the clone not to share slices with the host")
+	}
+}
@@ -7,11 +7,14 @@ package gocql
 import (
 	"bytes"
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
 	"net"
+	"reflect"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -31,6 +34,13 @@ import (
 // and automatically sets a default consistency level on all operations
 // that do not have a consistency level set.
 type Session struct {
+	// number of requests which found all the streams of their connection in
+	// use, and of TLS handshakes and those which resumed a TLS session,
+	// accessed atomically and kept first so that they are 64-bit aligned
+	streamExhaustions uint64
+	tlsHandshakes     uint64
+	tlsResumed        uint64
+
 	cons                Consistency
 	pageSize            int
 	prefetch            float64
@@ -41,8 +51,10 @@ type Session struct {
 	batchObserver       BatchObserver
 	connectObserver     ConnectObserver
 	frameObserver       FrameHeaderObserver
+	timestamps          TimestampGenerator
 	hostSource          *ringDescriber
 	stmtsLRU            *preparedLRU
+	framerPool          *framerPool
 
 	connCfg *ConnConfig
 
@@ -71,6 +83,23 @@ type Session struct {
 
 	closeMu  sync.RWMutex
 	isClosed bool
+	// closeDone is closed once the session has been torn down, for the
+	// calls to Close made while it is
+	closeDone chan struct{}
+
+	// inFlight tracks the requests being executed, which a graceful close
+	// waits for
+	inFlight sync.WaitGroup
+
+	supported atomic.Value // map[string][]string
+
+	// requests holds a token for each request in flight when they are limited
+	// by MaxConcurrentRequests
+	requests chan struct{}
+
+	// nowInSecondsIgnored logs that Query.NowInSeconds is ignored until
+	// protocol version 5 is supported, the first time it is
+	nowInSecondsIgnored sync.Once
 }
 
 var queryPool = &sync.Pool{
@@ -113,18 +142,26 @@ func NewSession(cfg ClusterConfig) (*Session, error) {
 		cfg:             cfg,
 		pageSize:        cfg.PageSize,
 		stmtsLRU:        &preparedLRU{lru: lru.New(cfg.MaxPreparedStmts)},
+		framerPool:      newFramerPool(cfg.EventFrameBufferSize),
 		quit:            make(chan struct{}),
 		connectObserver: cfg.ConnectObserver,
 	}
 
+	s.timestamps = cfg.TimestampGenerator
+	if s.timestamps == nil {
+		s.timestamps = MonotonicTimestampGenerator()
+	}
+
 	s.schemaDescriber = newSchemaDescriber(s)
 
-	s.nodeEvents = newEventDebouncer("NodeEvents", s.handleNodeEvent)
-	s.schemaEvents = newEventDebouncer("SchemaEvents", s.handleSchemaEvent)
+	if !cfg.DisableEvents {
+		s.nodeEvents = newEventDebouncer("NodeEvents", realClock{}, s.handleNodeEvent)
+		s.schemaEvents = newEventDebouncer("SchemaEvents", realClock{}, s.handleSchemaEvent)
+	}
 
 	s.routingKeyInfoCache.lru = lru.New(cfg.MaxRoutingKeyInfo)
 
-	s.hostSource = &ringDescriber{session: s}
+	s.hostSource = newRingDescriber(s, cfg.MaxConcurrentHostFetches)
 
 	if cfg.PoolConfig.HostSelectionPolicy == nil {
 		cfg.PoolConfig.HostSelectionPolicy = RoundRobinHostPolicy()
@@ -135,8 +172,14 @@ func NewSession(cfg ClusterConfig) (*Session, error) {
 	s.policy.Init(s)
 
 	s.executor = &queryExecutor{
-		pool:   s.pool,
-		policy: cfg.PoolConfig.HostSelectionPolicy,
+		pool:           s.pool,
+		policy:         cfg.PoolConfig.HostSelectionPolicy,
+		budget:         newRetryBudget(cfg.RetryBudget),
+		measureLatency: policyUsesLatency(cfg.PoolConfig.HostSelectionPolicy),
+	}
+
+	if cfg.MaxConcurrentRequests > 0 {
+		s.requests = make(chan struct{}, cfg.MaxConcurrentRequests)
 	}
 
 	s.queryObserver = cfg.QueryObserver
@@ -217,7 +260,13 @@ func (s *Session) init() error {
 
 	for _, host := range hostMap {
 		host = s.ring.addOrUpdate(host)
-		s.addNewNode(host)
+		s.addNewNode(host, StateChangeDiscovery)
+	}
+
+	if s.cfg.MinReadyHosts > 0 {
+		if err := s.waitForReadyHosts(s.cfg.MinReadyHosts, s.cfg.ReadyTimeout); err != nil {
+			return err
+		}
 	}
 
 	// TODO(zariel): we probably dont need this any more as we verify that we
@@ -246,6 +295,49 @@ func (s *Session) init() error {
 	return nil
 }
 
+// readyHostsRetryInterval is how often hosts without connections are retried
+// while waiting for MinReadyHosts.
+const readyHostsRetryInterval = 50 * time.Millisecond
+
+// waitForReadyHosts blocks until at least n hosts have an open connection,
+// reconnecting to the hosts without any until timeout.
+func (s *Session) waitForReadyHosts(n int, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		var ready int
+		var pending []*HostInfo
+		for _, host := range s.ring.allHosts() {
+			if pool, ok := s.pool.getPool(host); ok && pool.Size() > 0 {
+				ready++
+			} else {
+				pending = append(pending, host)
+			}
+		}
+
+		if ready >= n {
+			return nil
+		} else if !time.Now().Before(deadline) {
+			msg := fmt.Sprintf("gocql: only %d of the %d required hosts are ready after %v", ready, n, timeout)
+			if len(pending) > 0 {
+				addrs := make([]string, len(pending))
+				for i, host := range pending {
+					addrs[i] = JoinHostPort(host.ConnectAddress().String(), host.Port())
+				}
+				msg += ", unable to connect to " + strings.Join(addrs, ", ")
+			}
+			return errors.New(msg)
+		}
+
+		time.Sleep(readyHostsRetryInterval)
+		for _, host := range pending {
+			if !time.Now().Before(deadline) {
+				break
+			}
+			s.handleNodeUp(host.ConnectAddress(), host.Port(), false, StateChangeReconnect)
+		}
+	}
+}
+
 func (s *Session) reconnectDownedHosts(intv time.Duration) {
 	reconnectTicker := time.NewTicker(intv)
 	defer reconnectTicker.Stop()
@@ -268,7 +360,7 @@ func (s *Session) reconnectDownedHosts(intv time.Duration) {
 				if h.IsUp() {
 					continue
 				}
-				s.handleNodeUp(h.ConnectAddress(), h.Port(), true)
+				s.handleNodeUp(h.ConnectAddress(), h.Port(), true, StateChangeReconnect)
 			}
 		case <-s.quit:
 			return
@@ -293,6 +385,34 @@ func (s *Session) SetPageSize(n int) {
 	s.mu.Unlock()
 }
 
+// statementKeyspace returns the keyspace which the table of a CQL statement
+// is qualified with, such as ks for SELECT * FROM ks.tbl, or empty if the
+// table is not qualified. Unquoted keyspaces are lower cased like they are by
+// Cassandra.
+func statementKeyspace(stmt string) string {
+	fields := strings.Fields(stmt)
+	for i, field := range fields {
+		switch strings.ToLower(field) {
+		case "from", "into", "update", "truncate":
+		default:
+			continue
+		}
+		if i+1 == len(fields) {
+			return ""
+		}
+		n := strings.IndexByte(fields[i+1], '.')
+		if n <= 0 {
+			return ""
+		}
+		ks := fields[i+1][:n]
+		if len(ks) > 1 && ks[0] == '"' && ks[len(ks)-1] == '"' {
+			return ks[1 : len(ks)-1]
+		}
+		return strings.ToLower(ks)
+	}
+	return ""
+}
+
 // SetPrefetch sets the default threshold for pre-fetching new pages. If
 // there are only p*pageSize rows remaining, the next page will be requested
 // automatically. This value can also be changed on a per-query basis and
@@ -349,22 +469,55 @@ func (s *Session) Bind(stmt string, b func(q *QueryInfo) ([]interface{}, error))
 // Close closes all connections. The session is unusable after this
 // operation.
 func (s *Session) Close() {
+	s.CloseWithTimeout(0)
+}
 
+// CloseWithTimeout closes the session gracefully, new queries and batches
+// fail with ErrSessionClosed while those in flight are waited for, for up to
+// timeout, before the event handlers are stopped and the connections closed.
+// In flight requests which do not finish within the timeout fail as their
+// connections are closed and ErrCloseTimeout is returned. A timeout which is
+// not positive does not wait, like Close. Calls made while the session is
+// closing return once it has been closed.
+func (s *Session) CloseWithTimeout(timeout time.Duration) error {
 	s.closeMu.Lock()
-	defer s.closeMu.Unlock()
 	if s.isClosed {
-		return
+		done := s.closeDone
+		s.closeMu.Unlock()
+		if done != nil {
+			<-done
+		}
+		return nil
 	}
 	s.isClosed = true
+	s.closeDone = make(chan struct{})
+	s.closeMu.Unlock()
+	defer close(s.closeDone)
 
-	if s.pool != nil {
-		s.pool.Close()
+	var err error
+	if timeout > 0 {
+		drained := make(chan struct{})
+		go func() {
+			s.inFlight.Wait()
+			close(drained)
+		}()
+
+		timer := time.NewTimer(timeout)
+		select {
+		case <-drained:
+		case <-timer.C:
+			err = ErrCloseTimeout
+		}
+		timer.Stop()
 	}
 
-	if s.control != nil {
-		s.control.close()
-	}
+	s.close()
+	return err
+}
 
+func (s *Session) close() {
+	// the events are stopped first so that none are handled against the
+	// connections being closed
 	if s.nodeEvents != nil {
 		s.nodeEvents.stop()
 	}
@@ -373,6 +526,14 @@ func (s *Session) Close() {
 		s.schemaEvents.stop()
 	}
 
+	if s.pool != nil {
+		s.pool.Close()
+	}
+
+	if s.control != nil {
+		s.control.close()
+	}
+
 	if s.quit != nil {
 		close(s.quit)
 	}
@@ -385,12 +546,137 @@ func (s *Session) Closed() bool {
 	return closed
 }
 
+// GetHosts returns a snapshot of the hosts in the ring along with their state,
+// NodeUp or NodeDown, as last set by the cluster events and the driver's own
+// connection attempts. The hosts are copies so they are not updated as the
+// ring changes, call GetHosts again for the current state.
+func (s *Session) GetHosts() []*HostInfo {
+	hosts := s.ring.allHosts()
+	for i, host := range hosts {
+		hosts[i] = host.clone()
+	}
+	return hosts
+}
+
+// FramerPoolStats returns the counters for the pool of framers used to read
+// event frames from the cluster.
+func (s *Session) FramerPoolStats() FramerPoolStats {
+	return s.framerPool.stats()
+}
+
+// NodeEventStats returns the counters for the buffer which node status and
+// topology events are debounced in, since the session was created or they
+// were last reset. The counters are reset if reset is true. The high-water
+// mark shows how close bursts of events come to filling the buffer before
+// they are dropped. They are zero if events are disabled.
+func (s *Session) NodeEventStats(reset bool) EventBufferStats {
+	if s.nodeEvents == nil {
+		return EventBufferStats{}
+	}
+	return s.nodeEvents.stats(reset)
+}
+
+// SchemaEventStats is like NodeEventStats for the buffer of schema events.
+func (s *Session) SchemaEventStats(reset bool) EventBufferStats {
+	if s.schemaEvents == nil {
+		return EventBufferStats{}
+	}
+	return s.schemaEvents.stats(reset)
+}
+
+// ThrottledRetries returns the number of retries which were skipped because
+// the RetryBudget of the session was exhausted.
+func (s *Session) ThrottledRetries() uint64 {
+	return s.executor.budget.throttledRetries()
+}
+
+// EncodePageState signs the paging state of an iterator with the
+// PageStateKey of the session so that it can be round-tripped through an
+// untrusted client, the returned bytes are passed back to DecodePageState to
+// recover the paging state. The state is signed but not encrypted.
+func (s *Session) EncodePageState(state []byte) ([]byte, error) {
+	if len(s.cfg.PageStateKey) == 0 {
+		return nil, ErrNoPageStateKey
+	}
+
+	mac := hmac.New(sha256.New, s.cfg.PageStateKey)
+	mac.Write(state)
+
+	encoded := make([]byte, 0, sha256.Size+len(state))
+	encoded = mac.Sum(encoded)
+	return append(encoded, state...), nil
+}
+
+// DecodePageState returns the paging state encoded by EncodePageState,
+// ErrInvalidPageState is returned if the encoded state was not signed with
+// the PageStateKey of the session or was modified since.
+func (s *Session) DecodePageState(encoded []byte) ([]byte, error) {
+	if len(s.cfg.PageStateKey) == 0 {
+		return nil, ErrNoPageStateKey
+	}
+	if len(encoded) < sha256.Size {
+		return nil, ErrInvalidPageState
+	}
+
+	sum, state := encoded[:sha256.Size], encoded[sha256.Size:]
+	mac := hmac.New(sha256.New, s.cfg.PageStateKey)
+	mac.Write(state)
+	if !hmac.Equal(sum, mac.Sum(nil)) {
+		return nil, ErrInvalidPageState
+	}
+
+	return append([]byte(nil), state...), nil
+}
+
+// TLSHandshakes returns the number of TLS handshakes made by the connections
+// of the session and how many of them resumed the TLS session of an earlier
+// connection instead of doing a full handshake.
+func (s *Session) TLSHandshakes() (handshakes, resumed uint64) {
+	return atomic.LoadUint64(&s.tlsHandshakes), atomic.LoadUint64(&s.tlsResumed)
+}
+
+// StreamExhaustions returns the number of requests which found all the
+// streams of their connection in use, limited by MaxRequestsPerConn, and so
+// either failed with ErrNoStreams or waited for a stream to be released.
+func (s *Session) StreamExhaustions() uint64 {
+	return atomic.LoadUint64(&s.streamExhaustions)
+}
+
+// keyspaceConsistencyLocked returns the default consistency for queries
+// against keyspace, s.mu must be held.
+func (s *Session) keyspaceConsistencyLocked(keyspace string) Consistency {
+	return s.keyspaceConsistency(keyspace, s.cons)
+}
+
+// keyspaceConsistency returns the default consistency for queries against
+// keyspace, or cons if the keyspace has none.
+func (s *Session) keyspaceConsistency(keyspace string, cons Consistency) Consistency {
+	if ksCons, ok := s.cfg.KeyspaceConsistency[keyspace]; ok {
+		return ksCons
+	}
+	return cons
+}
+
 func (s *Session) executeQuery(qry *Query) (it *Iter) {
 	// fail fast
 	if s.Closed() {
 		return &Iter{err: ErrSessionClosed}
 	}
 
+	if !qry.consSet {
+		// the default depends on the keyspace the query is executed against
+		qry.cons = s.keyspaceConsistency(qry.consistencyKeyspace(), qry.defaultCons)
+	}
+
+	if err := s.validateConsistency(qry, qry.cons, statementType(qry.stmt) == "select"); err != nil {
+		return &Iter{err: err}
+	}
+
+	if err := s.acquireRequest(qry.context); err != nil {
+		return &Iter{err: err}
+	}
+	defer s.releaseRequest()
+
 	iter, err := s.executor.executeQuery(qry)
 	if err != nil {
 		return &Iter{err: err}
@@ -402,6 +688,43 @@ func (s *Session) executeQuery(qry *Query) (it *Iter) {
 	return iter
 }
 
+// validateConsistency returns an error if the consistency cons of qry can
+// not be met as the session is configured or would be rejected by the
+// cluster, read is whether qry is a SELECT. Local consistencies are only met
+// by the replicas of the datacenter of the coordinator, so the host selection
+// policy must pick the hosts of a local datacenter, unless qry is pinned to a
+// host.
+func (s *Session) validateConsistency(qry ExecutableQuery, cons Consistency, read bool) error {
+	switch cons {
+	case LocalOne, LocalQuorum:
+		if s.cfg.RequireLocalDC && qry.pinnedHost() == "" && policyLocalDC(s.policy) == "" {
+			return ErrLocalConsistencyNoDC
+		}
+	case Consistency(Serial), Consistency(LocalSerial):
+		// serial reads see the result of conditional updates in progress
+		if !read {
+			return ErrSerialConsistency
+		}
+	}
+	return nil
+}
+
+// RefreshRing fetches the hosts in the cluster and updates the ring, adding
+// any new hosts and removing those which have left, before returning. It can be
+// used when the topology is known to have changed before the driver has been
+// notified by an event, and is safe to call concurrently with the refreshes
+// done in response to events. It does nothing if DisableInitialHostLookup is
+// set.
+func (s *Session) RefreshRing() error {
+	if s.Closed() {
+		return ErrSessionClosed
+	} else if s.control == nil {
+		return errNoControl
+	}
+
+	return s.hostSource.refreshRing()
+}
+
 func (s *Session) removeHost(h *HostInfo) {
 	s.policy.RemoveHost(h)
 	s.pool.removeHost(h.ConnectAddress())
@@ -587,6 +910,25 @@ func (s *Session) executeBatch(batch *Batch) *Iter {
 		return &Iter{err: ErrTooManyStmts}
 	}
 
+	if err := batch.validate(); err != nil {
+		return &Iter{err: err}
+	}
+
+	if !batch.consSet && batch.Cons == batch.defaultCons {
+		// the default depends on the keyspace of the statements
+		batch.Cons = s.keyspaceConsistency(batch.consistencyKeyspace(), batch.clusterCons)
+		batch.defaultCons = batch.Cons
+	}
+
+	if err := s.validateConsistency(batch, batch.Cons, false); err != nil {
+		return &Iter{err: err}
+	}
+
+	if err := s.acquireRequest(batch.context); err != nil {
+		return &Iter{err: err}
+	}
+	defer s.releaseRequest()
+
 	iter, err := s.executor.executeQuery(batch)
 	if err != nil {
 		return &Iter{err: err}
@@ -595,6 +937,65 @@ func (s *Session) executeBatch(batch *Batch) *Iter {
 	return iter
 }
 
+// acquireRequest tracks a request in flight until it is released with
+// releaseRequest, failing with ErrSessionClosed if the session is closed. It
+// takes one of the MaxConcurrentRequests slots for the request, if all are in
+// use it fails with ErrTooManyRequests when MaxConcurrentRequestsFailFast is
+// set and otherwise waits for one to be released or for ctx to be done.
+func (s *Session) acquireRequest(ctx context.Context) error {
+	s.closeMu.RLock()
+	if s.isClosed {
+		s.closeMu.RUnlock()
+		return ErrSessionClosed
+	}
+	s.inFlight.Add(1)
+	s.closeMu.RUnlock()
+
+	if err := s.acquireRequestSlot(ctx); err != nil {
+		s.inFlight.Done()
+		return err
+	}
+	return nil
+}
+
+func (s *Session) acquireRequestSlot(ctx context.Context) error {
+	if s.requests == nil {
+		return nil
+	}
+
+	select {
+	case s.requests <- struct{}{}:
+		return nil
+	default:
+	}
+
+	if s.cfg.MaxConcurrentRequestsFailFast {
+		return ErrTooManyRequests
+	}
+
+	var ctxDone <-chan struct{}
+	if ctx != nil {
+		ctxDone = ctx.Done()
+	}
+
+	select {
+	case s.requests <- struct{}{}:
+		return nil
+	case <-ctxDone:
+		return ctx.Err()
+	case <-s.quit:
+		return ErrSessionClosed
+	}
+}
+
+// releaseRequest releases a request acquired by acquireRequest.
+func (s *Session) releaseRequest() {
+	if s.requests != nil {
+		<-s.requests
+	}
+	s.inFlight.Done()
+}
+
 // ExecuteBatch executes a batch operation and returns nil if successful
 // otherwise an error is returned describing the failure.
 func (s *Session) ExecuteBatch(batch *Batch) error {
@@ -621,7 +1022,7 @@ func (s *Session) ExecuteBatchCAS(batch *Batch, dest ...interface{}) (applied bo
 		iter.Scan(&applied)
 	}
 
-	return applied, iter, nil
+	return applied, iter, iter.err
 }
 
 // MapExecuteBatchCAS executes a batch operation much like ExecuteBatchCAS,
@@ -643,19 +1044,61 @@ func (s *Session) MapExecuteBatchCAS(batch *Batch, dest map[string]interface{})
 	return applied, iter, iter.err
 }
 
-func (s *Session) connect(host *HostInfo, errorHandler ConnErrorHandler) (*Conn, error) {
+func (s *Session) connect(ctx context.Context, host *HostInfo, errorHandler ConnErrorHandler) (*Conn, error) {
+	var (
+		conn *Conn
+		err  error
+	)
 	if s.connectObserver != nil {
 		obs := ObservedConnect{
 			Host:  host,
 			Start: time.Now(),
 		}
-		conn, err := s.dial(host, s.connCfg, errorHandler)
+		conn, err = s.dial(ctx, host, s.connCfg, errorHandler)
 		obs.End = time.Now()
 		obs.Err = err
 		s.connectObserver.ObserveConnect(obs)
-		return conn, err
+	} else {
+		conn, err = s.dial(ctx, host, s.connCfg, errorHandler)
+	}
+
+	if err == nil && s.supported.Load() == nil {
+		s.supported.Store(conn.supported)
+	}
+	return conn, err
+}
+
+// SupportedOptions returns the startup options and their values supported by
+// the cluster, as reported in the SUPPORTED response to the OPTIONS request
+// sent on the control connection, or on the first connection made by the
+// session if the control connection is disabled. For example it includes the
+// CQL_VERSION, COMPRESSION and PROTOCOL_VERSIONS options.
+func (s *Session) SupportedOptions() map[string][]string {
+	supported, _ := s.supported.Load().(map[string][]string)
+
+	options := make(map[string][]string, len(supported))
+	for k, v := range supported {
+		options[k] = append([]string(nil), v...)
+	}
+	return options
+}
+
+// ServerVersion returns the lowest Cassandra release version of the hosts in
+// the ring, which is the version which features can be relied upon in a
+// cluster that is being upgraded. The version is zero if it is not known, which
+// is the case when the control connection and host lookup are disabled.
+func (s *Session) ServerVersion() cassVersion {
+	var lowest cassVersion
+	for _, host := range s.ring.allHosts() {
+		v := host.Version()
+		if v.Major == 0 {
+			continue
+		}
+		if lowest.Major == 0 || v.less(lowest) {
+			lowest = v
+		}
 	}
-	return s.dial(host, s.connCfg, errorHandler)
+	return lowest
 }
 
 // Query represents a CQL statement that can be executed.
@@ -678,18 +1121,31 @@ type Query struct {
 	serialCons            SerialConsistency
 	defaultTimestamp      bool
 	defaultTimestampValue int64
+	lastTimestamp         int64
 	disableSkipMetadata   bool
 	context               context.Context
 	idempotent            bool
+	timeout               time.Duration
+	keyspace              string
+	pageStateCallback     func(state []byte)
+	nowInSeconds          int
+	hostAddr              string
 
 	disableAutoPage bool
+
+	// consSet is whether the consistency was set on the query, otherwise the
+	// default of the keyspace of the query is used, or defaultCons if the
+	// keyspace has none
+	consSet     bool
+	defaultCons Consistency
 }
 
 func (q *Query) defaultsFromSession() {
 	s := q.session
 
 	s.mu.RLock()
-	q.cons = s.cons
+	q.cons = s.keyspaceConsistencyLocked(s.cfg.Keyspace)
+	q.defaultCons = s.cons
 	q.pageSize = s.pageSize
 	q.trace = s.trace
 	q.observer = s.queryObserver
@@ -726,9 +1182,13 @@ func (q *Query) Latency() int64 {
 
 // Consistency sets the consistency level for this query. If no consistency
 // level have been set, the default consistency level of the cluster
-// is used.
+// is used. With ClusterConfig.RequireLocalDC LOCAL_ONE and LOCAL_QUORUM fail
+// with ErrLocalConsistencyNoDC unless the host selection policy has a local
+// datacenter, SERIAL and LOCAL_SERIAL fail with ErrSerialConsistency unless
+// the query is a SELECT.
 func (q *Query) Consistency(c Consistency) *Query {
 	q.cons = c
+	q.consSet = true
 	return q
 }
 
@@ -741,6 +1201,7 @@ func (q *Query) GetConsistency() Consistency {
 // Same as Consistency but without a return value
 func (q *Query) SetConsistency(c Consistency) {
 	q.cons = c
+	q.consSet = true
 }
 
 // Trace enables tracing of this query. Look at the documentation of the
@@ -789,6 +1250,15 @@ func (q *Query) WithTimestamp(timestamp int64) *Query {
 	return q
 }
 
+// LastTimestamp returns the default timestamp, in microseconds, which was
+// sent with the last execution of the query, either the one set by
+// WithTimestamp or the one generated by the TimestampGenerator of the session.
+// Zero is returned if no timestamp was sent, as the default timestamp is
+// disabled or the protocol is older than version 3.
+func (q *Query) LastTimestamp() int64 {
+	return q.lastTimestamp
+}
+
 // RoutingKey sets the routing key to use when a token aware connection
 // pool is used to optimize the routing of this query.
 func (q *Query) RoutingKey(routingKey []byte) *Query {
@@ -796,6 +1266,24 @@ func (q *Query) RoutingKey(routingKey []byte) *Query {
 	return q
 }
 
+// SetRoutingKey sets the routing key of the query from the values of the
+// partition key columns, each marshalled as the type of its column, encoding
+// them as a composite key if there is more than one, see CompositeRoutingKey.
+// Routing keys are otherwise computed from the bound values of prepared
+// statements.
+func (q *Query) SetRoutingKey(components ...[]byte) *Query {
+	q.routingKey = CompositeRoutingKey(components...)
+	return q
+}
+
+// Timeout sets how long to wait for a response to this query, overriding the
+// Timeout of the ClusterConfig. If the context of the query has an earlier
+// deadline then that is used instead.
+func (q *Query) Timeout(timeout time.Duration) *Query {
+	q.timeout = timeout
+	return q
+}
+
 // WithContext will set the context to use during a query, it will be used to
 // timeout when waiting for responses from Cassandra.
 func (q *Query) WithContext(ctx context.Context) *Query {
@@ -812,33 +1300,144 @@ func (q *Query) attempt(keyspace string, end, start time.Time, iter *Iter, host
 	q.totalLatency += end.Sub(start).Nanoseconds()
 	// TODO: track latencies per host and things as well instead of just total
 
+	if q.session != nil && q.session.cfg.SlowQueryThreshold > 0 {
+		if latency := end.Sub(start); latency > q.session.cfg.SlowQueryThreshold {
+			logSlowQuery(q.stmt, q.values, host, latency)
+		}
+	}
+
 	if q.observer != nil {
+		var pageState []byte
+		if len(q.pageState) > 0 {
+			// the observer may keep the state, which is sent again if the
+			// query is retried
+			pageState = copyBytes(q.pageState)
+		}
 		q.observer.ObserveQuery(q.context, ObservedQuery{
 			Keyspace:  keyspace,
 			Statement: q.stmt,
 			Start:     start,
 			End:       end,
 			Rows:      iter.numRows,
+			PageState: pageState,
 			Host:      host,
 			Err:       iter.err,
 		})
 	}
 }
 
+// logSlowQuery logs a query attempt which took longer than the
+// SlowQueryThreshold. The bound values are redacted to their types as they may
+// hold sensitive data.
+func logSlowQuery(stmt string, values []interface{}, host *HostInfo, latency time.Duration) {
+	types := make([]string, len(values))
+	for i, v := range values {
+		types[i] = fmt.Sprintf("%T", v)
+	}
+
+	addr := "<nil>"
+	if host != nil {
+		addr = JoinHostPort(host.ConnectAddress().String(), host.Port())
+	}
+
+	Logger.Printf("gocql: slow query took %v on host %s: %q args=[%s]\n", latency, addr, stmt, strings.Join(types, ", "))
+}
+
 func (q *Query) retryPolicy() RetryPolicy {
 	return q.rt
 }
 
+// SetKeyspace sets the keyspace the query is executed against, instead of
+// the keyspace of the session. On protocol version 5 and above the keyspace is
+// sent with the query. Older versions can not send it, so the query is
+// executed on a connection to the host dedicated to the keyspace, which is
+// opened and switched to the keyspace with USE the first time it is needed.
+func (q *Query) SetKeyspace(keyspace string) *Query {
+	q.keyspace = keyspace
+	return q
+}
+
+// NowInSeconds sets the current time in seconds since the epoch which the
+// server uses for the query, to evaluate the expiry of TTLs. It requires
+// protocol version 5, which the driver does not support yet, so it is
+// currently a no-op and a warning is logged the first time a session is asked
+// to use it.
+func (q *Query) NowInSeconds(now int) *Query {
+	q.nowInSeconds = now
+	return q
+}
+
+// SetHost pins the query to the host at addr, an IP address optionally
+// followed by its port, bypassing the host selection policy. The query fails
+// with ErrHostDown if the host is down rather than being sent to another host,
+// which is useful for debugging and testing individual nodes.
+func (q *Query) SetHost(addr string) *Query {
+	q.hostAddr = addr
+	return q
+}
+
+func (q *Query) pinnedHost() string {
+	return q.hostAddr
+}
+
 // Keyspace returns the keyspace the query will be executed against.
 func (q *Query) Keyspace() string {
+	if q.keyspace != "" {
+		return q.keyspace
+	}
 	if q.session == nil {
 		return ""
 	}
-	// TODO(chbannis): this should be parsed from the query or we should let
-	// this be set by users.
+	// TODO(chbannis): this should be parsed from the query.
 	return q.session.cfg.Keyspace
 }
 
+// consistencyKeyspace returns the keyspace whose default consistency the
+// query uses, the keyspace set with SetKeyspace or which the table of the
+// statement is qualified with, otherwise that of the session.
+func (q *Query) consistencyKeyspace() string {
+	if q.keyspace != "" {
+		return q.keyspace
+	} else if ks := statementKeyspace(q.stmt); ks != "" {
+		return ks
+	}
+	return q.Keyspace()
+}
+
+// Prepared prepares the statement of the query, or gets it from the cache of
+// prepared statements, and returns its metadata as passed to the binding
+// function of Bind, such as to check the types of the values before binding
+// them. PKeyColumns are only returned by protocol version 4 and above.
+// Statements which can not be prepared, such as schema changes, return the
+// error of the server.
+func (q *Query) Prepared() (*QueryInfo, error) {
+	if q.session.Closed() {
+		return nil, ErrSessionClosed
+	}
+
+	conn := q.session.getConn()
+	if conn == nil {
+		return nil, ErrNoConnections
+	}
+
+	conn, err := conn.keyspaceConn(q.keyspace)
+	if err != nil {
+		return nil, err
+	}
+	info, err := conn.prepareStatementKeyspace(q.context, conn.queryKeyspace(q.keyspace), q.stmt, q.trace)
+	if err != nil {
+		return nil, err
+	}
+
+	// the metadata is shared by every query of the statement
+	return &QueryInfo{
+		Id:          copyBytes(info.id),
+		Args:        append([]ColumnInfo(nil), info.request.columns...),
+		Rval:        append([]ColumnInfo(nil), info.response.columns...),
+		PKeyColumns: append([]int(nil), info.request.pkeyColumns...),
+	}, nil
+}
+
 // GetRoutingKey gets the routing key to use for routing this query. If
 // a routing key has not been explicitly set, then the routing key will
 // be constructed if possible using the keyspace's schema and the query
@@ -865,11 +1464,32 @@ func (q *Query) GetRoutingKey() ([]byte, error) {
 		return nil, nil
 	}
 
+	// We allocate that buffer only once, so that further re-bind/exec of the
+	// same query don't allocate more memory.
+	if q.routingKeyBuffer == nil && len(routingKeyInfo.indexes) > 1 {
+		q.routingKeyBuffer = make([]byte, 0, 256)
+	}
+
+	return createRoutingKey(routingKeyInfo, q.values, q.routingKeyBuffer)
+}
+
+// createRoutingKey marshals the partition key columns of values described by
+// routingKeyInfo into a routing key. Composite partition keys are encoded as
+// Cassandra's CompositeType into buf. If a partition key column was not bound
+// no routing key is returned and the query should be routed by the fallback
+// policy.
+func createRoutingKey(routingKeyInfo *routingKeyInfo, values []interface{}, buf []byte) ([]byte, error) {
+	for _, index := range routingKeyInfo.indexes {
+		if index >= len(values) {
+			return nil, nil
+		}
+	}
+
 	if len(routingKeyInfo.indexes) == 1 {
 		// single column routing key
 		routingKey, err := Marshal(
 			routingKeyInfo.types[0],
-			q.values[routingKeyInfo.indexes[0]],
+			values[routingKeyInfo.indexes[0]],
 		)
 		if err != nil {
 			return nil, err
@@ -877,35 +1497,58 @@ func (q *Query) GetRoutingKey() ([]byte, error) {
 		return routingKey, nil
 	}
 
-	// We allocate that buffer only once, so that further re-bind/exec of the
-	// same query don't allocate more memory.
-	if q.routingKeyBuffer == nil {
-		q.routingKeyBuffer = make([]byte, 0, 256)
-	}
-
 	// composite routing key
-	buf := bytes.NewBuffer(q.routingKeyBuffer)
 	for i := range routingKeyInfo.indexes {
 		encoded, err := Marshal(
 			routingKeyInfo.types[i],
-			q.values[routingKeyInfo.indexes[i]],
+			values[routingKeyInfo.indexes[i]],
 		)
 		if err != nil {
 			return nil, err
 		}
-		lenBuf := []byte{0x00, 0x00}
-		binary.BigEndian.PutUint16(lenBuf, uint16(len(encoded)))
-		buf.Write(lenBuf)
-		buf.Write(encoded)
-		buf.WriteByte(0x00)
+		buf = appendCompositeComponent(buf, encoded)
+	}
+	return buf, nil
+}
+
+// CompositeRoutingKey returns the routing key of a partition key from the
+// values of its columns, each marshalled as the type of its column with
+// Marshal. The columns of a composite partition key are encoded as Cassandra's
+// CompositeType, each prefixed by its length and followed by a zero byte, and
+// the value of a single column is the routing key itself.
+func CompositeRoutingKey(components ...[]byte) []byte {
+	if len(components) == 1 {
+		return components[0]
+	}
+
+	var buf []byte
+	for _, component := range components {
+		buf = appendCompositeComponent(buf, component)
 	}
-	routingKey := buf.Bytes()
-	return routingKey, nil
+	return buf
+}
+
+// appendCompositeComponent appends a component of a CompositeType value to buf.
+func appendCompositeComponent(buf, component []byte) []byte {
+	var lenBuf [2]byte
+	binary.BigEndian.PutUint16(lenBuf[:], uint16(len(component)))
+	buf = append(buf, lenBuf[:]...)
+	buf = append(buf, component...)
+	return append(buf, 0x00)
 }
 
 func (q *Query) shouldPrepare() bool {
+	switch statementType(q.stmt) {
+	case "select", "insert", "update", "delete", "batch":
+		return true
+	}
+	return false
+}
 
-	stmt := strings.TrimLeftFunc(strings.TrimRightFunc(q.stmt, func(r rune) bool {
+// statementType returns the lower cased leading keyword of a CQL statement,
+// BEGIN ... APPLY BATCH statements are reported as "batch".
+func statementType(stmt string) string {
+	stmt = strings.TrimLeftFunc(strings.TrimRightFunc(stmt, func(r rune) bool {
 		return unicode.IsSpace(r) || r == ';'
 	}), unicode.IsSpace)
 
@@ -918,11 +1561,7 @@ func (q *Query) shouldPrepare() bool {
 			stmtType = strings.ToLower(stmt[n+1:])
 		}
 	}
-	switch stmtType {
-	case "select", "insert", "update", "delete", "batch":
-		return true
-	}
-	return false
+	return stmtType
 }
 
 // SetPrefetch sets the default threshold for pre-fetching new pages. If
@@ -957,11 +1596,57 @@ func (q *Query) Bind(v ...interface{}) *Query {
 	return q
 }
 
+// BindMap binds the named markers of the statement, such as :id, to values
+// taken from v which must be either a map[string]interface{} or a struct (or
+// pointer to a struct). Struct fields are matched by their cql tag or
+// otherwise their name, ignoring case. The statement is prepared to find the
+// order of the markers and executing the query fails if any marker has no
+// value.
+func (q *Query) BindMap(v interface{}) *Query {
+	q.values = nil
+	q.binding = bindNamed(v)
+	return q
+}
+
+func bindNamed(v interface{}) func(q *QueryInfo) ([]interface{}, error) {
+	return func(q *QueryInfo) ([]interface{}, error) {
+		values := make([]interface{}, len(q.Args))
+
+		switch m := v.(type) {
+		case map[string]interface{}:
+			for i, col := range q.Args {
+				val, ok := m[col.Name]
+				if !ok {
+					return nil, fmt.Errorf("gocql: no value bound for named marker %q", col.Name)
+				}
+				values[i] = val
+			}
+			return values, nil
+		}
+
+		rv := reflect.Indirect(reflect.ValueOf(v))
+		if rv.Kind() != reflect.Struct {
+			return nil, fmt.Errorf("gocql: can not bind named markers from %T, expected a map[string]interface{} or struct", v)
+		}
+
+		for i, col := range q.Args {
+			field, ok := fieldByColumn(rv, col.Name)
+			if !ok {
+				return nil, fmt.Errorf("gocql: no value bound for named marker %q", col.Name)
+			}
+			values[i] = field.Interface()
+		}
+
+		return values, nil
+	}
+}
+
 // SerialConsistency sets the consistency level for the
 // serial phase of conditional updates. That consistency can only be
 // either SERIAL or LOCAL_SERIAL and if not present, it defaults to
 // SERIAL. This option will be ignored for anything else that a
-// conditional update/insert.
+// conditional update/insert. It is sent independently of the consistency set
+// with Consistency, which applies to the commit phase.
 func (q *Query) SerialConsistency(cons SerialConsistency) *Query {
 	q.serialCons = cons
 	return q
@@ -976,6 +1661,24 @@ func (q *Query) PageState(state []byte) *Query {
 	return q
 }
 
+// NoAutoPaging stops the iterator of the query at the end of the first page
+// instead of fetching the following pages, the number of rows of the page is
+// set by PageSize. Iter.PageState returns the paging state to fetch the next
+// page with, which is empty if there are no more rows.
+func (q *Query) NoAutoPaging() *Query {
+	q.disableAutoPage = true
+	return q
+}
+
+// PageStateCallback sets a function which is called with the paging state of
+// the next page each time the iterator has consumed a page and moves on to the
+// next, so that consumers of large results can checkpoint and later resume
+// from the state with PageState.
+func (q *Query) PageStateCallback(fn func(state []byte)) *Query {
+	q.pageStateCallback = fn
+	return q
+}
+
 // NoSkipMetadata will override the internal result metadata cache so that the driver does not
 // send skip_metadata for queries, this means that the result will always contain
 // the metadata to parse the rows and will not reuse the metadata from the prepared
@@ -1035,6 +1738,13 @@ func (q *Query) Scan(dest ...interface{}) error {
 	return iter.Close()
 }
 
+// ScanAll executes the query and appends every selected row, from all pages,
+// to the slice of structs or pointers to structs pointed at by dst, see
+// Iter.ScanAll.
+func (q *Query) ScanAll(dst interface{}) error {
+	return q.Iter().ScanAll(dst)
+}
+
 // ScanCAS executes a lightweight transaction (i.e. an UPDATE or INSERT
 // statement containing an IF clause). If the transaction fails because
 // the existing values did not match, the previous values will be stored
@@ -1105,6 +1815,11 @@ type Iter struct {
 
 	framer *framer
 	closed int32
+
+	skipUnmapped bool
+
+	// the last error from each host the query failed on, see Error
+	hostErrors []HostError
 }
 
 // Host returns the host which the query was sent to.
@@ -1150,7 +1865,17 @@ func (is *iterScanner) Next() bool {
 
 	if iter.pos >= iter.numRows {
 		if iter.next != nil {
-			is.iter = iter.next.fetch()
+			if fn := iter.next.qry.pageStateCallback; fn != nil {
+				fn(copyBytes(iter.next.qry.pageState))
+			}
+			next := iter.next.fetch()
+			if next.err != nil {
+				iter.err = next.err
+				iter.hostErrors = next.hostErrors
+				iter.next = nil
+				return false
+			}
+			is.iter = next
 			return is.Next()
 		}
 		return false
@@ -1171,10 +1896,6 @@ func (is *iterScanner) Next() bool {
 }
 
 func scanColumn(p []byte, col ColumnInfo, dest []interface{}) (int, error) {
-	if dest[0] == nil {
-		return 1, nil
-	}
-
 	if col.TypeInfo.Type() == TypeTuple {
 		// this will panic, actually a bug, please report
 		tuple := col.TypeInfo.(TupleTypeInfo)
@@ -1186,6 +1907,8 @@ func scanColumn(p []byte, col ColumnInfo, dest []interface{}) (int, error) {
 			return 0, err
 		}
 		return count, nil
+	} else if dest[0] == nil {
+		return 1, nil
 	} else {
 		if err := Unmarshal(col.TypeInfo, p, dest[0]); err != nil {
 			return 0, err
@@ -1211,6 +1934,15 @@ func (is *iterScanner) Scan(dest ...interface{}) error {
 	i := 0
 	var err error
 	for _, col := range iter.meta.columns {
+		if blob, ok := dest[i].(*BlobReader); ok {
+			// Next has moved the iterator past the row
+			if err = blob.reset(is, iter.framer, iter.pos-1, col, is.cols[i]); err != nil {
+				break
+			}
+			i++
+			continue
+		}
+
 		var n int
 		n, err = scanColumn(is.cols[i], col, dest[i:])
 		if err != nil {
@@ -1223,6 +1955,13 @@ func (is *iterScanner) Scan(dest ...interface{}) error {
 	return err
 }
 
+func (is *iterScanner) currentRow() (*framer, int) {
+	if is.iter == nil {
+		return nil, -1
+	}
+	return is.iter.currentRow()
+}
+
 func (is *iterScanner) Err() error {
 	iter := is.iter
 	is.iter = nil
@@ -1250,9 +1989,18 @@ func (iter *Iter) readColumn() ([]byte, error) {
 // to skip the corresponding column. Scan might send additional queries
 // to the database to retrieve the next set of rows if paging was enabled.
 //
+// Null columns are scanned as the zero value, to tell null apart from zero
+// scan into a pointer to a pointer such as **int64, which is set to nil, or
+// into a sql.Scanner such as sql.NullString.
+//
 // Scan returns true if the row was successfully unmarshaled or false if the
 // end of the result set was reached or if an error occurred. Close should
 // be called afterwards to retrieve any potential errors.
+//
+// If fetching the next page fails, the rows scanned before are those of the
+// pages already fetched and dest is left untouched. The iterator stays on the
+// last page, so PageState returns the paging state of the page which could not
+// be fetched for the results to be resumed from it.
 func (iter *Iter) Scan(dest ...interface{}) bool {
 	if iter.err != nil {
 		return false
@@ -1260,13 +2008,25 @@ func (iter *Iter) Scan(dest ...interface{}) bool {
 
 	if iter.pos >= iter.numRows {
 		if iter.next != nil {
-			*iter = *iter.next.fetch()
+			if fn := iter.next.qry.pageStateCallback; fn != nil {
+				fn(copyBytes(iter.next.qry.pageState))
+			}
+			next := iter.next.fetch()
+			if next.err != nil {
+				iter.err = next.err
+				iter.hostErrors = next.hostErrors
+				iter.next = nil
+				return false
+			}
+			*iter = *next
 			return iter.Scan(dest...)
 		}
 		return false
 	}
 
 	if iter.next != nil && iter.pos == iter.next.pos {
+		// marked as started before the fetch so that Close waits for it
+		atomic.StoreInt32(&iter.next.started, 1)
 		go iter.next.fetch()
 	}
 
@@ -1287,6 +2047,15 @@ func (iter *Iter) Scan(dest ...interface{}) bool {
 			return false
 		}
 
+		if blob, ok := dest[i].(*BlobReader); ok {
+			if err := blob.reset(iter, iter.framer, iter.pos, col, colBytes); err != nil {
+				iter.err = err
+				return false
+			}
+			i++
+			continue
+		}
+
 		n, err := scanColumn(colBytes, col, dest[i:])
 		if err != nil {
 			iter.err = err
@@ -1299,6 +2068,113 @@ func (iter *Iter) Scan(dest ...interface{}) bool {
 	return true
 }
 
+// BlobReader is a destination for Iter.Scan and Scanner.Scan which reads a
+// blob column through an io.Reader instead of unmarshaling it into a []byte,
+// so that a large blob can be written, such as to a file, without the extra
+// copy of it which unmarshaling makes for each row. A BlobReader can be reused
+// for every row.
+//
+// The blob is not streamed from the connection: the page it is in is received
+// and buffered whole like any other, and the reader reads from the buffer of
+// the page. So the reader must be consumed before the iterator advances: once
+// it moves to the next row, or is closed, Read fails with
+// ErrBlobReaderAdvanced.
+//
+//     var blob gocql.BlobReader
+//     for iter.Scan(&id, &blob) {
+//         if _, err := io.Copy(file, &blob); err != nil {
+//             return err
+//         }
+//     }
+type BlobReader struct {
+	src blobSource
+	// framer and row of the iterator the blob was scanned from
+	framer *framer
+	row    int
+
+	r    bytes.Reader
+	null bool
+}
+
+// blobSource is an iterator which BlobReaders are scanned from.
+type blobSource interface {
+	// currentRow returns the framer and the index of the row last read.
+	currentRow() (*framer, int)
+}
+
+// reset points the reader at the blob data of row in the page of framer.
+func (b *BlobReader) reset(src blobSource, framer *framer, row int, col ColumnInfo, data []byte) error {
+	if col.TypeInfo.Type() != TypeBlob {
+		return fmt.Errorf("gocql: can not scan %s column %q into *BlobReader", col.TypeInfo.Type(), col.Name)
+	}
+
+	b.src = src
+	b.framer = framer
+	b.row = row
+	b.null = data == nil
+	b.r.Reset(data)
+	return nil
+}
+
+// Read reads the blob, it returns io.EOF once the blob has been read and
+// ErrBlobReaderAdvanced if the iterator has advanced past its row.
+func (b *BlobReader) Read(p []byte) (int, error) {
+	if b.src == nil {
+		return 0, io.EOF
+	} else if framer, row := b.src.currentRow(); framer != b.framer || row != b.row {
+		return 0, ErrBlobReaderAdvanced
+	}
+	return b.r.Read(p)
+}
+
+// Len returns the number of bytes of the blob which have not been read yet.
+func (b *BlobReader) Len() int {
+	return b.r.Len()
+}
+
+// IsNull returns whether the blob column was null, which reads like an empty
+// blob.
+func (b *BlobReader) IsNull() bool {
+	return b.null
+}
+
+func (iter *Iter) currentRow() (*framer, int) {
+	return iter.framer, iter.pos - 1
+}
+
+// Applied returns whether the conditional statement of a lightweight
+// transaction was applied, from the [applied] column of the next row. The row
+// is not consumed, so that it can still be scanned, such as for the values
+// which conflicted with the condition when it was not applied.
+func (iter *Iter) Applied() (bool, error) {
+	if iter.err != nil {
+		return false, iter.err
+	} else if iter.pos >= iter.numRows {
+		return false, ErrNotFound
+	} else if iter.framer == nil {
+		// the rows are released when the iterator is closed
+		return false, errors.New("gocql: Applied called on a closed iterator")
+	}
+
+	if len(iter.meta.columns) == 0 || iter.meta.columns[0].Name != "[applied]" {
+		return false, errors.New("gocql: the result has no [applied] column")
+	}
+
+	// [applied] is the first column, read it without moving past it
+	rbuf := iter.framer.rbuf
+	colBytes, err := iter.readColumn()
+	iter.framer.rbuf = rbuf
+	if err != nil {
+		return false, err
+	}
+
+	var applied bool
+	if err := Unmarshal(iter.meta.columns[0].TypeInfo, colBytes, &applied); err != nil {
+		return false, err
+	}
+	return applied, nil
+}
+
 // GetCustomPayload returns any parsed custom payload results if given in the
 // response from Cassandra. Note that the result is not a copy.
 //
@@ -1321,17 +2197,38 @@ func (iter *Iter) Warnings() []string {
 }
 
 // Close closes the iterator and returns any errors that happened during
-// the query or the iteration.
+// the query or the iteration. This includes the error of fetching the next
+// page in the background, once it has been prefetched, even if the iteration
+// stopped before reaching that page.
 func (iter *Iter) Close() error {
 	if atomic.CompareAndSwapInt32(&iter.closed, 0, 1) {
 		if iter.framer != nil {
 			iter.framer = nil
 		}
+
+		if iter.err == nil && iter.next != nil && atomic.LoadInt32(&iter.next.started) == 1 {
+			// wait for the prefetch which was started, but do not start one
+			if next := iter.next.fetch(); next.err != nil {
+				iter.err = next.err
+				iter.hostErrors = next.hostErrors
+			}
+		}
 	}
 
 	return iter.err
 }
 
+// Error returns the error of the iterator without closing it. If the query
+// failed after being attempted on one or more hosts the error is an
+// *AttemptsError with the last error from each of the hosts tried, while Close
+// only returns the final error.
+func (iter *Iter) Error() error {
+	if iter.err == nil || len(iter.hostErrors) == 0 {
+		return iter.err
+	}
+	return &AttemptsError{Hosts: iter.hostErrors, Err: iter.err}
+}
+
 // WillSwitchPage detects if iterator reached end of current page
 // and the next page is available.
 func (iter *Iter) WillSwitchPage() bool {
@@ -1367,11 +2264,21 @@ type nextIter struct {
 	once sync.Once
 	next *Iter
 	conn *Conn
+
+	// whether the page has been fetched or is being fetched, accessed
+	// atomically
+	started int32
 }
 
 func (n *nextIter) fetch() *Iter {
+	atomic.StoreInt32(&n.started, 1)
 	n.once.Do(func() {
+		if err := n.qry.session.acquireRequest(n.qry.context); err != nil {
+			n.next = &Iter{err: err}
+			return
+		}
 		iter := n.qry.session.executor.attemptQuery(&n.qry, n.conn)
+		n.qry.session.releaseRequest()
 		if iter != nil && iter.err == nil {
 			n.next = iter
 		} else {
@@ -1394,6 +2301,14 @@ type Batch struct {
 	defaultTimestampValue int64
 	context               context.Context
 	keyspace              string
+
+	// defaultCons is the consistency the batch was created with, a batch
+	// whose Cons is left at it and not set with SetConsistency, consSet, uses
+	// the default of the keyspace of its statements, or clusterCons if the
+	// keyspace has none
+	consSet     bool
+	defaultCons Consistency
+	clusterCons Consistency
 }
 
 // NewBatch creates a new batch operation without defaults from the cluster
@@ -1411,10 +2326,12 @@ func (s *Session) NewBatch(typ BatchType) *Batch {
 		rt:               s.cfg.RetryPolicy,
 		serialCons:       s.cfg.SerialConsistency,
 		observer:         s.batchObserver,
-		Cons:             s.cons,
+		Cons:             s.keyspaceConsistencyLocked(s.cfg.Keyspace),
 		defaultTimestamp: s.cfg.DefaultTimestamp,
 		keyspace:         s.cfg.Keyspace,
+		clusterCons:      s.cons,
 	}
+	batch.defaultCons = batch.Cons
 	s.mu.RUnlock()
 	return batch
 }
@@ -1430,6 +2347,24 @@ func (b *Batch) Keyspace() string {
 	return b.keyspace
 }
 
+// consistencyKeyspace returns the keyspace whose default consistency the
+// batch uses, the keyspace every statement is qualified with if they all are
+// qualified with the same one.
+func (b *Batch) consistencyKeyspace() string {
+	var keyspace string
+	for i, entry := range b.Entries {
+		ks := statementKeyspace(entry.Stmt)
+		if ks == "" || (i > 0 && ks != keyspace) {
+			return b.keyspace
+		}
+		keyspace = ks
+	}
+	if keyspace == "" {
+		return b.keyspace
+	}
+	return keyspace
+}
+
 // Attempts returns the number of attempts made to execute the batch.
 func (b *Batch) Attempts() int {
 	return b.attempts
@@ -1453,9 +2388,13 @@ func (b *Batch) GetConsistency() Consistency {
 // operation.
 func (b *Batch) SetConsistency(c Consistency) {
 	b.Cons = c
+	b.consSet = true
 }
 
-// Query adds the query to the batch operation
+// Query adds the query to the batch operation. Queries with arguments are sent
+// as prepared statements, each unique statement is prepared once and its id is
+// reused by every entry of the batch. Queries without arguments are sent as
+// simple statements.
 func (b *Batch) Query(stmt string, args ...interface{}) {
 	b.Entries = append(b.Entries, BatchEntry{Stmt: stmt, Args: args})
 }
@@ -1471,6 +2410,10 @@ func (b *Batch) retryPolicy() RetryPolicy {
 	return b.rt
 }
 
+func (b *Batch) pinnedHost() string {
+	return ""
+}
+
 // RetryPolicy sets the retry policy to use when executing the batch operation
 func (b *Batch) RetryPolicy(r RetryPolicy) *Batch {
 	b.rt = r
@@ -1489,6 +2432,30 @@ func (b *Batch) Size() int {
 	return len(b.Entries)
 }
 
+// validate rejects batches which Cassandra would obviously refuse to execute,
+// only modification statements can be batched and counters can not be
+// inserted. Anything else is left for Cassandra to validate.
+func (b *Batch) validate() error {
+	switch b.Type {
+	case LoggedBatch, UnloggedBatch, CounterBatch:
+	default:
+		return ErrInvalidBatchType
+	}
+
+	for _, entry := range b.Entries {
+		switch statementType(entry.Stmt) {
+		case "insert":
+			if b.Type == CounterBatch {
+				return ErrCounterBatchStmt
+			}
+		case "select", "batch", "use", "truncate", "create", "alter", "drop":
+			return fmt.Errorf("gocql: statement can not be used in a batch: %q", entry.Stmt)
+		}
+	}
+
+	return nil
+}
+
 // SerialConsistency sets the consistency level for the
 // serial phase of conditional updates. That consistency can only be
 // either SERIAL or LOCAL_SERIAL and if not present, it defaults to
@@ -1501,10 +2468,11 @@ func (b *Batch) SerialConsistency(cons SerialConsistency) *Batch {
 	return b
 }
 
-// DefaultTimestamp will enable the with default timestamp flag on the query.
+// DefaultTimestamp will enable the with default timestamp flag on the batch.
 // If enable, this will replace the server side assigned
-// timestamp as default timestamp. Note that a timestamp in the query itself
-// will still override this timestamp. This is entirely optional.
+// timestamp as default timestamp, shared by all the statements of the batch.
+// Note that a timestamp in a statement itself will still override this
+// timestamp. This is entirely optional.
 //
 // Only available on protocol >= 3
 func (b *Batch) DefaultTimestamp(enable bool) *Batch {
@@ -1512,10 +2480,11 @@ func (b *Batch) DefaultTimestamp(enable bool) *Batch {
 	return b
 }
 
-// WithTimestamp will enable the with default timestamp flag on the query
+// WithTimestamp will enable the with default timestamp flag on the batch
 // like DefaultTimestamp does. But also allows to define value for timestamp.
-// It works the same way as USING TIMESTAMP in the query itself, but
-// should not break prepared query optimization
+// It works the same way as USING TIMESTAMP in the statements themselves, but
+// should not break prepared query optimization. Replaying the batch with the
+// same timestamp applies its writes in the same order.
 //
 // Only available on protocol >= 3
 func (b *Batch) WithTimestamp(timestamp int64) *Batch {
@@ -1544,8 +2513,9 @@ func (b *Batch) attempt(keyspace string, end, start time.Time, iter *Iter, host
 		Start:      start,
 		End:        end,
 		// Rows not used in batch observations // TODO - might be able to support it when using BatchCAS
-		Host: host,
-		Err:  iter.err,
+		Host:    host,
+		Err:     iter.err,
+		Attempt: b.attempts,
 	})
 }
 
@@ -1694,6 +2664,11 @@ type ObservedQuery struct {
 	// Rows is not used in batch queries and remains at the default value
 	Rows int
 
+	// PageState is a copy of the paging state the query was sent with, which
+	// is empty for the first page, so that observers can tell the pages of a
+	// paginated query apart.
+	PageState []byte
+
 	// Host is the informations about the host that performed the query
 	Host *HostInfo
 
@@ -1722,6 +2697,10 @@ type ObservedBatch struct {
 	// Host is the informations about the host that performed the batch
 	Host *HostInfo
 
+	// Attempt is the number of this attempt of the batch, starting from 1 and
+	// incremented on each retry.
+	Attempt int
+
 	// Err is the error in the batch query.
 	// It only tracks network errors or errors of bad cassandra syntax, in particular selects with no match return nil error
 	Err error
@@ -1730,7 +2709,7 @@ type ObservedBatch struct {
 // BatchObserver is the interface implemented by batch observers / stat collectors.
 type BatchObserver interface {
 	// ObserveBatch gets called on every batch query to cassandra.
-	// It also gets called once for each query in a batch.
+	// It gets called once for each attempt of the batch, including retries.
 	// It doesn't get called if there is no query because the session is closed or there are no connections available.
 	// The error reported only shows query errors, i.e. if a SELECT is valid but finds no matches it will be nil.
 	// Unlike QueryObserver.ObserveQuery it does no reporting on rows read.
@@ -1770,10 +2749,21 @@ var (
 	ErrTooManyStmts         = errors.New("too many statements")
 	ErrUseStmt              = errors.New("use statements aren't supported. Please see https://github.com/gocql/gocql for explanation.")
 	ErrSessionClosed        = errors.New("session has been closed")
+	ErrCloseTimeout         = errors.New("gocql: timed out waiting for requests in flight while closing the session")
 	ErrNoConnections        = errors.New("gocql: no hosts available in the pool")
 	ErrNoKeyspace           = errors.New("no keyspace provided")
 	ErrKeyspaceDoesNotExist = errors.New("keyspace does not exist")
 	ErrNoMetadata           = errors.New("no metadata available")
+	ErrInvalidBatchType     = errors.New("gocql: invalid batch type")
+	ErrCounterBatchStmt     = errors.New("gocql: counter batches can only contain counter updates")
+	ErrHostNotFound         = errors.New("gocql: the host the query is pinned to is not in the ring")
+	ErrHostDown             = errors.New("gocql: the host the query is pinned to is down")
+	ErrTooManyRequests      = errors.New("gocql: too many concurrent requests")
+	ErrBlobReaderAdvanced   = errors.New("gocql: blob read after the iterator advanced past its row")
+	ErrNoPageStateKey       = errors.New("gocql: no PageStateKey configured to encode paging states")
+	ErrInvalidPageState     = errors.New("gocql: paging state was tampered with or signed with another key")
+	ErrLocalConsistencyNoDC = errors.New("gocql: LOCAL_ONE and LOCAL_QUORUM consistency require a host selection policy with a local datacenter")
+	ErrSerialConsistency    = errors.New("gocql: SERIAL and LOCAL_SERIAL consistency can only be used to read")
 )
 
 type ErrProtocol struct{ error }
@@ -3,8 +3,10 @@
 package gocql
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"reflect"
 	"testing"
 )
 
@@ -90,12 +92,6 @@ func TestSessionAPI(t *testing.T) {
 	}
 }
 
-type funcQueryObserver func(context.Context, ObservedQuery)
-
-func (f funcQueryObserver) ObserveQuery(ctx context.Context, o ObservedQuery) {
-	f(ctx, o)
-}
-
 func TestQueryBasicAPI(t *testing.T) {
 	qry := &Query{}
 
@@ -250,6 +246,41 @@ func TestBatchBasicAPI(t *testing.T) {
 
 }
 
+func TestBatchValidate(t *testing.T) {
+	tests := []struct {
+		typ   BatchType
+		stmts []string
+		err   bool
+	}{
+		{LoggedBatch, []string{"INSERT INTO t (id) VALUES (?)", "DELETE FROM t WHERE id = ?"}, false},
+		{UnloggedBatch, []string{"UPDATE t SET v = ? WHERE id = ?"}, false},
+		{CounterBatch, []string{"UPDATE c SET n = n + 1 WHERE id = ?", "update c set n = n - 1 where id = ?"}, false},
+		{CounterBatch, []string{"UPDATE c SET n = n + 1 WHERE id = ?", "INSERT INTO t (id) VALUES (?)"}, true},
+		{LoggedBatch, []string{"SELECT * FROM t"}, true},
+		{UnloggedBatch, []string{"TRUNCATE t"}, true},
+		{BatchType(3), []string{"INSERT INTO t (id) VALUES (?)"}, true},
+	}
+
+	for i, test := range tests {
+		b := NewBatch(test.typ)
+		for _, stmt := range test.stmts {
+			b.Query(stmt)
+		}
+
+		if err := b.validate(); test.err && err == nil {
+			t.Errorf("%d: expected error validating %v batch %q", i, test.typ, test.stmts)
+		} else if !test.err && err != nil {
+			t.Errorf("%d: unexpected error validating %v batch %q: %v", i, test.typ, test.stmts, err)
+		}
+	}
+
+	b := NewBatch(CounterBatch)
+	b.Query("INSERT INTO t (id) VALUES (?)")
+	if err := b.validate(); err != ErrCounterBatchStmt {
+		t.Errorf("expected %v got %v", ErrCounterBatchStmt, err)
+	}
+}
+
 func TestConsistencyNames(t *testing.T) {
 	names := map[fmt.Stringer]string{
 		Any:         "ANY",
@@ -298,3 +329,180 @@ func TestIsUseStatement(t *testing.T) {
 		}
 	}
 }
+
+func TestCreateRoutingKeyComposite(t *testing.T) {
+	// partition key (id int, name text) bound as the 3rd and 1st markers of
+	// UPDATE t SET v = ? WHERE name = ? AND id = ?
+	info := &routingKeyInfo{
+		indexes: []int{2, 1},
+		types: []TypeInfo{
+			NativeType{proto: 4, typ: TypeInt},
+			NativeType{proto: 4, typ: TypeVarchar},
+		},
+	}
+
+	key, err := createRoutingKey(info, []interface{}{"value", "bob", 7}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := []byte{
+		0x00, 0x04, 0x00, 0x00, 0x00, 0x07, 0x00,
+		0x00, 0x03, 'b', 'o', 'b', 0x00,
+	}
+	if !bytes.Equal(key, exp) {
+		t.Fatalf("expected routing key % X got % X", exp, key)
+	}
+
+	// not all of the partition key is bound
+	key, err = createRoutingKey(info, []interface{}{"value", "bob"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	} else if key != nil {
+		t.Fatalf("expected no routing key got % X", key)
+	}
+
+	if _, err := createRoutingKey(info, []interface{}{"value", "bob", "not an int"}, nil); err == nil {
+		t.Fatal("expected error marshalling invalid partition key value")
+	}
+}
+
+func TestQuerySetRoutingKey(t *testing.T) {
+	// partition key (id int, name text)
+	id, err := Marshal(NativeType{proto: 4, typ: TypeInt}, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, err := Marshal(NativeType{proto: 4, typ: TypeVarchar}, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := (&Query{}).SetRoutingKey(id, name).GetRoutingKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the same key as computed from the bound values of a prepared statement
+	exp := []byte{
+		0x00, 0x04, 0x00, 0x00, 0x00, 0x07, 0x00,
+		0x00, 0x03, 'b', 'o', 'b', 0x00,
+	}
+	if !bytes.Equal(key, exp) {
+		t.Fatalf("expected routing key % X got % X", exp, key)
+	}
+
+	if key := CompositeRoutingKey(id); !bytes.Equal(key, id) {
+		t.Fatalf("expected a single column to be the routing key % X got % X", id, key)
+	}
+}
+
+func TestCreateRoutingKeySingle(t *testing.T) {
+	info := &routingKeyInfo{
+		indexes: []int{0},
+		types:   []TypeInfo{NativeType{proto: 4, typ: TypeInt}},
+	}
+
+	key, err := createRoutingKey(info, []interface{}{1}, nil)
+	if err != nil {
+		t.Fatal(err)
+	} else if exp := []byte{0, 0, 0, 1}; !bytes.Equal(key, exp) {
+		t.Fatalf("expected routing key % X got % X", exp, key)
+	}
+}
+
+func TestQueryBindMap(t *testing.T) {
+	// SELECT * FROM users WHERE id = :id AND name = :name
+	info := &QueryInfo{
+		Args: []ColumnInfo{
+			{Name: "id", TypeInfo: NativeType{proto: 4, typ: TypeInt}},
+			{Name: "name", TypeInfo: NativeType{proto: 4, typ: TypeVarchar}},
+		},
+	}
+
+	type user struct {
+		Name   string
+		UserID int `cql:"id"`
+		email  string
+	}
+
+	tests := []interface{}{
+		map[string]interface{}{"name": "bob", "id": 1},
+		user{UserID: 1, Name: "bob"},
+		&user{UserID: 1, Name: "bob"},
+	}
+
+	for _, test := range tests {
+		q := (&Query{}).Bind(10).BindMap(test)
+		if q.values != nil {
+			t.Errorf("%T: expected positional values to be cleared got %v", test, q.values)
+		}
+
+		values, err := q.binding(info)
+		if err != nil {
+			t.Errorf("%T: %v", test, err)
+			continue
+		}
+
+		if exp := []interface{}{1, "bob"}; !reflect.DeepEqual(values, exp) {
+			t.Errorf("%T: expected values %v got %v", test, exp, values)
+		}
+	}
+
+	missing := []interface{}{
+		map[string]interface{}{"id": 1},
+		struct{ ID int }{1},
+		struct{ id, name string }{"1", "bob"},
+		"not a struct",
+	}
+
+	for _, test := range missing {
+		if _, err := (&Query{}).BindMap(test).binding(info); err == nil {
+			t.Errorf("%T: expected error binding %v", test, test)
+		}
+	}
+}
+
+func TestKeyspaceConsistency(t *testing.T) {
+	s := &Session{
+		cfg: ClusterConfig{
+			Keyspace: "analytics",
+			KeyspaceConsistency: map[string]Consistency{
+				"analytics": One,
+				"billing":   All,
+			},
+		},
+		cons: Quorum,
+	}
+
+	// per keyspace > cluster
+	if cons := s.Query("SELECT * FROM t").GetConsistency(); cons != One {
+		t.Errorf("expected keyspace consistency %v got %v", One, cons)
+	}
+	if cons := s.NewBatch(LoggedBatch).GetConsistency(); cons != One {
+		t.Errorf("expected keyspace consistency %v for batch got %v", One, cons)
+	}
+
+	// per query > per keyspace
+	if cons := s.Query("SELECT * FROM t").Consistency(LocalQuorum).GetConsistency(); cons != LocalQuorum {
+		t.Errorf("expected query consistency %v got %v", LocalQuorum, cons)
+	}
+
+	// cluster default when the keyspace has no default
+	s.cfg.Keyspace = "users"
+	if cons := s.Query("SELECT * FROM t").GetConsistency(); cons != Quorum {
+		t.Errorf("expected cluster consistency %v got %v", Quorum, cons)
+	}
+}
+
+func TestSessionRefreshRingNoControl(t *testing.T) {
+	s := &Session{}
+	if err := s.RefreshRing(); err != errNoControl {
+		t.Fatalf("expected %v got %v", errNoControl, err)
+	}
+
+	s.isClosed = true
+	if err := s.RefreshRing(); err != ErrSessionClosed {
+		t.Fatalf("expected %v got %v", ErrSessionClosed, err)
+	}
+}
@@ -0,0 +1,46 @@
+package gocql
+
+import "sync/atomic"
+
+// TimestampGenerator generates the default timestamps, in microseconds since
+// the epoch, sent with the queries and batches which do not set one with
+// WithTimestamp. It must be safe for concurrent use.
+type TimestampGenerator interface {
+	Next() int64
+}
+
+// TimestampGeneratorFunc is a function which can be used as a TimestampGenerator.
+type TimestampGeneratorFunc func() int64
+
+// Next returns the timestamp returned by fn.
+func (fn TimestampGeneratorFunc) Next() int64 {
+	return fn()
+}
+
+// MonotonicTimestampGenerator returns the default TimestampGenerator, which
+// uses the system clock but never returns a timestamp lower than or equal to
+// one it returned before. If the clock jumps backwards, or several timestamps
+// are generated within the same microsecond, each timestamp is a microsecond
+// after the previous one until the clock catches up.
+func MonotonicTimestampGenerator() TimestampGenerator {
+	return &monotonicTimestampGenerator{clock: realClock{}}
+}
+
+type monotonicTimestampGenerator struct {
+	// accessed atomically and kept first so that it is 64-bit aligned
+	last  int64
+	clock clock
+}
+
+func (g *monotonicTimestampGenerator) Next() int64 {
+	for {
+		last := atomic.LoadInt64(&g.last)
+		ts := g.clock.Now().UnixNano() / 1000
+		if ts <= last {
+			ts = last + 1
+		}
+		if atomic.CompareAndSwapInt64(&g.last, last, ts) {
+			return ts
+		}
+	}
+}
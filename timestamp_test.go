@@ -0,0 +1,72 @@
+package gocql
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMonotonicTimestampGenerator(t *testing.T) {
+	clock := newFakeClock()
+	clock.Advance(time.Hour)
+	gen := &monotonicTimestampGenerator{clock: clock}
+
+	start := clock.Now().UnixNano() / 1000
+	if ts := gen.Next(); ts != start {
+		t.Fatalf("expected the time of the clock %d got %d", start, ts)
+	}
+
+	// timestamps within the same microsecond still increase
+	if ts := gen.Next(); ts != start+1 {
+		t.Fatalf("expected %d got %d", start+1, ts)
+	}
+
+	// the clock jumping backwards does not make the timestamps go back
+	clock.Advance(-time.Minute)
+	for i := int64(2); i < 5; i++ {
+		if ts := gen.Next(); ts != start+i {
+			t.Fatalf("expected %d after the clock jumped backwards got %d", start+i, ts)
+		}
+	}
+
+	// once the clock catches up the timestamps follow it again
+	clock.Advance(2 * time.Minute)
+	if ts, now := gen.Next(), clock.Now().UnixNano()/1000; ts != now {
+		t.Fatalf("expected the time of the clock %d got %d", now, ts)
+	}
+}
+
+func TestMonotonicTimestampGeneratorConcurrent(t *testing.T) {
+	gen := &monotonicTimestampGenerator{clock: newFakeClock()}
+
+	const workers, n = 4, 1000
+	var (
+		mu   sync.Mutex
+		seen = make(map[int64]bool)
+		wg   sync.WaitGroup
+	)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var last int64
+			for j := 0; j < n; j++ {
+				ts := gen.Next()
+				if ts <= last {
+					t.Errorf("expected a timestamp after %d got %d", last, ts)
+					return
+				}
+				last = ts
+
+				mu.Lock()
+				seen[ts] = true
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(seen) != workers*n {
+		t.Fatalf("expected %d distinct timestamps got %d", workers*n, len(seen))
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"bytes"
 	"crypto/md5"
 	"fmt"
+	"math"
 	"math/big"
 	"sort"
 	"strconv"
@@ -38,7 +39,16 @@ func (p murmur3Partitioner) Name() string {
 }
 
 func (p murmur3Partitioner) Hash(partitionKey []byte) token {
+	// Cassandra maps the empty partition key to the minimum token and
+	// normalizes hashes so that no key ever hashes to the minimum token.
+	if len(partitionKey) == 0 {
+		return murmur3Token(math.MinInt64)
+	}
+
 	h1 := murmur.Murmur3H1(partitionKey)
+	if h1 == math.MinInt64 {
+		h1 = math.MaxInt64
+	}
 	return murmur3Token(h1)
 }
 
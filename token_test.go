@@ -7,6 +7,7 @@ package gocql
 import (
 	"bytes"
 	"fmt"
+	"math"
 	"math/big"
 	"net"
 	"sort"
@@ -31,6 +32,42 @@ func TestMurmur3Partitioner(t *testing.T) {
 	}
 }
 
+// Tokens as returned by Cassandra's token() function
+func TestMurmur3PartitionerMatchesCassandra(t *testing.T) {
+	tests := []struct {
+		key   int32
+		token string
+	}{
+		{0, "-3485513579396041028"},
+		{1, "-4069959284402364209"},
+		{2, "-3248873570005575792"},
+		{3, "9010454139840013625"},
+		{4, "-2729420104000364805"},
+		{5, "-7509452495886106294"},
+	}
+
+	for _, test := range tests {
+		pk, err := marshalInt(nil, test.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if token := (murmur3Partitioner{}).Hash(pk); token.String() != test.token {
+			t.Errorf("token(%d): expected %s got %s", test.key, test.token, token)
+		}
+	}
+
+	token := murmur3Partitioner{}.Hash([]byte("a"))
+	if exp := "-8839064797231613815"; token.String() != exp {
+		t.Errorf("token('a'): expected %s got %s", exp, token)
+	}
+
+	token = murmur3Partitioner{}.Hash(nil)
+	if exp := strconv.FormatInt(math.MinInt64, 10); token.String() != exp {
+		t.Errorf("token of empty key: expected %s got %s", exp, token)
+	}
+}
+
 // Tests of the murmur3Token
 func TestMurmur3Token(t *testing.T) {
 	if murmur3Token(42).Less(murmur3Token(42)) {
@@ -11,32 +11,40 @@ type placementStrategy interface {
 	replicationFactor(dc string) int
 }
 
-func getReplicationFactorFromOpts(keyspace string, val interface{}) int {
-	// TODO: dont really want to panic here, but is better
-	// than spamming
+func getReplicationFactorFromOpts(keyspace string, val interface{}) (int, error) {
+	var rf int
 	switch v := val.(type) {
 	case int:
-		if v <= 0 {
-			panic(fmt.Sprintf("invalid replication_factor %d. Is the %q keyspace configured correctly?", v, keyspace))
-		}
-		return v
+		rf = v
 	case string:
 		n, err := strconv.Atoi(v)
 		if err != nil {
-			panic(fmt.Sprintf("invalid replication_factor. Is the %q keyspace configured correctly? %v", keyspace, err))
-		} else if n <= 0 {
-			panic(fmt.Sprintf("invalid replication_factor %d. Is the %q keyspace configured correctly?", n, keyspace))
+			return 0, fmt.Errorf("invalid replication_factor. Is the %q keyspace configured correctly? %v", keyspace, err)
 		}
-		return n
+		rf = n
 	default:
-		panic(fmt.Sprintf("unkown replication_factor type %T", v))
+		return 0, fmt.Errorf("unkown replication_factor type %T", v)
+	}
+
+	if rf < 0 {
+		return 0, fmt.Errorf("invalid replication_factor %d. Is the %q keyspace configured correctly?", rf, keyspace)
 	}
+
+	return rf, nil
 }
 
+// getStrategy returns the placement strategy for the keyspace or nil if the
+// strategy is unknown or the replication options can not be parsed, in which
+// case replicas can not be computed for the keyspace.
 func getStrategy(ks *KeyspaceMetadata) placementStrategy {
 	switch {
 	case strings.Contains(ks.StrategyClass, "SimpleStrategy"):
-		return &simpleStrategy{rf: getReplicationFactorFromOpts(ks.Name, ks.StrategyOptions["replication_factor"])}
+		rf, err := getReplicationFactorFromOpts(ks.Name, ks.StrategyOptions["replication_factor"])
+		if err != nil {
+			Logger.Printf("gocql: unable to parse replication for keyspace %q: %v\n", ks.Name, err)
+			return nil
+		}
+		return &simpleStrategy{rf: rf}
 	case strings.Contains(ks.StrategyClass, "NetworkTopologyStrategy"):
 		dcs := make(map[string]int)
 		for dc, rf := range ks.StrategyOptions {
@@ -44,12 +52,22 @@ func getStrategy(ks *KeyspaceMetadata) placementStrategy {
 				continue
 			}
 
-			dcs[dc] = getReplicationFactorFromOpts(ks.Name+":dc="+dc, rf)
+			n, err := getReplicationFactorFromOpts(ks.Name+":dc="+dc, rf)
+			if err != nil {
+				Logger.Printf("gocql: unable to parse replication for keyspace %q: %v\n", ks.Name, err)
+				return nil
+			} else if n == 0 {
+				// the keyspace is not replicated to this dc
+				continue
+			}
+
+			dcs[dc] = n
 		}
 		return &networkTopology{dcs: dcs}
 	default:
-		// TODO: handle unknown replicas and just return the primary host for a token
-		panic(fmt.Sprintf("unsupported strategy class: %v", ks.StrategyClass))
+		// LocalStrategy, EverywhereStrategy and custom strategies are not
+		// supported, queries against them will fall back to the host policy.
+		return nil
 	}
 }
 
@@ -67,9 +85,11 @@ func (s *simpleStrategy) replicaMap(_ []*HostInfo, tokens []hostToken) map[token
 	for i, th := range tokens {
 		replicas := make([]*HostInfo, 0, s.rf)
 		for j := 0; j < len(tokens) && len(replicas) < s.rf; j++ {
-			// TODO: need to ensure we dont add the same hosts twice
-			h := tokens[(i+j)%len(tokens)]
-			replicas = append(replicas, h.host)
+			h := tokens[(i+j)%len(tokens)].host
+			// hosts own many tokens when using vnodes
+			if !containsHost(replicas, h) {
+				replicas = append(replicas, h)
+			}
 		}
 		tokenRing[th.token] = replicas
 	}
@@ -77,6 +97,15 @@ func (s *simpleStrategy) replicaMap(_ []*HostInfo, tokens []hostToken) map[token
 	return tokenRing
 }
 
+func containsHost(hosts []*HostInfo, host *HostInfo) bool {
+	for _, h := range hosts {
+		if h == host {
+			return true
+		}
+	}
+	return false
+}
+
 type networkTopology struct {
 	dcs map[string]int
 }
@@ -132,8 +161,10 @@ func (n *networkTopology) replicaMap(hosts []*HostInfo, tokens []hostToken) map[
 
 		replicas := make([]*HostInfo, 0, totalRF)
 		for j := 0; j < len(tokens) && !n.haveRF(replicasInDC); j++ {
-			// TODO: ensure we dont add the same host twice
 			h := tokens[(i+j)%len(tokens)].host
+			if containsHost(replicas, h) || containsHost(skipped[h.DataCenter()], h) {
+				continue
+			}
 
 			dc := h.DataCenter()
 			rack := h.Rack()
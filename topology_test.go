@@ -2,6 +2,7 @@ package gocql
 
 import (
 	"fmt"
+	"reflect"
 	"sort"
 	"testing"
 )
@@ -161,3 +162,92 @@ func TestPlacementStrategy_NetworkStrategy(t *testing.T) {
 		}
 	}
 }
+
+func TestGetStrategy(t *testing.T) {
+	simple := getStrategy(&KeyspaceMetadata{
+		Name:          "simple",
+		StrategyClass: "org.apache.cassandra.locator.SimpleStrategy",
+		StrategyOptions: map[string]interface{}{
+			"class":              "org.apache.cassandra.locator.SimpleStrategy",
+			"replication_factor": "3",
+		},
+	})
+	if s, ok := simple.(*simpleStrategy); !ok {
+		t.Errorf("expected simpleStrategy got %T", simple)
+	} else if s.rf != 3 {
+		t.Errorf("expected rf=3 got %d", s.rf)
+	}
+
+	nts := getStrategy(&KeyspaceMetadata{
+		Name:          "nts",
+		StrategyClass: "org.apache.cassandra.locator.NetworkTopologyStrategy",
+		StrategyOptions: map[string]interface{}{
+			"class": "org.apache.cassandra.locator.NetworkTopologyStrategy",
+			"dc1":   "3",
+			"dc2":   2,
+			"dc3":   "0",
+		},
+	})
+	if n, ok := nts.(*networkTopology); !ok {
+		t.Errorf("expected networkTopology got %T", nts)
+	} else if exp := map[string]int{"dc1": 3, "dc2": 2}; !reflect.DeepEqual(n.dcs, exp) {
+		t.Errorf("expected dcs %v got %v", exp, n.dcs)
+	}
+
+	invalid := []*KeyspaceMetadata{
+		{Name: "local", StrategyClass: "org.apache.cassandra.locator.LocalStrategy"},
+		{
+			Name:            "bad_rf",
+			StrategyClass:   "org.apache.cassandra.locator.SimpleStrategy",
+			StrategyOptions: map[string]interface{}{"replication_factor": "three"},
+		},
+		{
+			Name:            "negative_rf",
+			StrategyClass:   "org.apache.cassandra.locator.NetworkTopologyStrategy",
+			StrategyOptions: map[string]interface{}{"dc1": "-1"},
+		},
+	}
+	for _, ks := range invalid {
+		if strat := getStrategy(ks); strat != nil {
+			t.Errorf("expected no strategy for keyspace %q got %T", ks.Name, strat)
+		}
+	}
+}
+
+func TestPlacementStrategy_VNodes(t *testing.T) {
+	hosts := []*HostInfo{
+		{hostId: "a", dataCenter: "dc1", rack: "rack1"},
+		{hostId: "b", dataCenter: "dc1", rack: "rack1"},
+		{hostId: "c", dataCenter: "dc2", rack: "rack1"},
+		{hostId: "d", dataCenter: "dc2", rack: "rack2"},
+	}
+
+	// each host owns 3 tokens, adjacent tokens belong to the same host
+	var tokens []hostToken
+	for i, h := range hosts {
+		for j := 0; j < 3; j++ {
+			tokens = append(tokens, hostToken{intToken(i*10 + j), h})
+		}
+	}
+
+	strategies := []placementStrategy{
+		&simpleStrategy{rf: 3},
+		&networkTopology{dcs: map[string]int{"dc1": 2, "dc2": 1}},
+	}
+
+	for _, strat := range strategies {
+		for token, replicas := range strat.replicaMap(hosts, tokens) {
+			if len(replicas) != 3 {
+				t.Errorf("%T: expected 3 replicas for token %v got %v", strat, token, replicas)
+			}
+
+			seen := make(map[*HostInfo]bool)
+			for _, h := range replicas {
+				if seen[h] {
+					t.Errorf("%T: host %v is a replica more than once for token %v", strat, h.HostID(), token)
+				}
+				seen[h] = true
+			}
+		}
+	}
+}